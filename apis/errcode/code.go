@@ -18,6 +18,8 @@ const (
 	ErrCode_SendVerifyCodeTooBusy          = 10005
 	ErrCode_PasswordTooShort               = 10006
 	ErrCode_RequireMemberLogin             = 10007 // 需要添加家庭成员并选择
+
+	ErrCode_StorageQuotaExceeded = 10101 // 存储空间不足
 )
 
 var (
@@ -28,6 +30,7 @@ var (
 		ErrCode_NotSupportMobileForgotPassword: "暂不支持手机号找回密码",
 		ErrCode_SendVerifyCodeTooBusy:          "发送验证码太过频繁",
 		ErrCode_PasswordTooShort:               "密码太短",
+		ErrCode_StorageQuotaExceeded:           "存储空间不足",
 	}
 )
 
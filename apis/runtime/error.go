@@ -35,7 +35,7 @@ func BadRequest(ctx *gin.Context, msgs ...interface{}) {
 // BadRequestWithCode 参数错误
 func BadRequestWithCode(ctx *gin.Context, code int, msgs ...interface{}) {
 	ctx.Writer.WriteHeader(http.StatusBadRequest)
-	ResponseMessage(ctx, errcode.ErrCode_BadRequest, formatMessage(msgs))
+	ResponseMessage(ctx, uint32(code), formatMessage(msgs))
 }
 
 // InternalError 服务器内部错误
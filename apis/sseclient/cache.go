@@ -6,11 +6,20 @@ import (
 )
 
 type Cache interface {
-	// WriteMessage 写入消息到指定流
-	WriteMessage(ctx context.Context, key, msg string, expiration time.Duration) error
-	// ReadMessages 读取指定流的消息
-	ReadMessages(ctx context.Context, key string) (string, []string, error)
-	ReadAfterID(ctx context.Context, key, id string) (string, string, error)
+	// WriteMessage 写入消息到指定流, 返回消息ID
+	WriteMessage(ctx context.Context, key string, ev *Event, expiration time.Duration) (string, error)
+	// ReadMessages 读取指定流的消息, 返回最新的消息ID
+	ReadMessages(ctx context.Context, key string) (string, []*Event, error)
+	// ReadRange 读取 (startID, endID] 区间内的消息
+	ReadRange(ctx context.Context, key, startID, endID string) ([]*Event, error)
+	// ReadBlock 读取 id 之后最多 count 条消息, 没有新消息时最多阻塞 timeout
+	ReadBlock(ctx context.Context, key, id string, count int64, timeout time.Duration) ([]*Event, error)
+	// LatestID 获取流中最新的消息ID, 流为空时返回 StartID
+	LatestID(ctx context.Context, key string) (string, error)
+	// Publish 发布信号
+	Publish(ctx context.Context, channel, payload string) error
+	// Subscribe 订阅信号, 返回的通道在 ctx 结束时关闭
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
 	// Set 设置数据
 	Set(ctx context.Context, key string, expiration time.Duration) error
 	// Exist 检查数据是否存在
@@ -5,18 +5,25 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	defaultExpiration    = 30 * time.Minute
-	defaultBlockTimeout  = 300 * time.Millisecond
-	defaultBlockMaxRetry = 3
-	writeKeyPrefix       = "stream_write"
-	stopKeyPrefix        = "stream_stop"
+	defaultExpiration   = 30 * time.Minute
+	defaultBlockTimeout = 5 * time.Second
+	defaultIdleTimeout  = time.Minute
+	defaultHeartbeat    = 15 * time.Second
+	writeKeyPrefix      = "stream_write"
+	stopKeyPrefix       = "stream_stop"
+
+	// StartID 从流的第一条消息开始读取
+	StartID = "0"
+	// HeaderLastEventID 浏览器 EventSource 重连时携带的请求头
+	HeaderLastEventID = "Last-Event-ID"
+	// EventEnd 流结束事件, 由 Finish 写入, 客户端收到后应关闭连接
+	EventEnd = "end"
 )
 
 type config struct {
@@ -24,6 +31,8 @@ type config struct {
 	expiration    time.Duration
 	blockTimeout  time.Duration
 	blockMaxRetry int
+	idleTimeout   time.Duration
+	heartbeat     time.Duration
 }
 
 type Option interface {
@@ -47,50 +56,80 @@ func WithExpiration(expiration time.Duration) Option {
 		cfg.expiration = expiration
 	})
 }
+
+// WithBlockTimeout 设置单次阻塞读取(XREAD BLOCK)的超时时间,
+// 使用同一个 redis 客户端时每个进程内每个流只占用一个阻塞连接, 与读取方数量无关
 func WithBlockTimeout(blockTimeout time.Duration) Option {
 	return configFunc(func(cfg *config) {
 		cfg.blockTimeout = blockTimeout
 	})
 }
 
+// WithBlockMaxRetry 读取方连续 blockMaxRetry 次阻塞读取都没有新消息时退出,
+// 等同于 WithIdleTimeout(blockMaxRetry * blockTimeout), 同时设置时以 WithIdleTimeout 为准
+//
+// Deprecated: 请使用 WithIdleTimeout
 func WithBlockMaxRetry(blockMaxRetry int) Option {
 	return configFunc(func(cfg *config) {
 		cfg.blockMaxRetry = blockMaxRetry
 	})
 }
 
+// WithIdleTimeout 设置读取方在没有新消息时的最长等待时间, 默认 1 分钟.
+// 读取方在收到 Finish 写入的结束事件、Stop 或 Close 时立即退出,
+// 写入方不调用 Finish 时读取方在空闲超时后退出, 心跳不计入空闲
+func WithIdleTimeout(idleTimeout time.Duration) Option {
+	return configFunc(func(cfg *config) {
+		cfg.idleTimeout = idleTimeout
+	})
+}
+
+// WithHeartbeat 设置空闲时心跳注释的发送间隔, 小于等于0时不发送
+func WithHeartbeat(heartbeat time.Duration) Option {
+	return configFunc(func(cfg *config) {
+		cfg.heartbeat = heartbeat
+	})
+}
+
 // SSEClient SSE客户端管理器
 type SSEClient struct {
 	storage Cache // 存储接口
+	hub     *hub
 	config  *config
 }
 
 // New 创建SSE客户端实例
 func New(opts ...Option) *SSEClient {
 	cfg := &config{
-		expiration:    defaultExpiration,
-		blockTimeout:  defaultBlockTimeout,
-		blockMaxRetry: defaultBlockMaxRetry,
+		expiration:   defaultExpiration,
+		blockTimeout: defaultBlockTimeout,
+		heartbeat:    defaultHeartbeat,
 	}
 	for _, opt := range opts {
 		opt.apply(cfg)
 	}
-
-	// 不建议超出默认值，防止连接池被耗尽，时间尽可能短，防止阻塞时间过长
-	if cfg.blockTimeout > defaultBlockTimeout {
+	if cfg.blockTimeout <= 0 {
 		cfg.blockTimeout = defaultBlockTimeout
 	}
+	if cfg.idleTimeout <= 0 && cfg.blockMaxRetry > 0 {
+		cfg.idleTimeout = time.Duration(cfg.blockMaxRetry) * cfg.blockTimeout
+	}
+	if cfg.idleTimeout <= 0 {
+		cfg.idleTimeout = defaultIdleTimeout
+	}
 
-	var storage Cache
+	// 使用 redis 时同一个客户端的 SSEClient 共用 hub, 可以按请求创建 SSEClient
+	var h *hub
 	if cfg.rdb != nil {
-		storage = newRedisCache(cfg.rdb, cfg.blockTimeout)
+		h = sharedRedisHub(cfg.rdb, cfg.blockTimeout)
 	} else {
-		storage = newMemoryCache(writeKeyPrefix, stopKeyPrefix)
+		h = newHub(newMemoryCache(writeKeyPrefix, stopKeyPrefix), cfg.blockTimeout)
 	}
 
 	cache := &SSEClient{
 		config:  cfg,
-		storage: storage,
+		storage: h.storage,
+		hub:     h,
 	}
 
 	return cache
@@ -115,7 +154,7 @@ func (s *SSEClient) WriteMessage(ctx context.Context, writer io.Writer, streamID
 	}
 
 	writeKey := s.buildWriteKey(streamID)
-	if err := s.storage.WriteMessage(ctx, writeKey, msg, s.config.expiration); err != nil {
+	if _, err := s.storage.WriteMessage(ctx, writeKey, &Event{Data: msg}, s.config.expiration); err != nil {
 		return false, err
 	}
 
@@ -126,6 +165,34 @@ func (s *SSEClient) WriteMessage(ctx context.Context, writer io.Writer, streamID
 	return false, nil
 }
 
+// WriteEvent 写入事件到指定流, 并以 SSE 协议格式发送给 writer, writer 为空时只写入流
+// 事件ID由存储生成并回填到 ev.ID, 返回写入是否被停止
+func (s *SSEClient) WriteEvent(ctx context.Context, writer io.Writer, streamID string, ev *Event) (bool, error) {
+	stopKey := s.buildStopKey(streamID)
+	stopped, err := s.storage.Exist(ctx, stopKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to get write stop signal, err: %v, key:%s", err, stopKey)
+	}
+	if stopped {
+		return true, nil
+	}
+
+	writeKey := s.buildWriteKey(streamID)
+	id, err := s.storage.WriteMessage(ctx, writeKey, ev, s.config.expiration)
+	if err != nil {
+		return false, err
+	}
+	ev.ID = id
+
+	if writer == nil {
+		return false, nil
+	}
+	if err := s.Send(writer, ev); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
 func (s *SSEClient) SendEvent(writer io.Writer, msg string) error {
 	if writer == nil {
 		return fmt.Errorf("response writer is nil")
@@ -143,50 +210,155 @@ func (s *SSEClient) SendEvent(writer io.Writer, msg string) error {
 
 // ReadMessages 读取指定流的消息
 func (s *SSEClient) ReadMessages(ctx context.Context, streamID string) (string, []string, error) {
+	latestID, events, err := s.ReadEvents(ctx, streamID)
+	if err != nil {
+		return "", nil, err
+	}
+	var messages []string
+	for _, ev := range events {
+		messages = append(messages, ev.Data)
+	}
+	return latestID, messages, nil
+}
+
+// ReadEvents 读取指定流的全部事件, 返回最新的事件ID
+func (s *SSEClient) ReadEvents(ctx context.Context, streamID string) (string, []*Event, error) {
 	key := s.buildWriteKey(streamID)
 	return s.storage.ReadMessages(ctx, key)
 }
 
 // BlockRead 阻塞读取指定流的消息，返回 bool 表示是否读取结束，true-读取结束，false-未结束
 func (s *SSEClient) BlockRead(ctx context.Context, writer io.Writer, streamID string, latestID string) (bool, int, error) {
+	return s.blockRead(ctx, streamID, latestID, func(ev *Event) error {
+		if ev.Event == EventEnd {
+			return nil
+		}
+		return s.SendEvent(writer, ev.Data)
+	}, nil)
+}
+
+// ResumeRead 从 lastEventID 之后开始阻塞读取指定流, 以 SSE 协议格式发送事件,
+// 空闲时按 WithHeartbeat 的间隔发送心跳注释. 返回值同 BlockRead
+func (s *SSEClient) ResumeRead(ctx context.Context, writer io.Writer, streamID string, lastEventID string) (bool, int, error) {
+	if lastEventID == "" {
+		lastEventID = StartID
+	}
+	lastSent := time.Now()
+	return s.blockRead(ctx, streamID, lastEventID, func(ev *Event) error {
+		lastSent = time.Now()
+		return s.Send(writer, ev)
+	}, func() error {
+		if s.config.heartbeat <= 0 || time.Since(lastSent) < s.config.heartbeat {
+			return nil
+		}
+		lastSent = time.Now()
+		return s.SendComment(writer, "heartbeat")
+	})
+}
+
+// blockRead 先从存储中追赶 latestID 之后的历史消息, 再接收 tail 推送的实时消息,
+// 直到收到结束事件、停止信号、ctx 结束或空闲超时
+func (s *SSEClient) blockRead(ctx context.Context, streamID string, latestID string,
+	emit func(*Event) error, idle func() error) (bool, int, error) {
 	writeKey := s.buildWriteKey(streamID)
 	stopKey := s.buildStopKey(streamID)
-	var timeoutCount atomic.Int32
-	var affectedRows atomic.Int32
+	affectedRows := 0
 	nextID := latestID
+	if nextID == "" {
+		nextID = StartID
+	}
+
+	// handle 处理单条消息, 返回 true 表示流已结束
+	handle := func(ev *Event) (bool, error) {
+		if err := emit(ev); err != nil {
+			return false, err
+		}
+		nextID = ev.ID
+		if ev.Event == EventEnd {
+			return true, nil
+		}
+		affectedRows++
+		return false, nil
+	}
+
+	var heartbeat <-chan time.Time
+	if idle != nil && s.config.heartbeat > 0 {
+		ticker := time.NewTicker(s.config.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	idleTimer := time.NewTimer(s.config.idleTimeout)
+	defer idleTimer.Stop()
 
 	for {
+		sub, err := s.hub.subscribe(ctx, writeKey)
+		if err != nil {
+			if ctx.Err() != nil {
+				return true, affectedRows, nil
+			}
+			return false, affectedRows, err
+		}
+		// 订阅后再检查停止标记, 订阅之前发出的 Stop/Close 信号不会丢失
 		stopped, err := s.storage.Exist(ctx, stopKey)
 		if err != nil {
-			return false, 0, fmt.Errorf("failed to get write stop signal, err: %v, key:%s", err, stopKey)
+			s.hub.unsubscribe(sub)
+			return false, affectedRows, fmt.Errorf("failed to get write stop signal, err: %v, key:%s", err, stopKey)
 		}
 		if stopped {
-			return true, int(affectedRows.Load()), nil
+			s.hub.unsubscribe(sub)
+			return true, affectedRows, nil
 		}
 
-		if timeoutCount.Load() >= int32(s.config.blockMaxRetry) {
-			return true, int(affectedRows.Load()), nil
+		// 追赶订阅前已写入的消息
+		history, err := s.storage.ReadRange(ctx, writeKey, nextID, sub.startID)
+		if err != nil {
+			s.hub.unsubscribe(sub)
+			return false, affectedRows, err
 		}
-		select {
-		case <-ctx.Done():
-			return true, int(affectedRows.Load()), nil
-		default:
-
-			msgID, msg, err := s.storage.ReadAfterID(ctx, writeKey, nextID)
-			if err != nil {
-				return false, int(affectedRows.Load()), err
-			}
-			if msgID == "" {
-				timeoutCount.Add(1)
-				continue
+		for _, ev := range history {
+			ended, err := handle(ev)
+			if err != nil || ended {
+				s.hub.unsubscribe(sub)
+				return ended, affectedRows, err
 			}
+		}
 
-			if err := s.SendEvent(writer, msg); err != nil {
-				return false, int(affectedRows.Load()), err
+		lagged, ended, err := func() (bool, bool, error) {
+			defer s.hub.unsubscribe(sub)
+			for {
+				select {
+				case <-ctx.Done():
+					return false, true, nil
+				case <-idleTimer.C:
+					return false, true, nil
+				case <-heartbeat:
+					if err := idle(); err != nil {
+						return false, false, err
+					}
+				case ev, ok := <-sub.ch:
+					if !ok {
+						switch sub.reason {
+						case reasonLagged:
+							return true, false, nil
+						case reasonError:
+							return false, false, sub.err
+						default:
+							return false, true, nil
+						}
+					}
+					ended, err := handle(ev)
+					if err != nil || ended {
+						return false, ended, err
+					}
+					if !idleTimer.Stop() {
+						<-idleTimer.C
+					}
+					idleTimer.Reset(s.config.idleTimeout)
+				}
 			}
-			nextID = msgID
-			affectedRows.Add(1)
-			timeoutCount.Store(0)
+		}()
+		if !lagged {
+			return ended, affectedRows, err
 		}
 	}
 }
@@ -201,21 +373,42 @@ func (s *SSEClient) Stop(ctx context.Context, streamID string) error {
 	if err := s.storage.Delete(ctx, writeKey); err != nil {
 		return fmt.Errorf("failed to delete message, err: %v, key:%s", err, writeKey)
 	}
+	return s.hub.publish(ctx, signalStop, writeKey)
+}
+
+// Finish 标记流写入完成, 写入结束事件后读取方会在消费完所有消息后退出,
+// 与 Close 不同, 流中的消息会保留到过期, 供断线重连的客户端续传
+func (s *SSEClient) Finish(ctx context.Context, streamID string) error {
+	writeKey := s.buildWriteKey(streamID)
+	if _, err := s.storage.WriteMessage(ctx, writeKey, &Event{Event: EventEnd}, s.config.expiration); err != nil {
+		return err
+	}
 	return nil
 }
 
+// StopNotify 返回在流被 Stop 或 Close 时关闭的通道, 用于及时取消生成任务.
+// 信号通过 pub/sub 投递, 写入方仍应检查 WriteEvent 返回的停止状态
+func (s *SSEClient) StopNotify(ctx context.Context, streamID string) (<-chan struct{}, error) {
+	return s.hub.watchStop(ctx, s.buildWriteKey(streamID))
+}
+
 func (s *SSEClient) GetStopSignal(ctx context.Context, streamID string) (bool, error) {
 	key := s.buildStopKey(streamID)
 	return s.storage.Exist(ctx, key)
 }
 
-// Close 写入完成时关闭相关资源
+// Close 写入完成时关闭相关资源, 删除流中的消息并结束所有读取方.
+// 同时设置停止标记, 之后的写入返回已停止, 晚到的读取方不会等待已删除的流
 func (s *SSEClient) Close(ctx context.Context, streamID string) error {
+	stopKey := s.buildStopKey(streamID)
+	if err := s.storage.Set(ctx, stopKey, s.config.expiration*2); err != nil {
+		return fmt.Errorf("failed to set write stop signal, err: %v, key:%s", err, stopKey)
+	}
 	key := s.buildWriteKey(streamID)
 	if err := s.storage.Delete(ctx, key); err != nil {
-		return fmt.Errorf("failed to delete stop signal, err: %v, key:%s", err, key)
+		return fmt.Errorf("failed to delete message, err: %v, key:%s", err, key)
 	}
-	return nil
+	return s.hub.publish(ctx, signalClose, key)
 }
 
 func (s *SSEClient) buildWriteKey(streamID string) string {
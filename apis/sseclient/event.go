@@ -0,0 +1,91 @@
+package sseclient
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event SSE 协议事件
+type Event struct {
+	// ID 事件ID, 客户端重连时通过 Last-Event-ID 请求头带回
+	ID string `json:"id,omitempty"`
+	// Event 事件类型, 为空时浏览器按 message 处理
+	Event string `json:"event,omitempty"`
+	// Data 事件数据, 多行数据会被拆分为多个 data 字段
+	Data string `json:"data"`
+	// Retry 建议客户端的重连间隔
+	Retry time.Duration `json:"retry,omitempty"`
+}
+
+var lineBreakReplacer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// Encode 按 SSE 协议格式编码事件
+func (e *Event) Encode() []byte {
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: ")
+		buf.WriteString(singleLine(e.ID))
+		buf.WriteByte('\n')
+	}
+	if e.Event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(singleLine(e.Event))
+		buf.WriteByte('\n')
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&buf, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(lineBreakReplacer.Replace(e.Data), "\n") {
+		buf.WriteString("data: ")
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// WriteTo 将编码后的事件写入 w
+func (e *Event) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(e.Encode())
+	return int64(n), err
+}
+
+// singleLine id 和 event 字段不允许换行, 否则会破坏协议帧
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Send 以 SSE 协议格式发送事件并刷新
+func (s *SSEClient) Send(writer io.Writer, ev *Event) error {
+	if writer == nil {
+		return fmt.Errorf("response writer is nil")
+	}
+	if _, err := ev.WriteTo(writer); err != nil {
+		return fmt.Errorf("failed to write to response writer: %v", err)
+	}
+	return flush(writer)
+}
+
+// SendComment 发送注释行, 客户端会忽略, 常用于心跳保活
+func (s *SSEClient) SendComment(writer io.Writer, comment string) error {
+	if writer == nil {
+		return fmt.Errorf("response writer is nil")
+	}
+	if _, err := fmt.Fprintf(writer, ": %s\n\n", singleLine(comment)); err != nil {
+		return fmt.Errorf("failed to write to response writer: %v", err)
+	}
+	return flush(writer)
+}
+
+func flush(writer io.Writer) error {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("failed to flush response writer")
+	}
+	flusher.Flush()
+	return nil
+}
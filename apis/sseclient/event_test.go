@@ -0,0 +1,50 @@
+package sseclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventEncode(t *testing.T) {
+	ev := &Event{
+		ID:    "1-0",
+		Event: "delta\nbad",
+		Data:  "line1\r\nline2\nline3",
+		Retry: 3 * time.Second,
+	}
+	expected := "id: 1-0\nevent: deltabad\nretry: 3000\ndata: line1\ndata: line2\ndata: line3\n\n"
+	assert.Equal(t, expected, string(ev.Encode()))
+
+	assert.Equal(t, "data: \n\n", string((&Event{}).Encode()))
+}
+
+func TestServeStreamResume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := New(WithHeartbeat(0))
+	for _, data := range []string{"a", "b", "c"} {
+		stopped, err := client.WriteEvent(context.Background(), nil, "resume", &Event{Event: "delta", Data: data})
+		assert.Nil(t, err)
+		assert.False(t, stopped)
+	}
+	assert.Nil(t, client.Finish(context.Background(), "resume"))
+
+	eng := gin.New()
+	eng.GET("/stream", client.Handler(func(ctx *gin.Context) string {
+		return ctx.Query("id")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/stream?id=resume", nil)
+	req.Header.Set(HeaderLastEventID, "1")
+	w := httptest.NewRecorder()
+	eng.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "id: 2\nevent: delta\ndata: b\n\nid: 3\nevent: delta\ndata: c\n\nid: 4\nevent: end\ndata: \n\n", w.Body.String())
+}
@@ -0,0 +1,42 @@
+package sseclient
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LastEventID 获取客户端最后收到的事件ID, 优先使用 Last-Event-ID 请求头,
+// 其次使用 last_event_id 查询参数(用于无法自定义请求头的首次连接)
+func LastEventID(req *http.Request) string {
+	if id := req.Header.Get(HeaderLastEventID); id != "" {
+		return id
+	}
+	return req.URL.Query().Get("last_event_id")
+}
+
+// ServeStream 以 SSE 协议输出指定流, 浏览器断线重连时根据 Last-Event-ID 续传
+func (s *SSEClient) ServeStream(ctx *gin.Context, streamID string) error {
+	s.SetHeaders(ctx.Writer)
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	ctx.Writer.WriteHeaderNow()
+	ctx.Writer.Flush()
+
+	_, _, err := s.ResumeRead(ctx.Request.Context(), ctx.Writer, streamID, LastEventID(ctx.Request))
+	return err
+}
+
+// Handler 返回 gin 处理函数, streamIDFunc 从请求中解析流ID, 返回空字符串时响应 400
+func (s *SSEClient) Handler(streamIDFunc func(ctx *gin.Context) string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		streamID := streamIDFunc(ctx)
+		if streamID == "" {
+			ctx.String(http.StatusBadRequest, "stream id is empty")
+			return
+		}
+		if err := s.ServeStream(ctx, streamID); err != nil {
+			ctx.Error(err)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package sseclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ygpkg/yg-go/logs"
+)
+
+const (
+	signalChannel     = "stream_signal"
+	signalStop        = "stop"
+	signalClose       = "close"
+	subscriberBuffer  = 256
+	tailReadCount     = 100
+	tailRetryInterval = time.Second
+)
+
+type closeReason int
+
+const (
+	reasonNone closeReason = iota
+	// reasonStopped 流被停止或关闭
+	reasonStopped
+	// reasonLagged 订阅者消费过慢, 需要从存储中追赶
+	reasonLagged
+	// reasonError 读取存储出错
+	reasonError
+)
+
+// subscriber 单个读取方, 通过 ch 接收实时消息
+type subscriber struct {
+	t *tail
+	// startID 订阅时的游标, 之后的消息都会投递到 ch
+	startID string
+	ch      chan *Event
+	reason  closeReason
+	err     error
+}
+
+// tail 每个流在每个进程内只有一个 tail, 使用一个阻塞的 XREAD 将新消息分发给所有订阅者
+type tail struct {
+	key    string
+	cursor string
+	// started 游标已初始化, 之后加入的订阅者从加入时的游标开始
+	started bool
+	subs    map[*subscriber]struct{}
+	ready   chan struct{}
+	err     error
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+}
+
+// hub 管理当前进程内所有流的 tail, 并通过 pub/sub 接收停止信号.
+// 信号订阅只在有读取方或 StopNotify 监听时保持, 全部退出后关闭, 不会随 SSEClient 泄漏
+type hub struct {
+	storage      Cache
+	blockTimeout time.Duration
+	tails        map[string]*tail
+	watchers     map[string]map[chan struct{}]struct{}
+	// users 正在使用信号订阅的读取方和监听方数量
+	users        int
+	listening    bool
+	signalGen    int
+	signalCancel context.CancelFunc
+	signalMu     sync.Mutex
+	mu           sync.Mutex
+}
+
+// redisHubKey 同一个 redis 客户端和阻塞超时共用一个 hub
+type redisHubKey struct {
+	rdb          *redis.Client
+	blockTimeout time.Duration
+}
+
+var (
+	redisHubs   = make(map[redisHubKey]*hub)
+	redisHubsMu sync.Mutex
+)
+
+// sharedRedisHub 返回 redis 客户端共用的 hub, 每次请求创建 SSEClient 时不会重复建立订阅和 tail
+func sharedRedisHub(rdb *redis.Client, blockTimeout time.Duration) *hub {
+	redisHubsMu.Lock()
+	defer redisHubsMu.Unlock()
+	key := redisHubKey{rdb: rdb, blockTimeout: blockTimeout}
+	h, ok := redisHubs[key]
+	if !ok {
+		h = newHub(newRedisCache(rdb), blockTimeout)
+		redisHubs[key] = h
+	}
+	return h
+}
+
+func newHub(storage Cache, blockTimeout time.Duration) *hub {
+	return &hub{
+		storage:      storage,
+		blockTimeout: blockTimeout,
+		tails:        make(map[string]*tail),
+		watchers:     make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// subscribe 订阅指定流, 返回的 subscriber.startID 之后的消息将通过通道推送
+func (h *hub) subscribe(ctx context.Context, key string) (*subscriber, error) {
+	if err := h.acquireSignals(); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	t, ok := h.tails[key]
+	if !ok {
+		tctx, cancel := context.WithCancel(context.Background())
+		t = &tail{
+			key:    key,
+			subs:   make(map[*subscriber]struct{}),
+			ready:  make(chan struct{}),
+			cancel: cancel,
+		}
+		h.tails[key] = t
+		go h.run(tctx, t)
+	}
+	sub := &subscriber{t: t, ch: make(chan *Event, subscriberBuffer)}
+	// startID 与加入订阅在同一临界区内确定, 分发时同样持有锁, 订阅者不会收到 startID 之前的消息;
+	// tail 尚未初始化时由 run 在设置游标时一并设置
+	t.mu.Lock()
+	if t.started {
+		sub.startID = t.cursor
+	}
+	t.subs[sub] = struct{}{}
+	t.mu.Unlock()
+	h.mu.Unlock()
+
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		h.unsubscribe(sub)
+		return nil, ctx.Err()
+	}
+	if t.err != nil {
+		h.unsubscribe(sub)
+		return nil, t.err
+	}
+	return sub, nil
+}
+
+// unsubscribe 每个 subscribe 成功或失败后都要调用一次
+func (h *hub) unsubscribe(sub *subscriber) {
+	defer h.releaseSignals()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := sub.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.subs, sub)
+	if len(t.subs) == 0 && h.tails[t.key] == t {
+		delete(h.tails, t.key)
+		t.cancel()
+	}
+}
+
+// run 阻塞读取流的新消息并分发, 直到没有订阅者
+func (h *hub) run(ctx context.Context, t *tail) {
+	latestID, err := h.storage.LatestID(ctx, t.key)
+	t.mu.Lock()
+	t.cursor = latestID
+	t.err = err
+	t.started = true
+	for sub := range t.subs {
+		sub.startID = latestID
+	}
+	t.mu.Unlock()
+	close(t.ready)
+	if err != nil {
+		h.closeTail(t, reasonError, err)
+		return
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		t.mu.Lock()
+		cursor := t.cursor
+		t.mu.Unlock()
+
+		events, err := h.storage.ReadBlock(ctx, t.key, cursor, tailReadCount, h.blockTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logs.Warnf("[sseclient] tail %s read failed: %v", t.key, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(tailRetryInterval):
+			}
+			continue
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		t.mu.Lock()
+		for _, ev := range events {
+			t.cursor = ev.ID
+			for sub := range t.subs {
+				select {
+				case sub.ch <- ev:
+				default:
+					sub.reason = reasonLagged
+					close(sub.ch)
+					delete(t.subs, sub)
+				}
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// closeTail 关闭 tail 下所有订阅者
+func (h *hub) closeTail(t *tail, reason closeReason, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for sub := range t.subs {
+		sub.reason = reason
+		sub.err = err
+		close(sub.ch)
+		delete(t.subs, sub)
+	}
+	if h.tails[t.key] == t {
+		delete(h.tails, t.key)
+	}
+	t.cancel()
+}
+
+// acquireSignals 增加信号订阅的使用方, hub 内只建立一个信号订阅, 收到停止信号时结束对应流的所有读取
+func (h *hub) acquireSignals() error {
+	h.signalMu.Lock()
+	defer h.signalMu.Unlock()
+	if h.listening {
+		h.users++
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := h.storage.Subscribe(ctx, signalChannel)
+	if err != nil {
+		cancel()
+		return err
+	}
+	h.users++
+	h.listening = true
+	h.signalGen++
+	h.signalCancel = cancel
+	gen := h.signalGen
+	go func() {
+		defer func() {
+			// 订阅断开后, 下次订阅时重新建立
+			h.signalMu.Lock()
+			if h.signalGen == gen {
+				h.listening = false
+				h.signalCancel = nil
+			}
+			h.signalMu.Unlock()
+			cancel()
+		}()
+		for payload := range ch {
+			_, key, ok := strings.Cut(payload, ":")
+			if !ok {
+				continue
+			}
+			h.mu.Lock()
+			t, ok := h.tails[key]
+			for ch := range h.watchers[key] {
+				close(ch)
+			}
+			delete(h.watchers, key)
+			h.mu.Unlock()
+			if ok {
+				h.closeTail(t, reasonStopped, nil)
+			}
+		}
+	}()
+	return nil
+}
+
+// releaseSignals 减少信号订阅的使用方, 没有使用方时关闭订阅
+func (h *hub) releaseSignals() {
+	h.signalMu.Lock()
+	defer h.signalMu.Unlock()
+	h.users--
+	if h.users > 0 || !h.listening {
+		return
+	}
+	h.listening = false
+	h.signalCancel()
+	h.signalCancel = nil
+}
+
+// watchStop 监听流的停止信号, 收到信号时关闭返回的通道
+func (h *hub) watchStop(ctx context.Context, key string) (<-chan struct{}, error) {
+	if err := h.acquireSignals(); err != nil {
+		return nil, err
+	}
+	ch := make(chan struct{})
+	h.mu.Lock()
+	if h.watchers[key] == nil {
+		h.watchers[key] = make(map[chan struct{}]struct{})
+	}
+	h.watchers[key][ch] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		defer h.releaseSignals()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			h.mu.Lock()
+			if _, ok := h.watchers[key][ch]; ok {
+				delete(h.watchers[key], ch)
+				if len(h.watchers[key]) == 0 {
+					delete(h.watchers, key)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}()
+	return ch, nil
+}
+
+func (h *hub) publish(ctx context.Context, signal, key string) error {
+	return h.storage.Publish(ctx, signalChannel, fmt.Sprintf("%s:%s", signal, key))
+}
@@ -0,0 +1,143 @@
+package sseclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockReadLive(t *testing.T) {
+	client := New(WithBlockTimeout(50*time.Millisecond), WithHeartbeat(0))
+	ctx := context.Background()
+	streamID := "live"
+
+	_, err := client.WriteEvent(ctx, nil, streamID, &Event{Data: "first"})
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 5)
+	for i := range results {
+		results[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			ended, rows, err := client.ResumeRead(ctx, w, streamID, StartID)
+			assert.Nil(t, err)
+			assert.True(t, ended)
+			assert.Equal(t, 11, rows)
+		}(results[i])
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		_, err := client.WriteEvent(ctx, nil, streamID, &Event{Data: fmt.Sprintf("msg%d", i)})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, client.Finish(ctx, streamID))
+	wg.Wait()
+
+	for _, w := range results {
+		body := w.Body.String()
+		assert.Contains(t, body, "data: first\n")
+		assert.Contains(t, body, "data: msg9\n")
+		assert.Contains(t, body, "event: end\n")
+	}
+}
+
+func TestBlockReadStop(t *testing.T) {
+	client := New(WithBlockTimeout(50*time.Millisecond), WithHeartbeat(0))
+	ctx := context.Background()
+	streamID := "stop"
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ended, _, err := client.BlockRead(ctx, httptest.NewRecorder(), streamID, StartID)
+		assert.Nil(t, err)
+		assert.True(t, ended)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, client.Stop(ctx, streamID))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reader not stopped")
+	}
+
+	stopped, err := client.WriteEvent(ctx, nil, streamID, &Event{Data: "late"})
+	assert.Nil(t, err)
+	assert.True(t, stopped)
+}
+
+func TestBlockReadIdleTimeout(t *testing.T) {
+	client := New(WithBlockTimeout(20*time.Millisecond), WithIdleTimeout(100*time.Millisecond), WithHeartbeat(30*time.Millisecond))
+	w := httptest.NewRecorder()
+	ended, rows, err := client.ResumeRead(context.Background(), w, "idle", StartID)
+	assert.Nil(t, err)
+	assert.True(t, ended)
+	assert.Equal(t, 0, rows)
+	assert.True(t, bytes.Contains(w.Body.Bytes(), []byte(": heartbeat\n\n")))
+}
+
+func TestBlockReadClosed(t *testing.T) {
+	client := New(WithBlockTimeout(50*time.Millisecond), WithHeartbeat(0))
+	ctx := context.Background()
+	streamID := "closed"
+
+	_, err := client.WriteEvent(ctx, nil, streamID, &Event{Data: "first"})
+	assert.Nil(t, err)
+	assert.Nil(t, client.Close(ctx, streamID))
+
+	// 流关闭后到达的读取方立即退出, 不等待空闲超时
+	start := time.Now()
+	ended, rows, err := client.BlockRead(ctx, httptest.NewRecorder(), streamID, StartID)
+	assert.Nil(t, err)
+	assert.True(t, ended)
+	assert.Equal(t, 0, rows)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestBlockReadMaxRetry(t *testing.T) {
+	// 未调用 Finish 的写入方, 读取方在 blockMaxRetry * blockTimeout 后退出
+	client := New(WithBlockTimeout(20*time.Millisecond), WithBlockMaxRetry(3), WithHeartbeat(0))
+	assert.Equal(t, 60*time.Millisecond, client.config.idleTimeout)
+	assert.Equal(t, defaultIdleTimeout, New().config.idleTimeout)
+}
+
+func TestHubReleaseSignals(t *testing.T) {
+	client := New(WithBlockTimeout(20*time.Millisecond), WithHeartbeat(0))
+	mc := client.storage.(*memoryCache)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.BlockRead(ctx, httptest.NewRecorder(), "release", StartID)
+	}()
+	_, err := client.StopNotify(ctx, "release")
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	mc.mu.RLock()
+	assert.Len(t, mc.subscribers[signalChannel], 1)
+	mc.mu.RUnlock()
+
+	// 读取方和监听方全部退出后关闭信号订阅
+	cancel()
+	<-done
+	assert.Eventually(t, func() bool {
+		mc.mu.RLock()
+		defer mc.mu.RUnlock()
+		return len(mc.subscribers[signalChannel]) == 0
+	}, time.Second, 10*time.Millisecond)
+	client.hub.signalMu.Lock()
+	assert.Equal(t, 0, client.hub.users)
+	assert.False(t, client.hub.listening)
+	client.hub.signalMu.Unlock()
+}
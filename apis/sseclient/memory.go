@@ -10,90 +10,187 @@ import (
 
 type memoryCache struct {
 	dataMap        map[string]memoryDataItem
-	signals        map[string]bool
+	signals        map[string]time.Time
+	waiters        map[string]chan struct{}
+	subscribers    map[string][]chan string
 	writeKeyPrefix string
 	stopKeyPrefix  string
 	mu             sync.RWMutex
 }
 
+// memoryDataItem 消息ID为从1开始的序号, StartID(0) 表示从头读取
 type memoryDataItem struct {
-	list    []string
+	list    []*Event
 	expired time.Time
 }
 
 func newMemoryCache(writeKeyPrefix, stopKeyPrefix string) *memoryCache {
 	return &memoryCache{
 		dataMap:        make(map[string]memoryDataItem),
-		signals:        make(map[string]bool),
+		signals:        make(map[string]time.Time),
+		waiters:        make(map[string]chan struct{}),
+		subscribers:    make(map[string][]chan string),
 		writeKeyPrefix: writeKeyPrefix,
 		stopKeyPrefix:  stopKeyPrefix,
 	}
 }
 
-func (m *memoryCache) WriteMessage(ctx context.Context, key, msg string, expiration time.Duration) error {
+func (m *memoryCache) WriteMessage(ctx context.Context, key string, ev *Event, expiration time.Duration) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	list := m.dataMap[key].list
-	list = append(list, msg)
+	id := strconv.Itoa(len(list) + 1)
+	list = append(list, &Event{ID: id, Event: ev.Event, Data: ev.Data})
 	m.dataMap[key] = memoryDataItem{
 		list:    list,
 		expired: time.Now().Add(expiration),
 	}
-	return nil
+	if waiter, ok := m.waiters[key]; ok {
+		close(waiter)
+		delete(m.waiters, key)
+	}
+	return id, nil
+}
+
+func (m *memoryCache) ReadMessages(ctx context.Context, key string) (string, []*Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := m.list(key)
+	if len(list) == 0 {
+		return "", nil, nil
+	}
+	return strconv.Itoa(len(list)), copyEvents(list, 0, len(list)), nil
+}
+
+func (m *memoryCache) ReadRange(ctx context.Context, key, startID, endID string) ([]*Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := m.list(key)
+	start, _ := strconv.Atoi(startID)
+	end := len(list)
+	if endID != "" {
+		end, _ = strconv.Atoi(endID)
+	}
+	return copyEvents(list, start, end), nil
 }
 
-func (m *memoryCache) ReadMessages(ctx context.Context, key string) (string, []string, error) {
+func (m *memoryCache) ReadBlock(ctx context.Context, key, id string, count int64, timeout time.Duration) ([]*Event, error) {
+	idx, _ := strconv.Atoi(id)
+	m.mu.Lock()
+	if list := m.list(key); idx < len(list) {
+		defer m.mu.Unlock()
+		return copyEvents(list, idx, idx+int(count)), nil
+	}
+	waiter, ok := m.waiters[key]
+	if !ok {
+		waiter = make(chan struct{})
+		m.waiters[key] = waiter
+	}
+	m.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, nil
+	case <-waiter:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyEvents(m.list(key), idx, idx+int(count)), nil
+}
+
+func (m *memoryCache) LatestID(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return strconv.Itoa(len(m.list(key))), nil
+}
+
+func (m *memoryCache) Publish(ctx context.Context, channel, payload string) error {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-
-	if data, exists := m.dataMap[key]; exists {
-		if data.expired.Before(time.Now()) {
-			delete(m.dataMap, key)
-			stoppedKey := strings.ReplaceAll(key, m.writeKeyPrefix, m.stopKeyPrefix)
-			delete(m.signals, stoppedKey)
-			return "", nil, nil
+	for _, ch := range m.subscribers[channel] {
+		select {
+		case ch <- payload:
+		default:
 		}
-		n := len(data.list)
-		result := make([]string, n)
-		copy(result, data.list)
-		return strconv.Itoa(n - 1), result, nil
 	}
-	return "", nil, nil
+	return nil
 }
 
-func (m *memoryCache) ReadAfterID(ctx context.Context, key, id string) (string, string, error) {
+func (m *memoryCache) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	ch := make(chan string, 64)
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.subscribers[channel] = append(m.subscribers[channel], ch)
+	m.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		subs := m.subscribers[channel]
+		for i, c := range subs {
+			if c == ch {
+				m.subscribers[channel] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// list 返回未过期的消息列表, 调用方需持有写锁
+func (m *memoryCache) list(key string) []*Event {
 	data, exists := m.dataMap[key]
 	if !exists {
-		return "", "", nil
+		return nil
 	}
-
 	if data.expired.Before(time.Now()) {
-		delete(m.dataMap, key)
-		stoppedKey := strings.ReplaceAll(key, m.writeKeyPrefix, m.stopKeyPrefix)
-		delete(m.signals, stoppedKey)
-		return "", "", nil
+		m.expire(key)
+		return nil
 	}
+	return data.list
+}
 
-	n := len(data.list)
-	idx, _ := strconv.Atoi(id)
-	if idx+1 >= n {
-		return "", "", nil
+func copyEvents(list []*Event, start, end int) []*Event {
+	if start < 0 {
+		start = 0
 	}
-	return strconv.Itoa(idx + 1), data.list[idx+1], nil
+	if end > len(list) {
+		end = len(list)
+	}
+	if start >= end {
+		return nil
+	}
+	result := make([]*Event, 0, end-start)
+	for _, ev := range list[start:end] {
+		cp := *ev
+		result = append(result, &cp)
+	}
+	return result
 }
 
 func (m *memoryCache) Set(ctx context.Context, key string, expiration time.Duration) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.signals[key] = true
+	m.signals[key] = time.Now().Add(expiration)
 	return nil
 }
 
 func (m *memoryCache) Exist(ctx context.Context, key string) (bool, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if expired, ok := m.signals[key]; ok {
+		if expired.Before(time.Now()) {
+			delete(m.signals, key)
+			return false, nil
+		}
+		return true, nil
+	}
 	_, exists := m.dataMap[key]
 	return exists, nil
 }
@@ -104,3 +201,9 @@ func (m *memoryCache) Delete(ctx context.Context, key string) error {
 	delete(m.dataMap, key)
 	return nil
 }
+
+func (m *memoryCache) expire(key string) {
+	delete(m.dataMap, key)
+	stoppedKey := strings.ReplaceAll(key, m.writeKeyPrefix, m.stopKeyPrefix)
+	delete(m.signals, stoppedKey)
+}
@@ -9,39 +9,40 @@ import (
 )
 
 type redisCache struct {
-	rdb          *redis.Client
-	blockTimeout time.Duration
+	rdb *redis.Client
 }
 
-func newRedisCache(rdb *redis.Client, blockTimeout time.Duration) *redisCache {
+func newRedisCache(rdb *redis.Client) *redisCache {
 	return &redisCache{
-		rdb:          rdb,
-		blockTimeout: blockTimeout,
+		rdb: rdb,
 	}
 }
 
-func (r *redisCache) WriteMessage(ctx context.Context, key, msg string, expiration time.Duration) error {
-	args := &redis.XAddArgs{
-		Stream: key,
-		ID:     "*",
-		Values: map[string]interface{}{
-			"data": msg,
-		},
+func (r *redisCache) WriteMessage(ctx context.Context, key string, ev *Event, expiration time.Duration) (string, error) {
+	values := map[string]interface{}{
+		"data": ev.Data,
 	}
-
-	_, err := r.rdb.XAdd(ctx, args).Result()
-	if err != nil {
-		return fmt.Errorf("failed to add message to redis stream, err: %v, key:%s, msg:%s", err, key, msg)
+	if ev.Event != "" {
+		values["event"] = ev.Event
 	}
 
-	if _, err := r.rdb.Expire(ctx, key, expiration).Result(); err != nil {
-		return fmt.Errorf("failed to set expiration for redis stream, err: %v, key:%s", err, key)
+	var addCmd *redis.StringCmd
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		addCmd = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			ID:     "*",
+			Values: values,
+		})
+		pipe.Expire(ctx, key, expiration)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to add message to redis stream, err: %v, key:%s, msg:%s", err, key, ev.Data)
 	}
-
-	return nil
+	return addCmd.Val(), nil
 }
 
-func (r *redisCache) ReadMessages(ctx context.Context, key string) (string, []string, error) {
+func (r *redisCache) ReadMessages(ctx context.Context, key string) (string, []*Event, error) {
 
 	// 获取最新的 id
 	latestResList, getLatestErr := r.rdb.XRevRangeN(ctx, key, "+", "-", 1).Result()
@@ -59,43 +60,117 @@ func (r *redisCache) ReadMessages(ctx context.Context, key string) (string, []st
 		return "", nil, fmt.Errorf("failed to read from redis stream, err: %v, key: %s", getListErr, key)
 	}
 
-	var messages []string
+	messages := make([]*Event, 0, len(resList))
 	for _, v := range resList {
-		if dataVal, ok := v.Values["data"]; ok {
-			if str, ok := dataVal.(string); ok {
-				messages = append(messages, str)
-			}
-		}
+		messages = append(messages, xMessageToEvent(v))
 	}
 
 	return latestID, messages, nil
 }
 
-func (r *redisCache) ReadAfterID(ctx context.Context, key, id string) (string, string, error) {
+func (r *redisCache) ReadRange(ctx context.Context, key, startID, endID string) ([]*Event, error) {
+	start := startID
+	if start == "" || start == StartID {
+		start = "-"
+	}
+	end := endID
+	if end == "" {
+		end = "+"
+	}
+	resList, err := r.rdb.XRange(ctx, key, start, end).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from redis stream, err: %v, key: %s", err, key)
+	}
+	messages := make([]*Event, 0, len(resList))
+	for _, v := range resList {
+		// XRANGE 的起始ID是闭区间, 跳过已读取的消息
+		if v.ID == startID {
+			continue
+		}
+		messages = append(messages, xMessageToEvent(v))
+	}
+	return messages, nil
+}
+
+func (r *redisCache) ReadBlock(ctx context.Context, key, id string, count int64, timeout time.Duration) ([]*Event, error) {
 	res, err := r.rdb.XRead(ctx, &redis.XReadArgs{
 		Streams: []string{key, id},
-		Block:   r.blockTimeout,
-		Count:   1,
+		Block:   timeout,
+		Count:   count,
 	}).Result()
 	if err != nil {
 		if err == redis.Nil {
-			return "", "", nil
+			return nil, nil
 		}
-		return "", "", fmt.Errorf("failed to read from redis stream, err: %v, key: %s, id:%s", err, key, id)
+		return nil, fmt.Errorf("failed to read from redis stream, err: %v, key: %s, id:%s", err, key, id)
 	}
-	var msg string
-	var msgID string
+	var messages []*Event
 	for _, v := range res {
 		for _, msgVal := range v.Messages {
-			msgID = msgVal.ID
-			if dataVal, ok := msgVal.Values["data"]; ok {
-				if str, ok := dataVal.(string); ok {
-					msg = str
+			messages = append(messages, xMessageToEvent(msgVal))
+		}
+	}
+	return messages, nil
+}
+
+func (r *redisCache) LatestID(ctx context.Context, key string) (string, error) {
+	resList, err := r.rdb.XRevRangeN(ctx, key, "+", "-", 1).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get latest id, err: %v, key: %s", err, key)
+	}
+	if len(resList) == 0 {
+		return StartID, nil
+	}
+	return resList[0].ID, nil
+}
+
+func (r *redisCache) Publish(ctx context.Context, channel, payload string) error {
+	if err := r.rdb.Publish(ctx, channel, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish signal, err: %v, channel:%s", err, channel)
+	}
+	return nil
+}
+
+func (r *redisCache) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	ps := r.rdb.Subscribe(ctx, channel)
+	// 等待订阅确认, 确保返回后发布的信号不会丢失
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, fmt.Errorf("failed to subscribe signal, err: %v, channel:%s", err, channel)
+	}
+	ch := make(chan string, 64)
+	go func() {
+		defer close(ch)
+		defer ps.Close()
+		msgCh := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgCh:
+				if !ok {
+					return
+				}
+				select {
+				case ch <- msg.Payload:
+				case <-ctx.Done():
+					return
 				}
 			}
 		}
+	}()
+	return ch, nil
+}
+
+func xMessageToEvent(msg redis.XMessage) *Event {
+	ev := &Event{ID: msg.ID}
+	if str, ok := msg.Values["data"].(string); ok {
+		ev.Data = str
+	}
+	if str, ok := msg.Values["event"].(string); ok {
+		ev.Event = str
 	}
-	return msgID, msg, nil
+	return ev
 }
 
 func (r *redisCache) Set(ctx context.Context, key string, expiration time.Duration) error {
@@ -1,6 +1,9 @@
 package upload
 
-import "github.com/ygpkg/yg-go/apis/apiobj"
+import (
+	"github.com/ygpkg/yg-go/apis/apiobj"
+	"github.com/ygpkg/yg-go/storage/quota"
+)
 
 type UploadImageResponse struct {
 	apiobj.BaseResponse
@@ -17,4 +20,15 @@ type FileInfo struct {
 	URL string `json:"url,omitempty"`
 	// Filename 原始文件名
 	Filename string `json:"filename,omitempty"`
+	// Status 文件状态, pending_scan 时扫描完成前不返回访问地址
+	Status string `json:"status,omitempty"`
+	// Variants 图片变体的访问地址, key 为变体名
+	Variants map[string]string `json:"variants,omitempty"`
+}
+
+// StorageUsageResponse 存储用量
+type StorageUsageResponse struct {
+	apiobj.BaseResponse
+
+	Response *quota.UsageReport
 }
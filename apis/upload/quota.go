@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygpkg/yg-go/apis/errcode"
+	"github.com/ygpkg/yg-go/apis/runtime"
+	"github.com/ygpkg/yg-go/storage/quota"
+	"github.com/ygpkg/yg-go/storage/uploadpolicy"
+	"gorm.io/gorm"
+)
+
+// GetStorageUsage 获取当前公司和用户的存储用量及限制
+func GetStorageUsage(ctx *gin.Context, db *gorm.DB) {
+	var (
+		logger    = runtime.Logger(ctx)
+		companyID = runtime.CompanyID(ctx)
+		uin       = runtime.Uin(ctx)
+	)
+	usage, err := quota.GetUsage(db, companyID, uin)
+	if err != nil {
+		logger.Errorf("get storage usage error: %v", err)
+		runtime.InternalError(ctx, "服务器错误")
+		return
+	}
+	ctx.JSON(200, &StorageUsageResponse{Response: usage})
+}
+
+// ResponseUploadError 返回上传失败的响应, 分片上传的初始化和合并也应使用:
+// 超出存储空间返回 ErrCode_StorageQuotaExceeded, 不满足上传策略返回 400, 其他错误返回 500
+func ResponseUploadError(ctx *gin.Context, err error) {
+	logger := runtime.Logger(ctx)
+	switch {
+	case errors.Is(err, quota.ErrQuotaExceeded):
+		logger.Warnf("upload error: %v", err)
+		runtime.BadRequestWithCode(ctx, errcode.ErrCode_StorageQuotaExceeded, errcode.GetMessage(errcode.ErrCode_StorageQuotaExceeded))
+	case errors.Is(err, uploadpolicy.ErrRejected):
+		logger.Warnf("upload error: %v", err)
+		runtime.BadRequest(ctx, "文件类型或大小不符合要求")
+	default:
+		logger.Errorf("upload error: %v", err)
+		runtime.InternalError(ctx, "服务器错误")
+	}
+}
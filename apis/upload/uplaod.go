@@ -1,12 +1,16 @@
 package upload
 
 import (
-	"path/filepath"
+	"errors"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ygpkg/yg-go/apis/runtime"
 	"github.com/ygpkg/yg-go/storage"
+	"github.com/ygpkg/yg-go/storage/imaging"
+	"github.com/ygpkg/yg-go/storage/quota"
+	"github.com/ygpkg/yg-go/storage/uploadpolicy"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -25,15 +29,48 @@ func UploadUserImage(ctx *gin.Context, db *gorm.DB, purpose string) {
 	}
 	defer f.Close()
 
-	ext := strings.ToLower(filepath.Ext(fh.Filename))
+	policy, err := uploadpolicy.Load(purpose)
+	if err != nil {
+		logger.Errorf("upload image error: %v", err)
+		runtime.InternalError(ctx, "服务器错误")
+		return
+	}
+	if err := policy.CheckSize(fh.Size); err != nil {
+		logger.Warnf("upload image error: %v", err)
+		runtime.BadRequest(ctx, "文件类型或大小不符合要求")
+		return
+	}
+	qkey := quota.Key{CompanyID: companyID, Uin: uin, Purpose: purpose}
+	if err := quota.Check(db, qkey, fh.Size); err != nil {
+		ResponseUploadError(ctx, err)
+		return
+	}
+	detected, r, err := policy.Check(f)
+	if err != nil {
+		logger.Warnf("upload image error: %v", err)
+		runtime.BadRequest(ctx, "文件类型或大小不符合要求")
+		return
+	}
+	if !strings.HasPrefix(detected.MIMEType, "image/") {
+		logger.Warnf("upload image error: content type %s is not image", detected.MIMEType)
+		runtime.BadRequest(ctx, "文件类型或大小不符合要求")
+		return
+	}
+
+	ext := uploadpolicy.FileExt(detected, fh.Filename)
 	fi := &storage.FileInfo{
 		Purpose:     purpose,
 		CompanyID:   companyID,
 		Uin:         uin,
-		Filename:    fh.Filename,
+		Filename:    uploadpolicy.SanitizeFilename(fh.Filename),
+		MIMEType:    detected.MIMEType,
 		Size:        fh.Size,
 		FileExt:     ext,
 		StoragePath: "/" + storage.GenerateFileStoragePath(purpose, uin, ext),
+		Status:      storage.FileStatusNormal,
+	}
+	if policy.NeedScan() {
+		fi.Status = storage.FileStatusPendingScan
 	}
 
 	st, err := storage.LoadStorager(purpose)
@@ -42,15 +79,40 @@ func UploadUserImage(ctx *gin.Context, db *gorm.DB, purpose string) {
 		runtime.InternalError(ctx, "服务器错误")
 		return
 	}
-	err = st.Save(ctx, fi, f)
+	err = storage.SaveImage(ctx, st, fi, r)
+	if errors.Is(err, uploadpolicy.ErrRejected) {
+		logger.Warnf("upload image error: %v", err)
+		runtime.BadRequest(ctx, "文件类型或大小不符合要求")
+		return
+	}
+	if errors.Is(err, imaging.ErrInvalidImage) {
+		logger.Warnf("upload image error: %v", err)
+		runtime.BadRequest(ctx, "图片格式或尺寸不符合要求")
+		return
+	}
 	if err != nil {
 		logger.Errorf("upload image error: %v", err)
 		runtime.InternalError(ctx, "服务器错误")
 		return
 	}
+	// 扫描完成前不保存访问地址, 扫描通过后由扫描任务补上
 	fi.PublicURL = st.GetPublicURL(fi.StoragePath, false)
+	if fi.Status == storage.FileStatusPendingScan {
+		fi.PublicURL = ""
+		if fi.Extra, err = withholdVariantURLs(fi.Extra); err != nil {
+			logger.Errorf("upload image error: %v", err)
+			runtime.InternalError(ctx, "服务器错误")
+			return
+		}
+	}
 
-	if err := db.Create(fi).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(fi).Error; err != nil {
+			return err
+		}
+		return quota.Add(tx, qkey, fi.Size)
+	})
+	if err != nil {
 		logger.Errorf("upload image error: %v", err)
 		runtime.InternalError(ctx, "服务器错误")
 		return
@@ -60,7 +122,26 @@ func UploadUserImage(ctx *gin.Context, db *gorm.DB, purpose string) {
 		Response: FileInfo{
 			FileID: fi.ID,
 			URL:    fi.PublicURL,
+			Status: string(fi.Status),
 		},
 	}
+	if img := imaging.GetImageExtra(fi.Extra); img != nil && len(img.Variants) > 0 && fi.PublicURL != "" {
+		resp.Response.Variants = make(map[string]string, len(img.Variants))
+		for name, v := range img.Variants {
+			resp.Response.Variants[name] = v.URL
+		}
+	}
 	ctx.JSON(200, resp)
 }
+
+// withholdVariantURLs 清空 Extra 中图片变体的访问地址
+func withholdVariantURLs(extra datatypes.JSON) (datatypes.JSON, error) {
+	img := imaging.GetImageExtra(extra)
+	if img == nil || len(img.Variants) == 0 {
+		return extra, nil
+	}
+	for _, v := range img.Variants {
+		v.URL = ""
+	}
+	return imaging.SetImageExtra(extra, img)
+}
@@ -2,11 +2,19 @@ package cachetype
 
 import (
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/ygpkg/yg-go/logs"
 )
 
+var (
+	// ErrCacheMiss key 不存在或已过期
+	ErrCacheMiss = errors.New("cache: key not found")
+	// ErrTagsUnsupported 缓存后端不支持标签
+	ErrTagsUnsupported = errors.New("cache: tags unsupported")
+)
+
 // Cache interface
 type Cache interface {
 	Get(key string, val interface{}) error
@@ -15,6 +23,15 @@ type Cache interface {
 	Delete(key string) error
 }
 
+// TagCache 支持标签的缓存, 写入时可以给 key 打标签, 按标签批量失效
+type TagCache interface {
+	Cache
+	// SetWithTags 写入并给 key 打上标签, 覆盖写时以最后一次的标签为准
+	SetWithTags(key string, val interface{}, timeout time.Duration, tags ...string) error
+	// InvalidateTag 删除所有带有该标签的 key
+	InvalidateTag(tag string) error
+}
+
 // Marshal json marshal
 func Marshal(val interface{}) string {
 	bs, err := json.Marshal(val)
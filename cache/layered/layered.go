@@ -0,0 +1,225 @@
+package layered
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ygpkg/yg-go/cache/cachetype"
+	"github.com/ygpkg/yg-go/cache/memory"
+	"github.com/ygpkg/yg-go/logs"
+)
+
+var _ cachetype.TagCache = (*Cache)(nil)
+
+const (
+	defaultL1TTL          = 10 * time.Second
+	defaultPublishTimeout = 3 * time.Second
+	resubscribeInterval   = time.Second
+)
+
+// Bus 跨实例广播失效消息
+type Bus interface {
+	Publish(ctx context.Context, msg string) error
+	// Subscribe 订阅失效消息, 连接断开时关闭返回的 channel
+	Subscribe(ctx context.Context) (<-chan string, error)
+}
+
+type options struct {
+	l1TTL      time.Duration
+	memoryOpts []memory.Option
+}
+
+// Option 两级缓存配置项
+type Option func(*options)
+
+// WithL1TTL 设置本地缓存的最长有效期, 即其他实例失效消息丢失时的最大不一致时间, 默认 10 秒
+func WithL1TTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.l1TTL = ttl
+	}
+}
+
+// WithMemoryOptions 设置本地缓存的容量、淘汰策略等
+func WithMemoryOptions(opts ...memory.Option) Option {
+	return func(o *options) {
+		o.memoryOpts = append(o.memoryOpts, opts...)
+	}
+}
+
+// invalidation 失效消息
+type invalidation struct {
+	Node string   `json:"n"`
+	Keys []string `json:"k,omitempty"`
+	Tags []string `json:"t,omitempty"`
+}
+
+// Cache 两级缓存, 先读本地内存(L1)再读共享缓存(L2).
+// 写入和删除会通过 Bus 通知其他实例删除本地缓存, 订阅断开期间无法收到通知,
+// 重新订阅时会清空本地缓存, L1 的短 TTL 兜底消息丢失时的不一致
+type Cache struct {
+	l1   *memory.Memory
+	l2   cachetype.Cache
+	bus  Bus
+	opts options
+	node string
+
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// NewCache 创建两级缓存, 并在后台订阅失效消息, 不再使用时需要调用 Close
+func NewCache(l2 cachetype.Cache, bus Bus, opts ...Option) *Cache {
+	o := options{l1TTL: defaultL1TTL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Cache{
+		l1:     memory.NewCache(o.memoryOpts...),
+		l2:     l2,
+		bus:    bus,
+		opts:   o,
+		node:   uuid.NewString(),
+		cancel: cancel,
+	}
+	go c.subscribe(ctx)
+	return c
+}
+
+// Get 先读本地缓存, 未命中时读共享缓存并回填本地缓存
+func (c *Cache) Get(key string, val interface{}) error {
+	var raw json.RawMessage
+	if err := c.l1.Get(key, &raw); err == nil {
+		return cachetype.Unmarshal(raw, val)
+	}
+	if err := c.l2.Get(key, &raw); err != nil {
+		return err
+	}
+	c.l1.Set(key, raw, c.opts.l1TTL)
+	return cachetype.Unmarshal(raw, val)
+}
+
+// Set 写入两级缓存并通知其他实例
+func (c *Cache) Set(key string, val interface{}, timeout time.Duration) error {
+	if err := c.l2.Set(key, val, timeout); err != nil {
+		return err
+	}
+	l1TTL := c.opts.l1TTL
+	if timeout > 0 && timeout < l1TTL {
+		l1TTL = timeout
+	}
+	c.l1.Set(key, val, l1TTL)
+	c.publish(invalidation{Keys: []string{key}})
+	return nil
+}
+
+// SetWithTags 写入并给 key 打标签, L2 需要支持标签.
+// 本地缓存不记录标签, 收到标签失效时直接清空本地缓存
+func (c *Cache) SetWithTags(key string, val interface{}, timeout time.Duration, tags ...string) error {
+	if len(tags) == 0 {
+		return c.Set(key, val, timeout)
+	}
+	tc, ok := c.l2.(cachetype.TagCache)
+	if !ok {
+		return cachetype.ErrTagsUnsupported
+	}
+	if err := tc.SetWithTags(key, val, timeout, tags...); err != nil {
+		return err
+	}
+	c.l1.Delete(key)
+	c.publish(invalidation{Keys: []string{key}})
+	return nil
+}
+
+// InvalidateTag 删除 L2 中带有该标签的 key, 并清空所有实例的本地缓存
+func (c *Cache) InvalidateTag(tag string) error {
+	tc, ok := c.l2.(cachetype.TagCache)
+	if !ok {
+		return cachetype.ErrTagsUnsupported
+	}
+	if err := tc.InvalidateTag(tag); err != nil {
+		return err
+	}
+	c.l1.Purge()
+	c.publish(invalidation{Tags: []string{tag}})
+	return nil
+}
+
+// IsExist 判断 key 是否存在
+func (c *Cache) IsExist(key string) bool {
+	return c.l1.IsExist(key) || c.l2.IsExist(key)
+}
+
+// Delete 删除两级缓存并通知其他实例
+func (c *Cache) Delete(key string) error {
+	c.l1.Delete(key)
+	err := c.l2.Delete(key)
+	c.publish(invalidation{Keys: []string{key}})
+	return err
+}
+
+// Close 停止订阅并释放本地缓存
+func (c *Cache) Close() error {
+	c.once.Do(func() {
+		c.cancel()
+		c.l1.Close()
+	})
+	return nil
+}
+
+func (c *Cache) publish(inv invalidation) {
+	inv.Node = c.node
+	msg, err := json.Marshal(inv)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultPublishTimeout)
+	defer cancel()
+	if err := c.bus.Publish(ctx, string(msg)); err != nil {
+		logs.Warnf("[layered_cache] publish invalidation %s failed, %s", msg, err)
+	}
+}
+
+// subscribe 持续订阅失效消息, 断开后清空本地缓存并重新订阅
+func (c *Cache) subscribe(ctx context.Context) {
+	for {
+		ch, err := c.bus.Subscribe(ctx)
+		if err != nil {
+			logs.Warnf("[layered_cache] subscribe failed, %s", err)
+		} else {
+			// 订阅建立前的消息可能已丢失
+			c.l1.Purge()
+			for msg := range ch {
+				c.handle(msg)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resubscribeInterval):
+		}
+	}
+}
+
+func (c *Cache) handle(msg string) {
+	var inv invalidation
+	if err := json.Unmarshal([]byte(msg), &inv); err != nil {
+		logs.Warnf("[layered_cache] invalid message %q, %s", msg, err)
+		return
+	}
+	if inv.Node == c.node {
+		return
+	}
+	if len(inv.Tags) > 0 {
+		c.l1.Purge()
+		return
+	}
+	for _, key := range inv.Keys {
+		c.l1.Delete(key)
+	}
+}
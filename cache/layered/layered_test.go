@@ -0,0 +1,118 @@
+package layered
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/cache/memory"
+)
+
+// localBus 进程内的 Bus
+type localBus struct {
+	mu   sync.Mutex
+	subs []chan string
+}
+
+func (b *localBus) Publish(ctx context.Context, msg string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		ch <- msg
+	}
+	return nil
+}
+
+func (b *localBus) Subscribe(ctx context.Context) (<-chan string, error) {
+	ch := make(chan string, 64)
+	b.mu.Lock()
+	b.subs = append(b.subs, ch)
+	b.mu.Unlock()
+	return ch, nil
+}
+
+// drop 模拟连接断开
+func (b *localBus) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		close(ch)
+	}
+	b.subs = nil
+}
+
+func (b *localBus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func TestInvalidateAcrossInstances(t *testing.T) {
+	l2 := memory.NewCache()
+	defer l2.Close()
+	bus := &localBus{}
+	a := NewCache(l2, bus, WithL1TTL(time.Minute))
+	defer a.Close()
+	b := NewCache(l2, bus, WithL1TTL(time.Minute))
+	defer b.Close()
+	assert.Eventually(t, func() bool { return bus.subscribers() == 2 }, time.Second, time.Millisecond)
+
+	var v string
+	assert.NoError(t, a.Set("k", "v1", time.Minute))
+	assert.NoError(t, b.Get("k", &v))
+	assert.Equal(t, "v1", v)
+
+	// b 的 L1 已缓存 v1, a 更新后 b 应立即读到新值
+	assert.NoError(t, a.Set("k", "v2", time.Minute))
+	assert.Eventually(t, func() bool {
+		return b.Get("k", &v) == nil && v == "v2"
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, a.Delete("k"))
+	assert.Eventually(t, func() bool {
+		return b.Get("k", &v) != nil
+	}, time.Second, time.Millisecond)
+}
+
+func TestPurgeOnResubscribe(t *testing.T) {
+	l2 := memory.NewCache()
+	defer l2.Close()
+	bus := &localBus{}
+	c := NewCache(l2, bus, WithL1TTL(time.Minute))
+	defer c.Close()
+	assert.Eventually(t, func() bool { return bus.subscribers() == 1 }, time.Second, time.Millisecond)
+
+	var v int
+	assert.NoError(t, c.Set("k", 1, 0))
+	// 绕过 Bus 直接修改 L2, 相当于丢失了失效消息
+	assert.NoError(t, l2.Set("k", 2, 0))
+	assert.NoError(t, c.Get("k", &v))
+	assert.Equal(t, 1, v)
+
+	bus.drop()
+	assert.Eventually(t, func() bool {
+		return bus.subscribers() == 1 && c.Get("k", &v) == nil && v == 2
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestInvalidateTag(t *testing.T) {
+	l2 := memory.NewCache()
+	defer l2.Close()
+	bus := &localBus{}
+	a := NewCache(l2, bus, WithL1TTL(time.Minute))
+	defer a.Close()
+	b := NewCache(l2, bus, WithL1TTL(time.Minute))
+	defer b.Close()
+	assert.Eventually(t, func() bool { return bus.subscribers() == 2 }, time.Second, time.Millisecond)
+
+	var v int
+	assert.NoError(t, a.SetWithTags("company:42:user:1", 1, time.Minute, "company:42"))
+	assert.NoError(t, b.Get("company:42:user:1", &v))
+
+	assert.NoError(t, a.InvalidateTag("company:42"))
+	assert.Eventually(t, func() bool {
+		return b.Get("company:42:user:1", &v) != nil
+	}, time.Second, time.Millisecond)
+}
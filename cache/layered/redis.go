@@ -0,0 +1,60 @@
+package layered
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	cacheredis "github.com/ygpkg/yg-go/cache/redis"
+)
+
+// DefaultChannel 默认的失效消息频道
+const DefaultChannel = "cache_invalidate"
+
+// NewRedisCache 创建以 redis 为 L2 的两级缓存, 失效消息通过 redis pub/sub 广播
+func NewRedisCache(rdb redis.UniversalClient, opts ...Option) *Cache {
+	l2 := &cacheredis.Redis{}
+	l2.SetContext(context.Background())
+	l2.SetConn(rdb)
+	return NewCache(l2, NewRedisBus(rdb, DefaultChannel), opts...)
+}
+
+type redisBus struct {
+	rdb     redis.UniversalClient
+	channel string
+}
+
+// NewRedisBus 基于 redis pub/sub 的 Bus
+func NewRedisBus(rdb redis.UniversalClient, channel string) Bus {
+	return &redisBus{rdb: rdb, channel: channel}
+}
+
+func (b *redisBus) Publish(ctx context.Context, msg string) error {
+	return b.rdb.Publish(ctx, b.channel, msg).Err()
+}
+
+func (b *redisBus) Subscribe(ctx context.Context) (<-chan string, error) {
+	ps := b.rdb.Subscribe(ctx, b.channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, fmt.Errorf("failed to subscribe %s, err: %v", b.channel, err)
+	}
+	ch := make(chan string, 64)
+	go func() {
+		defer close(ch)
+		defer ps.Close()
+		for {
+			// 不使用 ps.Channel(), 它会静默重连, 无法得知期间是否丢失了消息
+			msg, err := ps.ReceiveMessage(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- msg.Payload:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ygpkg/yg-go/cache/cachetype"
+	"github.com/ygpkg/yg-go/logs"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound 加载函数返回该错误时, 如果开启了负缓存, 会缓存"不存在"的结果
+var ErrNotFound = errors.New("cache: not found")
+
+// LoadFunc 缓存未命中时的加载函数
+type LoadFunc[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+type loaderOptions struct {
+	jitter        float64
+	negativeTTL   time.Duration
+	notFoundErrs  []error
+	refreshAhead  time.Duration
+	loadTimeout   time.Duration
+	keyFormatFunc func(key interface{}) string
+}
+
+// LoaderOption Loader 配置项
+type LoaderOption func(*loaderOptions)
+
+// WithJitter 在 TTL 上增加 ±fraction 的随机抖动, 避免大量 key 同时过期
+func WithJitter(fraction float64) LoaderOption {
+	return func(o *loaderOptions) {
+		o.jitter = fraction
+	}
+}
+
+// WithNegativeCache 开启负缓存, 加载函数返回的错误匹配 errs 中任意一个(errors.Is)时缓存 ttl,
+// 期间命中时返回 errs[0]; errs 为空时使用 ErrNotFound
+func WithNegativeCache(ttl time.Duration, errs ...error) LoaderOption {
+	return func(o *loaderOptions) {
+		o.negativeTTL = ttl
+		if len(errs) > 0 {
+			o.notFoundErrs = errs
+		}
+	}
+}
+
+// WithRefreshAhead 剩余有效期小于 window 时, 返回旧值并在后台提前刷新
+func WithRefreshAhead(window time.Duration) LoaderOption {
+	return func(o *loaderOptions) {
+		o.refreshAhead = window
+	}
+}
+
+// WithLoadTimeout 设置加载函数的超时时间, 默认 30 秒
+func WithLoadTimeout(timeout time.Duration) LoaderOption {
+	return func(o *loaderOptions) {
+		o.loadTimeout = timeout
+	}
+}
+
+// WithKeyFormat 自定义缓存 key 的格式化方式, 默认为 fmt.Sprint
+func WithKeyFormat(fn func(key interface{}) string) LoaderOption {
+	return func(o *loaderOptions) {
+		o.keyFormatFunc = fn
+	}
+}
+
+// loaderEntry 缓存中实际存储的内容
+type loaderEntry[V any] struct {
+	Value V `json:"v"`
+	// NotFound 负缓存标记
+	NotFound bool `json:"nf,omitempty"`
+	// RefreshAt 超过该时间(unix ms)后触发后台刷新
+	RefreshAt int64 `json:"ra"`
+}
+
+// Loader 带加载函数的类型化缓存, 在任意 cachetype.Cache 上实现 cache-aside:
+// 并发未命中通过 singleflight 合并为一次加载, 支持负缓存、TTL 抖动和提前刷新
+type Loader[K comparable, V any] struct {
+	c      cachetype.Cache
+	prefix string
+	ttl    time.Duration
+	opts   loaderOptions
+	group  singleflight.Group
+}
+
+// NewLoader 创建 Loader, prefix 作为缓存 key 的前缀, ttl 为值的有效期
+func NewLoader[K comparable, V any](c cachetype.Cache, prefix string, ttl time.Duration, opts ...LoaderOption) *Loader[K, V] {
+	l := &Loader[K, V]{
+		c:      c,
+		prefix: prefix,
+		ttl:    ttl,
+		opts: loaderOptions{
+			notFoundErrs: []error{ErrNotFound},
+			loadTimeout:  30 * time.Second,
+			keyFormatFunc: func(key interface{}) string {
+				return fmt.Sprint(key)
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(&l.opts)
+	}
+	return l
+}
+
+// GetOrLoad 获取缓存, 未命中时调用 loadFn 加载并写入缓存
+func (l *Loader[K, V]) GetOrLoad(ctx context.Context, key K, loadFn LoadFunc[K, V]) (V, error) {
+	cacheKey := l.cacheKey(key)
+
+	var entry loaderEntry[V]
+	if err := l.c.Get(cacheKey, &entry); err == nil && entry.RefreshAt > 0 {
+		if entry.NotFound {
+			var zero V
+			return zero, l.opts.notFoundErrs[0]
+		}
+		if l.opts.refreshAhead > 0 && time.Now().UnixMilli() >= entry.RefreshAt {
+			go l.refresh(ctx, key, cacheKey, loadFn)
+		}
+		return entry.Value, nil
+	}
+
+	ch := l.group.DoChan(cacheKey, func() (interface{}, error) {
+		return l.load(ctx, key, cacheKey, loadFn)
+	})
+	select {
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	case ret := <-ch:
+		if ret.Err != nil {
+			var zero V
+			return zero, ret.Err
+		}
+		// V 为接口或指针类型时, 加载结果为 nil 会使断言失败
+		val, _ := ret.Val.(V)
+		return val, nil
+	}
+}
+
+// Get 只读取缓存, 未命中时返回 ErrNotFound
+func (l *Loader[K, V]) Get(key K) (V, error) {
+	var entry loaderEntry[V]
+	if err := l.c.Get(l.cacheKey(key), &entry); err != nil || entry.RefreshAt == 0 || entry.NotFound {
+		var zero V
+		return zero, ErrNotFound
+	}
+	return entry.Value, nil
+}
+
+// Set 直接写入缓存
+func (l *Loader[K, V]) Set(key K, val V) error {
+	return l.store(l.cacheKey(key), loaderEntry[V]{Value: val}, l.ttl)
+}
+
+// Invalidate 删除缓存
+func (l *Loader[K, V]) Invalidate(key K) error {
+	return l.c.Delete(l.cacheKey(key))
+}
+
+// refresh 后台刷新, 同一个 key 同时只有一个刷新
+func (l *Loader[K, V]) refresh(ctx context.Context, key K, cacheKey string, loadFn LoadFunc[K, V]) {
+	_, err, _ := l.group.Do(cacheKey, func() (interface{}, error) {
+		return l.load(ctx, key, cacheKey, loadFn)
+	})
+	if err != nil && !l.isNotFound(err) {
+		logs.Warnf("[cache] refresh %s failed, %s", cacheKey, err)
+	}
+}
+
+// load 加载并写入缓存, 加载与调用方的取消解耦, 避免一个调用方取消导致其他等待者失败
+func (l *Loader[K, V]) load(ctx context.Context, key K, cacheKey string, loadFn LoadFunc[K, V]) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.opts.loadTimeout)
+	defer cancel()
+
+	val, err := loadFn(ctx, key)
+	if err != nil {
+		if l.opts.negativeTTL > 0 && l.isNotFound(err) {
+			if serr := l.store(cacheKey, loaderEntry[V]{NotFound: true}, l.opts.negativeTTL); serr != nil {
+				logs.Warnf("[cache] set negative %s failed, %s", cacheKey, serr)
+			}
+		}
+		return nil, err
+	}
+	if serr := l.store(cacheKey, loaderEntry[V]{Value: val}, l.ttl); serr != nil {
+		logs.Warnf("[cache] set %s failed, %s", cacheKey, serr)
+	}
+	return val, nil
+}
+
+func (l *Loader[K, V]) store(cacheKey string, entry loaderEntry[V], ttl time.Duration) error {
+	ttl = l.jitterTTL(ttl)
+	refreshIn := ttl
+	if !entry.NotFound && l.opts.refreshAhead > 0 && l.opts.refreshAhead < ttl {
+		refreshIn = ttl - l.opts.refreshAhead
+	}
+	entry.RefreshAt = time.Now().Add(refreshIn).UnixMilli()
+	return l.c.Set(cacheKey, entry, ttl)
+}
+
+func (l *Loader[K, V]) jitterTTL(ttl time.Duration) time.Duration {
+	if l.opts.jitter <= 0 {
+		return ttl
+	}
+	delta := (rand.Float64()*2 - 1) * l.opts.jitter * float64(ttl)
+	if ret := ttl + time.Duration(delta); ret > 0 {
+		return ret
+	}
+	return ttl
+}
+
+func (l *Loader[K, V]) isNotFound(err error) bool {
+	for _, target := range l.opts.notFoundErrs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Loader[K, V]) cacheKey(key K) string {
+	return l.prefix + l.opts.keyFormatFunc(key)
+}
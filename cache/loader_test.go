@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// jsonCache 按 JSON 序列化存储的测试缓存, 行为与 redis 后端一致
+type jsonCache struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newJSONCache() *jsonCache {
+	return &jsonCache{data: map[string][]byte{}}
+}
+
+func (c *jsonCache) Get(key string, val interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.data[key]
+	if !ok {
+		return fmt.Errorf("key %s not found", key)
+	}
+	return json.Unmarshal(data, val)
+}
+
+func (c *jsonCache) Set(key string, val interface{}, timeout time.Duration) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = data
+	return nil
+}
+
+func (c *jsonCache) IsExist(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.data[key]
+	return ok
+}
+
+func (c *jsonCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.data, key)
+	return nil
+}
+
+type user struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestLoaderSingleflight(t *testing.T) {
+	l := NewLoader[uint, *user](newJSONCache(), "user:", time.Minute)
+	var calls atomic.Int32
+	load := func(ctx context.Context, id uint) (*user, error) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return &user{ID: id, Name: "tom"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := l.GetOrLoad(context.Background(), 1, load)
+			assert.NoError(t, err)
+			assert.Equal(t, "tom", u.Name)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+
+	u, err := l.GetOrLoad(context.Background(), 1, load)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), u.ID)
+	assert.Equal(t, int32(1), calls.Load())
+
+	assert.NoError(t, l.Invalidate(1))
+	_, err = l.Get(1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLoaderNilInterface(t *testing.T) {
+	l := NewLoader[string, any](newJSONCache(), "nil:", time.Minute)
+	v, err := l.GetOrLoad(context.Background(), "k", func(ctx context.Context, key string) (any, error) {
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+}
+
+func TestLoaderNegativeCache(t *testing.T) {
+	errMissing := fmt.Errorf("record missing")
+	l := NewLoader[string, int](newJSONCache(), "n:", time.Minute, WithNegativeCache(time.Minute, errMissing))
+	var calls atomic.Int32
+	load := func(ctx context.Context, key string) (int, error) {
+		calls.Add(1)
+		return 0, fmt.Errorf("wrap: %w", errMissing)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := l.GetOrLoad(context.Background(), "k", load)
+		assert.ErrorIs(t, err, errMissing)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestLoaderRefreshAhead(t *testing.T) {
+	l := NewLoader[string, int](newJSONCache(), "r:", 200*time.Millisecond, WithRefreshAhead(150*time.Millisecond))
+	var calls atomic.Int32
+	load := func(ctx context.Context, key string) (int, error) {
+		return int(calls.Add(1)), nil
+	}
+
+	v, err := l.GetOrLoad(context.Background(), "k", load)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	time.Sleep(80 * time.Millisecond)
+	// 进入提前刷新窗口, 返回旧值并在后台刷新
+	v, err = l.GetOrLoad(context.Background(), "k", load)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	assert.Eventually(t, func() bool {
+		v, _ := l.Get("k")
+		return v == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestLoaderJitter(t *testing.T) {
+	l := NewLoader[string, int](newJSONCache(), "j:", time.Minute, WithJitter(0.1))
+	for i := 0; i < 100; i++ {
+		ttl := l.jitterTTL(time.Minute)
+		assert.GreaterOrEqual(t, ttl, 54*time.Second)
+		assert.LessOrEqual(t, ttl, 66*time.Second)
+	}
+}
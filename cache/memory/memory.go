@@ -1,79 +1,221 @@
 package memory
 
 import (
-	"fmt"
+	"encoding/json"
+	"hash/fnv"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ygpkg/yg-go/cache/cachetype"
 )
 
-var _ cachetype.Cache = (*Memory)(nil)
+var _ cachetype.TagCache = (*Memory)(nil)
 
-// Memory struct contains *memcache.Client
-type Memory struct {
-	sync.Mutex
+const (
+	defaultShards          = 16
+	defaultCleanupInterval = time.Minute
+	defaultName            = "memory"
+)
 
-	data map[string]*data
+// Memory 进程内缓存, 按 key 分片加锁, 支持条目数/字节数上限和 LRU/LFU 淘汰,
+// 后台定期清理过期条目. 值以 JSON 序列化存储, Get 时反序列化到调用方的指针中,
+// 与 redis 后端行为一致, 调用方修改取出的值不会影响缓存
+type Memory struct {
+	*memory
 }
 
-type data struct {
-	Data    interface{}
-	Expired time.Time
+// memory 实际的缓存实现, 与 Memory 分离以便在 Memory 被回收时停止后台清理
+type memory struct {
+	opts   options
+	shards []*shard
+	tags   *tagIndex
+	stats  stats
+	stop   chan struct{}
+	once   sync.Once
 }
 
-// NewCache create new memcache
-func NewCache() *Memory {
-	return &Memory{
-		data: map[string]*data{},
+// NewCache 创建内存缓存, 默认不限制容量
+func NewCache(opts ...Option) *Memory {
+	o := options{
+		shards:          defaultShards,
+		cleanupInterval: defaultCleanupInterval,
+		policy:          PolicyLRU,
+		name:            defaultName,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.shards <= 0 {
+		o.shards = 1
+	}
+
+	m := &memory{
+		opts:   o,
+		shards: make([]*shard, o.shards),
+		tags:   newTagIndex(),
+		stop:   make(chan struct{}),
+	}
+	for i := range m.shards {
+		m.shards[i] = newShard(o.policy, perShard(int64(o.maxEntries), o.shards), perShard(o.maxBytes, o.shards), m.tags)
+	}
+
+	mem := &Memory{m}
+	if o.cleanupInterval > 0 {
+		go m.janitor()
+		runtime.SetFinalizer(mem, func(mem *Memory) { mem.Close() })
 	}
+	return mem
 }
 
 // Get return cached value
 func (mem *Memory) Get(key string, val interface{}) error {
-	if ret, ok := mem.data[key]; ok {
-		if ret.Expired.Before(time.Now()) {
-			mem.deleteKey(key)
-			return fmt.Errorf("key(%s) expired at %s", key, ret.Expired)
-		}
-		val = ret.Data
+	data, ok := mem.shard(key).get(key, time.Now().UnixNano())
+	if !ok {
+		mem.stats.misses.Add(1)
+		return cachetype.ErrCacheMiss
 	}
-	return nil
+	mem.stats.hits.Add(1)
+	return cachetype.Unmarshal(data, val)
 }
 
 // IsExist check value exists in memcache.
 func (mem *Memory) IsExist(key string) bool {
-	if ret, ok := mem.data[key]; ok {
-		if ret.Expired.Before(time.Now()) {
-			mem.deleteKey(key)
-			return false
-		}
-		return true
-	}
-	return false
+	return mem.shard(key).exists(key, time.Now().UnixNano())
 }
 
-// Set cached value with key and expire time.
-func (mem *Memory) Set(key string, val interface{}, timeout time.Duration) (err error) {
-	mem.Lock()
-	defer mem.Unlock()
+// Set cached value with key and expire time, timeout <= 0 表示永不过期
+func (mem *Memory) Set(key string, val interface{}, timeout time.Duration) error {
+	return mem.SetWithTags(key, val, timeout)
+}
 
-	mem.data[key] = &data{
-		Data:    val,
-		Expired: time.Now().Add(timeout),
+// SetWithTags 写入并给 key 打标签
+func (mem *Memory) SetWithTags(key string, val interface{}, timeout time.Duration, tags ...string) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
 	}
+	var expireAt int64
+	if timeout > 0 {
+		expireAt = time.Now().Add(timeout).UnixNano()
+	}
+	evicted := mem.shard(key).set(key, data, expireAt, tags)
+	mem.stats.evictions.Add(uint64(evicted))
 	return nil
 }
 
 // Delete delete value in memcache.
 func (mem *Memory) Delete(key string) error {
-	return mem.deleteKey(key)
+	mem.shard(key).delete(key)
+	return nil
 }
 
-// deleteKey
-func (mem *Memory) deleteKey(key string) error {
-	mem.Lock()
-	defer mem.Unlock()
-	delete(mem.data, key)
+// InvalidateTag 删除所有带有该标签的 key
+func (mem *Memory) InvalidateTag(tag string) error {
+	for _, key := range mem.tags.keys(tag) {
+		mem.shard(key).deleteTagged(key, tag)
+	}
 	return nil
 }
+
+// Purge 清空所有条目
+func (mem *Memory) Purge() {
+	for _, s := range mem.shards {
+		s.purge()
+	}
+}
+
+// Len 返回当前条目数, 可能包含尚未清理的过期条目
+func (m *memory) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		n += len(s.items)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Bytes 返回当前占用的估算字节数
+func (m *memory) Bytes() int64 {
+	var n int64
+	for _, s := range m.shards {
+		s.mu.Lock()
+		n += s.bytes
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Stats 返回命中、未命中、淘汰和过期清理的累计次数
+func (m *memory) Stats() Stats {
+	return Stats{
+		Hits:        m.stats.hits.Load(),
+		Misses:      m.stats.misses.Load(),
+		Evictions:   m.stats.evictions.Load(),
+		Expirations: m.stats.expirations.Load(),
+		Entries:     m.Len(),
+		Bytes:       m.Bytes(),
+	}
+}
+
+// Close 停止后台清理
+func (mem *Memory) Close() {
+	mem.once.Do(func() { close(mem.stop) })
+}
+
+func (m *memory) shard(key string) *shard {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+func (m *memory) janitor() {
+	ticker := time.NewTicker(m.opts.cleanupInterval)
+	defer ticker.Stop()
+	var exported Stats
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			now := time.Now().UnixNano()
+			for _, s := range m.shards {
+				m.stats.expirations.Add(uint64(s.deleteExpired(now)))
+			}
+			exported = m.exportMetrics(exported)
+		}
+	}
+}
+
+func perShard(total int64, shards int) int64 {
+	if total <= 0 {
+		return 0
+	}
+	n := total / int64(shards)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+type stats struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+// Stats 缓存统计
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+}
@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/cache/cachetype"
+)
+
+type item struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func TestGetCopiesValue(t *testing.T) {
+	c := NewCache()
+	defer c.Close()
+
+	assert.NoError(t, c.Set("k", &item{Name: "a", Tags: []string{"x"}}, time.Minute))
+	var got item
+	assert.NoError(t, c.Get("k", &got))
+	assert.Equal(t, "a", got.Name)
+
+	got.Tags[0] = "y"
+	var again item
+	assert.NoError(t, c.Get("k", &again))
+	assert.Equal(t, []string{"x"}, again.Tags)
+
+	assert.ErrorIs(t, c.Get("missing", &got), cachetype.ErrCacheMiss)
+	assert.NoError(t, c.Delete("k"))
+	assert.False(t, c.IsExist("k"))
+}
+
+func TestNoExpiry(t *testing.T) {
+	c := NewCache()
+	defer c.Close()
+
+	assert.NoError(t, c.Set("k", 1, 0))
+	var v int
+	assert.NoError(t, c.Get("k", &v))
+	assert.Equal(t, 1, v)
+}
+
+func TestLRUEviction(t *testing.T) {
+	c := NewCache(WithShards(1), WithMaxEntries(3))
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c.Set(fmt.Sprint(i), i, time.Minute))
+	}
+	var v int
+	assert.NoError(t, c.Get("0", &v))
+	assert.NoError(t, c.Set("3", 3, time.Minute))
+
+	assert.True(t, c.IsExist("0"))
+	assert.False(t, c.IsExist("1"))
+	assert.True(t, c.IsExist("3"))
+	assert.Equal(t, uint64(1), c.Stats().Evictions)
+}
+
+func TestLFUEviction(t *testing.T) {
+	c := NewCache(WithShards(1), WithMaxEntries(3), WithPolicy(PolicyLFU))
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c.Set(fmt.Sprint(i), i, time.Minute))
+	}
+	var v int
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c.Get("0", &v))
+		assert.NoError(t, c.Get("2", &v))
+	}
+	assert.NoError(t, c.Get("1", &v))
+	assert.NoError(t, c.Set("3", 3, time.Minute))
+
+	assert.False(t, c.IsExist("1"))
+	assert.True(t, c.IsExist("0"))
+	assert.True(t, c.IsExist("2"))
+}
+
+func TestMaxBytes(t *testing.T) {
+	c := NewCache(WithShards(1), WithMaxBytes(1024))
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, c.Set(fmt.Sprint(i), i, time.Minute))
+	}
+	assert.LessOrEqual(t, c.Bytes(), int64(1024))
+	assert.True(t, c.IsExist("99"))
+}
+
+func TestJanitor(t *testing.T) {
+	c := NewCache(WithCleanupInterval(20 * time.Millisecond))
+	defer c.Close()
+
+	assert.NoError(t, c.Set("a", 1, 10*time.Millisecond))
+	assert.NoError(t, c.Set("b", 1, time.Minute))
+	assert.Eventually(t, func() bool {
+		return c.Len() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	var v int
+	assert.NoError(t, c.Get("b", &v))
+	assert.Error(t, c.Get("a", &v))
+	st := c.Stats()
+	assert.Equal(t, uint64(1), st.Expirations)
+	assert.Equal(t, uint64(1), st.Hits)
+	assert.Equal(t, uint64(1), st.Misses)
+}
+
+func TestInvalidateTag(t *testing.T) {
+	c := NewCache(WithShards(4))
+	defer c.Close()
+
+	assert.NoError(t, c.SetWithTags("company:42:user:1", 1, time.Minute, "company:42"))
+	assert.NoError(t, c.SetWithTags("company:42:user:2", 2, time.Minute, "company:42", "app:x"))
+	assert.NoError(t, c.SetWithTags("company:43:user:3", 3, time.Minute, "company:43"))
+	// 覆盖写去掉标签后不再受该标签影响
+	assert.NoError(t, c.SetWithTags("company:42:user:1", 1, time.Minute))
+
+	assert.NoError(t, c.InvalidateTag("company:42"))
+	assert.True(t, c.IsExist("company:42:user:1"))
+	assert.False(t, c.IsExist("company:42:user:2"))
+	assert.True(t, c.IsExist("company:43:user:3"))
+	assert.Empty(t, c.tags.keys("app:x"))
+
+	c.Purge()
+	assert.Empty(t, c.tags.keys("company:43"))
+}
@@ -0,0 +1,21 @@
+package memory
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ygpkg/yg-go/metrics"
+)
+
+// exportMetrics 把上次上报以来的增量写入 prometheus, 由后台清理定期调用,
+// 避免每次读写都经过 metrics 的全局锁
+func (m *memory) exportMetrics(last Stats) Stats {
+	cur := m.Stats()
+	labels := prometheus.Labels{"cache": m.opts.name}
+
+	metrics.Counter("cache_hits_total").With(labels).Add(float64(cur.Hits - last.Hits))
+	metrics.Counter("cache_misses_total").With(labels).Add(float64(cur.Misses - last.Misses))
+	metrics.Counter("cache_evictions_total").With(labels).Add(float64(cur.Evictions - last.Evictions))
+	metrics.Counter("cache_expirations_total").With(labels).Add(float64(cur.Expirations - last.Expirations))
+	metrics.Gauge("cache_entries").With(labels).Set(float64(cur.Entries))
+	metrics.Gauge("cache_bytes").With(labels).Set(float64(cur.Bytes))
+	return cur
+}
@@ -0,0 +1,67 @@
+package memory
+
+import "time"
+
+// EvictionPolicy 超出容量时的淘汰策略
+type EvictionPolicy int
+
+const (
+	// PolicyLRU 淘汰最久未访问的条目
+	PolicyLRU EvictionPolicy = iota
+	// PolicyLFU 淘汰访问次数最少的条目, 次数相同时淘汰最久未访问的
+	PolicyLFU
+)
+
+type options struct {
+	maxEntries      int
+	maxBytes        int64
+	policy          EvictionPolicy
+	shards          int
+	cleanupInterval time.Duration
+	name            string
+}
+
+// Option 内存缓存配置项
+type Option func(*options)
+
+// WithMaxEntries 设置最大条目数, 0 表示不限制
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// WithMaxBytes 设置最大占用字节数(按 key 和序列化后的值估算), 0 表示不限制
+func WithMaxBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// WithPolicy 设置淘汰策略, 默认 LRU
+func WithPolicy(p EvictionPolicy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithShards 设置分片数, 容量上限平均分配到每个分片
+func WithShards(n int) Option {
+	return func(o *options) {
+		o.shards = n
+	}
+}
+
+// WithCleanupInterval 设置过期清理和指标上报的间隔, 小于等于 0 时不启动后台清理
+func WithCleanupInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.cleanupInterval = d
+	}
+}
+
+// WithName 设置指标中 cache 标签的值, 默认 memory
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
@@ -0,0 +1,246 @@
+package memory
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+)
+
+// entryOverhead 每个条目除 key 和值之外的估算开销
+const entryOverhead = 64
+
+type entry struct {
+	key      string
+	data     []byte
+	expireAt int64
+	size     int64
+	tags     []string
+
+	// LRU
+	elem *list.Element
+	// LFU
+	freq  uint64
+	seq   uint64
+	index int
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expireAt > 0 && now >= e.expireAt
+}
+
+// shard 一个分片, 所有操作在分片锁内完成
+type shard struct {
+	mu         sync.Mutex
+	policy     EvictionPolicy
+	maxEntries int64
+	maxBytes   int64
+	tags       *tagIndex
+
+	items map[string]*entry
+	bytes int64
+	lru   *list.List
+	lfu   lfuHeap
+	seq   uint64
+}
+
+func newShard(policy EvictionPolicy, maxEntries, maxBytes int64, tags *tagIndex) *shard {
+	return &shard{
+		policy:     policy,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		tags:       tags,
+		items:      make(map[string]*entry),
+		lru:        list.New(),
+	}
+}
+
+func (s *shard) get(key string, now int64) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(now) {
+		s.remove(e)
+		return nil, false
+	}
+	s.touch(e)
+	return e.data, true
+}
+
+func (s *shard) exists(key string, now int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	return ok && !e.expired(now)
+}
+
+// set 写入条目, 返回因容量淘汰的条目数; 先淘汰再插入, 新条目不会被自己挤出,
+// 单个值超过分片字节上限时不写入
+func (s *shard) set(key string, data []byte, expireAt int64, tags []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 覆盖写时保留访问次数
+	e, ok := s.items[key]
+	if ok {
+		s.remove(e)
+	} else {
+		e = &entry{key: key}
+	}
+	e.data, e.expireAt, e.tags = data, expireAt, tags
+	e.size = int64(len(key)+len(data)) + entryOverhead
+	if s.maxBytes > 0 && e.size > s.maxBytes {
+		return 0
+	}
+
+	evicted := 0
+	for s.full(e.size) {
+		victim := s.victim()
+		if victim == nil {
+			break
+		}
+		s.remove(victim)
+		evicted++
+	}
+
+	s.items[key] = e
+	s.bytes += e.size
+	s.tags.add(key, tags)
+	s.seq++
+	e.seq = s.seq
+	if s.policy == PolicyLFU {
+		e.freq++
+		heap.Push(&s.lfu, e)
+	} else {
+		e.elem = s.lru.PushFront(e)
+	}
+	return evicted
+}
+
+func (s *shard) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.remove(e)
+	}
+}
+
+func (s *shard) purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.items {
+		s.tags.remove(e.key, e.tags)
+	}
+	s.items = make(map[string]*entry)
+	s.bytes = 0
+	s.lru.Init()
+	s.lfu = nil
+}
+
+// deleteTagged 删除仍带有 tag 的条目, 条目可能在取得索引快照后被覆盖写
+func (s *shard) deleteTagged(key, tag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return
+	}
+	for _, t := range e.tags {
+		if t == tag {
+			s.remove(e)
+			return
+		}
+	}
+}
+
+// deleteExpired 清理过期条目, 返回清理数量
+func (s *shard) deleteExpired(now int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, e := range s.items {
+		if e.expired(now) {
+			s.remove(e)
+			n++
+		}
+	}
+	return n
+}
+
+// full 再放入 size 字节的新条目是否会超出容量
+func (s *shard) full(size int64) bool {
+	return (s.maxEntries > 0 && int64(len(s.items))+1 > s.maxEntries) ||
+		(s.maxBytes > 0 && s.bytes+size > s.maxBytes)
+}
+
+func (s *shard) touch(e *entry) {
+	if s.policy == PolicyLFU {
+		e.freq++
+		s.seq++
+		e.seq = s.seq
+		heap.Fix(&s.lfu, e.index)
+		return
+	}
+	s.lru.MoveToFront(e.elem)
+}
+
+func (s *shard) victim() *entry {
+	if s.policy == PolicyLFU {
+		if len(s.lfu) == 0 {
+			return nil
+		}
+		return s.lfu[0]
+	}
+	back := s.lru.Back()
+	if back == nil {
+		return nil
+	}
+	return back.Value.(*entry)
+}
+
+func (s *shard) remove(e *entry) {
+	delete(s.items, e.key)
+	s.bytes -= e.size
+	s.tags.remove(e.key, e.tags)
+	if s.policy == PolicyLFU {
+		heap.Remove(&s.lfu, e.index)
+	} else {
+		s.lru.Remove(e.elem)
+	}
+}
+
+// lfuHeap 按访问次数排序的小顶堆, 次数相同时最久未访问的在前
+type lfuHeap []*entry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
@@ -0,0 +1,55 @@
+package memory
+
+import "sync"
+
+// tagIndex 标签到 key 的索引, 由所有分片共享, 加锁顺序为先分片后索引
+type tagIndex struct {
+	mu   sync.Mutex
+	tags map[string]map[string]struct{}
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{tags: make(map[string]map[string]struct{})}
+}
+
+func (ti *tagIndex) add(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	for _, tag := range tags {
+		keys, ok := ti.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			ti.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (ti *tagIndex) remove(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	for _, tag := range tags {
+		keys := ti.tags[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(ti.tags, tag)
+		}
+	}
+}
+
+// keys 返回标签下所有 key 的快照
+func (ti *tagIndex) keys(tag string) []string {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ret := make([]string, 0, len(ti.tags[tag]))
+	for key := range ti.tags[tag] {
+		ret = append(ret, key)
+	}
+	return ret
+}
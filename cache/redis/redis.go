@@ -42,7 +42,7 @@ func (r *Redis) GetContext(ctx context.Context, key string) interface{} {
 
 // Get 获取一个值
 func (r *Redis) Get(key string, reply interface{}) error {
-	data, err := r.conn.Get(r.ctx, key).Result()
+	data, err := r.getRaw(key)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ygpkg/yg-go/cache/cachetype"
+	"github.com/ygpkg/yg-go/logs"
+)
+
+var _ cachetype.TagCache = (*Redis)(nil)
+
+const (
+	tagKeyPrefix = "cache_tag:"
+	// taggedPrefix 带标签的值的前缀, 合法的 JSON 不会以控制字符开头, 据此与普通值区分
+	taggedPrefix = "\x1ftagged:"
+	// invalidateBatch InvalidateTag 每批删除的 key 数量
+	invalidateBatch = 500
+)
+
+// taggedValue 带标签的值, 记录写入时各标签的代数.
+// InvalidateTag 会递增代数, 读取时代数不一致即视为已失效, 因此即使标签集合中的 key 没有删干净,
+// 或者写入与失效并发, 也不会读到失效前的值
+type taggedValue struct {
+	Value json.RawMessage  `json:"v"`
+	Gens  map[string]int64 `json:"g"`
+}
+
+// addTagScript 把 key 加入标签集合, 集合的过期时间不短于 key 的过期时间, key 永不过期时集合也不过期
+var addTagScript = redis.NewScript(`
+local existed = redis.call('EXISTS', KEYS[1])
+redis.call('SADD', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call('PERSIST', KEYS[1])
+	return 1
+end
+local cur = redis.call('PTTL', KEYS[1])
+if existed == 0 or (cur >= 0 and cur < ttl) then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
+// delTaggedScript 只在 key 当前的值仍带有该标签时删除, 之后被 Set 或其他标签覆盖的值不受影响
+var delTaggedScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if not v or string.sub(v, 1, #ARGV[1]) ~= ARGV[1] then
+	return 0
+end
+local ok, tv = pcall(cjson.decode, string.sub(v, #ARGV[1] + 1))
+if ok and type(tv.g) == 'table' and tv.g[ARGV[2]] ~= nil then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func tagGenKey(tag string) string { return tagKeyPrefix + tag + ":gen" }
+
+func tagSetKey(tag string) string { return tagKeyPrefix + tag + ":keys" }
+
+// SetWithTags 写入并给 key 打标签. 标签集合只用于 InvalidateTag 查找 key, 删除前会校验 key 当前的标签,
+// 因此覆盖写(包括不带标签的 Set)后旧标签不再影响该 key, 集合中残留的成员随集合过期或失效时清理
+func (r *Redis) SetWithTags(key string, val interface{}, timeout time.Duration, tags ...string) error {
+	if len(tags) == 0 {
+		return r.Set(key, val, timeout)
+	}
+	gens, err := r.tagGens(tags)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(taggedValue{Value: json.RawMessage(cachetype.Marshal(val)), Gens: gens})
+	if err != nil {
+		return err
+	}
+
+	// 不使用事务, 集群模式下 key 和标签集合可能不在同一个 slot
+	_, err = r.conn.Pipelined(r.ctx, func(p redis.Pipeliner) error {
+		p.Set(r.ctx, key, taggedPrefix+string(data), timeout)
+		for _, tag := range tags {
+			addTagScript.Eval(r.ctx, p, []string{tagSetKey(tag)}, key, timeout.Milliseconds())
+		}
+		return nil
+	})
+	return err
+}
+
+// InvalidateTag 递增标签代数使所有带该标签的值失效, 然后尽量删除标签集合中的 key 释放内存
+func (r *Redis) InvalidateTag(tag string) error {
+	if err := r.conn.Incr(r.ctx, tagGenKey(tag)).Err(); err != nil {
+		return err
+	}
+
+	setKey := tagSetKey(tag)
+	var cursor uint64
+	for {
+		keys, next, err := r.conn.SScan(r.ctx, setKey, cursor, "", invalidateBatch).Result()
+		if err != nil {
+			logs.Warnf("[redis_cache] scan tag %s failed, %s", tag, err)
+			return nil
+		}
+		if len(keys) > 0 {
+			_, err = r.conn.Pipelined(r.ctx, func(p redis.Pipeliner) error {
+				for _, key := range keys {
+					delTaggedScript.Eval(r.ctx, p, []string{key}, taggedPrefix, tag)
+				}
+				return nil
+			})
+			if err != nil {
+				logs.Warnf("[redis_cache] delete keys of tag %s failed, %s", tag, err)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	r.conn.Del(r.ctx, setKey)
+	return nil
+}
+
+// getRaw 读取值, 带标签的值校验代数后返回原始 JSON
+func (r *Redis) getRaw(key string) (string, error) {
+	data, err := r.conn.Get(r.ctx, key).Result()
+	if err != nil || !strings.HasPrefix(data, taggedPrefix) {
+		return data, err
+	}
+
+	var tv taggedValue
+	if err := json.Unmarshal([]byte(data[len(taggedPrefix):]), &tv); err != nil {
+		return "", err
+	}
+	tags := make([]string, 0, len(tv.Gens))
+	for tag := range tv.Gens {
+		tags = append(tags, tag)
+	}
+	gens, err := r.tagGens(tags)
+	if err != nil {
+		return "", err
+	}
+	for tag, gen := range tv.Gens {
+		if gens[tag] != gen {
+			r.conn.Del(r.ctx, key)
+			return "", redis.Nil
+		}
+	}
+	return string(tv.Value), nil
+}
+
+func (r *Redis) tagGens(tags []string) (map[string]int64, error) {
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagGenKey(tag)
+	}
+	// 逐个 GET 而不是 MGET, 集群模式下标签可能分布在不同 slot
+	cmds, err := r.conn.Pipelined(r.ctx, func(p redis.Pipeliner) error {
+		for _, key := range keys {
+			p.Get(r.ctx, key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	gens := make(map[string]int64, len(tags))
+	for i, cmd := range cmds {
+		str, err := cmd.(*redis.StringCmd).Result()
+		if err == redis.Nil {
+			gens[tags[i]] = 0
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		gen, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		gens[tags[i]] = gen
+	}
+	return gens, nil
+}
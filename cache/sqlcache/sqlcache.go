@@ -0,0 +1,270 @@
+package sqlcache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ygpkg/yg-go/cache/cachetype"
+	"github.com/ygpkg/yg-go/encryptor"
+	"github.com/ygpkg/yg-go/logs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TableName    = "sys_cache"
+	TableNameTag = "sys_cache_tag"
+)
+
+const (
+	defaultSweepInterval = 5 * time.Minute
+	sweepBatch           = 500
+)
+
+// CacheVal 缓存值, 字段类型不指定数据库方言, 由 gorm 按驱动映射,
+// []byte 在 MySQL/Postgres/SQLite 中分别为 longblob/bytea/blob
+type CacheVal struct {
+	Key       string     `gorm:"column:id;type:varchar(255);primaryKey"`
+	Val       []byte     `gorm:"column:value"`
+	Gzip      bool       `gorm:"column:gzip;not null;default:false"`
+	ExpiredAt *time.Time `gorm:"column:expired_at;index"`
+}
+
+func (*CacheVal) TableName() string { return TableName }
+
+// CacheTag 缓存 key 的标签
+type CacheTag struct {
+	Tag string `gorm:"column:tag;type:varchar(64);primaryKey"`
+	Key string `gorm:"column:cache_id;type:varchar(255);primaryKey;index"`
+}
+
+func (*CacheTag) TableName() string { return TableNameTag }
+
+type options struct {
+	gzipThreshold int
+	sweepInterval time.Duration
+}
+
+// Option 配置项
+type Option func(*options)
+
+// WithGzip 序列化后超过 threshold 字节的值使用 gzip 压缩存储
+func WithGzip(threshold int) Option {
+	return func(o *options) {
+		o.gzipThreshold = threshold
+	}
+}
+
+// WithSweepInterval 设置清理过期数据的间隔, 默认 5 分钟, 小于等于 0 时不清理
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.sweepInterval = d
+	}
+}
+
+var _ cachetype.TagCache = (*Cache)(nil)
+
+// Cache 基于数据库的缓存, 支持 dbtools/v2 中的 MySQL、Postgres 和 SQLite
+type Cache struct {
+	db   *gorm.DB
+	opts options
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewCache 创建数据库缓存并迁移表结构, 后台定期清理过期数据, 不再使用时需要调用 Close
+func NewCache(db *gorm.DB, opts ...Option) (*Cache, error) {
+	o := options{sweepInterval: defaultSweepInterval}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := db.AutoMigrate(&CacheVal{}, &CacheTag{}); err != nil {
+		logs.Errorf("[sql_cache] migrate table failed, %s", err)
+		return nil, err
+	}
+
+	c := &Cache{db: db, opts: o, stop: make(chan struct{})}
+	if o.sweepInterval > 0 {
+		go c.sweeper()
+	}
+	return c, nil
+}
+
+// Get 获取未过期的值, 不存在时返回 cachetype.ErrCacheMiss
+func (c *Cache) Get(key string, val interface{}) error {
+	cv := &CacheVal{}
+	err := c.db.Where("id = ?", key).
+		Where("expired_at IS NULL OR expired_at > ?", time.Now()).
+		Take(cv).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return cachetype.ErrCacheMiss
+	}
+	if err != nil {
+		logs.Warnf("[sql_cache] get key(%s) failed, %s", key, err)
+		return err
+	}
+
+	data := cv.Val
+	if cv.Gzip {
+		if data, err = encryptor.GzipDecompress(data); err != nil {
+			logs.Errorf("[sql_cache] decompress key(%s) failed, %s", key, err)
+			return err
+		}
+	}
+	return cachetype.Unmarshal(data, val)
+}
+
+// Set 写入或覆盖, timeout <= 0 表示永不过期, 覆盖时清除 key 原有的标签
+func (c *Cache) Set(key string, val interface{}, timeout time.Duration) error {
+	return c.SetWithTags(key, val, timeout)
+}
+
+// SetWithTags 写入并给 key 打标签, 在同一个事务中替换 key 原有的标签
+func (c *Cache) SetWithTags(key string, val interface{}, timeout time.Duration, tags ...string) error {
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		if err := c.upsert(tx, key, val, timeout); err != nil {
+			return err
+		}
+		if err := tx.Where("cache_id = ?", key).Delete(&CacheTag{}).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		rows := make([]*CacheTag, len(tags))
+		for i, tag := range tags {
+			rows[i] = &CacheTag{Tag: tag, Key: key}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(rows).Error
+	})
+}
+
+// InvalidateTag 删除所有带有该标签的 key
+func (c *Cache) InvalidateTag(tag string) error {
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id IN (?)", tx.Model(&CacheTag{}).Select("cache_id").Where("tag = ?", tag)).
+			Delete(&CacheVal{}).Error
+		if err != nil {
+			logs.Warnf("[sql_cache] invalidate tag(%s) failed, %s", tag, err)
+			return err
+		}
+		return tx.Where("tag = ?", tag).Delete(&CacheTag{}).Error
+	})
+}
+
+// IsExist 判断未过期的 key 是否存在
+func (c *Cache) IsExist(key string) bool {
+	var count int64
+	err := c.db.Model(&CacheVal{}).
+		Where("id = ?", key).
+		Where("expired_at IS NULL OR expired_at > ?", time.Now()).
+		Count(&count).Error
+	if err != nil {
+		logs.Warnf("[sql_cache] exists key(%s) failed, %s", key, err)
+		return false
+	}
+	return count > 0
+}
+
+// Delete 删除 key 及其标签
+func (c *Cache) Delete(key string) error {
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", key).Delete(&CacheVal{}).Error; err != nil {
+			logs.Warnf("[sql_cache] delete key(%s) failed, %s", key, err)
+			return err
+		}
+		return tx.Where("cache_id = ?", key).Delete(&CacheTag{}).Error
+	})
+}
+
+// Close 停止后台清理
+func (c *Cache) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	return nil
+}
+
+// Sweep 删除已过期的数据, 返回删除的数量
+func (c *Cache) Sweep() (int64, error) {
+	var total int64
+	for {
+		now := time.Now()
+		var keys []string
+		// 先查再删, MySQL 不支持 IN 子查询中使用 LIMIT, Postgres 不支持 DELETE ... LIMIT
+		err := c.db.Model(&CacheVal{}).
+			Where("expired_at IS NOT NULL AND expired_at <= ?", now).
+			Limit(sweepBatch).
+			Pluck("id", &keys).Error
+		if err != nil {
+			return total, err
+		}
+		if len(keys) == 0 {
+			return total, nil
+		}
+
+		err = c.db.Transaction(func(tx *gorm.DB) error {
+			ret := tx.Where("id IN ? AND expired_at <= ?", keys, now).Delete(&CacheVal{})
+			if ret.Error != nil {
+				return ret.Error
+			}
+			total += ret.RowsAffected
+			return tx.Where("cache_id IN ?", keys).
+				Where("cache_id NOT IN (?)", tx.Model(&CacheVal{}).Select("id").Where("id IN ?", keys)).
+				Delete(&CacheTag{}).Error
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(keys) < sweepBatch {
+			return total, nil
+		}
+	}
+}
+
+func (c *Cache) sweeper() {
+	ticker := time.NewTicker(c.opts.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			n, err := c.Sweep()
+			if err != nil {
+				logs.Warnf("[sql_cache] sweep expired failed, %s", err)
+			} else if n > 0 {
+				logs.Debugf("[sql_cache] swept %d expired keys", n)
+			}
+		}
+	}
+}
+
+// upsert 原子地插入或覆盖
+func (c *Cache) upsert(db *gorm.DB, key string, val interface{}, timeout time.Duration) error {
+	data := []byte(cachetype.Marshal(val))
+	if len(data) == 0 {
+		return fmt.Errorf("marshal %T failed", val)
+	}
+	cv := &CacheVal{Key: key, Val: data}
+	if c.opts.gzipThreshold > 0 && len(data) > c.opts.gzipThreshold {
+		zipped, err := encryptor.GzipCompress(data)
+		if err != nil {
+			return err
+		}
+		cv.Val, cv.Gzip = zipped, true
+	}
+	if timeout > 0 {
+		expiredAt := time.Now().Add(timeout)
+		cv.ExpiredAt = &expiredAt
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "gzip", "expired_at"}),
+	}).Create(cv).Error
+	if err != nil {
+		logs.Warnf("[sql_cache] set key(%s) failed, %s", key, err)
+	}
+	return err
+}
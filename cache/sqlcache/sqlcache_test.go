@@ -0,0 +1,89 @@
+package sqlcache
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/cache/cachetype"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestCache(t *testing.T, opts ...Option) *Cache {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "cache.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	c, err := NewCache(db, append([]Option{WithSweepInterval(0)}, opts...)...)
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestGetSet(t *testing.T) {
+	c := newTestCache(t, WithGzip(128))
+
+	var v string
+	assert.ErrorIs(t, c.Get("k", &v), cachetype.ErrCacheMiss)
+
+	large := strings.Repeat("a", 64*1024)
+	assert.NoError(t, c.Set("k", large, time.Minute))
+	assert.NoError(t, c.Get("k", &v))
+	assert.Equal(t, large, v)
+
+	cv := &CacheVal{}
+	assert.NoError(t, c.db.Where("id = ?", "k").Take(cv).Error)
+	assert.True(t, cv.Gzip)
+	assert.Less(t, len(cv.Val), 1024)
+
+	// 覆盖写
+	assert.NoError(t, c.Set("k", "small", 0))
+	assert.NoError(t, c.Get("k", &v))
+	assert.Equal(t, "small", v)
+	assert.True(t, c.IsExist("k"))
+
+	assert.NoError(t, c.Delete("k"))
+	assert.False(t, c.IsExist("k"))
+}
+
+func TestExpiryAndSweep(t *testing.T) {
+	c := newTestCache(t)
+
+	assert.NoError(t, c.Set("a", 1, time.Millisecond))
+	assert.NoError(t, c.SetWithTags("b", 2, time.Millisecond, "t"))
+	assert.NoError(t, c.Set("c", 3, time.Minute))
+	time.Sleep(5 * time.Millisecond)
+
+	var v int
+	assert.ErrorIs(t, c.Get("a", &v), cachetype.ErrCacheMiss)
+	assert.False(t, c.IsExist("b"))
+
+	n, err := c.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	var count int64
+	c.db.Model(&CacheTag{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, c.Get("c", &v))
+	assert.Equal(t, 3, v)
+}
+
+func TestInvalidateTag(t *testing.T) {
+	c := newTestCache(t)
+
+	assert.NoError(t, c.SetWithTags("a", 1, time.Minute, "company:42", "app:x"))
+	assert.NoError(t, c.SetWithTags("b", 2, time.Minute, "company:42"))
+	assert.NoError(t, c.SetWithTags("c", 3, time.Minute, "company:43"))
+	assert.NoError(t, c.SetWithTags("b", 2, time.Minute, "company:44"))
+	// 不带标签覆盖写后不再受原有标签影响
+	assert.NoError(t, c.SetWithTags("d", 4, time.Minute, "company:42"))
+	assert.NoError(t, c.Set("d", 4, time.Minute))
+
+	assert.NoError(t, c.InvalidateTag("company:42"))
+	assert.False(t, c.IsExist("a"))
+	assert.True(t, c.IsExist("b"))
+	assert.True(t, c.IsExist("c"))
+	assert.True(t, c.IsExist("d"))
+}
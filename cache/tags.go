@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/ygpkg/yg-go/cache/cachetype"
+)
+
+// SetWithTags 写入并给 key 打标签, 后端不支持标签时返回 cachetype.ErrTagsUnsupported
+func SetWithTags(c cachetype.Cache, key string, val interface{}, timeout time.Duration, tags ...string) error {
+	if len(tags) == 0 {
+		return c.Set(key, val, timeout)
+	}
+	tc, ok := c.(cachetype.TagCache)
+	if !ok {
+		return cachetype.ErrTagsUnsupported
+	}
+	return tc.SetWithTags(key, val, timeout, tags...)
+}
+
+// InvalidateTag 删除所有带有该标签的 key, 后端不支持标签时返回 cachetype.ErrTagsUnsupported
+func InvalidateTag(c cachetype.Cache, tag string) error {
+	tc, ok := c.(cachetype.TagCache)
+	if !ok {
+		return cachetype.ErrTagsUnsupported
+	}
+	return tc.InvalidateTag(tag)
+}
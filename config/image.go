@@ -0,0 +1,33 @@
+package config
+
+// ImageConfig 图片处理配置, 按用途保存在 core 组的 image-{purpose} 配置项中
+type ImageConfig struct {
+	// MinWidth/MinHeight/MaxWidth/MaxHeight 校验按 EXIF 方向旋转后的尺寸, 0 表示不限制
+	MinWidth  int `yaml:"min_width"`
+	MinHeight int `yaml:"min_height"`
+	MaxWidth  int `yaml:"max_width"`
+	MaxHeight int `yaml:"max_height"`
+	// MaxPixels 宽高乘积上限, 解码前根据文件头检查, 默认 40000000
+	MaxPixels int `yaml:"max_pixels"`
+	// KeepMetadata 为 true 时保留 EXIF/XMP 等元数据, 默认去除(包括 GPS 位置)
+	KeepMetadata bool `yaml:"keep_metadata"`
+	// DisableAutoOrient 为 true 时不按 JPEG EXIF 方向旋转原图
+	DisableAutoOrient bool `yaml:"disable_auto_orient"`
+	// Quality JPEG 编码质量, 默认 85
+	Quality int `yaml:"quality"`
+	// Variants 上传时生成的缩略图等变体, 与原图保存在同一目录
+	Variants []ImageVariantConfig `yaml:"variants"`
+}
+
+// ImageVariantConfig 图片变体配置, 只缩小不放大
+type ImageVariantConfig struct {
+	// Name 变体名, 存储路径为 {原图路径去掉扩展名}_{name}.{ext}
+	Name string `yaml:"name"`
+	// Width/Height 目标尺寸, contain 模式下其中一个可以为 0
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+	// Fit contain: 等比缩放到框内(默认); cover: 等比缩放并居中裁剪为目标尺寸
+	Fit string `yaml:"fit"`
+	// Format 输出格式 jpeg/png, 为空时 JPEG 原图输出 JPEG, 其他格式输出 PNG
+	Format string `yaml:"format"`
+}
@@ -0,0 +1,12 @@
+package config
+
+// StorageQuotaConfig 存储容量限制(字节), 0 表示不限制.
+// 默认值保存在 core 组的 storage-quota 配置项中, 公司购买的套餐写入 core_storage_quota_plans 表覆盖默认值
+type StorageQuotaConfig struct {
+	// CompanyLimit 每个公司的总容量
+	CompanyLimit int64 `yaml:"company_limit" json:"company_limit"`
+	// UinLimit 公司内每个用户的容量
+	UinLimit int64 `yaml:"uin_limit" json:"uin_limit"`
+	// PurposeLimits 公司内每种用途的容量, key 为用途
+	PurposeLimits map[string]int64 `yaml:"purpose_limits" json:"purpose_limits,omitempty"`
+}
@@ -0,0 +1,14 @@
+package config
+
+import "time"
+
+// ClamAVConfig clamd 连接配置
+type ClamAVConfig struct {
+	// Address clamd 地址, 如 tcp://127.0.0.1:3310 或 unix:///var/run/clamav/clamd.ctl,
+	// 没有 scheme 时按 tcp 处理
+	Address string `yaml:"address"`
+	// Timeout 单次扫描的超时时间, 默认 5m
+	Timeout time.Duration `yaml:"timeout"`
+	// ChunkSize INSTREAM 每个数据块的大小, 默认 64KB
+	ChunkSize int `yaml:"chunk_size"`
+}
@@ -8,12 +8,19 @@ type FilePurpose = string
 type StorageConfig struct {
 	StorageOption `yaml:",inline"`
 
-	Local   *LocalStorageConfig `yaml:"local,omitempty"`
-	AliOSS  *AliOSSConfig       `yaml:"alioss,omitempty"`
-	UpYun   *UpYunConfig        `yaml:"upyun,omitempty"`
-	Tencent *TencentCOSConfig   `yaml:"tencent,omitempty"`
-	Minoss  *MinossConfig       `yaml:"minoss,omitempty"`
-	S3      *S3StorageConfig    `yaml:"s3,omitempty"`
+	Local   *LocalStorageConfig  `yaml:"local,omitempty"`
+	AliOSS  *AliOSSConfig        `yaml:"alioss,omitempty"`
+	UpYun   *UpYunConfig         `yaml:"upyun,omitempty"`
+	Tencent *TencentCOSConfig    `yaml:"tencent,omitempty"`
+	Minoss  *MinossConfig        `yaml:"minoss,omitempty"`
+	S3      *S3StorageConfig     `yaml:"s3,omitempty"`
+	WeedFS  *WeedFSConfig        `yaml:"weedfs,omitempty"`
+	Memory  *MemoryStorageConfig `yaml:"memory,omitempty"`
+
+	// Replication 配置后使用主备两个存储, 忽略上面的后端配置
+	Replication *StorageReplicationConfig `yaml:"replication,omitempty"`
+	// Encryption 配置后文件在写入存储前加密, 可与任意存储后端组合
+	Encryption *StorageEncryptionConfig `yaml:"encryption,omitempty"`
 }
 
 // StorageOption 对象存储通用配置选项
@@ -24,17 +31,53 @@ type StorageOption struct {
 	PresignedTimeout time.Duration `yaml:"presigned_timeout"`
 }
 
+// StorageReplicationConfig 主备复制配置, 写入主存储后复制到副本, 读取主存储失败时读取副本
+type StorageReplicationConfig struct {
+	Primary   *StorageConfig `yaml:"primary"`
+	Secondary *StorageConfig `yaml:"secondary"`
+	// Async 为 true 时写入主存储后立即返回, 由后台队列复制到副本, 失败按指数退避重试;
+	// 为 false 时同步复制, 复制失败时返回错误
+	Async bool `yaml:"async"`
+	// MaxRetries 异步复制的最大重试次数, 默认 5, 超过后等待对账任务修复
+	MaxRetries int `yaml:"max_retries"`
+	// RetryInterval 首次重试的间隔, 默认 10s, 之后每次翻倍
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	// QueueSize 异步复制队列长度, 默认 1024, 队列满时丢弃并等待对账任务修复
+	QueueSize int `yaml:"queue_size"`
+}
+
+// StorageEncryptionConfig 客户端加密配置, 使用 AES-256-GCM 分段加密, 每个文件使用独立的数据密钥
+type StorageEncryptionConfig struct {
+	// KeyID 加密新文件使用的主密钥 ID, 主密钥保存在 core 组的 secret 配置项 storage-key-{KeyID} 中,
+	// 值为 base64 编码的 32 字节密钥. 轮换时修改 KeyID, 旧密钥需保留用于解密已有文件
+	KeyID string `yaml:"key_id"`
+	// SegmentSize 分段加密的明文段大小, 默认 64KB
+	SegmentSize int `yaml:"segment_size"`
+}
+
 // LocalStorageConfig 。
 type LocalStorageConfig struct {
 	Dir string `yaml:"dir"`
 	// PublicPrefix 公开访问的前缀
 	PublicPrefix string `yaml:"public_prefix"`
+	// SignKey 预签名URL的HMAC密钥, 为空时启动时随机生成(多实例部署时必须配置)
+	SignKey string `yaml:"sign_key"`
+	// Private 为true时拒绝未签名的GET请求
+	Private bool `yaml:"private"`
+}
+
+// MemoryStorageConfig 内存存储配置, 仅用于测试
+type MemoryStorageConfig struct {
+	// PublicPrefix 公开访问的前缀, 通常为挂载了 MemoryStorage 的 httptest 服务地址
+	PublicPrefix string `yaml:"public_prefix"`
 }
 
 // AliOSSConfig .
 type AliOSSConfig struct {
-	AliConfig
-	Bucket string `yaml:"bucket"`
+	AliConfig `yaml:",inline"`
+	Bucket    string `yaml:"bucket"`
+	// PublicDomain 公开访问的域名(如 CDN), 为空时使用 https://{bucket}.{endpoint}
+	PublicDomain string `yaml:"public_domain"`
 }
 
 type AliConfig struct {
@@ -49,6 +92,12 @@ type UpYunConfig struct {
 	Bucket   string `yaml:"bucket"`
 	Operator string `yaml:"operator"`
 	Password string `yaml:"password"`
+	// Endpoint REST API 地址, 默认 https://v0.api.upyun.com
+	Endpoint string `yaml:"endpoint"`
+	// Domain 公开访问的域名, 默认 http://{bucket}.test.upcdn.net
+	Domain string `yaml:"domain"`
+	// TokenSecret 控制台中配置的 token 防盗链密钥, 用于生成临时访问链接
+	TokenSecret string `yaml:"token_secret"`
 }
 
 // TencentConfig 腾讯云配置
@@ -83,3 +132,15 @@ type S3StorageConfig struct {
 	Bucket          string `yaml:"bucket"`
 	UsePathStyle    bool   `yaml:"use_path_style"` // 是否使用路径风格的URL minio true 腾讯云 false
 }
+
+// WeedFSConfig SeaweedFS 存储配置, 通过 filer 读写
+type WeedFSConfig struct {
+	// FilerURL filer 地址, 如 http://127.0.0.1:8888
+	FilerURL string `yaml:"filer_url"`
+	// RootDir 文件在 filer 中的根目录, 为空时为 /
+	RootDir string `yaml:"root_dir"`
+	// PublicPrefix 公开访问的前缀(如 CDN 或网关), 为空时使用 FilerURL
+	PublicPrefix string `yaml:"public_prefix"`
+	// Collection 写入的 collection, 可选
+	Collection string `yaml:"collection"`
+}
@@ -0,0 +1,14 @@
+package config
+
+// UploadPolicyConfig 上传校验策略, 按用途保存在 core 组的 upload-policy-{purpose} 配置项中
+type UploadPolicyConfig struct {
+	// AllowedMIMETypes 允许的 MIME 类型, 根据文件内容检测, 支持 image/* 形式的通配, 为空时不限制
+	AllowedMIMETypes []string `yaml:"allowed_mime_types"`
+	// MaxSize 文件大小上限(字节), 0 表示不限制
+	MaxSize int64 `yaml:"max_size"`
+	// MaxWidth/MaxHeight 图片的宽高上限, 0 表示不限制
+	MaxWidth  int `yaml:"max_width"`
+	MaxHeight int `yaml:"max_height"`
+	// Scan 为 true 时上传完成后文件处于 pending_scan 状态, 由扫描任务检查病毒后再变为 normal
+	Scan bool `yaml:"scan"`
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/ygpkg/yg-go/cache/layered"
+	"github.com/ygpkg/yg-go/cache/memory"
 	"github.com/ygpkg/yg-go/config"
 	"github.com/ygpkg/yg-go/logs"
 	"github.com/ygpkg/yg-go/settings"
@@ -35,6 +37,7 @@ func InitRedis(group, key string) error {
 	stdRedis = rds
 
 	InitCache(rds)
+	initSettingsCache(rds)
 	return nil
 }
 
@@ -54,6 +57,7 @@ func InitRedisWithConfig(cfg *redis.Options) (*redis.Client, error) {
 	stdRedis = rds
 
 	InitCache(rds)
+	initSettingsCache(rds)
 	return rds, nil
 }
 
@@ -72,3 +76,9 @@ func GetRedis() (*redis.Client, error) {
 	}
 	return stdRedis, nil
 }
+
+// initSettingsCache 配置项使用两级缓存, 更新后通过 pub/sub 立即通知所有实例
+func initSettingsCache(rds *redis.Client) {
+	settings.InitCache(layered.NewRedisCache(rds,
+		layered.WithMemoryOptions(memory.WithName("settings"), memory.WithMaxEntries(4096))))
+}
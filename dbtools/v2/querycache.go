@@ -0,0 +1,202 @@
+package dbtools
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ygpkg/yg-go/cache/cachetype"
+	"github.com/ygpkg/yg-go/logs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+const (
+	queryCacheName       = "dbtools:query_cache"
+	queryCacheSettingKey = "dbtools:query_cache_setting"
+	queryCachePrefix     = "dbq:"
+)
+
+type queryCacheSetting struct {
+	ttl  time.Duration
+	tags []string
+}
+
+// Cached 标记查询结果使用缓存, 需要先在 db 上注册 QueryCache 插件:
+//
+//	db.Scopes(dbtools.Cached(time.Minute, "company:42")).Where(...).Find(&items)
+//
+// 结果以 JSON 序列化缓存, `json:"-"` 的字段不会被缓存.
+// 对同一张表的 Create/Update/Delete 会使该表的缓存失效, 联表查询的其他表和 Exec 执行的原生 SQL
+// 不会触发失效, 需要通过 tags 和 QueryCache.Invalidate 手动处理
+func Cached(ttl time.Duration, tags ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Set(queryCacheSettingKey, &queryCacheSetting{ttl: ttl, tags: tags})
+	}
+}
+
+// QueryCache 查询结果缓存插件, 只缓存通过 Cached 标记的查询
+type QueryCache struct {
+	c cachetype.Cache
+	// tc 缓存后端支持标签时不为空
+	tc cachetype.TagCache
+}
+
+var _ gorm.Plugin = (*QueryCache)(nil)
+
+// NewQueryCache 创建查询缓存插件, 通过 db.Use 注册.
+// 后端不支持标签时, 每个表和标签在缓存中保存一个版本号, 版本号参与缓存 key 的计算,
+// 失效时更新版本号, 旧的缓存不再命中, 等待 TTL 过期
+func NewQueryCache(c cachetype.Cache) *QueryCache {
+	tc, _ := c.(cachetype.TagCache)
+	return &QueryCache{c: c, tc: tc}
+}
+
+// Name gorm.Plugin
+func (qc *QueryCache) Name() string { return queryCacheName }
+
+// Initialize gorm.Plugin
+func (qc *QueryCache) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Query().Replace("gorm:query", qc.query); err != nil {
+		return err
+	}
+	if err := db.Callback().Create().After("gorm:create").Register(queryCacheName, qc.afterWrite); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register(queryCacheName, qc.afterWrite); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register(queryCacheName, qc.afterWrite)
+}
+
+// Invalidate 使带有该标签的查询缓存失效
+func (qc *QueryCache) Invalidate(tag string) error {
+	if qc.tc != nil {
+		return qc.tc.InvalidateTag(tag)
+	}
+	_, err := qc.bumpVersion(tag)
+	return err
+}
+
+// InvalidateTable 使该表的查询缓存失效
+func (qc *QueryCache) InvalidateTable(table string) error {
+	return qc.Invalidate(tableTag(table))
+}
+
+// queryCacheEntry 缓存的查询结果
+type queryCacheEntry struct {
+	Rows int64           `json:"rows"`
+	Data json.RawMessage `json:"data"`
+}
+
+func (qc *QueryCache) query(db *gorm.DB) {
+	v, ok := db.Get(queryCacheSettingKey)
+	setting, _ := v.(*queryCacheSetting)
+	if !ok || setting == nil || db.Error != nil || db.DryRun || db.Statement.Dest == nil {
+		callbacks.Query(db)
+		return
+	}
+
+	callbacks.BuildQuerySQL(db)
+	if db.Error != nil {
+		return
+	}
+	key := queryCacheKey(db)
+	tags := append([]string{tableTag(db.Statement.Table)}, setting.tags...)
+	if qc.tc == nil {
+		versions, err := qc.versions(tags)
+		if err != nil {
+			logs.Warnf("[dbv2] get query cache versions of %s failed, %s", key, err)
+			callbacks.Query(db)
+			return
+		}
+		key += ":" + versions
+	}
+
+	var entry queryCacheEntry
+	if err := qc.c.Get(key, &entry); err == nil {
+		if err = json.Unmarshal(entry.Data, db.Statement.Dest); err == nil {
+			db.RowsAffected = entry.Rows
+			if db.Statement.Result != nil {
+				db.Statement.Result.RowsAffected = entry.Rows
+			}
+			if entry.Rows == 0 && db.Statement.RaiseErrorOnNotFound {
+				db.AddError(gorm.ErrRecordNotFound)
+			}
+			return
+		}
+		logs.Warnf("[dbv2] unmarshal query cache %s failed, %s", key, err)
+	}
+
+	// SQL 已经构建, Query 不会重复构建
+	callbacks.Query(db)
+	if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+		return
+	}
+	data, err := json.Marshal(db.Statement.Dest)
+	if err != nil {
+		logs.Warnf("[dbv2] marshal query cache %s failed, %s", key, err)
+		return
+	}
+	entry = queryCacheEntry{Rows: db.RowsAffected, Data: data}
+	if qc.tc != nil {
+		err = qc.tc.SetWithTags(key, &entry, setting.ttl, tags...)
+	} else {
+		err = qc.c.Set(key, &entry, setting.ttl)
+	}
+	if err != nil {
+		logs.Warnf("[dbv2] set query cache %s failed, %s", key, err)
+	}
+}
+
+// afterWrite 写入后使该表的缓存失效.
+// 在事务中时失效发生在提交之前, 提交前其他连接读到的旧数据可能被重新缓存, 直到 TTL 过期
+func (qc *QueryCache) afterWrite(db *gorm.DB) {
+	if db.Error != nil || db.DryRun || db.Statement.Table == "" {
+		return
+	}
+	if err := qc.InvalidateTable(db.Statement.Table); err != nil {
+		logs.Warnf("[dbv2] invalidate query cache of %s failed, %s", db.Statement.Table, err)
+	}
+}
+
+// versions 返回各标签当前的版本号, 读取失败时生成新的版本号, 不同后端未命中返回的错误不同
+func (qc *QueryCache) versions(tags []string) (string, error) {
+	vers := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		var ver string
+		if err := qc.c.Get(versionKey(tag), &ver); err == nil {
+			vers = append(vers, ver)
+			continue
+		}
+		ver, err := qc.bumpVersion(tag)
+		if err != nil {
+			return "", err
+		}
+		vers = append(vers, ver)
+	}
+	return strings.Join(vers, "."), nil
+}
+
+// bumpVersion 写入新的版本号, 版本号使用当前纳秒时间, 被淘汰后重新生成也不会与旧版本号重复
+func (qc *QueryCache) bumpVersion(tag string) (string, error) {
+	ver := strconv.FormatInt(time.Now().UnixNano(), 36)
+	return ver, qc.c.Set(versionKey(tag), ver, 0)
+}
+
+func queryCacheKey(db *gorm.DB) string {
+	sql := db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...)
+	sum := sha1.Sum([]byte(sql))
+	return queryCachePrefix + db.Statement.Table + ":" + hex.EncodeToString(sum[:])
+}
+
+func tableTag(table string) string {
+	return queryCachePrefix + "table:" + table
+}
+
+func versionKey(tag string) string {
+	return queryCachePrefix + "ver:" + tag
+}
@@ -0,0 +1,115 @@
+package dbtools
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/cache/cachetype"
+	"github.com/ygpkg/yg-go/cache/memory"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type cachedItem struct {
+	ID    uint   `gorm:"primaryKey"`
+	Group string `gorm:"column:group"`
+	Value string
+}
+
+func newQueryCacheDB(t *testing.T) (*gorm.DB, *QueryCache) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "qc.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&cachedItem{}))
+	c := memory.NewCache()
+	t.Cleanup(c.Close)
+	qc := NewQueryCache(c)
+	assert.NoError(t, db.Use(qc))
+	return db, qc
+}
+
+func TestQueryCache(t *testing.T) {
+	db, _ := newQueryCacheDB(t)
+	assert.NoError(t, db.Create(&cachedItem{Group: "core", Value: "v1"}).Error)
+
+	var items []*cachedItem
+	assert.NoError(t, db.Scopes(Cached(time.Minute)).Where("`group` = ?", "core").Find(&items).Error)
+	assert.Len(t, items, 1)
+
+	// 绕过 gorm 直接修改, 缓存命中时读到旧值
+	assert.NoError(t, db.Exec("UPDATE cached_items SET value = ?", "raw").Error)
+	items = nil
+	ret := db.Scopes(Cached(time.Minute)).Where("`group` = ?", "core").Find(&items)
+	assert.NoError(t, ret.Error)
+	assert.Equal(t, int64(1), ret.RowsAffected)
+	assert.Equal(t, "v1", items[0].Value)
+
+	// 未标记的查询不使用缓存
+	var item cachedItem
+	assert.NoError(t, db.Where("`group` = ?", "core").First(&item).Error)
+	assert.Equal(t, "raw", item.Value)
+
+	// 通过 gorm 写入同一张表使缓存失效
+	assert.NoError(t, db.Model(&cachedItem{}).Where("id = ?", item.ID).Update("value", "v2").Error)
+	items = nil
+	assert.NoError(t, db.Scopes(Cached(time.Minute)).Where("`group` = ?", "core").Find(&items).Error)
+	assert.Equal(t, "v2", items[0].Value)
+}
+
+func TestQueryCacheNotFoundAndTags(t *testing.T) {
+	db, qc := newQueryCacheDB(t)
+
+	var item cachedItem
+	for i := 0; i < 2; i++ {
+		err := db.Scopes(Cached(time.Minute, "group:core")).Where("`group` = ?", "core").First(&item).Error
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	}
+
+	assert.NoError(t, db.Exec("INSERT INTO cached_items (`group`, value) VALUES (?, ?)", "core", "v1").Error)
+	err := db.Scopes(Cached(time.Minute, "group:core")).Where("`group` = ?", "core").First(&item).Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	assert.NoError(t, qc.Invalidate("group:core"))
+	assert.NoError(t, db.Scopes(Cached(time.Minute, "group:core")).Where("`group` = ?", "core").First(&item).Error)
+	assert.Equal(t, "v1", item.Value)
+
+	var count int64
+	assert.NoError(t, db.Scopes(Cached(time.Minute)).Model(&cachedItem{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+// plainCache 隐藏标签方法, 模拟不支持标签的后端
+type plainCache struct {
+	cachetype.Cache
+}
+
+func TestQueryCacheWithoutTags(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "qc.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&cachedItem{}))
+	c := memory.NewCache()
+	t.Cleanup(c.Close)
+	qc := NewQueryCache(plainCache{c})
+	assert.NoError(t, db.Use(qc))
+	assert.NoError(t, db.Create(&cachedItem{Group: "core", Value: "v1"}).Error)
+
+	var item cachedItem
+	assert.NoError(t, db.Scopes(Cached(time.Minute, "group:core")).First(&item).Error)
+	assert.NoError(t, db.Exec("UPDATE cached_items SET value = ?", "raw").Error)
+	item = cachedItem{}
+	assert.NoError(t, db.Scopes(Cached(time.Minute, "group:core")).First(&item).Error)
+	assert.Equal(t, "v1", item.Value)
+
+	// 按标签失效
+	assert.NoError(t, qc.Invalidate("group:core"))
+	item = cachedItem{}
+	assert.NoError(t, db.Scopes(Cached(time.Minute, "group:core")).First(&item).Error)
+	assert.Equal(t, "raw", item.Value)
+
+	// 通过 gorm 写入同一张表使缓存失效
+	assert.NoError(t, db.Model(&cachedItem{}).Where("id = ?", item.ID).Update("value", "v2").Error)
+	item = cachedItem{}
+	assert.NoError(t, db.Scopes(Cached(time.Minute, "group:core")).First(&item).Error)
+	assert.Equal(t, "v2", item.Value)
+}
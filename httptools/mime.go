@@ -1,6 +1,12 @@
 package httptools
 
-import "net/http"
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
 
 var (
 	extToMimeTypeMap = map[string]string{
@@ -115,3 +121,27 @@ func TransformExt2ContentType(ext string) string {
 	}
 	return mt
 }
+
+// MimeType2Ext 根据 MIME 类型获取扩展名, 忽略 charset 等参数
+func MimeType2Ext(mt string) string {
+	mt, _, _ = strings.Cut(mt, ";")
+	if ext, ok := mimeTypeToExtMap[strings.TrimSpace(strings.ToLower(mt))]; ok {
+		return ext
+	}
+	return ""
+}
+
+// DetectContentType 根据文件开头的内容(magic bytes)检测 MIME 类型, 不含 charset 等参数.
+// 返回检测到的类型和对应的扩展名, 无法识别时为 application/octet-stream
+func DetectContentType(head []byte) (string, string) {
+	m := mimetype.Detect(head)
+	mt, _, err := mime.ParseMediaType(m.String())
+	if err != nil {
+		mt = "application/octet-stream"
+	}
+	ext := MimeType2Ext(mt)
+	if ext == "" {
+		ext = m.Extension()
+	}
+	return mt, ext
+}
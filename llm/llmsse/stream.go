@@ -0,0 +1,202 @@
+// Package llmsse 将 llmtype.Client 的流式输出桥接到 sseclient 的流中,
+// 生成在后台进行, 浏览器断线后可通过 sseclient.ServeStream 按 Last-Event-ID 续传
+package llmsse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ygpkg/yg-go/apis/sseclient"
+	"github.com/ygpkg/yg-go/llm/llmtype"
+	"github.com/ygpkg/yg-go/logs"
+)
+
+const (
+	// EventChunk 增量数据事件, data 为 JSON 格式的 llmtype.StreamChunk
+	EventChunk = "chunk"
+	// EventError 生成失败事件, data 为错误信息
+	EventError = "error"
+
+	defaultTimeout = 10 * time.Minute
+)
+
+// Result 生成结束后的汇总结果, 用于计费和落库
+type Result struct {
+	Content      string               `json:"content"`
+	ToolCalls    []llmtype.ToolCall   `json:"tool_calls,omitempty"`
+	FinishReason llmtype.FinishReason `json:"finish_reason"`
+	Usage        llmtype.Usage        `json:"usage"`
+	// Stopped 是否被 SSEClient.Stop 中断
+	Stopped bool `json:"stopped"`
+}
+
+type config struct {
+	timeout time.Duration
+}
+
+// Option 配置项
+type Option func(*config)
+
+// WithTimeout 设置后台生成的超时时间, 默认 10 分钟
+func WithTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.timeout = timeout
+	}
+}
+
+// Task 后台生成任务
+type Task struct {
+	StreamID string
+
+	done   chan struct{}
+	result *Result
+	err    error
+}
+
+// Done 返回在生成结束时关闭的通道
+func (t *Task) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait 等待生成结束, 返回汇总结果; 被停止时返回已生成部分的结果且 Stopped 为 true
+func (t *Task) Wait(ctx context.Context) (*Result, error) {
+	select {
+	case <-t.done:
+		return t.result, t.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Start 在后台调用 cli.ChatStream, 将每个 StreamChunk 写入 streamID 对应的流.
+// 生成与发起请求的连接解耦, ctx 只用于传递值, 其取消不会中断生成;
+// 调用 sse.Stop(streamID) 会取消大模型调用
+func Start(ctx context.Context, cli llmtype.Client, sse *sseclient.SSEClient,
+	streamID string, req *llmtype.ChatRequest, opts ...Option) *Task {
+	cfg := &config{timeout: defaultTimeout}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	task := &Task{
+		StreamID: streamID,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(task.done)
+		task.result, task.err = run(context.WithoutCancel(ctx), cli, sse, streamID, req, cfg)
+	}()
+	return task
+}
+
+func run(ctx context.Context, cli llmtype.Client, sse *sseclient.SSEClient,
+	streamID string, req *llmtype.ChatRequest, cfg *config) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		stopped bool
+		content strings.Builder
+		result  = &Result{}
+		// writeErr 写入流失败时中断生成
+		writeErr error
+	)
+
+	stopCh, err := sse.StopNotify(ctx, streamID)
+	if err != nil {
+		logs.Warnf("[llmsse] watch stop signal of %s failed: %v", streamID, err)
+	} else {
+		go func() {
+			select {
+			case <-stopCh:
+				mu.Lock()
+				stopped = true
+				mu.Unlock()
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	write := func(ev *sseclient.Event) bool {
+		isStopped, err := sse.WriteEvent(ctx, nil, streamID, ev)
+		if err != nil {
+			writeErr = err
+			return false
+		}
+		if isStopped {
+			mu.Lock()
+			stopped = true
+			mu.Unlock()
+			return false
+		}
+		return true
+	}
+
+	var chatErr error
+	err = cli.ChatStream(ctx, req, func(chunk *llmtype.StreamChunk, err error) bool {
+		if err != nil {
+			chatErr = err
+			return false
+		}
+		if chunk == nil || chunk.Done {
+			return true
+		}
+
+		content.WriteString(chunk.Content)
+		if len(chunk.ToolCalls) > 0 {
+			result.ToolCalls = chunk.ToolCalls
+		}
+		if chunk.FinishReason != "" {
+			result.FinishReason = chunk.FinishReason
+		}
+		if chunk.Usage != nil {
+			result.Usage = *chunk.Usage
+		}
+
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			writeErr = err
+			return false
+		}
+		return write(&sseclient.Event{Event: EventChunk, Data: string(data)})
+	})
+	if chatErr == nil {
+		chatErr = err
+	}
+	result.Content = content.String()
+
+	mu.Lock()
+	result.Stopped = stopped
+	mu.Unlock()
+	if result.Stopped {
+		return result, nil
+	}
+
+	// 使用独立的 ctx 写入结束状态, 避免超时后读取方无法退出
+	finishCtx, finishCancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer finishCancel()
+
+	if writeErr != nil {
+		logs.Errorf("[llmsse] write stream %s failed: %v", streamID, writeErr)
+		return result, writeErr
+	}
+	if chatErr != nil && !errors.Is(chatErr, context.Canceled) {
+		logs.Errorf("[llmsse] chat stream %s failed: %v", streamID, chatErr)
+		if _, err := sse.WriteEvent(finishCtx, nil, streamID, &sseclient.Event{Event: EventError, Data: chatErr.Error()}); err != nil {
+			logs.Errorf("[llmsse] write error event to %s failed: %v", streamID, err)
+		}
+	}
+	if err := sse.Finish(finishCtx, streamID); err != nil {
+		logs.Errorf("[llmsse] finish stream %s failed: %v", streamID, err)
+		if chatErr == nil {
+			chatErr = err
+		}
+	}
+	return result, chatErr
+}
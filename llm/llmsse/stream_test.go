@@ -0,0 +1,82 @@
+package llmsse
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/apis/sseclient"
+	"github.com/ygpkg/yg-go/llm/llmtype"
+)
+
+type fakeClient struct {
+	chunks []*llmtype.StreamChunk
+	delay  time.Duration
+}
+
+func (f *fakeClient) Chat(ctx context.Context, req *llmtype.ChatRequest) (*llmtype.ChatResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) ChatStream(ctx context.Context, req *llmtype.ChatRequest, handler llmtype.StreamHandler) error {
+	for _, chunk := range f.chunks {
+		select {
+		case <-ctx.Done():
+			handler(nil, ctx.Err())
+			return ctx.Err()
+		case <-time.After(f.delay):
+		}
+		if !handler(chunk, nil) {
+			return nil
+		}
+	}
+	handler(&llmtype.StreamChunk{Done: true}, nil)
+	return nil
+}
+
+func TestStart(t *testing.T) {
+	cli := &fakeClient{chunks: []*llmtype.StreamChunk{
+		{Content: "Hello"},
+		{Content: ", world"},
+		{FinishReason: llmtype.FinishReasonStop, Usage: &llmtype.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}},
+	}}
+	sse := sseclient.New()
+	task := Start(context.Background(), cli, sse, "chat-1", &llmtype.ChatRequest{})
+
+	result, err := task.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world", result.Content)
+	assert.Equal(t, llmtype.FinishReasonStop, result.FinishReason)
+	assert.Equal(t, 5, result.Usage.TotalTokens)
+	assert.False(t, result.Stopped)
+
+	_, events, err := sse.ReadEvents(context.Background(), "chat-1")
+	assert.NoError(t, err)
+	assert.Len(t, events, 4)
+	var chunk llmtype.StreamChunk
+	assert.NoError(t, json.Unmarshal([]byte(events[1].Data), &chunk))
+	assert.Equal(t, ", world", chunk.Content)
+	assert.Equal(t, sseclient.EventEnd, events[3].Event)
+}
+
+func TestStartStopped(t *testing.T) {
+	chunks := make([]*llmtype.StreamChunk, 100)
+	for i := range chunks {
+		chunks[i] = &llmtype.StreamChunk{Content: "x"}
+	}
+	cli := &fakeClient{chunks: chunks, delay: 10 * time.Millisecond}
+	sse := sseclient.New()
+	task := Start(context.Background(), cli, sse, "chat-2", &llmtype.ChatRequest{})
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, sse.Stop(context.Background(), "chat-2"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := task.Wait(ctx)
+	assert.NoError(t, err)
+	assert.True(t, result.Stopped)
+	assert.Less(t, len(result.Content), 100)
+}
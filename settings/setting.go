@@ -3,9 +3,11 @@ package settings
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/ygpkg/yg-go/cache"
+	"github.com/ygpkg/yg-go/cache/cachetype"
 	dbtools "github.com/ygpkg/yg-go/dbtools/v2"
 	"github.com/ygpkg/yg-go/logs"
 	"gopkg.in/yaml.v3"
@@ -33,6 +35,24 @@ const (
 	ValueYaml     ValueType = "yaml"
 )
 
+var settingCache cachetype.Cache
+
+// InitCache 设置配置项使用的缓存, 默认使用 stdCache().
+// 多实例部署时应使用 layered 两级缓存, 使配置更新立即在所有实例生效
+func InitCache(c cachetype.Cache) {
+	if closer, ok := settingCache.(io.Closer); ok && settingCache != c {
+		closer.Close()
+	}
+	settingCache = c
+}
+
+func stdCache() cachetype.Cache {
+	if settingCache != nil {
+		return settingCache
+	}
+	return cache.Std()
+}
+
 // SettingItem 系统配置
 type SettingItem struct {
 	gorm.Model
@@ -120,12 +140,16 @@ func SetYaml(group, key string, value interface{}) error {
 
 // UpsertSetting or update the trade calendar of a stock.
 func UpsertSetting(v *SettingItem) error {
-	rdsKey := redisCacheKey(v.Group, v.Key)
-	cache.Std().Delete(rdsKey)
-	return dbtools.Core().Table(TableNameSettings).
+	err := dbtools.Core().Table(TableNameSettings).
 		Clauses(clause.OnConflict{
 			DoUpdates: clause.AssignmentColumns([]string{"name", "describe", "value", "value_type", "default"}),
 		}).Create(v).Error
+	if err != nil {
+		return err
+	}
+	// 写入成功后再清除缓存, 避免并发读取在写入前把旧值重新写回缓存
+	stdCache().Delete(redisCacheKey(v.Group, v.Key))
+	return nil
 }
 
 // Get 获取数据库或者缓存配置项
@@ -133,7 +157,7 @@ func Get(group, key string) (*SettingItem, error) {
 	ret := &SettingItem{}
 
 	rdsKey := redisCacheKey(group, key)
-	err := cache.Std().Get(rdsKey, ret)
+	err := stdCache().Get(rdsKey, ret)
 	if err == nil && ret.Value != "" {
 		return ret, nil
 	}
@@ -147,7 +171,7 @@ func Get(group, key string) (*SettingItem, error) {
 	}
 
 	if ret.Value != "" {
-		cache.Std().Set(rdsKey, ret, time.Minute*5)
+		stdCache().Set(rdsKey, ret, time.Minute*5)
 	}
 
 	return ret, nil
@@ -215,28 +239,49 @@ func List(group string, keys ...string) ([]*SettingItem, error) {
 	return ret, nil
 }
 
-// Updates 更新settings值
+// Updates 在同一事务中更新settings值, 提交成功后清除缓存
 func Updates(sets ...*SettingItem) error {
-	for _, set := range sets {
-		sql := dbtools.Core().Table(TableNameSettings)
-		if set.ID != 0 {
-			sql = sql.Where("id = ?", set.ID)
-		} else {
-			sql = sql.Where(map[string]interface{}{"group": set.Group, "key": set.Key})
-		}
-		update := &SettingItem{
-			Value:     set.Value,
-			ValueType: set.ValueType,
-			Describe:  set.Describe,
-			Name:      set.Name,
+	rdsKeys := make([]string, 0, len(sets))
+	err := dbtools.Core().Transaction(func(tx *gorm.DB) error {
+		for _, set := range sets {
+			group, key := set.Group, set.Key
+			sql := tx.Table(TableNameSettings)
+			if set.ID != 0 {
+				sql = sql.Where("id = ?", set.ID)
+				if group == "" || key == "" {
+					// 只指定了 ID 时查出 group/key 用于清除缓存
+					found := &SettingItem{}
+					err := tx.Table(TableNameSettings).Select("group", "key").
+						Where("id = ?", set.ID).First(found).Error
+					if err != nil {
+						logs.Errorf("[settings] update %s failed, %s", set.Identify(), err)
+						return err
+					}
+					group, key = found.Group, found.Key
+				}
+			} else {
+				sql = sql.Where(map[string]interface{}{"group": set.Group, "key": set.Key})
+			}
+			update := &SettingItem{
+				Value:     set.Value,
+				ValueType: set.ValueType,
+				Describe:  set.Describe,
+				Name:      set.Name,
+			}
+			err := sql.Select("value", "value_type", "describe", "name").Updates(update).Error
+			if err != nil {
+				logs.Errorf("[settings] update %s failed, %s", set.Identify(), err)
+				return err
+			}
+			rdsKeys = append(rdsKeys, redisCacheKey(group, key))
 		}
-		err := sql.Select("value", "value_type", "describe", "name").Updates(update).Error
-		if err != nil {
-			logs.Errorf("[settings] update %s failed, %s", set.Identify(), err)
-			return err
-		}
-		rdsKey := redisCacheKey(set.Group, set.Key)
-		cache.Std().Delete(rdsKey)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rdsKey := range rdsKeys {
+		stdCache().Delete(rdsKey)
 	}
 	return nil
 }
@@ -34,6 +34,10 @@ const (
 	FileStatusUploadWaitComp FileStatus = "upload_wait_comp"
 	// FileStatusUploadSuccess 上传成功
 	FileStatusUploadSuccess FileStatus = "upload_success"
+	// FileStatusPendingScan 上传完成, 等待病毒扫描
+	FileStatusPendingScan FileStatus = "pending_scan"
+	// FileStatusInfected 病毒扫描未通过, 对象已隔离或删除
+	FileStatusInfected FileStatus = "infected"
 )
 
 // FileInfo .
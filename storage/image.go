@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/storage/imaging"
+)
+
+// SaveImage 按用途的图片处理配置(core 组 image-{purpose})处理后保存原图和变体,
+// 变体地址写入 fi.Extra 的 image 字段; 没有配置时原样保存.
+// 图片不满足配置的限制时返回的错误包装 imaging.ErrInvalidImage
+func SaveImage(ctx context.Context, st Storager, fi *FileInfo, r io.Reader) error {
+	cfg, err := imaging.LoadConfig(fi.Purpose)
+	if err != nil {
+		logs.Errorf("load image config of %s failed: %s", fi.Purpose, err)
+		return err
+	}
+	if cfg == nil {
+		return st.Save(ctx, fi, r)
+	}
+	p, err := imaging.NewPipeline(*cfg)
+	if err != nil {
+		logs.Errorf("invalid image config of %s: %s", fi.Purpose, err)
+		return err
+	}
+	res, err := p.Process(r)
+	if err != nil {
+		return err
+	}
+
+	fi.Size = int64(len(res.Original.Data))
+	fi.MIMEType = res.Original.ContentType()
+	if err := st.Save(ctx, fi, bytes.NewReader(res.Original.Data)); err != nil {
+		return err
+	}
+	extra := &imaging.ImageExtra{
+		Width:  res.Original.Width,
+		Height: res.Original.Height,
+		Format: res.Original.Format,
+	}
+	for _, v := range res.Variants {
+		vfi := &FileInfo{
+			CompanyID:   fi.CompanyID,
+			Uin:         fi.Uin,
+			Purpose:     fi.Purpose,
+			Filename:    fi.Filename,
+			FileExt:     v.Ext(),
+			MIMEType:    v.ContentType(),
+			Size:        int64(len(v.Data)),
+			StoragePath: imaging.VariantPath(fi.StoragePath, v.Name, v.Format),
+		}
+		if err := st.Save(ctx, vfi, bytes.NewReader(v.Data)); err != nil {
+			logs.Errorf("save image variant %s failed: %s", vfi.StoragePath, err)
+			return err
+		}
+		if extra.Variants == nil {
+			extra.Variants = map[string]*imaging.VariantExtra{}
+		}
+		extra.Variants[v.Name] = &imaging.VariantExtra{
+			URL:    st.GetPublicURL(vfi.StoragePath, false),
+			Path:   vfi.StoragePath,
+			Width:  v.Width,
+			Height: v.Height,
+		}
+	}
+	fi.Extra, err = imaging.SetImageExtra(fi.Extra, extra)
+	return err
+}
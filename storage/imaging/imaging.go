@@ -0,0 +1,389 @@
+// Package imaging 上传图片的处理: 校验尺寸、去除 EXIF/GPS 等元数据、按 EXIF 方向旋转、生成缩略图等变体.
+// 支持 JPEG/PNG/GIF/WebP 解码, WebP 使用纯 Go 实现的 golang.org/x/image/webp, 解码后的变体输出为 PNG 或 JPEG.
+package imaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/settings"
+	_ "golang.org/x/image/webp"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// SettingPrefix 图片处理配置项的 key 前缀, 配置项位于 core 组, 值为 yaml
+	SettingPrefix = "image-"
+
+	defaultMaxPixels = 40000000
+	defaultQuality   = 85
+
+	formatJPEG = "jpeg"
+	formatPNG  = "png"
+	formatGIF  = "gif"
+	formatWebP = "webp"
+
+	extraImage = "image"
+)
+
+// ErrInvalidImage 图片无法解码或不满足配置的限制, 通常应返回 400
+var ErrInvalidImage = errors.New("invalid image")
+
+// Image 处理后的图片
+type Image struct {
+	Data []byte
+	// Format jpeg/png/gif/webp
+	Format string
+	Width  int
+	Height int
+}
+
+// ContentType 图片的 MIME 类型
+func (img *Image) ContentType() string {
+	return "image/" + img.Format
+}
+
+// Ext 图片格式对应的扩展名
+func (img *Image) Ext() string {
+	return FormatExt(img.Format)
+}
+
+// Variant 生成的图片变体
+type Variant struct {
+	Name string
+	*Image
+}
+
+// Result 图片处理结果
+type Result struct {
+	Original *Image
+	Variants []*Variant
+}
+
+// Pipeline 按配置处理上传的图片
+type Pipeline struct {
+	cfg config.ImageConfig
+}
+
+// LoadConfig 读取用途对应的图片处理配置, 没有配置时返回 nil
+func LoadConfig(purpose string) (*config.ImageConfig, error) {
+	cfg := &config.ImageConfig{}
+	err := settings.GetYaml(settings.SettingGroupCore, SettingPrefix+purpose, cfg)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// NewPipeline 检查配置并创建 Pipeline
+func NewPipeline(cfg config.ImageConfig) (*Pipeline, error) {
+	if cfg.MaxPixels <= 0 {
+		cfg.MaxPixels = defaultMaxPixels
+	}
+	if cfg.Quality <= 0 || cfg.Quality > 100 {
+		cfg.Quality = defaultQuality
+	}
+	names := map[string]bool{}
+	for _, v := range cfg.Variants {
+		if v.Name == "" || strings.ContainsAny(v.Name, "/\\. ") {
+			return nil, fmt.Errorf("invalid image variant name %q", v.Name)
+		}
+		if names[v.Name] {
+			return nil, fmt.Errorf("duplicate image variant %q", v.Name)
+		}
+		names[v.Name] = true
+		if v.Width < 0 || v.Height < 0 || (v.Width == 0 && v.Height == 0) {
+			return nil, fmt.Errorf("image variant %q must have width or height", v.Name)
+		}
+		switch v.Fit {
+		case "", FitContain:
+		case FitCover:
+			if v.Width == 0 || v.Height == 0 {
+				return nil, fmt.Errorf("image variant %q with fit cover must have both width and height", v.Name)
+			}
+		default:
+			return nil, fmt.Errorf("image variant %q has unknown fit %q", v.Name, v.Fit)
+		}
+		switch v.Format {
+		case "", formatJPEG, formatPNG:
+		default:
+			return nil, fmt.Errorf("image variant %q has unsupported format %q", v.Name, v.Format)
+		}
+	}
+	return &Pipeline{cfg: cfg}, nil
+}
+
+// Process 校验图片并生成处理后的原图和变体.
+// 原图需要旋转时会重新编码; 否则只去掉元数据, 不影响画质
+func (p *Pipeline) Process(r io.Reader) (*Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	hdr, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if int64(hdr.Width)*int64(hdr.Height) > int64(p.cfg.MaxPixels) {
+		return nil, fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrInvalidImage, hdr.Width, hdr.Height, p.cfg.MaxPixels)
+	}
+	orientation := 1
+	if format == formatJPEG && !p.cfg.DisableAutoOrient {
+		orientation = jpegOrientation(data)
+	}
+	width, height := hdr.Width, hdr.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	if err := p.checkSize(width, height); err != nil {
+		return nil, err
+	}
+
+	var decoded *image.RGBA
+	decode := func() (*image.RGBA, error) {
+		if decoded != nil {
+			return decoded, nil
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		decoded = orient(toRGBA(img), orientation)
+		return decoded, nil
+	}
+
+	original := &Image{Data: data, Format: format, Width: width, Height: height}
+	if orientation > 1 {
+		img, err := decode()
+		if err != nil {
+			return nil, err
+		}
+		if original.Data, err = encode(img, format, p.cfg.Quality); err != nil {
+			return nil, err
+		}
+	} else if !p.cfg.KeepMetadata && format != formatGIF {
+		stripped, ok := stripMetadata(format, data)
+		if !ok {
+			// 结构无法解析时重新编码, 确保不会带出位置信息
+			img, err := decode()
+			if err != nil {
+				return nil, err
+			}
+			if format != formatJPEG {
+				format = formatPNG
+			}
+			if stripped, err = encode(img, format, p.cfg.Quality); err != nil {
+				return nil, err
+			}
+			original.Format = format
+		}
+		original.Data = stripped
+	}
+
+	res := &Result{Original: original}
+	for _, v := range p.cfg.Variants {
+		img, err := decode()
+		if err != nil {
+			return nil, err
+		}
+		vf := v.Format
+		if vf == "" {
+			vf = OutputFormat(format)
+		}
+		out, err := resizeImage(img, v.Width, v.Height, v.Fit, vf, p.cfg.Quality)
+		if err != nil {
+			return nil, err
+		}
+		res.Variants = append(res.Variants, &Variant{Name: v.Name, Image: out})
+	}
+	return res, nil
+}
+
+func (p *Pipeline) checkSize(width, height int) error {
+	if p.cfg.MinWidth > 0 && width < p.cfg.MinWidth {
+		return fmt.Errorf("%w: width %d less than %d", ErrInvalidImage, width, p.cfg.MinWidth)
+	}
+	if p.cfg.MinHeight > 0 && height < p.cfg.MinHeight {
+		return fmt.Errorf("%w: height %d less than %d", ErrInvalidImage, height, p.cfg.MinHeight)
+	}
+	if p.cfg.MaxWidth > 0 && width > p.cfg.MaxWidth {
+		return fmt.Errorf("%w: width %d greater than %d", ErrInvalidImage, width, p.cfg.MaxWidth)
+	}
+	if p.cfg.MaxHeight > 0 && height > p.cfg.MaxHeight {
+		return fmt.Errorf("%w: height %d greater than %d", ErrInvalidImage, height, p.cfg.MaxHeight)
+	}
+	return nil
+}
+
+// ResizeOptions 按需缩放参数
+type ResizeOptions struct {
+	Width  int
+	Height int
+	// Fit contain/cover, 默认 contain
+	Fit string
+	// Format 输出格式 jpeg/png, 默认按 OutputFormat 选择
+	Format string
+	// Quality JPEG 编码质量, 默认 85
+	Quality int
+	// MaxPixels 原图宽高乘积上限, 默认 40000000
+	MaxPixels int
+}
+
+// Resize 缩放图片, 会按 JPEG EXIF 方向旋转, 输出不含元数据
+func Resize(r io.Reader, opts ResizeOptions) (*Image, error) {
+	if opts.Width < 0 || opts.Height < 0 || (opts.Width == 0 && opts.Height == 0) {
+		return nil, fmt.Errorf("width or height is required")
+	}
+	if opts.MaxPixels <= 0 {
+		opts.MaxPixels = defaultMaxPixels
+	}
+	if opts.Quality <= 0 || opts.Quality > 100 {
+		opts.Quality = defaultQuality
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	hdr, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if int64(hdr.Width)*int64(hdr.Height) > int64(opts.MaxPixels) {
+		return nil, fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrInvalidImage, hdr.Width, hdr.Height, opts.MaxPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	orientation := 1
+	if format == formatJPEG {
+		orientation = jpegOrientation(data)
+	}
+	if opts.Format == "" {
+		opts.Format = OutputFormat(format)
+	}
+	return resizeImage(orient(toRGBA(img), orientation), opts.Width, opts.Height, opts.Fit, opts.Format, opts.Quality)
+}
+
+func resizeImage(img *image.RGBA, width, height int, fit, format string, quality int) (*Image, error) {
+	dw, dh, crop := targetSize(img.Rect.Dx(), img.Rect.Dy(), width, height, fit)
+	out := resize(img, crop, dw, dh)
+	data, err := encode(out, format, quality)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Data: data, Format: format, Width: dw, Height: dh}, nil
+}
+
+func encode(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case formatJPEG:
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+	case formatPNG:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("encode %s not supported", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// OutputFormat 变体和缩放结果的默认格式, JPEG 输出 JPEG, 其他格式输出 PNG 以保留透明度
+func OutputFormat(srcFormat string) string {
+	if srcFormat == formatJPEG {
+		return formatJPEG
+	}
+	return formatPNG
+}
+
+// FormatByExt 根据扩展名推断格式, 用于按需缩放时预先确定缓存路径
+func FormatByExt(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return formatJPEG
+	case ".png":
+		return formatPNG
+	case ".gif":
+		return formatGIF
+	case ".webp":
+		return formatWebP
+	}
+	return ""
+}
+
+// FormatExt 格式对应的扩展名, JPEG 为 .jpg
+func FormatExt(format string) string {
+	if format == formatJPEG {
+		return ".jpg"
+	}
+	return "." + format
+}
+
+// VariantPath 变体的存储路径: {原图路径去掉扩展名}_{name}{ext}
+func VariantPath(storagePath, name, format string) string {
+	return strings.TrimSuffix(storagePath, path.Ext(storagePath)) + "_" + name + FormatExt(format)
+}
+
+// ImageExtra 写入 FileInfo.Extra 的 image 字段
+type ImageExtra struct {
+	Width    int                      `json:"width"`
+	Height   int                      `json:"height"`
+	Format   string                   `json:"format"`
+	Variants map[string]*VariantExtra `json:"variants,omitempty"`
+}
+
+// VariantExtra 变体信息
+type VariantExtra struct {
+	URL    string `json:"url"`
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// SetImageExtra 在 Extra 中写入 image 字段, 保留其他字段
+func SetImageExtra(extra datatypes.JSON, img *ImageExtra) (datatypes.JSON, error) {
+	m := map[string]interface{}{}
+	if len(extra) > 0 {
+		if err := json.Unmarshal(extra, &m); err != nil {
+			return nil, err
+		}
+	}
+	m[extraImage] = img
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return datatypes.JSON(data), nil
+}
+
+// GetImageExtra 读取 Extra 中的图片信息, 没有时返回 nil
+func GetImageExtra(extra datatypes.JSON) *ImageExtra {
+	if len(extra) == 0 {
+		return nil
+	}
+	var m struct {
+		Image *ImageExtra `json:"image"`
+	}
+	if err := json.Unmarshal(extra, &m); err != nil {
+		return nil
+	}
+	return m.Image
+}
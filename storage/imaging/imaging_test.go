@@ -0,0 +1,184 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+)
+
+// testImage 左半红色右半蓝色
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// withExif 在 SOI 后插入只包含方向标签的 EXIF 段
+func withExif(data []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	seg := []byte{0xff, jpegAPP1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	seg = append(seg, payload...)
+	out := append([]byte{0xff, jpegSOI}, seg...)
+	return append(out, data[2:]...)
+}
+
+// withTextChunk 在 IHDR 后插入 tEXt 块
+func withTextChunk(data []byte) []byte {
+	ihdrEnd := len(pngMagic) + 12 + 13
+	text := []byte("Comment\x00secret")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(chunk, "tEXt"...)
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}))
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestJPEGOrientationAndStrip(t *testing.T) {
+	plain := encodeJPEG(t, testImage(8, 4))
+	data := withExif(plain, 6)
+	assert.Equal(t, 6, jpegOrientation(data))
+	assert.Equal(t, 1, jpegOrientation(plain))
+
+	stripped, ok := stripJPEG(data)
+	assert.True(t, ok)
+	assert.Equal(t, plain, stripped)
+	assert.False(t, bytes.Contains(stripped, exifHeader))
+}
+
+func TestPipelineProcess(t *testing.T) {
+	p, err := NewPipeline(config.ImageConfig{
+		MinWidth: 2,
+		Variants: []config.ImageVariantConfig{
+			{Name: "thumb", Width: 2},
+			{Name: "square", Width: 2, Height: 2, Fit: FitCover, Format: "png"},
+		},
+	})
+	assert.NoError(t, err)
+
+	// 方向 6 需要顺时针旋转 90 度, 8x4 变为 4x8, 红色在上
+	res, err := p.Process(bytes.NewReader(withExif(encodeJPEG(t, testImage(8, 4)), 6)))
+	assert.NoError(t, err)
+	assert.Equal(t, "jpeg", res.Original.Format)
+	assert.Equal(t, 4, res.Original.Width)
+	assert.Equal(t, 8, res.Original.Height)
+	assert.False(t, bytes.Contains(res.Original.Data, exifHeader))
+	img, err := jpeg.Decode(bytes.NewReader(res.Original.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 4, 8), img.Bounds())
+	r, _, b, _ := img.At(2, 1).RGBA()
+	assert.Greater(t, r, b)
+	r, _, b, _ = img.At(2, 6).RGBA()
+	assert.Greater(t, b, r)
+
+	assert.Len(t, res.Variants, 2)
+	assert.Equal(t, "thumb", res.Variants[0].Name)
+	assert.Equal(t, "jpeg", res.Variants[0].Format)
+	assert.Equal(t, 2, res.Variants[0].Width)
+	assert.Equal(t, 4, res.Variants[0].Height)
+	assert.Equal(t, "png", res.Variants[1].Format)
+	sq, err := png.Decode(bytes.NewReader(res.Variants[1].Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 2, 2), sq.Bounds())
+
+	// PNG 只去掉文本块, 不重新编码
+	plain := encodePNG(t, testImage(4, 2))
+	res, err = p.Process(bytes.NewReader(withTextChunk(plain)))
+	assert.NoError(t, err)
+	assert.Equal(t, plain, res.Original.Data)
+	assert.Equal(t, "png", res.Variants[0].Format)
+
+	_, err = p.Process(bytes.NewReader(encodePNG(t, testImage(1, 1))))
+	assert.ErrorIs(t, err, ErrInvalidImage)
+	_, err = p.Process(bytes.NewReader([]byte("not an image")))
+	assert.ErrorIs(t, err, ErrInvalidImage)
+
+	keep, err := NewPipeline(config.ImageConfig{KeepMetadata: true, DisableAutoOrient: true})
+	assert.NoError(t, err)
+	data := withExif(encodeJPEG(t, testImage(8, 4)), 6)
+	res, err = keep.Process(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, data, res.Original.Data)
+	assert.Equal(t, 8, res.Original.Width)
+
+	_, err = NewPipeline(config.ImageConfig{Variants: []config.ImageVariantConfig{{Name: "a/b", Width: 1}}})
+	assert.Error(t, err)
+	_, err = NewPipeline(config.ImageConfig{Variants: []config.ImageVariantConfig{{Name: "a", Width: 1, Fit: FitCover}}})
+	assert.Error(t, err)
+}
+
+func TestResize(t *testing.T) {
+	data := encodePNG(t, testImage(100, 50))
+	img, err := Resize(bytes.NewReader(data), ResizeOptions{Width: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, "png", img.Format)
+	assert.Equal(t, 20, img.Width)
+	assert.Equal(t, 10, img.Height)
+
+	// 只缩小不放大
+	img, err = Resize(bytes.NewReader(data), ResizeOptions{Width: 200, Height: 200})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, img.Width)
+
+	img, err = Resize(bytes.NewReader(data), ResizeOptions{Width: 10, Height: 10, Fit: FitCover, Format: "jpeg"})
+	assert.NoError(t, err)
+	assert.Equal(t, "jpeg", img.Format)
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 10), decoded.Bounds())
+
+	_, err = Resize(bytes.NewReader(data), ResizeOptions{Width: 10, MaxPixels: 100})
+	assert.ErrorIs(t, err, ErrInvalidImage)
+}
+
+func TestVariantPath(t *testing.T) {
+	assert.Equal(t, "/a/b/c_thumb.jpg", VariantPath("/a/b/c.jpeg", "thumb", "jpeg"))
+	assert.Equal(t, "a/c_x.png", VariantPath("a/c", "x", "png"))
+}
+
+func TestWebPDecode(t *testing.T) {
+	// 1x1 无损 WebP
+	data, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	res, err := Resize(bytes.NewReader(data), ResizeOptions{Width: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "png", res.Format)
+	assert.Equal(t, 1, res.Width)
+}
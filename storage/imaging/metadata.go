@@ -0,0 +1,211 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	jpegSOI  = 0xd8
+	jpegEOI  = 0xd9
+	jpegSOS  = 0xda
+	jpegAPP1 = 0xe1
+	// jpegAPP13 Photoshop/IPTC
+	jpegAPP13 = 0xed
+	jpegCOM   = 0xfe
+
+	exifOrientationTag = 0x0112
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	pngMagic   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// jpegSegments 遍历 SOS 之前的 JPEG 段, fn 的 start/end 为段(含标记)在 data 中的范围, 返回 false 时停止.
+// 返回 SOS 标记的位置, 格式错误时返回 -1
+func jpegSegments(data []byte, fn func(marker byte, start, end int) bool) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != jpegSOI {
+		return -1
+	}
+	i := 2
+	for i+1 < len(data) {
+		if data[i] != 0xff {
+			return -1
+		}
+		// 标记前可以有填充的 0xff
+		for i+1 < len(data) && data[i+1] == 0xff {
+			i++
+		}
+		if i+1 >= len(data) {
+			return -1
+		}
+		marker := data[i+1]
+		if marker == jpegSOS || marker == jpegEOI {
+			return i
+		}
+		// 没有长度字段的标记
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			if !fn(marker, i, i+2) {
+				return i
+			}
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return -1
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return -1
+		}
+		if !fn(marker, i, end) {
+			return i
+		}
+		i = end
+	}
+	return -1
+}
+
+// jpegOrientation 读取 JPEG EXIF 中的方向, 没有或无法解析时返回 1
+func jpegOrientation(data []byte) int {
+	orientation := 1
+	jpegSegments(data, func(marker byte, start, end int) bool {
+		if marker != jpegAPP1 || !bytes.HasPrefix(data[start+4:end], exifHeader) {
+			return true
+		}
+		if o := exifOrientation(data[start+4+len(exifHeader) : end]); o > 0 {
+			orientation = o
+		}
+		return false
+	})
+	return orientation
+}
+
+// exifOrientation 从 TIFF 结构的 IFD0 中读取方向标签
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) != exifOrientationTag {
+			continue
+		}
+		o := int(order.Uint16(tiff[entry+8:]))
+		if o < 1 || o > 8 {
+			return 0
+		}
+		return o
+	}
+	return 0
+}
+
+// stripJPEG 去掉 EXIF/XMP(APP1)、IPTC(APP13) 和注释段, 保留 ICC 等其他段, 不重新编码
+func stripJPEG(data []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, jpegSOI)
+	sos := jpegSegments(data, func(marker byte, start, end int) bool {
+		switch marker {
+		case jpegAPP1, jpegAPP13, jpegCOM:
+		default:
+			out = append(out, data[start:end]...)
+		}
+		return true
+	})
+	if sos < 0 {
+		return nil, false
+	}
+	return append(out, data[sos:]...), true
+}
+
+// stripPNG 去掉 eXIf、文本和时间块
+func stripPNG(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, pngMagic) {
+		return nil, false
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngMagic...)
+	for i := len(pngMagic); i < len(data); {
+		if i+12 > len(data) {
+			return nil, false
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return nil, false
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, true
+}
+
+// stripWebP 去掉 EXIF 和 XMP 块, 并清除 VP8X 中对应的标志位
+func stripWebP(data []byte) ([]byte, bool) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, false
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size&1
+		if end > len(data) || size < 0 {
+			return nil, false
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if size > 0 {
+				out[start+8] &^= 0x08 | 0x04
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, true
+}
+
+// stripMetadata 按格式去掉元数据, 不支持的格式或结构异常时返回 false
+func stripMetadata(format string, data []byte) ([]byte, bool) {
+	switch format {
+	case formatJPEG:
+		return stripJPEG(data)
+	case formatPNG:
+		return stripPNG(data)
+	case formatWebP:
+		return stripWebP(data)
+	}
+	return nil, false
+}
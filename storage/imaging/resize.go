@@ -0,0 +1,188 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+const (
+	// FitContain 等比缩放到框内
+	FitContain = "contain"
+	// FitCover 等比缩放并居中裁剪为目标尺寸
+	FitCover = "cover"
+)
+
+// toRGBA 转为预乘 alpha 的 RGBA, 坐标从 0 开始
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// orient 按 EXIF 方向(1-8)变换为正向图片
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// targetSize 计算缩放后的尺寸和 cover 模式下的裁剪区域, 只缩小不放大
+func targetSize(w, h, width, height int, fit string) (dw, dh int, crop image.Rectangle) {
+	crop = image.Rect(0, 0, w, h)
+	if fit == FitCover && width > 0 && height > 0 {
+		// 先按目标宽高比居中裁剪
+		if w*height > h*width {
+			cw := h * width / height
+			crop = image.Rect((w-cw)/2, 0, (w-cw)/2+cw, h)
+		} else {
+			ch := w * height / width
+			crop = image.Rect(0, (h-ch)/2, w, (h-ch)/2+ch)
+		}
+		if crop.Dx() <= width {
+			return crop.Dx(), crop.Dy(), crop
+		}
+		return width, height, crop
+	}
+	scale := 1.0
+	if width > 0 && width < w {
+		scale = float64(width) / float64(w)
+	}
+	if height > 0 && height < h {
+		scale = math.Min(scale, float64(height)/float64(h))
+	}
+	dw = max(1, int(math.Round(float64(w)*scale)))
+	dh = max(1, int(math.Round(float64(h)*scale)))
+	return dw, dh, crop
+}
+
+// resize 把 src 中 crop 区域缩放到 dw*dh
+func resize(src *image.RGBA, crop image.Rectangle, dw, dh int) *image.RGBA {
+	if crop != src.Rect {
+		src = src.SubImage(crop).(*image.RGBA)
+	}
+	if dw == crop.Dx() && dh == crop.Dy() {
+		return toRGBA(src)
+	}
+	tmp := resampleX(src, dw)
+	return resampleY(tmp, dh)
+}
+
+// contrib 目标像素对应的源像素范围和权重
+type contrib struct {
+	start   int
+	weights []float64
+}
+
+// contribs 使用三角滤波计算权重, 缩小时按比例扩大滤波半径, 相当于区域平均
+func contribs(srcLen, dstLen int) []contrib {
+	scale := float64(srcLen) / float64(dstLen)
+	radius := math.Max(scale, 1)
+	out := make([]contrib, dstLen)
+	for i := range out {
+		center := (float64(i) + 0.5) * scale
+		lo := max(0, int(math.Floor(center-radius)))
+		hi := min(srcLen, int(math.Ceil(center+radius)))
+		c := contrib{start: lo}
+		sum := 0.0
+		for j := lo; j < hi; j++ {
+			w := 1 - math.Abs((float64(j)+0.5-center)/radius)
+			if w < 0 {
+				w = 0
+			}
+			c.weights = append(c.weights, w)
+			sum += w
+		}
+		if sum == 0 {
+			c.weights = []float64{1}
+			c.start = min(int(center), srcLen-1)
+			sum = 1
+		}
+		for k := range c.weights {
+			c.weights[k] /= sum
+		}
+		out[i] = c
+	}
+	return out
+}
+
+func resampleX(src *image.RGBA, dw int) *image.RGBA {
+	b := src.Rect
+	dst := image.NewRGBA(image.Rect(0, 0, dw, b.Dy()))
+	cs := contribs(b.Dx(), dw)
+	for y := 0; y < b.Dy(); y++ {
+		row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x, c := range cs {
+			var px [4]float64
+			for k, w := range c.weights {
+				o := (c.start + k) * 4
+				for ch := 0; ch < 4; ch++ {
+					px[ch] += float64(row[o+ch]) * w
+				}
+			}
+			setPixel(dst.Pix[dst.PixOffset(x, y):], px)
+		}
+	}
+	return dst
+}
+
+func resampleY(src *image.RGBA, dh int) *image.RGBA {
+	b := src.Rect
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), dh))
+	cs := contribs(b.Dy(), dh)
+	for y, c := range cs {
+		for x := 0; x < b.Dx(); x++ {
+			var px [4]float64
+			for k, w := range c.weights {
+				o := src.PixOffset(b.Min.X+x, b.Min.Y+c.start+k)
+				for ch := 0; ch < 4; ch++ {
+					px[ch] += float64(src.Pix[o+ch]) * w
+				}
+			}
+			setPixel(dst.Pix[dst.PixOffset(x, y):], px)
+		}
+	}
+	return dst
+}
+
+// setPixel 写入预乘 alpha 的像素, 颜色分量不能大于 alpha
+func setPixel(p []uint8, px [4]float64) {
+	a := math.Min(255, math.Max(0, math.Round(px[3])))
+	p[3] = uint8(a)
+	for ch := 0; ch < 3; ch++ {
+		p[ch] = uint8(math.Min(a, math.Max(0, math.Round(px[ch]))))
+	}
+}
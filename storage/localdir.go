@@ -51,6 +51,7 @@ func (ls *LocalStorage) Save(ctx context.Context, fi *FileInfo, r io.Reader) err
 		logs.Errorf("[local_storage] create file %s failed, %s", fpath, err)
 		return err
 	}
+	defer f.Close()
 	fi.Size, err = io.Copy(f, r)
 	if err != nil {
 		logs.Errorf("[local_storage] write file %s failed, %s", fpath, err)
@@ -90,6 +91,15 @@ func (ls *LocalStorage) ReadFile(storagePath string) (io.ReadCloser, error) {
 	return file, nil
 }
 
+// ObjectSize 获取文件大小
+func (ls *LocalStorage) ObjectSize(storagePath string) (int64, error) {
+	fi, err := os.Stat(filepath.Join(ls.Dir, filepath.Clean(storagePath)))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
 // DeleteFile 删除文件
 func (ls *LocalStorage) DeleteFile(storagePath string) error {
 	storagePath = filepath.Clean(storagePath)
@@ -211,17 +221,17 @@ func (ls *LocalStorage) copyDirectory(srcPath, destPath string) error {
 }
 
 func (l *LocalStorage) CreateMultipartUpload(ctx context.Context, in *CreateMultipartUploadInput) (*string, error) {
-	return nil, fmt.Errorf("multipart upload not supported for LocalStorage")
+	return nil, fmt.Errorf("%w for LocalStorage", ErrMultipartNotSupported)
 }
 func (l *LocalStorage) GeneratePresignedURL(ctx context.Context, in *GeneratePresignedURLInput) (*string, error) {
 	return nil, fmt.Errorf("presigned part URL not supported for LocalStorage")
 }
 func (l *LocalStorage) UploadPart(ctx context.Context, in *UploadPartInput) (*string, error) {
-	return nil, fmt.Errorf("multipart upload not supported for LocalStorage")
+	return nil, fmt.Errorf("%w for LocalStorage", ErrMultipartNotSupported)
 }
 func (l *LocalStorage) CompleteMultipartUpload(ctx context.Context, in *CompleteMultipartUploadInput) error {
-	return fmt.Errorf("multipart upload not supported for LocalStorage")
+	return fmt.Errorf("%w for LocalStorage", ErrMultipartNotSupported)
 }
 func (l *LocalStorage) AbortMultipartUpload(ctx context.Context, in *AbortMultipartUploadInput) error {
-	return fmt.Errorf("multipart upload not supported for LocalStorage")
+	return fmt.Errorf("%w for LocalStorage", ErrMultipartNotSupported)
 }
@@ -110,6 +110,16 @@ func (mfs *MinFs) ReadFile(storagePath string) (io.ReadCloser, error) {
 	return obj, nil
 }
 
+// ObjectSize 获取对象大小
+func (mfs *MinFs) ObjectSize(storagePath string) (int64, error) {
+	info, err := mfs.client.StatObject(mfs.ctx, mfs.mfsCfg.Bucket, storagePath, minio.StatObjectOptions{})
+	if err != nil {
+		logs.Errorf("minoss stat object error: %v", err)
+		return 0, err
+	}
+	return info.Size, nil
+}
+
 // DeleteFile 删除文件
 func (mfs *MinFs) DeleteFile(storagePath string) error {
 	if storagePath == "" {
@@ -244,8 +254,13 @@ func (mfs *MinFs) UploadPart(ctx context.Context, in *UploadPartInput) (*string,
 	if in.Data == nil {
 		return nil, fmt.Errorf("reader is empty")
 	}
+	// 长度未知时 minio 会把分片读入内存
+	size := int64(-1)
+	if in.ContentLength != nil {
+		size = *in.ContentLength
+	}
 	core := minio.Core{Client: mfs.client}
-	objPart, err := core.PutObjectPart(ctx, mfs.mfsCfg.Bucket, *in.StoragePath, *in.UploadID, *in.PartNumber, in.Data, -1, minio.PutObjectPartOptions{})
+	objPart, err := core.PutObjectPart(ctx, mfs.mfsCfg.Bucket, *in.StoragePath, *in.UploadID, *in.PartNumber, in.Data, size, minio.PutObjectPartOptions{})
 	if err != nil {
 		logs.Errorf("minoss upload part error: %v", err)
 		return nil, err
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/random"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TableNameTempFilePart = "core_upload_files_tmp_parts"
+
+	// chunkedUploadIDPrefix 不支持分片上传的存储使用的上传 ID 前缀, 分片作为独立对象保存
+	chunkedUploadIDPrefix = "chunked-"
+)
+
+// ErrMultipartNotSupported 存储不支持分片上传
+var ErrMultipartNotSupported = errors.New("multipart upload not supported")
+
+// TempFilePart 已上传的分片, 用于不支持列举分片的存储
+type TempFilePart struct {
+	ID uint `gorm:"primarykey"`
+	// UploadID 对应 TempFile.ThirdUploadID
+	UploadID   string `gorm:"column:upload_id;type:varchar(128);uniqueIndex:idx_upload_part"`
+	PartNumber int    `gorm:"column:part_number;uniqueIndex:idx_upload_part"`
+	ETag       string `gorm:"column:etag;type:varchar(128)"`
+	Size       int64  `gorm:"column:size"`
+	CreatedAt  time.Time
+}
+
+// TableName 表名
+func (*TempFilePart) TableName() string { return TableNameTempFilePart }
+
+// newTaskUploader 返回存储对应的分片上传实现: 存储自身实现了 iUploader 时直接使用(如腾讯云 COS),
+// 否则通过 Storager 的分片上传接口实现, 不支持分片上传的存储把分片保存为独立对象, 完成时合并
+func newTaskUploader(s Storager, db *gorm.DB) iUploader {
+	if updr, ok := s.(iUploader); ok {
+		return updr
+	}
+	return &multipartUploader{Storager: s, db: db}
+}
+
+// unwrapStorager 返回 newTaskUploader 包装前的存储
+func unwrapStorager(s Storager) Storager {
+	if mu, ok := s.(*multipartUploader); ok {
+		return mu.Storager
+	}
+	return s
+}
+
+// multipartUploader 基于 Storager 分片上传接口的通用实现, 分片的 ETag 记录在 TempFilePart 中
+type multipartUploader struct {
+	Storager
+	db *gorm.DB
+}
+
+// InitUploadTask 创建分片上传, 存储不支持时使用分片对象
+func (mu *multipartUploader) InitUploadTask(ctx context.Context, tempFile *TempFile) error {
+	uploadID, err := mu.CreateMultipartUpload(ctx, &CreateMultipartUploadInput{
+		StoragePath: &tempFile.StoragePath,
+		ContentType: &tempFile.MIMEType,
+	})
+	if errors.Is(err, ErrMultipartNotSupported) {
+		tempFile.ThirdUploadID = chunkedUploadIDPrefix + random.String(24)
+		return nil
+	}
+	if err != nil {
+		logs.Errorf("[multipart_uploader] create multipart upload error: %v", err)
+		return err
+	}
+	tempFile.ThirdUploadID = aws.ToString(uploadID)
+	return nil
+}
+
+// ListUploadExistsTrunk 列出已上传的分片, 分片号从 0 开始
+func (mu *multipartUploader) ListUploadExistsTrunk(ctx context.Context, tempFile *TempFile) ([]int, error) {
+	var parts []int
+	err := mu.db.WithContext(ctx).Model(&TempFilePart{}).
+		Where("upload_id = ?", tempFile.ThirdUploadID).
+		Order("part_number").Pluck("part_number", &parts).Error
+	if err != nil {
+		logs.Errorf("[multipart_uploader] list parts error: %v", err)
+		return nil, err
+	}
+	return parts, nil
+}
+
+// UploadTrunk 上传分片, 重复上传同一分片时覆盖
+func (mu *multipartUploader) UploadTrunk(ctx context.Context, tempFile *TempFile, partNumber int, r io.Reader, size int64) error {
+	part := &TempFilePart{UploadID: tempFile.ThirdUploadID, PartNumber: partNumber, Size: size}
+	if isChunkedUpload(tempFile) {
+		fi := &FileInfo{StoragePath: chunkPath(tempFile, partNumber), Size: size}
+		if err := mu.Save(ctx, fi, r); err != nil {
+			logs.Errorf("[multipart_uploader] save chunk error: %v", err)
+			return err
+		}
+	} else {
+		// 对象存储的分片号从 1 开始
+		in := &UploadPartInput{
+			StoragePath: &tempFile.StoragePath,
+			UploadID:    &tempFile.ThirdUploadID,
+			PartNumber:  aws.Int(partNumber + 1),
+			Data:        r,
+		}
+		if size > 0 {
+			in.ContentLength = aws.Int64(size)
+		}
+		etag, err := mu.UploadPart(ctx, in)
+		if err != nil {
+			logs.Errorf("[multipart_uploader] upload part error: %v", err)
+			return err
+		}
+		part.ETag = aws.ToString(etag)
+	}
+	err := mu.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "upload_id"}, {Name: "part_number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"etag", "size"}),
+	}).Create(part).Error
+	if err != nil {
+		logs.Errorf("[multipart_uploader] save part error: %v", err)
+	}
+	return err
+}
+
+// CompleteUploadTask 合并分片, 所有分片都上传后才能完成
+func (mu *multipartUploader) CompleteUploadTask(ctx context.Context, tempFile *TempFile) error {
+	var parts []*TempFilePart
+	err := mu.db.WithContext(ctx).Where("upload_id = ?", tempFile.ThirdUploadID).
+		Order("part_number").Find(&parts).Error
+	if err != nil {
+		logs.Errorf("[multipart_uploader] list parts error: %v", err)
+		return err
+	}
+	if int64(len(parts)) != tempFile.PartCount {
+		return fmt.Errorf("uploaded %d of %d parts", len(parts), tempFile.PartCount)
+	}
+	for i, p := range parts {
+		if p.PartNumber != i {
+			return fmt.Errorf("invalid part number %d, expect %d", p.PartNumber, i)
+		}
+	}
+
+	if isChunkedUpload(tempFile) {
+		err = mu.mergeChunks(ctx, tempFile, parts)
+	} else {
+		completed := &types.CompletedMultipartUpload{}
+		for _, p := range parts {
+			completed.Parts = append(completed.Parts, types.CompletedPart{
+				ETag:       aws.String(p.ETag),
+				PartNumber: aws.Int32(int32(p.PartNumber + 1)),
+			})
+		}
+		err = mu.CompleteMultipartUpload(ctx, &CompleteMultipartUploadInput{
+			StoragePath: &tempFile.StoragePath,
+			UploadID:    &tempFile.ThirdUploadID,
+			Parts:       completed,
+		})
+	}
+	if err != nil {
+		logs.Errorf("[multipart_uploader] complete upload error: %v", err)
+		return err
+	}
+	if err := mu.db.WithContext(ctx).Where("upload_id = ?", tempFile.ThirdUploadID).Delete(&TempFilePart{}).Error; err != nil {
+		logs.Warnf("[multipart_uploader] delete parts of %s error: %v", tempFile.ThirdUploadID, err)
+	}
+	return nil
+}
+
+// AbortUploadTask 取消分片上传, 删除已上传的分片对象和分片记录, 已不存在的分片对象忽略
+func (mu *multipartUploader) AbortUploadTask(ctx context.Context, tempFile *TempFile) error {
+	if isChunkedUpload(tempFile) {
+		var parts []int
+		err := mu.db.WithContext(ctx).Model(&TempFilePart{}).
+			Where("upload_id = ?", tempFile.ThirdUploadID).Pluck("part_number", &parts).Error
+		if err != nil {
+			logs.Errorf("[multipart_uploader] list parts error: %v", err)
+			return err
+		}
+		for _, n := range parts {
+			if err := mu.DeleteFile(chunkPath(tempFile, n)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logs.Errorf("[multipart_uploader] delete chunk %d of %s error: %v", n, tempFile.StoragePath, err)
+				return err
+			}
+		}
+	} else {
+		err := mu.AbortMultipartUpload(ctx, &AbortMultipartUploadInput{
+			StoragePath: &tempFile.StoragePath,
+			UploadID:    &tempFile.ThirdUploadID,
+		})
+		if err != nil {
+			logs.Errorf("[multipart_uploader] abort multipart upload error: %v", err)
+			return err
+		}
+	}
+	if err := mu.db.WithContext(ctx).Where("upload_id = ?", tempFile.ThirdUploadID).Delete(&TempFilePart{}).Error; err != nil {
+		logs.Errorf("[multipart_uploader] delete parts of %s error: %v", tempFile.ThirdUploadID, err)
+		return err
+	}
+	return nil
+}
+
+// mergeChunks 按顺序读取分片对象写入目标路径, 成功后删除分片对象
+func (mu *multipartUploader) mergeChunks(ctx context.Context, tempFile *TempFile, parts []*TempFilePart) error {
+	pr, pw := io.Pipe()
+	go func() {
+		for _, p := range parts {
+			rc, err := mu.ReadFile(chunkPath(tempFile, p.PartNumber))
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(pw, rc)
+			rc.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	fi := &FileInfo{StoragePath: tempFile.StoragePath, FileExt: tempFile.FileExt, MIMEType: tempFile.MIMEType, Size: tempFile.Size}
+	err := mu.Save(ctx, fi, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := mu.DeleteFile(chunkPath(tempFile, p.PartNumber)); err != nil {
+			logs.Warnf("[multipart_uploader] delete chunk %d of %s error: %v", p.PartNumber, tempFile.StoragePath, err)
+		}
+	}
+	return nil
+}
+
+func isChunkedUpload(tempFile *TempFile) bool {
+	return strings.HasPrefix(tempFile.ThirdUploadID, chunkedUploadIDPrefix)
+}
+
+// chunkPath 分片对象的路径, 与目标文件放在同一目录下
+func chunkPath(tempFile *TempFile, partNumber int) string {
+	return path.Join(path.Dir(tempFile.StoragePath), ".parts", tempFile.ThirdUploadID, fmt.Sprintf("%05d", partNumber))
+}
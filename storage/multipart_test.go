@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeMultipartStorage 在本地存储上模拟对象存储的分片上传
+type fakeMultipartStorage struct {
+	*LocalStorage
+	parts map[int]string
+}
+
+func (fs *fakeMultipartStorage) CreateMultipartUpload(ctx context.Context, in *CreateMultipartUploadInput) (*string, error) {
+	fs.parts = map[int]string{}
+	return aws.String("upload-1"), nil
+}
+
+func (fs *fakeMultipartStorage) UploadPart(ctx context.Context, in *UploadPartInput) (*string, error) {
+	data, err := io.ReadAll(in.Data)
+	if err != nil {
+		return nil, err
+	}
+	if in.ContentLength == nil || *in.ContentLength != int64(len(data)) {
+		return nil, fmt.Errorf("content length of part %d mismatch", *in.PartNumber)
+	}
+	fs.parts[*in.PartNumber] = string(data)
+	return aws.String(fmt.Sprintf("etag-%d", *in.PartNumber)), nil
+}
+
+func (fs *fakeMultipartStorage) CompleteMultipartUpload(ctx context.Context, in *CompleteMultipartUploadInput) error {
+	var sb strings.Builder
+	for i, p := range in.Parts.Parts {
+		n := int(*p.PartNumber)
+		if n != i+1 || *p.ETag != fmt.Sprintf("etag-%d", n) {
+			return fmt.Errorf("invalid part %d", n)
+		}
+		sb.WriteString(fs.parts[n])
+	}
+	return fs.Save(ctx, &FileInfo{StoragePath: *in.StoragePath}, strings.NewReader(sb.String()))
+}
+
+func (fs *fakeMultipartStorage) AbortMultipartUpload(ctx context.Context, in *AbortMultipartUploadInput) error {
+	fs.parts = nil
+	return nil
+}
+
+func TestMultipartUploader(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "upload.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&TempFilePart{}))
+
+	local, err := NewLocalStorage(config.LocalStorageConfig{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	for name, s := range map[string]Storager{
+		"chunked":   local,
+		"multipart": &fakeMultipartStorage{LocalStorage: local},
+	} {
+		t.Run(name, func(t *testing.T) {
+			updr := newTaskUploader(s, db)
+			tf := &TempFile{StoragePath: "/1/doc/" + name + ".txt", PartCount: 3, PartSize: 4}
+			assert.NoError(t, updr.InitUploadTask(ctx, tf))
+			assert.NotEmpty(t, tf.ThirdUploadID)
+			assert.Equal(t, name == "chunked", isChunkedUpload(tf))
+
+			chunks := []string{"aaaa", "bbbb", "cc"}
+			for _, i := range []int{2, 0} {
+				assert.NoError(t, updr.UploadTrunk(ctx, tf, i, strings.NewReader(chunks[i]), int64(len(chunks[i]))))
+			}
+			parts, err := updr.ListUploadExistsTrunk(ctx, tf)
+			assert.NoError(t, err)
+			assert.Equal(t, []int{0, 2}, parts)
+			assert.Error(t, updr.CompleteUploadTask(ctx, tf))
+
+			// 重复上传覆盖已有分片
+			assert.NoError(t, updr.UploadTrunk(ctx, tf, 1, strings.NewReader("xxxx"), 4))
+			assert.NoError(t, updr.UploadTrunk(ctx, tf, 1, strings.NewReader(chunks[1]), 4))
+			parts, err = updr.ListUploadExistsTrunk(ctx, tf)
+			assert.NoError(t, err)
+			assert.True(t, sort.IntsAreSorted(parts))
+			assert.Len(t, parts, 3)
+
+			assert.NoError(t, updr.CompleteUploadTask(ctx, tf))
+			rc, err := updr.ReadFile(tf.StoragePath)
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			assert.Equal(t, "aaaabbbbcc", string(data))
+
+			parts, err = updr.ListUploadExistsTrunk(ctx, tf)
+			assert.NoError(t, err)
+			assert.Empty(t, parts)
+			if isChunkedUpload(tf) {
+				_, err = local.ReadFile(chunkPath(tf, 0))
+				assert.Error(t, err)
+			}
+
+			// 分片号必须是 0 到 PartCount-1
+			tf = &TempFile{StoragePath: "/1/doc/" + name + "-invalid.txt", PartCount: 2, PartSize: 4}
+			assert.NoError(t, updr.InitUploadTask(ctx, tf))
+			assert.NoError(t, updr.UploadTrunk(ctx, tf, 0, strings.NewReader("aaaa"), 4))
+			assert.NoError(t, updr.UploadTrunk(ctx, tf, 5, strings.NewReader("bbbb"), 4))
+			assert.Error(t, updr.CompleteUploadTask(ctx, tf))
+
+			// 取消后删除分片对象和分片记录
+			assert.NoError(t, updr.AbortUploadTask(ctx, tf))
+			parts, err = updr.ListUploadExistsTrunk(ctx, tf)
+			assert.NoError(t, err)
+			assert.Empty(t, parts)
+			if isChunkedUpload(tf) {
+				_, err = local.ReadFile(chunkPath(tf, 0))
+				assert.Error(t, err)
+				_, err = local.ReadFile(chunkPath(tf, 5))
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+// Package quota 按公司、用户和用途统计已上传文件的容量, 并在上传前检查套餐限制.
+//
+// 用量保存在 core_storage_usage 表中, 上传完成时调用 Add 增量更新, 文件变为不计入用量的状态(如 deleted)时调用 Release;
+// 只软删除而状态仍计入用量的记录在垃圾回收彻底删除时释放. 没有经过这两个函数的修改(如直接改状态、批量清理)
+// 会产生偏差, 由 Reconcile 定期按文件记录重新计算.
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/settings"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TableNameUsage = "core_storage_usage"
+	TableNamePlan  = "core_storage_quota_plans"
+
+	// SettingKey 默认限制的配置项, 位于 core 组, 值为 yaml
+	SettingKey = "storage-quota"
+
+	// tableNameFile 与 storage.TableNameFileInfo 一致, 避免依赖 storage 包
+	tableNameFile = "core_upload_files"
+)
+
+// excludedStatus 不计入用量的文件状态: 上传未完成、已取消、已删除和已隔离的文件
+var excludedStatus = []string{"init", "uploading", "upload_wait_comp", "failed", "aborted", "deleted", "infected"}
+
+// IsCounted 该状态的文件是否计入用量
+func IsCounted(status string) bool {
+	return !slices.Contains(excludedStatus, status)
+}
+
+// ErrQuotaExceeded 超出容量限制
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Key 用量的统计维度
+type Key struct {
+	CompanyID uint
+	Uin       uint
+	Purpose   string
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("%d/%d/%s", k.CompanyID, k.Uin, k.Purpose)
+}
+
+// Usage 每个公司、用户、用途的用量
+type Usage struct {
+	ID        uint      `gorm:"primarykey" json:"-"`
+	CompanyID uint      `gorm:"column:company_id;uniqueIndex:idx_storage_usage_key" json:"company_id"`
+	Uin       uint      `gorm:"column:uin;uniqueIndex:idx_storage_usage_key" json:"uin"`
+	Purpose   string    `gorm:"column:purpose;type:varchar(32);uniqueIndex:idx_storage_usage_key" json:"purpose"`
+	Size      int64     `gorm:"column:size;not null;default:0" json:"size"`
+	FileCount int64     `gorm:"column:file_count;not null;default:0" json:"file_count"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (*Usage) TableName() string { return TableNameUsage }
+
+// Plan 公司购买的套餐, 存在时替代 storage-quota 配置项中的默认限制
+type Plan struct {
+	gorm.Model
+
+	CompanyID     uint             `gorm:"column:company_id;uniqueIndex" json:"company_id"`
+	Name          string           `gorm:"column:name;type:varchar(64)" json:"name"`
+	CompanyLimit  int64            `gorm:"column:company_limit" json:"company_limit"`
+	UinLimit      int64            `gorm:"column:uin_limit" json:"uin_limit"`
+	PurposeLimits map[string]int64 `gorm:"column:purpose_limits;type:json;serializer:json" json:"purpose_limits"`
+}
+
+func (*Plan) TableName() string { return TableNamePlan }
+
+// InitDB 创建用量表和套餐表
+func InitDB(db *gorm.DB) error {
+	return db.AutoMigrate(&Usage{}, &Plan{})
+}
+
+// GetLimits 获取公司的容量限制, 优先使用套餐, 其次使用 storage-quota 配置项, 都没有时不限制
+func GetLimits(db *gorm.DB, companyID uint) (*config.StorageQuotaConfig, error) {
+	plan := &Plan{}
+	err := db.Where("company_id = ?", companyID).First(plan).Error
+	if err == nil {
+		return &config.StorageQuotaConfig{
+			CompanyLimit:  plan.CompanyLimit,
+			UinLimit:      plan.UinLimit,
+			PurposeLimits: plan.PurposeLimits,
+		}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	cfg := &config.StorageQuotaConfig{}
+	err = settings.GetYaml(settings.SettingGroupCore, SettingKey, cfg)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Check 检查再上传 size 字节后是否超出限制, 超出时返回包装 ErrQuotaExceeded 的错误.
+// 检查和 Add 之间没有加锁, 并发上传时可能略微超出
+func Check(db *gorm.DB, key Key, size int64) error {
+	limits, err := GetLimits(db, key.CompanyID)
+	if err != nil {
+		return err
+	}
+	checks := []struct {
+		scope string
+		limit int64
+		where string
+		args  []interface{}
+	}{
+		{"company", limits.CompanyLimit, "company_id = ?", []interface{}{key.CompanyID}},
+		{"uin", limits.UinLimit, "company_id = ? AND uin = ?", []interface{}{key.CompanyID, key.Uin}},
+		{"purpose " + key.Purpose, limits.PurposeLimits[key.Purpose], "company_id = ? AND purpose = ?", []interface{}{key.CompanyID, key.Purpose}},
+	}
+	for _, c := range checks {
+		if c.limit <= 0 {
+			continue
+		}
+		var used int64
+		err := db.Model(&Usage{}).Where(c.where, c.args...).Select("COALESCE(SUM(size), 0)").Scan(&used).Error
+		if err != nil {
+			return err
+		}
+		if used+size > c.limit {
+			return fmt.Errorf("%w: %s of company %d used %d, adding %d exceeds %d",
+				ErrQuotaExceeded, c.scope, key.CompanyID, used, size, c.limit)
+		}
+	}
+	return nil
+}
+
+// Add 增加用量, 应在创建文件记录的同一事务中调用
+func Add(db *gorm.DB, key Key, size int64) error {
+	return incr(db, key, size, 1)
+}
+
+// Release 减少用量, 删除文件或文件变为不计入用量的状态时调用
+func Release(db *gorm.DB, key Key, size int64) error {
+	return incr(db, key, -size, -1)
+}
+
+func incr(db *gorm.DB, key Key, size, count int64) error {
+	u := &Usage{CompanyID: key.CompanyID, Uin: key.Uin, Purpose: key.Purpose, Size: max(size, 0), FileCount: max(count, 0)}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "company_id"}, {Name: "uin"}, {Name: "purpose"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"size":       gorm.Expr("size + ?", size),
+			"file_count": gorm.Expr("file_count + ?", count),
+			"updated_at": time.Now(),
+		}),
+	}).Create(u).Error
+}
+
+// UsageReport 公司的用量和限制
+type UsageReport struct {
+	Limits    config.StorageQuotaConfig `json:"limits"`
+	Size      int64                     `json:"size"`
+	FileCount int64                     `json:"file_count"`
+	// UinSize 指定用户时该用户的用量
+	UinSize int64 `json:"uin_size"`
+	// Purposes 公司每种用途的用量
+	Purposes map[string]int64 `json:"purposes"`
+}
+
+// GetUsage 获取公司的用量, uin 不为 0 时同时统计该用户的用量
+func GetUsage(db *gorm.DB, companyID, uin uint) (*UsageReport, error) {
+	limits, err := GetLimits(db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	var rows []*Usage
+	if err := db.Where("company_id = ?", companyID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	report := &UsageReport{Limits: *limits, Purposes: map[string]int64{}}
+	for _, u := range rows {
+		report.Size += u.Size
+		report.FileCount += u.FileCount
+		report.Purposes[u.Purpose] += u.Size
+		if uin != 0 && u.Uin == uin {
+			report.UinSize += u.Size
+		}
+	}
+	return report, nil
+}
+
+// ReconcileReport 对账结果, Fixed 的格式为 company/uin/purpose: 旧用量 -> 新用量
+type ReconcileReport struct {
+	Checked int      `json:"checked"`
+	Fixed   []string `json:"fixed"`
+}
+
+// Reconcile 按文件记录重新计算用量, 修正增量统计的偏差; 软删除但还没有被彻底删除的记录仍计入用量
+func Reconcile(ctx context.Context, db *gorm.DB) (*ReconcileReport, error) {
+	db = db.WithContext(ctx)
+	var actual []*Usage
+	err := db.Table(tableNameFile).
+		Select("company_id, uin, purpose, SUM(size) AS size, COUNT(*) AS file_count").
+		Where("status NOT IN ?", excludedStatus).
+		Group("company_id, uin, purpose").
+		Scan(&actual).Error
+	if err != nil {
+		return nil, err
+	}
+	var recorded []*Usage
+	if err := db.Find(&recorded).Error; err != nil {
+		return nil, err
+	}
+	current := make(map[Key]*Usage, len(recorded))
+	for _, u := range recorded {
+		current[Key{u.CompanyID, u.Uin, u.Purpose}] = u
+	}
+
+	report := &ReconcileReport{}
+	fix := func(key Key, old *Usage, size, count int64) error {
+		var oldSize int64
+		if old != nil {
+			if old.Size == size && old.FileCount == count {
+				return nil
+			}
+			oldSize = old.Size
+		}
+		report.Fixed = append(report.Fixed, fmt.Sprintf("%s: %d -> %d", key, oldSize, size))
+		return db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "company_id"}, {Name: "uin"}, {Name: "purpose"}},
+			DoUpdates: clause.AssignmentColumns([]string{"size", "file_count", "updated_at"}),
+		}).Create(&Usage{CompanyID: key.CompanyID, Uin: key.Uin, Purpose: key.Purpose, Size: size, FileCount: count}).Error
+	}
+	for _, u := range actual {
+		key := Key{u.CompanyID, u.Uin, u.Purpose}
+		report.Checked++
+		if err := fix(key, current[key], u.Size, u.FileCount); err != nil {
+			return report, err
+		}
+		delete(current, key)
+	}
+	// 没有有效文件的统计清零
+	for key, u := range current {
+		report.Checked++
+		if err := fix(key, u, 0, 0); err != nil {
+			return report, err
+		}
+	}
+	if len(report.Fixed) > 0 {
+		logs.Warnf("[storage_quota] reconcile fixed %d usages: %v", len(report.Fixed), report.Fixed)
+	}
+	return report, nil
+}
+
+// ReconcileFunc 返回可注册为定时任务的函数, 输出报告的 JSON, 例:
+// job.RegistryCronFunc(db, "0 0 4 * * *", "storage-quota-reconcile", quota.ReconcileFunc(db))
+func ReconcileFunc(db *gorm.DB) func() (string, error) {
+	return func() (string, error) {
+		report, err := Reconcile(context.Background(), db)
+		data, _ := json.Marshal(report)
+		return string(data), err
+	}
+}
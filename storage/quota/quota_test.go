@@ -0,0 +1,83 @@
+package quota
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// file 只包含对账用到的字段
+type file struct {
+	gorm.Model
+	CompanyID uint
+	Uin       uint
+	Purpose   string
+	Size      int64
+	Status    string
+}
+
+func (*file) TableName() string { return tableNameFile }
+
+func TestQuota(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "quota.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, InitDB(db))
+	assert.NoError(t, db.AutoMigrate(&file{}))
+
+	assert.NoError(t, db.Create(&Plan{CompanyID: 1, CompanyLimit: 1000, UinLimit: 600, PurposeLimits: map[string]int64{"avatar": 100}}).Error)
+	assert.NoError(t, db.Create(&Plan{CompanyID: 2}).Error)
+
+	key := Key{CompanyID: 1, Uin: 10, Purpose: "doc"}
+	assert.NoError(t, Check(db, key, 600))
+	assert.NoError(t, Add(db, key, 500))
+	assert.NoError(t, Add(db, key, 50))
+	assert.ErrorIs(t, Check(db, key, 51), ErrQuotaExceeded)
+	assert.NoError(t, Check(db, Key{CompanyID: 1, Uin: 11, Purpose: "doc"}, 450))
+	assert.ErrorIs(t, Check(db, Key{CompanyID: 1, Uin: 11, Purpose: "doc"}, 451), ErrQuotaExceeded)
+	assert.ErrorIs(t, Check(db, Key{CompanyID: 1, Uin: 11, Purpose: "avatar"}, 101), ErrQuotaExceeded)
+
+	assert.NoError(t, Release(db, key, 50))
+	report, err := GetUsage(db, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), report.Size)
+	assert.Equal(t, int64(1), report.FileCount)
+	assert.Equal(t, int64(500), report.UinSize)
+	assert.Equal(t, map[string]int64{"doc": 500}, report.Purposes)
+	assert.Equal(t, int64(1000), report.Limits.CompanyLimit)
+
+	// 文件记录与统计不一致时对账修正
+	assert.NoError(t, db.Create(&file{CompanyID: 1, Uin: 10, Purpose: "doc", Size: 300, Status: "normal"}).Error)
+	assert.NoError(t, db.Create(&file{CompanyID: 1, Uin: 10, Purpose: "doc", Size: 100, Status: "pending_scan"}).Error)
+	assert.NoError(t, db.Create(&file{CompanyID: 1, Uin: 10, Purpose: "doc", Size: 999, Status: "deleted"}).Error)
+	assert.NoError(t, db.Create(&file{CompanyID: 1, Uin: 12, Purpose: "avatar", Size: 20, Status: "normal"}).Error)
+	removed := &file{CompanyID: 1, Uin: 12, Purpose: "avatar", Size: 30, Status: "normal"}
+	assert.NoError(t, db.Create(removed).Error)
+	assert.NoError(t, db.Delete(removed).Error)
+	assert.NoError(t, Add(db, Key{CompanyID: 2, Uin: 1, Purpose: "doc"}, 10))
+
+	rec, err := Reconcile(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rec.Checked)
+	assert.Len(t, rec.Fixed, 3)
+
+	// 软删除的记录在彻底删除前仍计入用量
+	report, err = GetUsage(db, 1, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(450), report.Size)
+	assert.Equal(t, int64(4), report.FileCount)
+	assert.Equal(t, int64(50), report.UinSize)
+	report, err = GetUsage(db, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), report.Size)
+
+	rec, err = Reconcile(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Empty(t, rec.Fixed)
+
+	assert.True(t, IsCounted("pending_scan"))
+	assert.False(t, IsCounted("infected"))
+}
@@ -173,6 +173,18 @@ func (s3fs *S3Fs) ReadFile(storagePath string) (io.ReadCloser, error) {
 	return obj.Body, nil
 }
 
+// ObjectSize 获取对象大小
+func (s3fs *S3Fs) ObjectSize(storagePath string) (int64, error) {
+	out, err := s3fs.client.HeadObject(s3fs.ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s3fs.s3fsCfg.Bucket),
+		Key:    aws.String(storagePath),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
+
 // DeleteFile 删除文件
 func (s3fs *S3Fs) DeleteFile(storagePath string) error {
 	if storagePath == "" {
@@ -420,11 +432,12 @@ func (s3fs *S3Fs) UploadPart(ctx context.Context, in *UploadPartInput) (*string,
 		return nil, fmt.Errorf("reader is empty")
 	}
 	out, err := s3fs.client.UploadPart(ctx, &s3.UploadPartInput{
-		Bucket:     s3fs.getBucketName(in.Bucket),
-		Key:        in.StoragePath,
-		UploadId:   in.UploadID,
-		PartNumber: aws.Int32(int32(*in.PartNumber)),
-		Body:       in.Data,
+		Bucket:        s3fs.getBucketName(in.Bucket),
+		Key:           in.StoragePath,
+		UploadId:      in.UploadID,
+		PartNumber:    aws.Int32(int32(*in.PartNumber)),
+		Body:          in.Data,
+		ContentLength: in.ContentLength,
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,178 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ygpkg/yg-go/config"
+)
+
+const (
+	defaultClamAVTimeout   = 5 * time.Minute
+	defaultClamAVChunkSize = 64 * 1024
+)
+
+// ClamAV 通过 clamd 的 INSTREAM 命令扫描文件内容
+type ClamAV struct {
+	network   string
+	address   string
+	timeout   time.Duration
+	chunkSize int
+}
+
+// NewClamAV 创建 clamd 客户端
+func NewClamAV(cfg config.ClamAVConfig) (*ClamAV, error) {
+	c := &ClamAV{
+		network:   "tcp",
+		address:   cfg.Address,
+		timeout:   cfg.Timeout,
+		chunkSize: cfg.ChunkSize,
+	}
+	if scheme, addr, ok := strings.Cut(cfg.Address, "://"); ok {
+		c.network, c.address = scheme, addr
+	}
+	if c.network != "tcp" && c.network != "unix" {
+		return nil, fmt.Errorf("clamav: unsupported network %q", c.network)
+	}
+	if c.address == "" {
+		return nil, errors.New("clamav: address is empty")
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultClamAVTimeout
+	}
+	if c.chunkSize <= 0 {
+		c.chunkSize = defaultClamAVChunkSize
+	}
+	return c, nil
+}
+
+// Name 扫描器名称
+func (c *ClamAV) Name() string { return "clamav" }
+
+// Ping 检查 clamd 是否可用
+func (c *ClamAV) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("clamav: write ping: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamav: unexpected ping reply %q", reply)
+	}
+	return nil
+}
+
+// Scan 使用 INSTREAM 命令把内容分块发送给 clamd 扫描
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := c.stream(conn, r); err != nil {
+		// 超过 StreamMaxLength 时 clamd 会先返回错误再关闭连接, 优先使用其中的原因
+		if reply, rerr := readReply(conn); rerr == nil {
+			if _, perr := parseScanReply(reply); perr != nil {
+				return nil, perr
+			}
+		}
+		return nil, err
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return nil, err
+	}
+	return parseScanReply(reply)
+}
+
+// stream 发送 INSTREAM 命令和数据块, 每块以 4 字节大端长度开头, 长度为 0 的块表示结束
+func (c *ClamAV) stream(conn net.Conn, r io.Reader) error {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("clamav: write command: %w", err)
+	}
+	buf := make([]byte, 4+c.chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				return fmt.Errorf("clamav: write chunk: %w", werr)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("clamav: read content: %w", err)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("clamav: write end of stream: %w", err)
+	}
+	return nil
+}
+
+// dial 建立连接, 超时取 ctx 的截止时间和配置的超时中较早的一个, ctx 取消时关闭连接
+func (c *ClamAV) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("clamav: dial %s: %w", c.address, err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &clamConn{Conn: conn, release: func() { stop(); cancel() }}, nil
+}
+
+// clamConn 关闭时释放 dial 中创建的 context
+type clamConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *clamConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
+// readReply 读取以 \0 结尾的响应
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && (err != io.EOF || len(reply) == 0) {
+		return "", fmt.Errorf("clamav: read reply: %w", err)
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseScanReply 解析扫描响应, 格式为 "stream: OK"、"stream: <signature> FOUND" 或 "<message> ERROR"
+func parseScanReply(reply string) (*Result, error) {
+	msg := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case msg == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(msg, " FOUND")}, nil
+	case strings.HasSuffix(msg, " ERROR"):
+		return nil, fmt.Errorf("clamav: %s", strings.TrimSuffix(msg, " ERROR"))
+	}
+	return nil, fmt.Errorf("clamav: unexpected reply %q", reply)
+}
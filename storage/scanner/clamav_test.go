@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+)
+
+// serveClamd 模拟 clamd, 处理 PING 和 INSTREAM 命令, 超过 maxLen 时返回 size limit 错误
+func serveClamd(t *testing.T, maxLen int) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleClamd(conn, maxLen)
+		}
+	}()
+	return "tcp://" + ln.Addr().String()
+}
+
+func handleClamd(conn net.Conn, maxLen int) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	cmd, err := br.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch cmd {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+		return
+	case "zINSTREAM\x00":
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+	var data []byte
+	for {
+		var size uint32
+		if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return
+		}
+		data = append(data, chunk...)
+		if len(data) > maxLen {
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			return
+		}
+	}
+	if bytes.Contains(data, []byte(EICAR)) {
+		conn.Write([]byte("stream: Win.Test.EICAR_HDB-1 FOUND\x00"))
+		return
+	}
+	conn.Write([]byte("stream: OK\x00"))
+}
+
+func TestClamAV(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewClamAV(config.ClamAVConfig{Address: serveClamd(t, 1000), ChunkSize: 16})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Ping(ctx))
+
+	res, err := c.Scan(ctx, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.False(t, res.Infected)
+
+	res, err = c.Scan(ctx, strings.NewReader("prefix "+EICAR))
+	assert.NoError(t, err)
+	assert.True(t, res.Infected)
+	assert.Equal(t, "Win.Test.EICAR_HDB-1", res.Signature)
+
+	_, err = c.Scan(ctx, bytes.NewReader(make([]byte, 2000)))
+	assert.ErrorContains(t, err, "size limit exceeded")
+
+	_, err = NewClamAV(config.ClamAVConfig{Address: "udp://127.0.0.1:3310"})
+	assert.Error(t, err)
+}
+
+func TestParseScanReply(t *testing.T) {
+	res, err := parseScanReply("stream: OK")
+	assert.NoError(t, err)
+	assert.False(t, res.Infected)
+	res, err = parseScanReply("stream: Eicar-Signature FOUND")
+	assert.NoError(t, err)
+	assert.Equal(t, "Eicar-Signature", res.Signature)
+	_, err = parseScanReply("stream: broken")
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// EICAR 标准的反病毒测试字符串, 各扫描器都会报告为病毒
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// Fake 用于测试的扫描器, 内容包含特征串时报告为病毒
+type Fake struct {
+	mu         sync.Mutex
+	signatures map[string]string
+	err        error
+	scanned    int
+}
+
+// NewFake 创建测试扫描器, 默认识别 EICAR 测试字符串
+func NewFake() *Fake {
+	return &Fake{signatures: map[string]string{EICAR: "Eicar-Signature"}}
+}
+
+// Name 扫描器名称
+func (f *Fake) Name() string { return "fake" }
+
+// AddSignature 添加特征串, 内容包含 pattern 时报告为 name
+func (f *Fake) AddSignature(pattern, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signatures[pattern] = name
+}
+
+// SetError 设置后每次扫描都返回该错误, 用于模拟扫描服务不可用
+func (f *Fake) SetError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// Scanned 成功扫描的次数
+func (f *Fake) Scanned() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.scanned
+}
+
+// Scan 读取全部内容并匹配特征串
+func (f *Fake) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.scanned++
+	for pattern, name := range f.signatures {
+		if bytes.Contains(data, []byte(pattern)) {
+			return &Result{Infected: true, Signature: name}, nil
+		}
+	}
+	return &Result{}, nil
+}
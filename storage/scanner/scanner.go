@@ -0,0 +1,23 @@
+// Package scanner 上传文件的病毒扫描, 提供 clamd 客户端和用于测试的 Fake 实现.
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Result 扫描结果
+type Result struct {
+	// Infected 是否发现病毒
+	Infected bool
+	// Signature 命中的病毒特征名
+	Signature string
+}
+
+// Scanner 病毒扫描器
+type Scanner interface {
+	// Name 扫描器名称, 记录在扫描结果中
+	Name() string
+	// Scan 扫描 r 的全部内容, 无法完成扫描时返回错误
+	Scan(ctx context.Context, r io.Reader) (*Result, error)
+}
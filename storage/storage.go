@@ -11,6 +11,7 @@ import (
 	dbtools "github.com/ygpkg/yg-go/dbtools/v2"
 	"github.com/ygpkg/yg-go/logs"
 	"github.com/ygpkg/yg-go/settings"
+	"github.com/ygpkg/yg-go/storage/quota"
 	"gorm.io/gorm"
 )
 
@@ -49,6 +50,11 @@ type Storager interface {
 	AbortMultipartUpload(ctx context.Context, in *AbortMultipartUploadInput) error
 }
 
+// objectSizer 可选接口, 不读取内容获取对象大小
+type objectSizer interface {
+	ObjectSize(storagePath string) (int64, error)
+}
+
 // CreateMultipartUploadInput 请求对象
 type CreateMultipartUploadInput struct {
 	Bucket      *string
@@ -75,6 +81,8 @@ type UploadPartInput struct {
 	UploadID    *string
 	PartNumber  *int
 	Data        io.Reader
+	// ContentLength 分片长度, S3 和 MinIO 需要已知长度才能流式上传
+	ContentLength *int64
 }
 
 // CompleteMultipartUploadInput 请求对象
@@ -94,7 +102,7 @@ type AbortMultipartUploadInput struct {
 
 // InitDB .
 func InitDB(db *gorm.DB) error {
-	return dbtools.InitModel(db, &FileInfo{}, &TempFile{})
+	return dbtools.InitModel(db, &FileInfo{}, &TempFile{}, &TempFilePart{}, &quota.Usage{}, &quota.Plan{})
 }
 
 // UploadFile 上传文件
@@ -186,6 +186,14 @@ func (tc *TencentCos) UploadTrunk(ctx context.Context, tempFile *TempFile, partN
 	return nil
 }
 
+// AbortUploadTask .
+func (tc *TencentCos) AbortUploadTask(ctx context.Context, tempFile *TempFile) error {
+	return tc.AbortMultipartUpload(ctx, &AbortMultipartUploadInput{
+		StoragePath: &tempFile.StoragePath,
+		UploadID:    &tempFile.ThirdUploadID,
+	})
+}
+
 // CompleteUploadTask .
 func (tc *TencentCos) CompleteUploadTask(ctx context.Context, tempFile *TempFile) error {
 	var (
@@ -228,6 +236,17 @@ func (tc *TencentCos) ReadFile(storagePath string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// ObjectSize 获取对象大小
+func (tc *TencentCos) ObjectSize(storagePath string) (int64, error) {
+	resp, err := tc.client.Object.Head(context.Background(), storagePath, nil)
+	if err != nil {
+		logs.Errorf("tencent cos head object error: %v", err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
 // DeleteFile 删除文件
 func (tc *TencentCos) DeleteFile(storagePath string) error {
 	if storagePath == "" {
@@ -2,17 +2,19 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/ygpkg/yg-go/config"
+	dbtools "github.com/ygpkg/yg-go/dbtools/v2"
 	"github.com/ygpkg/yg-go/httptools"
 	"github.com/ygpkg/yg-go/logs"
 	"github.com/ygpkg/yg-go/random"
 	"github.com/ygpkg/yg-go/settings"
+	"github.com/ygpkg/yg-go/storage/quota"
+	"github.com/ygpkg/yg-go/storage/uploadpolicy"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -28,6 +30,7 @@ type iUploader interface {
 	ListUploadExistsTrunk(ctx context.Context, tempFile *TempFile) ([]int, error)
 	UploadTrunk(ctx context.Context, tempFile *TempFile, partNumber int, r io.Reader, size int64) error
 	CompleteUploadTask(ctx context.Context, tempFile *TempFile) error
+	AbortUploadTask(ctx context.Context, tempFile *TempFile) error
 }
 
 // Uploader 大文件分片上传
@@ -38,6 +41,7 @@ type Uploader struct {
 
 	tempFile *TempFile
 	updr     iUploader
+	policy   *uploadpolicy.Policy
 }
 
 func getUploadProvider(group, key string) (*config.StorageConfig, iUploader, error) {
@@ -47,24 +51,26 @@ func getUploadProvider(group, key string) (*config.StorageConfig, iUploader, err
 		logs.Errorf("get uploader storage config error: %v", err)
 		return nil, nil, err
 	}
-	if cfg.Tencent == nil {
-		logs.Errorf("get uploader storage config error: tenant config is nil")
-		return &cfg, nil, fmt.Errorf("storage config error")
-	}
-	tc, err := NewTencentCos(*cfg.Tencent, cfg.StorageOption)
+	s, err := NewStorageWithCfg(cfg)
 	if err != nil {
-		logs.Errorf("new tencent cos error: %v", err)
+		logs.Errorf("new uploader storage error: %v", err)
 		return &cfg, nil, err
 	}
-	return &cfg, tc, nil
+	return &cfg, newTaskUploader(s, dbtools.Core()), nil
 }
 
-// GetUploader 获取大文件上传器
+// GetUploader 获取大文件上传器, 超出存储空间时返回的错误包装 quota.ErrQuotaExceeded,
+// 不满足上传策略时包装 uploadpolicy.ErrRejected, 可使用 apis/upload.ResponseUploadError 返回
 func GetUploader(ctx context.Context, logger *zap.SugaredLogger, group, key string, req InitMultipartUploadRequest) (*Uploader, error) {
 	cfg, tc, err := getUploadProvider(group, key)
 	if err != nil {
 		return nil, err
 	}
+	policy, err := uploadpolicy.Load(cfg.Purpose)
+	if err != nil {
+		logger.Errorf("load upload policy error: %v", err)
+		return nil, err
+	}
 	var tempFile *TempFile
 	if req.UploadID != "" {
 		tempFile, err = GetTempFileByThirdUploadID(req.CompanyID, req.UploadID)
@@ -75,7 +81,7 @@ func GetUploader(ctx context.Context, logger *zap.SugaredLogger, group, key stri
 	} else {
 		tempFile, err = GetTempFileByHash(req.SHA1, req.Size)
 		if err == gorm.ErrRecordNotFound {
-			return newUploader(ctx, logger, cfg, tc, req)
+			return newUploader(ctx, logger, cfg, tc, policy, req)
 		} else if err != nil {
 			logger.Errorf("get temp file error: %v", err)
 			return nil, err
@@ -88,6 +94,7 @@ func GetUploader(ctx context.Context, logger *zap.SugaredLogger, group, key stri
 		ctx:      ctx,
 		tempFile: tempFile,
 		updr:     tc,
+		policy:   policy,
 	}
 
 	return up, nil
@@ -95,13 +102,22 @@ func GetUploader(ctx context.Context, logger *zap.SugaredLogger, group, key stri
 
 // newUploader 创建大文件上传器
 func newUploader(ctx context.Context, logger *zap.SugaredLogger,
-	cfg *config.StorageConfig, updr iUploader, req InitMultipartUploadRequest) (*Uploader, error) {
+	cfg *config.StorageConfig, updr iUploader, policy *uploadpolicy.Policy, req InitMultipartUploadRequest) (*Uploader, error) {
+	if err := policy.CheckSize(req.Size); err != nil {
+		logger.Warnf("init upload task error: %v", err)
+		return nil, err
+	}
+	qkey := quota.Key{CompanyID: req.CompanyID, Uin: req.Uin, Purpose: cfg.Purpose}
+	if err := quota.Check(dbtools.Core(), qkey, req.Size); err != nil {
+		logger.Warnf("init upload task error: %v", err)
+		return nil, err
+	}
 	tempFile := &TempFile{
 		CompanyID: req.CompanyID,
 		Uin:       req.Uin,
 		Purpose:   cfg.Purpose,
-		Filename:  req.Filename,
-		FileExt:   strings.ToLower(filepath.Ext(req.Filename)),
+		Filename:  uploadpolicy.SanitizeFilename(req.Filename),
+		FileExt:   uploadpolicy.FileExt(nil, req.Filename),
 		ChunkHash: req.SHA1,
 		Size:      req.Size,
 		ExpiredAt: time.Now().Add(time.Hour * 24 * 7),
@@ -129,6 +145,7 @@ func newUploader(ctx context.Context, logger *zap.SugaredLogger,
 		logger:   logger,
 		tempFile: tempFile,
 		updr:     updr,
+		policy:   policy,
 	}
 
 	return up, nil
@@ -158,8 +175,11 @@ func (u *Uploader) ListTrunks() (*CheckMultipartUploadResponse, error) {
 	return resp, nil
 }
 
-// UploadPart 上传分片
+// UploadPart 上传分片, 分片号从 0 开始
 func (u *Uploader) UploadPart(partNumber int, data io.Reader, size int64) error {
+	if partNumber < 0 || int64(partNumber) >= u.tempFile.PartCount {
+		return fmt.Errorf("invalid part number %d, part count %d", partNumber, u.tempFile.PartCount)
+	}
 	err := u.updr.UploadTrunk(u.ctx, u.tempFile, partNumber, data, size)
 	if err != nil {
 		u.logger.Errorf("upload trunk error: %v", err)
@@ -168,28 +188,45 @@ func (u *Uploader) UploadPart(partNumber int, data io.Reader, size int64) error
 	return nil
 }
 
-// CompleteUpload 合并分片
+// CompleteUpload 合并分片, 按合并后对象的实际大小检查上传策略和存储空间, 错误与 GetUploader 相同
 func (u *Uploader) CompleteUpload(db *gorm.DB) (*FileInfo, error) {
 	err := u.updr.CompleteUploadTask(u.ctx, u.tempFile)
 	if err != nil {
 		u.logger.Errorf("complete upload error: %v", err)
 		return nil, err
 	}
+	detected, size, err := u.checkUploaded()
+	if err != nil {
+		return nil, err
+	}
+	qkey := quota.Key{CompanyID: u.tempFile.CompanyID, Uin: u.tempFile.Uin, Purpose: u.tempFile.Purpose}
+	if err := quota.Check(db, qkey, size); err != nil {
+		u.logger.Warnf("complete upload error: %v", err)
+		if derr := u.updr.DeleteFile(u.tempFile.StoragePath); derr != nil {
+			u.logger.Errorf("delete over quota file %s error: %v", u.tempFile.StoragePath, derr)
+		}
+		return nil, err
+	}
 
 	fi := &FileInfo{
 		CompanyID:   u.tempFile.CompanyID,
 		Uin:         u.tempFile.Uin,
 		Purpose:     u.tempFile.Purpose,
 		Filename:    u.tempFile.Filename,
-		FileExt:     u.tempFile.FileExt,
-		MIMEType:    u.tempFile.MIMEType,
+		FileExt:     uploadpolicy.FileExt(detected, u.tempFile.Filename),
+		MIMEType:    detected.MIMEType,
 		ChunkHash:   u.tempFile.ChunkHash,
-		Size:        u.tempFile.Size,
+		Size:        size,
 		StoragePath: u.tempFile.StoragePath,
 		CopyNumber:  1,
 		Status:      FileStatusNormal,
 	}
-	fi.PublicURL = u.updr.GetPublicURL(fi.StoragePath, false)
+	// 需要扫描的文件在扫描通过后才保存访问地址
+	if u.policy.NeedScan() {
+		fi.Status = FileStatusPendingScan
+	} else {
+		fi.PublicURL = u.updr.GetPublicURL(fi.StoragePath, false)
+	}
 	err = db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(fi).Error; err != nil {
 			u.logger.Errorf("create file info error: %v", err)
@@ -199,6 +236,10 @@ func (u *Uploader) CompleteUpload(db *gorm.DB) (*FileInfo, error) {
 			u.logger.Errorf("delete temp file error: %v", err)
 			return err
 		}
+		if err := quota.Add(tx, qkey, fi.Size); err != nil {
+			u.logger.Errorf("add storage usage error: %v", err)
+			return err
+		}
 		return nil
 	})
 	if err != nil {
@@ -208,8 +249,64 @@ func (u *Uploader) CompleteUpload(db *gorm.DB) (*FileInfo, error) {
 	return fi, nil
 }
 
-// Cancel 取消上传
+// checkUploaded 合并后读取文件开头检测类型并获取实际大小, 实际大小超过上限或与声明的不一致时拒绝,
+// 不满足上传策略时删除已合并的文件
+func (u *Uploader) checkUploaded() (*uploadpolicy.Result, int64, error) {
+	detected, size, err := u.inspectUploaded()
+	if err == nil {
+		return detected, size, nil
+	}
+	u.logger.Warnf("check uploaded file %s error: %v", u.tempFile.StoragePath, err)
+	if errors.Is(err, uploadpolicy.ErrRejected) {
+		if derr := u.updr.DeleteFile(u.tempFile.StoragePath); derr != nil {
+			u.logger.Errorf("delete rejected file %s error: %v", u.tempFile.StoragePath, derr)
+		}
+	}
+	return nil, 0, err
+}
+
+func (u *Uploader) inspectUploaded() (*uploadpolicy.Result, int64, error) {
+	rc, err := u.updr.ReadFile(u.tempFile.StoragePath)
+	if err != nil {
+		u.logger.Errorf("read uploaded file error: %v", err)
+		return nil, 0, err
+	}
+	defer rc.Close()
+	detected, full, err := u.policy.Check(rc)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var size int64
+	if sizer, ok := unwrapStorager(u.updr).(objectSizer); ok {
+		size, err = sizer.ObjectSize(u.tempFile.StoragePath)
+	} else {
+		// 存储不支持获取大小时读取全部内容计数, 超过上限时 full 返回 ErrRejected
+		size, err = io.Copy(io.Discard, full)
+	}
+	if err != nil {
+		u.logger.Errorf("get uploaded file size error: %v", err)
+		return nil, 0, err
+	}
+	if err := u.policy.CheckSize(size); err != nil {
+		return nil, 0, err
+	}
+	if size != u.tempFile.Size {
+		return nil, 0, fmt.Errorf("%w: uploaded size %d differs from declared %d", uploadpolicy.ErrRejected, size, u.tempFile.Size)
+	}
+	return detected, size, nil
+}
+
+// Cancel 取消上传, 删除已上传的分片和临时文件记录
 func (u *Uploader) Cancel() error {
+	if err := u.updr.AbortUploadTask(u.ctx, u.tempFile); err != nil {
+		u.logger.Errorf("abort upload task error: %v", err)
+		return err
+	}
+	if err := dbtools.Core().Delete(u.tempFile).Error; err != nil {
+		u.logger.Errorf("delete temp file error: %v", err)
+		return err
+	}
 	return nil
 }
 
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/storage/uploadpolicy"
+	"go.uber.org/zap"
+)
+
+func TestUploaderCheckUploaded(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(config.LocalStorageConfig{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	u := &Uploader{
+		ctx:    ctx,
+		logger: zap.NewNop().Sugar(),
+		updr:   newTaskUploader(local, nil),
+		policy: uploadpolicy.New(config.UploadPolicyConfig{MaxSize: 8}),
+	}
+
+	for _, c := range []struct {
+		content  string
+		declared int64
+		ok       bool
+	}{
+		{"hello", 5, true},
+		// 实际大小与声明的不一致
+		{"hello world", 5, false},
+		// 实际大小超过上限
+		{"hello world", 11, false},
+	} {
+		u.tempFile = &TempFile{StoragePath: "/1/doc/a.txt", Size: c.declared}
+		assert.NoError(t, local.Save(ctx, &FileInfo{StoragePath: u.tempFile.StoragePath}, strings.NewReader(c.content)))
+		detected, size, err := u.checkUploaded()
+		if !c.ok {
+			assert.ErrorIs(t, err, uploadpolicy.ErrRejected)
+			_, err = local.ReadFile(u.tempFile.StoragePath)
+			assert.Error(t, err, "rejected file should be deleted")
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(c.content)), size)
+		assert.Equal(t, "text/plain", detected.MIMEType)
+	}
+}
@@ -0,0 +1,215 @@
+// Package uploadpolicy 按用途校验上传的文件: 根据内容检测类型、限制大小和图片尺寸、清理文件名.
+package uploadpolicy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/httptools"
+	"github.com/ygpkg/yg-go/settings"
+	"gorm.io/gorm"
+)
+
+const (
+	// SettingPrefix 上传策略配置项的 key 前缀, 配置项位于 core 组, 值为 yaml
+	SettingPrefix = "upload-policy-"
+
+	// sniffLen 检测类型读取的长度, 与 mimetype 的默认值一致
+	sniffLen = 3072
+	// maxImageHeaderLen 读取图片尺寸时最多读取的长度, JPEG 的 EXIF 可能较大
+	maxImageHeaderLen = 1 << 20
+
+	maxFilenameLen = 128
+	maxExtLen      = 8
+)
+
+// ErrRejected 文件不满足上传策略, 通常应返回 400
+var ErrRejected = errors.New("upload rejected by policy")
+
+// Result 检测结果
+type Result struct {
+	// MIMEType 根据内容检测的类型
+	MIMEType string
+	// Ext 类型对应的扩展名, 无法识别时为空
+	Ext string
+	// Width/Height 图片尺寸, 仅在配置了尺寸限制时读取
+	Width  int
+	Height int
+}
+
+// Policy 上传策略
+type Policy struct {
+	cfg config.UploadPolicyConfig
+}
+
+// New 创建上传策略
+func New(cfg config.UploadPolicyConfig) *Policy {
+	return &Policy{cfg: cfg}
+}
+
+// Load 读取用途对应的上传策略, 没有配置时返回不做限制的策略
+func Load(purpose string) (*Policy, error) {
+	var cfg config.UploadPolicyConfig
+	err := settings.GetYaml(settings.SettingGroupCore, SettingPrefix+purpose, &cfg)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return New(cfg), nil
+}
+
+// NeedScan 上传完成后是否需要等待病毒扫描
+func (p *Policy) NeedScan() bool {
+	return p.cfg.Scan
+}
+
+// CheckSize 检查客户端声明的文件大小, 用于在接收内容前拒绝
+func (p *Policy) CheckSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("%w: invalid size %d", ErrRejected, size)
+	}
+	if p.cfg.MaxSize > 0 && size > p.cfg.MaxSize {
+		return fmt.Errorf("%w: size %d exceeds %d", ErrRejected, size, p.cfg.MaxSize)
+	}
+	return nil
+}
+
+// Check 读取 r 开头的内容检测类型并校验, 返回的 Reader 包含完整内容;
+// 读取返回的 Reader 时超过大小上限会返回包装 ErrRejected 的错误
+func (p *Policy) Check(r io.Reader) (*Result, io.Reader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	head = head[:n]
+	if err := p.CheckSize(int64(n)); err != nil {
+		return nil, nil, err
+	}
+
+	res := &Result{}
+	res.MIMEType, res.Ext = httptools.DetectContentType(head)
+	if !p.allowed(res.MIMEType) {
+		return nil, nil, fmt.Errorf("%w: content type %s not allowed", ErrRejected, res.MIMEType)
+	}
+
+	// consumed 记录读取图片尺寸时从 r 多读取的内容
+	var consumed bytes.Buffer
+	if strings.HasPrefix(res.MIMEType, "image/") && (p.cfg.MaxWidth > 0 || p.cfg.MaxHeight > 0) {
+		hr := io.MultiReader(bytes.NewReader(head), io.TeeReader(io.LimitReader(r, maxImageHeaderLen), &consumed))
+		hdr, _, err := image.DecodeConfig(hr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: read dimensions of %s: %v", ErrRejected, res.MIMEType, err)
+		}
+		res.Width, res.Height = hdr.Width, hdr.Height
+		if p.cfg.MaxWidth > 0 && hdr.Width > p.cfg.MaxWidth {
+			return nil, nil, fmt.Errorf("%w: width %d exceeds %d", ErrRejected, hdr.Width, p.cfg.MaxWidth)
+		}
+		if p.cfg.MaxHeight > 0 && hdr.Height > p.cfg.MaxHeight {
+			return nil, nil, fmt.Errorf("%w: height %d exceeds %d", ErrRejected, hdr.Height, p.cfg.MaxHeight)
+		}
+	}
+
+	full := io.MultiReader(bytes.NewReader(head), &consumed, r)
+	if p.cfg.MaxSize > 0 {
+		full = &limitReader{r: full, remaining: p.cfg.MaxSize, max: p.cfg.MaxSize}
+	}
+	return res, full, nil
+}
+
+// allowed 判断类型是否在允许列表中, 列表项可以是 image/* 形式
+func (p *Policy) allowed(mt string) bool {
+	if len(p.cfg.AllowedMIMETypes) == 0 {
+		return true
+	}
+	for _, v := range p.cfg.AllowedMIMETypes {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == mt || v == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(v, "/*"); ok && strings.HasPrefix(mt, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// limitReader 读取超过 max 字节时返回错误
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+	max       int64
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, fmt.Errorf("%w: size exceeds %d", ErrRejected, lr.max)
+	}
+	// 多读一个字节用于判断是否超出
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return n + int(lr.remaining), fmt.Errorf("%w: size exceeds %d", ErrRejected, lr.max)
+	}
+	return n, err
+}
+
+// SanitizeFilename 清理客户端提交的文件名: 去掉路径、控制字符和 Windows 保留字符,
+// 去掉用于伪装扩展名的 Unicode 格式字符, 超长时保留扩展名截断
+func SanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ". ")
+	if name == "" {
+		return "file"
+	}
+	if len(name) <= maxFilenameLen {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > maxExtLen {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	limit := maxFilenameLen - len(ext)
+	for len(base) > limit {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	return base + ext
+}
+
+// FileExt 文件扩展名, 优先使用检测到的类型对应的扩展名, 否则使用文件名中的扩展名
+func FileExt(res *Result, filename string) string {
+	if res != nil && res.Ext != "" {
+		return res.Ext
+	}
+	ext := strings.ToLower(path.Ext(SanitizeFilename(filename)))
+	if len(ext) > maxExtLen {
+		return ""
+	}
+	return ext
+}
@@ -0,0 +1,77 @@
+package uploadpolicy
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))))
+	return buf.Bytes()
+}
+
+func TestCheck(t *testing.T) {
+	data := encodePNG(t, 40, 20)
+
+	p := New(config.UploadPolicyConfig{AllowedMIMETypes: []string{"image/*"}, MaxWidth: 50, MaxHeight: 50})
+	res, r, err := p.Check(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", res.MIMEType)
+	assert.Equal(t, ".png", res.Ext)
+	assert.Equal(t, 40, res.Width)
+	assert.Equal(t, 20, res.Height)
+	got, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// 扩展名伪装成图片的文本
+	_, _, err = p.Check(strings.NewReader("<html><script>alert(1)</script></html>"))
+	assert.ErrorIs(t, err, ErrRejected)
+
+	p = New(config.UploadPolicyConfig{MaxWidth: 30})
+	_, _, err = p.Check(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrRejected)
+
+	p = New(config.UploadPolicyConfig{AllowedMIMETypes: []string{"application/pdf"}})
+	_, _, err = p.Check(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrRejected)
+
+	// 超过大小上限时在读取过程中报错
+	big := bytes.Repeat([]byte("a"), 10000)
+	p = New(config.UploadPolicyConfig{MaxSize: 5000})
+	_, r, err = p.Check(bytes.NewReader(big))
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.ErrorIs(t, p.CheckSize(5001), ErrRejected)
+
+	p = New(config.UploadPolicyConfig{MaxSize: 10000})
+	_, r, err = p.Check(bytes.NewReader(big))
+	assert.NoError(t, err)
+	got, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Len(t, got, 10000)
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "passwd", SanitizeFilename("../../etc/passwd"))
+	assert.Equal(t, "a.txt", SanitizeFilename(`C:\dir\a.txt`))
+	assert.Equal(t, "evilgpj.exe", SanitizeFilename("evil\u202egpj.exe"))
+	assert.Equal(t, "a_b.txt", SanitizeFilename("a\x00<b.txt"))
+	assert.Equal(t, "file", SanitizeFilename(" .. "))
+
+	long := SanitizeFilename(strings.Repeat("中", 100) + ".png")
+	assert.LessOrEqual(t, len(long), maxFilenameLen)
+	assert.True(t, strings.HasSuffix(long, ".png"))
+
+	assert.Equal(t, ".png", FileExt(&Result{Ext: ".png"}, "a.jpg"))
+	assert.Equal(t, ".jpg", FileExt(nil, "a.JPG"))
+}
@@ -0,0 +1,75 @@
+package alioss
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// signedSubResources 参与签名的子资源, 见 OSS V1 签名文档
+var signedSubResources = map[string]bool{
+	"acl": true, "uploads": true, "location": true, "cors": true, "logging": true,
+	"website": true, "referer": true, "lifecycle": true, "delete": true, "append": true,
+	"tagging": true, "objectMeta": true, "uploadId": true, "partNumber": true,
+	"security-token": true, "position": true, "img": true, "style": true, "styleName": true,
+	"replication": true, "replicationProgress": true, "replicationLocation": true,
+	"cname": true, "bucketInfo": true, "comp": true, "qos": true, "live": true,
+	"status": true, "vod": true, "startTime": true, "endTime": true, "symlink": true,
+	"x-oss-process": true, "response-content-type": true, "response-content-language": true,
+	"response-expires": true, "response-cache-control": true, "response-content-disposition": true,
+	"response-content-encoding": true, "restore": true,
+}
+
+// stringToSign 构造 OSS V1 签名字符串, date 在预签名 URL 中为过期时间戳
+func stringToSign(method string, header http.Header, date, resource string) string {
+	var ossHeaders []string
+	for k := range header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-oss-") {
+			ossHeaders = append(ossHeaders, lk)
+		}
+	}
+	sort.Strings(ossHeaders)
+
+	var sb strings.Builder
+	sb.WriteString(method + "\n")
+	sb.WriteString(header.Get("Content-MD5") + "\n")
+	sb.WriteString(header.Get("Content-Type") + "\n")
+	sb.WriteString(date + "\n")
+	for _, k := range ossHeaders {
+		sb.WriteString(k + ":" + strings.TrimSpace(header.Get(k)) + "\n")
+	}
+	sb.WriteString(resource)
+	return sb.String()
+}
+
+// canonicalResource /{bucket}/{key} 加上排序后的子资源
+func canonicalResource(bucket, key string, query url.Values) string {
+	resource := "/" + bucket + "/" + key
+	var subs []string
+	for k := range query {
+		if signedSubResources[k] {
+			subs = append(subs, k)
+		}
+	}
+	if len(subs) == 0 {
+		return resource
+	}
+	sort.Strings(subs)
+	for i, k := range subs {
+		if v := query.Get(k); v != "" {
+			subs[i] = k + "=" + v
+		}
+	}
+	return resource + "?" + strings.Join(subs, "&")
+}
+
+func sign(secret, str string) string {
+	h := hmac.New(sha1.New, []byte(secret))
+	h.Write([]byte(str))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,625 @@
+package alioss
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/logs"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+func init() {
+	storage.Register("alioss", func(cfg config.StorageConfig) (storage.Storager, error) {
+		if cfg.AliOSS == nil {
+			return nil, fmt.Errorf("alioss config is nil")
+		}
+		return NewAliOSS(*cfg.AliOSS, cfg.StorageOption)
+	})
+}
+
+var _ storage.Storager = (*AliOSS)(nil)
+
+const (
+	defaultPresignedTimeout = 15 * time.Minute
+	metaPrefix              = "x-oss-meta-"
+)
+
+// AliOSS 阿里云对象存储, 直接调用 OSS REST API
+type AliOSS struct {
+	opt    config.StorageOption
+	ossCfg config.AliOSSConfig
+	// bucketURL https://{bucket}.{endpoint}
+	bucketURL *url.URL
+	client    *http.Client
+}
+
+func NewAliOSS(cfg config.AliOSSConfig, opt config.StorageOption) (*AliOSS, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("configuration bucket error")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" && cfg.RegionID != "" {
+		endpoint = fmt.Sprintf("oss-%s.aliyuncs.com", cfg.RegionID)
+	}
+	if endpoint == "" {
+		return nil, errors.New("configuration endpoint error")
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		logs.Errorf("parse alioss endpoint %s error: %v", endpoint, err)
+		return nil, err
+	}
+	u.Host = cfg.Bucket + "." + u.Host
+	u.Path = "/"
+
+	return &AliOSS{
+		opt:       opt,
+		ossCfg:    cfg,
+		bucketURL: u,
+		client:    http.DefaultClient,
+	}, nil
+}
+
+func (ao *AliOSS) Save(ctx context.Context, fi *storage.FileInfo, r io.Reader) error {
+	if fi.StoragePath == "" {
+		return fmt.Errorf("storage path is empty")
+	}
+	if r == nil {
+		return fmt.Errorf("reader is empty")
+	}
+	header := http.Header{}
+	if ct := mime.TypeByExtension(fi.FileExt); ct != "" {
+		header.Set("Content-Type", ct)
+	}
+	for k, v := range fi.Metadata {
+		header.Set(metaPrefix+k, v)
+	}
+	resp, err := ao.do(ctx, http.MethodPut, fi.StoragePath, nil, header, r, fi.Size)
+	if err != nil {
+		logs.Errorf("alioss put object error: %v", err)
+		return err
+	}
+	resp.Body.Close()
+
+	if md5str := resp.Header.Get("ETag"); md5str != "" {
+		fi.Hash = "md5:" + strings.Trim(md5str, "\"")
+	}
+	fi.PublicURL = ao.GetPublicURL(fi.StoragePath, false)
+	return nil
+}
+
+func (ao *AliOSS) GetPublicURL(storagePath string, temp bool) string {
+	if temp {
+		u, err := ao.GetPresignedURL(http.MethodGet, storagePath)
+		if err != nil {
+			logs.Errorf("alioss get presigned url error: %v", err)
+			return ""
+		}
+		return u
+	}
+	if ao.ossCfg.PublicDomain != "" {
+		return strings.TrimSuffix(ao.ossCfg.PublicDomain, "/") + "/" + escapeKey(storagePath)
+	}
+	return ao.objectURL(storagePath, nil).String()
+}
+
+func (ao *AliOSS) GetPresignedURL(method, storagePath string) (string, error) {
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", fmt.Errorf("only GET and PUT are allowed, now: %s", method)
+	}
+	return ao.presign(method, storagePath, nil, http.Header{}), nil
+}
+
+func (ao *AliOSS) ReadFile(storagePath string) (io.ReadCloser, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	resp, err := ao.do(context.Background(), http.MethodGet, storagePath, nil, nil, nil, 0)
+	if err != nil {
+		logs.Errorf("alioss get object error: %v", err)
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (ao *AliOSS) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	header := http.Header{}
+	rng := storage.RangeHeader(offset, length)
+	if rng != "" {
+		header.Set("Range", rng)
+	}
+	resp, err := ao.do(ctx, http.MethodGet, storagePath, nil, header, nil, 0)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("alioss object %s: %w", storagePath, storage.ErrObjectNotFound)
+		}
+		logs.Errorf("alioss get object range error: %v", err)
+		return nil, err
+	}
+	// Range 不合法时 OSS 忽略该头并返回完整内容
+	if rng != "" && resp.StatusCode == http.StatusOK {
+		return storage.LimitRange(resp.Body, offset, length)
+	}
+	return resp.Body, nil
+}
+
+func (ao *AliOSS) Stat(ctx context.Context, storagePath string) (*storage.ObjectInfo, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	resp, err := ao.do(ctx, http.MethodHead, storagePath, nil, nil, nil, 0)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("alioss object %s: %w", storagePath, storage.ErrObjectNotFound)
+		}
+		logs.Errorf("alioss head object error: %v", err)
+		return nil, err
+	}
+	resp.Body.Close()
+	info := &storage.ObjectInfo{
+		StoragePath: storagePath,
+		Size:        resp.ContentLength,
+		ETag:        strings.Trim(resp.Header.Get("ETag"), "\""),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	info.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	for k := range resp.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, metaPrefix) {
+			if info.Metadata == nil {
+				info.Metadata = map[string]string{}
+			}
+			info.Metadata[strings.TrimPrefix(lk, metaPrefix)] = resp.Header.Get(k)
+		}
+	}
+	return info, nil
+}
+
+func (ao *AliOSS) Exists(ctx context.Context, storagePath string) (bool, error) {
+	return storage.ExistsByStat(ao.Stat(ctx, storagePath))
+}
+
+// List NextMarker 为 OSS 返回的 NextMarker
+func (ao *AliOSS) List(ctx context.Context, prefix string, opts *storage.ListOptions) (*storage.ListResult, error) {
+	res, err := ao.listObjects(ctx, prefix, opts.GetMarker(), opts.GetMaxKeys())
+	if err != nil {
+		logs.Errorf("alioss list objects error: %v", err)
+		return nil, err
+	}
+	ret := &storage.ListResult{
+		Objects:     make([]*storage.ObjectInfo, 0, len(res.Contents)),
+		NextMarker:  res.NextMarker,
+		IsTruncated: res.IsTruncated,
+	}
+	for _, obj := range res.Contents {
+		info := &storage.ObjectInfo{
+			StoragePath: obj.Key,
+			Size:        obj.Size,
+			ETag:        strings.Trim(obj.ETag, "\""),
+		}
+		info.LastModified, _ = time.Parse(time.RFC3339, obj.LastModified)
+		ret.Objects = append(ret.Objects, info)
+	}
+	return ret, nil
+}
+
+func (ao *AliOSS) DeleteFile(storagePath string) error {
+	if storagePath == "" {
+		return fmt.Errorf("storage path is empty")
+	}
+	resp, err := ao.do(context.Background(), http.MethodDelete, storagePath, nil, nil, nil, 0)
+	if err != nil {
+		logs.Errorf("alioss delete object error: %v", err)
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (ao *AliOSS) CopyDir(storagePath, dest string) error {
+	if storagePath == "" {
+		return fmt.Errorf("source storage path is empty")
+	}
+	if dest == "" {
+		return fmt.Errorf("destination storage path is empty")
+	}
+	isDir, err := ao.isDirectory(storagePath)
+	if err != nil {
+		logs.Errorf("alioss check source path error: %v", err)
+		return err
+	}
+	if isDir {
+		return ao.copyDirectory(storagePath, dest)
+	}
+	return ao.copyObject(storagePath, dest)
+}
+
+func (ao *AliOSS) UploadDirectory(localDirPath, destDir string) ([]string, error) {
+	var uploadedPaths []string
+	if localDirPath == "" {
+		return nil, fmt.Errorf("local directory path is empty")
+	}
+	err := filepath.WalkDir(localDirPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing path %s: %w", filePath, err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(localDirPath, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
+		}
+		storagePath := path.Join(destDir, filepath.ToSlash(relPath))
+		file, err := os.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", filePath, err)
+		}
+		defer file.Close()
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fi := &storage.FileInfo{
+			StoragePath: storagePath,
+			FileExt:     path.Ext(filePath),
+			Size:        info.Size(),
+		}
+		if err := ao.Save(context.Background(), fi, file); err != nil {
+			return fmt.Errorf("failed to upload file %s to %s: %w", filePath, storagePath, err)
+		}
+		uploadedPaths = append(uploadedPaths, storagePath)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploadedPaths, nil
+}
+
+func (ao *AliOSS) isDirectory(storagePath string) (bool, error) {
+	resp, err := ao.do(context.Background(), http.MethodHead, storagePath, nil, nil, nil, 0)
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return false, nil
+}
+
+func (ao *AliOSS) copyObject(storagePath, dest string) error {
+	if storagePath == dest {
+		return nil
+	}
+	header := http.Header{}
+	header.Set("x-oss-copy-source", "/"+ao.ossCfg.Bucket+"/"+escapeKey(storagePath))
+	resp, err := ao.do(context.Background(), http.MethodPut, dest, nil, header, nil, 0)
+	if err != nil {
+		logs.Errorf("alioss copy object %s to %s error: %v", storagePath, dest, err)
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (ao *AliOSS) copyDirectory(storagePath, dest string) error {
+	marker := ""
+	for {
+		res, err := ao.listObjects(context.Background(), storagePath, marker, storage.DefaultListMaxKeys)
+		if err != nil {
+			logs.Errorf("alioss list objects error: %v", err)
+			return err
+		}
+		for _, obj := range res.Contents {
+			targetPath := path.Join(dest, strings.TrimPrefix(obj.Key, storagePath))
+			if targetPath == dest {
+				continue
+			}
+			if err := ao.copyObject(obj.Key, targetPath); err != nil {
+				return err
+			}
+		}
+		if !res.IsTruncated || res.NextMarker == "" {
+			return nil
+		}
+		marker = res.NextMarker
+	}
+}
+
+type listBucketResult struct {
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+	Contents    []struct {
+		Key          string `xml:"Key"`
+		Size         int64  `xml:"Size"`
+		ETag         string `xml:"ETag"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+func (ao *AliOSS) listObjects(ctx context.Context, prefix, marker string, maxKeys int) (*listBucketResult, error) {
+	query := url.Values{}
+	query.Set("prefix", prefix)
+	query.Set("max-keys", strconv.Itoa(maxKeys))
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	res := &listBucketResult{}
+	if err := ao.doXML(ctx, http.MethodGet, "", query, nil, nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (ao *AliOSS) CreateMultipartUpload(ctx context.Context, in *storage.CreateMultipartUploadInput) (*string, error) {
+	if in == nil || in.StoragePath == nil || *in.StoragePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	header := http.Header{}
+	if in.ContentType != nil && *in.ContentType != "" {
+		header.Set("Content-Type", *in.ContentType)
+	}
+	for k, v := range in.Metadata {
+		header.Set(metaPrefix+k, v)
+	}
+	res := &struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+	err := ao.doXML(ctx, http.MethodPost, *in.StoragePath, url.Values{"uploads": {""}}, header, nil, res)
+	if err != nil {
+		logs.Errorf("alioss initiate multipart upload error: %v", err)
+		return nil, err
+	}
+	return &res.UploadID, nil
+}
+
+func (ao *AliOSS) GeneratePresignedURL(ctx context.Context, in *storage.GeneratePresignedURLInput) (*string, error) {
+	if in == nil || in.StoragePath == nil {
+		return nil, fmt.Errorf("storagePath is nil")
+	}
+	method := aws.ToString(in.Method)
+	if method == "" {
+		method = http.MethodPut
+	}
+	if method != http.MethodGet && method != http.MethodPut {
+		return nil, fmt.Errorf("only GET and PUT are allowed, now: %s", method)
+	}
+	header := http.Header{}
+	if in.ContentType != nil {
+		header.Set("Content-Type", *in.ContentType)
+	}
+	if in.ContentMD5 != nil {
+		header.Set("Content-MD5", *in.ContentMD5)
+	}
+	query := url.Values{}
+	if in.UploadID != nil && *in.UploadID != "" {
+		if in.PartNumber == nil {
+			return nil, fmt.Errorf("partNumber is nil")
+		}
+		query.Set("uploadId", *in.UploadID)
+		query.Set("partNumber", strconv.Itoa(*in.PartNumber))
+	}
+	u := ao.presign(method, *in.StoragePath, query, header)
+	return &u, nil
+}
+
+func (ao *AliOSS) UploadPart(ctx context.Context, in *storage.UploadPartInput) (*string, error) {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil || in.PartNumber == nil {
+		return nil, fmt.Errorf("storagePath, uploadID or partNumber is nil")
+	}
+	if *in.StoragePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	if in.Data == nil {
+		return nil, fmt.Errorf("reader is empty")
+	}
+	// OSS 上传分片需要 Content-Length, 没有指定时读入内存
+	body, size := in.Data, int64(-1)
+	if in.ContentLength != nil {
+		size = *in.ContentLength
+	} else {
+		data, err := io.ReadAll(in.Data)
+		if err != nil {
+			return nil, err
+		}
+		body, size = bytes.NewReader(data), int64(len(data))
+	}
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(*in.PartNumber))
+	query.Set("uploadId", *in.UploadID)
+	resp, err := ao.do(ctx, http.MethodPut, *in.StoragePath, query, nil, body, size)
+	if err != nil {
+		logs.Errorf("alioss upload part error: %v", err)
+		return nil, err
+	}
+	resp.Body.Close()
+	etag := strings.Trim(resp.Header.Get("ETag"), "\"")
+	return &etag, nil
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []completePart `xml:"Part"`
+}
+
+type completePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (ao *AliOSS) CompleteMultipartUpload(ctx context.Context, in *storage.CompleteMultipartUploadInput) error {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil || in.Parts == nil {
+		return fmt.Errorf("storagePath, uploadID or parts is nil")
+	}
+	body := completeMultipartUpload{}
+	for _, p := range in.Parts.Parts {
+		body.Parts = append(body.Parts, completePart{
+			PartNumber: int(aws.ToInt32(p.PartNumber)),
+			ETag:       "\"" + strings.Trim(aws.ToString(p.ETag), "\"") + "\"",
+		})
+	}
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	err = ao.doXML(ctx, http.MethodPost, *in.StoragePath, url.Values{"uploadId": {*in.UploadID}}, header, data, nil)
+	if err != nil {
+		logs.Errorf("alioss complete multipart upload error: %v", err)
+	}
+	return err
+}
+
+func (ao *AliOSS) AbortMultipartUpload(ctx context.Context, in *storage.AbortMultipartUploadInput) error {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil {
+		return fmt.Errorf("storagePath or uploadID is nil")
+	}
+	resp, err := ao.do(ctx, http.MethodDelete, *in.StoragePath, url.Values{"uploadId": {*in.UploadID}}, nil, nil, 0)
+	if err != nil {
+		logs.Errorf("alioss abort multipart upload error: %v", err)
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ossError OSS 返回的错误
+type ossError struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestID  string `xml:"RequestId"`
+}
+
+func (e *ossError) Error() string {
+	return fmt.Sprintf("alioss error, status: %d, code: %s, message: %s, request_id: %s",
+		e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+func isNotFound(err error) bool {
+	var oe *ossError
+	return errors.As(err, &oe) && oe.StatusCode == http.StatusNotFound
+}
+
+// do 发送签名请求, 非 2xx 时返回 *ossError, 成功时调用方负责关闭 resp.Body
+func (ao *AliOSS) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := ao.objectURL(key, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if size > 0 {
+		req.ContentLength = size
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req.Header.Set("Date", date)
+	strToSign := stringToSign(method, req.Header, date, canonicalResource(ao.ossCfg.Bucket, key, query))
+	req.Header.Set("Authorization", "OSS "+ao.ossCfg.AccessKeyID+":"+sign(ao.ossCfg.AccessKeySecret, strToSign))
+
+	resp, err := ao.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		oe := &ossError{StatusCode: resp.StatusCode}
+		if data, _ := io.ReadAll(resp.Body); len(data) > 0 {
+			xml.Unmarshal(data, oe)
+		}
+		return nil, oe
+	}
+	return resp, nil
+}
+
+// doXML 发送请求并把 XML 响应解析到 out
+func (ao *AliOSS) doXML(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	resp, err := ao.do(ctx, method, key, query, header, r, int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return xml.NewDecoder(resp.Body).Decode(out)
+}
+
+// presign 生成预签名 URL, header 中的 Content-Type/Content-MD5 需要与实际请求一致
+func (ao *AliOSS) presign(method, key string, query url.Values, header http.Header) string {
+	timeout := ao.opt.PresignedTimeout
+	if timeout <= 0 {
+		timeout = defaultPresignedTimeout
+	}
+	expires := strconv.FormatInt(time.Now().Add(timeout).Unix(), 10)
+	strToSign := stringToSign(method, header, expires, canonicalResource(ao.ossCfg.Bucket, key, query))
+
+	q := url.Values{}
+	for k, vs := range query {
+		q[k] = vs
+	}
+	q.Set("OSSAccessKeyId", ao.ossCfg.AccessKeyID)
+	q.Set("Expires", expires)
+	q.Set("Signature", sign(ao.ossCfg.AccessKeySecret, strToSign))
+	return ao.objectURL(key, q).String()
+}
+
+func (ao *AliOSS) objectURL(key string, query url.Values) *url.URL {
+	u := *ao.bucketURL
+	u.Path = "/" + key
+	u.RawPath = "/" + escapeKey(key)
+	u.RawQuery = encodeQuery(query)
+	return &u
+}
+
+// encodeQuery 与 url.Values.Encode 相同, 但值为空的子资源不带等号, 如 ?uploads
+func encodeQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	parts := strings.Split(query.Encode(), "&")
+	for i, p := range parts {
+		parts[i] = strings.TrimSuffix(p, "=")
+	}
+	return strings.Join(parts, "&")
+}
+
+// escapeKey 逐段转义对象名, 保留 /
+func escapeKey(key string) string {
+	segs := strings.Split(key, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
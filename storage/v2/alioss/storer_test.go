@@ -0,0 +1,402 @@
+package alioss
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+const (
+	testBucket = "yg-test"
+	testKeyID  = "test-key-id"
+	testSecret = "test-key-secret"
+)
+
+func TestSignature(t *testing.T) {
+	// OSS 文档中的签名字符串示例
+	header := http.Header{}
+	header.Set("Content-MD5", "ODBGOERFMDMzQTczRUY3NUE3NzA5QzdFNUYzMDQxNEM=")
+	header.Set("Content-Type", "text/html")
+	header.Set("X-OSS-Magic", "abracadabra")
+	header.Set("X-OSS-Meta-Author", "foo@example.com")
+	str := stringToSign(http.MethodPut, header, "Thu, 17 Nov 2005 18:49:58 GMT", canonicalResource("oss-example", "nelson", nil))
+	assert.Equal(t, "PUT\nODBGOERFMDMzQTczRUY3NUE3NzA5QzdFNUYzMDQxNEM=\ntext/html\nThu, 17 Nov 2005 18:49:58 GMT\n"+
+		"x-oss-magic:abracadabra\nx-oss-meta-author:foo@example.com\n/oss-example/nelson", str)
+
+	assert.Equal(t, "/b/k?partNumber=1&uploadId=abc", canonicalResource("b", "k", url.Values{
+		"uploadId": {"abc"}, "partNumber": {"1"}, "prefix": {"x"},
+	}))
+	assert.Equal(t, "/b/k?uploads", canonicalResource("b", "k", url.Values{"uploads": {""}}))
+}
+
+// fakeOSS 本地模拟的 OSS 服务, 校验签名并在内存中保存对象
+type fakeOSS struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	meta    map[string]http.Header
+	uploads map[string]map[int][]byte
+	seq     int
+}
+
+func newFakeOSS() *fakeOSS {
+	return &fakeOSS{
+		objects: map[string][]byte{},
+		types:   map[string]string{},
+		meta:    map[string]http.Header{},
+		uploads: map[string]map[int][]byte{},
+	}
+}
+
+func (f *fakeOSS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Host, testBucket+".") {
+		f.fail(w, http.StatusBadRequest, "InvalidBucketName")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	resource := canonicalResource(testBucket, key, query)
+
+	if sig := query.Get("Signature"); sig != "" {
+		expires, _ := strconv.ParseInt(query.Get("Expires"), 10, 64)
+		if time.Now().Unix() > expires {
+			f.fail(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		if sig != sign(testSecret, stringToSign(r.Method, r.Header, query.Get("Expires"), resource)) {
+			f.fail(w, http.StatusForbidden, "SignatureDoesNotMatch")
+			return
+		}
+	} else {
+		expected := "OSS " + testKeyID + ":" + sign(testSecret, stringToSign(r.Method, r.Header, r.Header.Get("Date"), resource))
+		if r.Header.Get("Authorization") != expected {
+			f.fail(w, http.StatusForbidden, "SignatureDoesNotMatch")
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, query.Get("prefix"), query.Get("marker"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.seq++
+		id := fmt.Sprintf("upload-%d", f.seq)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[n] = data
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var body completeMultipartUpload
+		xml.NewDecoder(r.Body).Decode(&body)
+		var buf bytes.Buffer
+		for _, p := range body.Parts {
+			if etag(parts[p.PartNumber]) != p.ETag {
+				f.fail(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			buf.Write(parts[p.PartNumber])
+		}
+		f.objects[key] = buf.Bytes()
+		delete(f.uploads, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		var data []byte
+		if src := r.Header.Get("x-oss-copy-source"); src != "" {
+			srcKey, _ := url.PathUnescape(strings.TrimPrefix(src, "/"+testBucket+"/"))
+			var ok bool
+			if data, ok = f.objects[srcKey]; !ok {
+				f.fail(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+		} else {
+			data, _ = io.ReadAll(r.Body)
+		}
+		f.objects[key] = data
+		f.types[key] = r.Header.Get("Content-Type")
+		f.meta[key] = http.Header{}
+		for k, vs := range r.Header {
+			if strings.HasPrefix(strings.ToLower(k), metaPrefix) {
+				f.meta[key][k] = vs
+			}
+		}
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", f.types[key])
+		w.Header().Set("ETag", etag(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		for k, vs := range f.meta[key] {
+			w.Header()[k] = vs
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeOSS) list(w http.ResponseWriter, prefix, marker string) {
+	keys := []string{}
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) && k > marker {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	// 每页 1 个, 覆盖翻页逻辑
+	res := listBucketResult{}
+	if len(keys) > 1 {
+		res.IsTruncated = true
+		res.NextMarker = keys[0]
+		keys = keys[:1]
+	}
+	for _, k := range keys {
+		res.Contents = append(res.Contents, struct {
+			Key          string `xml:"Key"`
+			Size         int64  `xml:"Size"`
+			ETag         string `xml:"ETag"`
+			LastModified string `xml:"LastModified"`
+		}{Key: k, Size: int64(len(f.objects[k]))})
+	}
+	data, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listBucketResult
+	}{listBucketResult: res})
+	w.Write(data)
+}
+
+func (f *fakeOSS) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message><RequestId>test</RequestId></Error>", code, code)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return "\"" + strings.ToUpper(hex.EncodeToString(sum[:])) + "\""
+}
+
+func newTestOSS(t *testing.T) (*AliOSS, *fakeOSS) {
+	fake := newFakeOSS()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg := config.AliOSSConfig{Bucket: testBucket}
+	cfg.Endpoint = "http://oss-cn-test.aliyuncs.com"
+	cfg.AccessKeyID = testKeyID
+	cfg.AccessKeySecret = testSecret
+	// 经由注册表创建, 同时覆盖 NewStorageWithCfg 的选择逻辑
+	s, err := storage.NewStorageWithCfg(config.StorageConfig{AliOSS: &cfg})
+	assert.NoError(t, err)
+	ao := s.(*AliOSS)
+	// 所有请求都发到本地服务, 保留 Host
+	addr := srv.Listener.Addr().String()
+	ao.client = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	return ao, fake
+}
+
+func TestSaveReadDelete(t *testing.T) {
+	ao, fake := newTestOSS(t)
+
+	fi := &storage.FileInfo{StoragePath: "docs/a b.txt", FileExt: ".txt", Size: 5}
+	assert.NoError(t, ao.Save(context.Background(), fi, strings.NewReader("hello")))
+	assert.Equal(t, "md5:"+strings.Trim(etag([]byte("hello")), "\""), fi.Hash)
+	assert.Equal(t, "http://yg-test.oss-cn-test.aliyuncs.com/docs/a%20b.txt", fi.PublicURL)
+	assert.Contains(t, fake.types["docs/a b.txt"], "text/plain")
+
+	rc, err := ao.ReadFile("docs/a b.txt")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "hello", string(data))
+
+	assert.NoError(t, ao.DeleteFile("docs/a b.txt"))
+	_, err = ao.ReadFile("docs/a b.txt")
+	assert.True(t, isNotFound(err))
+}
+
+func TestPresignedURL(t *testing.T) {
+	ao, _ := newTestOSS(t)
+
+	putURL, err := ao.GetPresignedURL(http.MethodPut, "p/x.bin")
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPut, putURL, strings.NewReader("data"))
+	resp, err := ao.client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	getURL := ao.GetPublicURL("p/x.bin", true)
+	resp, err = ao.client.Get(getURL)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "data", string(data))
+
+	// 篡改对象名后签名失效
+	resp, err = ao.client.Get(strings.Replace(getURL, "x.bin", "y.bin", 1))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestCopyAndUploadDir(t *testing.T) {
+	ao, fake := newTestOSS(t)
+
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("b"), 0644))
+
+	paths, err := ao.UploadDirectory(dir, "src")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"src/a.txt", "src/sub/b.txt"}, paths)
+
+	assert.NoError(t, ao.CopyDir("src/", "dst"))
+	assert.Equal(t, []byte("a"), fake.objects["dst/a.txt"])
+	assert.Equal(t, []byte("b"), fake.objects["dst/sub/b.txt"])
+
+	assert.NoError(t, ao.CopyDir("src/a.txt", "single.txt"))
+	assert.Equal(t, []byte("a"), fake.objects["single.txt"])
+}
+
+func TestStatAndList(t *testing.T) {
+	ao, _ := newTestOSS(t)
+	ctx := context.Background()
+
+	fi := &storage.FileInfo{StoragePath: "l/a.txt", FileExt: ".txt", Metadata: map[string]string{"owner": "42"}}
+	assert.NoError(t, ao.Save(ctx, fi, strings.NewReader("hello")))
+	assert.NoError(t, ao.Save(ctx, &storage.FileInfo{StoragePath: "l/b.txt", FileExt: ".txt"}, strings.NewReader("b")))
+
+	info, err := ao.Stat(ctx, "l/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+	assert.Equal(t, strings.Trim(etag([]byte("hello")), "\""), info.ETag)
+	assert.Contains(t, info.ContentType, "text/plain")
+	assert.Equal(t, map[string]string{"owner": "42"}, info.Metadata)
+
+	// 模拟服务忽略 Range, 由客户端截取
+	rc, err := ao.ReadRange(ctx, "l/a.txt", 1, 3)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "ell", string(data))
+	rc, err = ao.ReadRange(ctx, "l/a.txt", 2, 0)
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "llo", string(data))
+	_, err = ao.ReadRange(ctx, "l/none.txt", 0, 1)
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+
+	_, err = ao.Stat(ctx, "l/none.txt")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+	ok, err := ao.Exists(ctx, "l/none.txt")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = ao.Exists(ctx, "l/b.txt")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 模拟服务每页返回 1 个
+	res, err := ao.List(ctx, "l/", nil)
+	assert.NoError(t, err)
+	assert.True(t, res.IsTruncated)
+	assert.Equal(t, "l/a.txt", res.Objects[0].StoragePath)
+	keys := []string{}
+	for obj, err := range storage.ListAll(ctx, ao, "l/", nil) {
+		assert.NoError(t, err)
+		keys = append(keys, obj.StoragePath)
+	}
+	assert.Equal(t, []string{"l/a.txt", "l/b.txt"}, keys)
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ao, fake := newTestOSS(t)
+	ctx := context.Background()
+	key := aws.String("big/file.bin")
+
+	uploadID, err := ao.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: key})
+	assert.NoError(t, err)
+
+	parts := &types.CompletedMultipartUpload{}
+	for i, chunk := range []string{"part-1,", "part-2"} {
+		n := i + 1
+		etag, err := ao.UploadPart(ctx, &storage.UploadPartInput{
+			StoragePath: key, UploadID: uploadID, PartNumber: &n, Data: strings.NewReader(chunk),
+		})
+		assert.NoError(t, err)
+		parts.Parts = append(parts.Parts, types.CompletedPart{PartNumber: aws.Int32(int32(n)), ETag: etag})
+	}
+	assert.NoError(t, ao.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+		StoragePath: key, UploadID: uploadID, Parts: parts,
+	}))
+	assert.Equal(t, "part-1,part-2", string(fake.objects["big/file.bin"]))
+
+	// 预签名分片上传
+	uploadID, err = ao.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: key})
+	assert.NoError(t, err)
+	n := 1
+	partURL, err := ao.GeneratePresignedURL(ctx, &storage.GeneratePresignedURLInput{
+		Method: aws.String(http.MethodPut), StoragePath: key, UploadID: uploadID, PartNumber: &n,
+	})
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPut, *partURL, strings.NewReader("presigned"))
+	resp, err := ao.client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []byte("presigned"), fake.uploads[*uploadID][1])
+
+	assert.NoError(t, ao.AbortMultipartUpload(ctx, &storage.AbortMultipartUploadInput{StoragePath: key, UploadID: uploadID}))
+	assert.NotContains(t, fake.uploads, *uploadID)
+}
@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/settings"
+	"gorm.io/datatypes"
+)
+
+const (
+	// MasterKeySettingPrefix 主密钥配置项的 key 前缀, 配置项位于 core 组, 类型为 secret
+	MasterKeySettingPrefix = "storage-key-"
+
+	// EncryptionAlgorithm 写入元数据和 FileInfo.Extra 的加密算法标识
+	EncryptionAlgorithm = "AES-256-GCM-STREAM"
+
+	metaEncAlgorithm = "encryption-alg"
+	metaEncKeyID     = "encryption-key-id"
+	metaEncSegSize   = "encryption-segment-size"
+	metaEncMultipart = "encryption-multipart"
+
+	extraEncryption = "encryption"
+)
+
+// EncryptionExtra 写入 FileInfo.Extra 的 encryption 字段
+type EncryptionExtra struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"key_id"`
+}
+
+// EncryptedStorager 客户端加密装饰器, Save/UploadPart 写入前加密, ReadFile/ReadRange 读取后解密.
+// 密文自带主密钥 ID 和被主密钥加密的数据密钥, 解密不依赖元数据, 主密钥轮换后旧文件仍可读取.
+// 直传链接会绕过加密, 因此 GetPresignedURL 和 GeneratePresignedURL 返回错误;
+// GetPublicURL 返回的是密文地址.
+type EncryptedStorager struct {
+	Storager
+	keyID   string
+	segSize int
+	keys    MasterKeyFunc
+}
+
+var _ Storager = (*EncryptedStorager)(nil)
+
+// NewEncryptedStorager 创建加密装饰器, keys 为 nil 时从 settings 读取主密钥
+func NewEncryptedStorager(s Storager, cfg config.StorageEncryptionConfig, keys MasterKeyFunc) (*EncryptedStorager, error) {
+	if cfg.KeyID == "" {
+		return nil, fmt.Errorf("encryption key_id is empty")
+	}
+	if len(cfg.KeyID) > 255 {
+		return nil, fmt.Errorf("encryption key_id too long")
+	}
+	if cfg.SegmentSize < 0 || cfg.SegmentSize > maxEncSegmentSize {
+		return nil, fmt.Errorf("encryption segment_size must be in (0, %d]", maxEncSegmentSize)
+	}
+	if cfg.SegmentSize == 0 {
+		cfg.SegmentSize = defaultEncSegmentSize
+	}
+	if keys == nil {
+		keys = SettingsMasterKey
+	}
+	// 提前检查主密钥, 避免配置错误到写入时才发现
+	if _, err := keys(cfg.KeyID); err != nil {
+		logs.Errorf("[encrypted_storage] load master key %s failed: %s", cfg.KeyID, err)
+		return nil, err
+	}
+	return &EncryptedStorager{
+		Storager: s,
+		keyID:    cfg.KeyID,
+		segSize:  cfg.SegmentSize,
+		keys:     keys,
+	}, nil
+}
+
+// SettingsMasterKey 从 core 组的 secret 配置项 storage-key-{keyID} 读取 base64 编码的主密钥
+func SettingsMasterKey(keyID string) ([]byte, error) {
+	value, err := settings.GetSecret(settings.SettingGroupCore, MasterKeySettingPrefix+keyID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("master key %s is not valid base64: %w", keyID, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key %s must be 32 bytes, got %d", keyID, len(key))
+	}
+	return key, nil
+}
+
+// Save 加密后保存, 保存后 fi.Size 和 fi.Hash 为明文的长度和 md5,
+// 主密钥 ID 写入 fi.Metadata 和 fi.Extra
+func (es *EncryptedStorager) Save(ctx context.Context, fi *FileInfo, r io.Reader) error {
+	if r == nil {
+		return fmt.Errorf("reader is empty")
+	}
+	masterKey, err := es.keys(es.keyID)
+	if err != nil {
+		logs.Errorf("[encrypted_storage] load master key %s failed: %s", es.keyID, err)
+		return err
+	}
+	h := md5.New()
+	er, err := newEncryptReader(io.TeeReader(r, h), masterKey, es.keyID, es.segSize, 0, -1)
+	if err != nil {
+		return err
+	}
+
+	plainSize, metadata := fi.Size, fi.Metadata
+	if plainSize > 0 {
+		// 部分后端按 Size 设置 Content-Length
+		fi.Size = encCipherSize(es.keyID, es.segSize, plainSize)
+	}
+	fi.Metadata = make(map[string]string, len(metadata)+3)
+	for k, v := range metadata {
+		fi.Metadata[k] = v
+	}
+	fi.Metadata[metaEncAlgorithm] = EncryptionAlgorithm
+	fi.Metadata[metaEncKeyID] = es.keyID
+	fi.Metadata[metaEncSegSize] = strconv.Itoa(es.segSize)
+	err = es.Storager.Save(ctx, fi, er)
+	fi.Metadata = metadata
+	if err != nil {
+		fi.Size = plainSize
+		return err
+	}
+	fi.Size = er.n
+	fi.Hash = "md5:" + hex.EncodeToString(h.Sum(nil))
+	extra, err := setEncryptionExtra(fi.Extra, EncryptionExtra{Algorithm: EncryptionAlgorithm, KeyID: es.keyID})
+	if err != nil {
+		logs.Errorf("[encrypted_storage] set extra of %s failed: %s", fi.StoragePath, err)
+		return err
+	}
+	fi.Extra = extra
+	return nil
+}
+
+func (es *EncryptedStorager) ReadFile(storagePath string) (io.ReadCloser, error) {
+	rc, err := es.Storager.ReadFile(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: newDecryptReader(rc, es.keys), Closer: rc}, nil
+}
+
+// ReadRange 单帧的文件按固定的分段大小定位到 offset 所在的段, 只读取帧头和需要的段;
+// 分片上传的文件由多个帧组成, 从头解密后截取
+func (es *EncryptedStorager) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		rc, err := es.seekRange(ctx, storagePath, offset, length)
+		if rc != nil || err != nil {
+			return rc, err
+		}
+	}
+	rc, err := es.Storager.ReadRange(ctx, storagePath, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return LimitRange(&readCloser{Reader: newDecryptReader(rc, es.keys), Closer: rc}, offset, length)
+}
+
+// seekRange 从 offset 所在的段开始读取单帧的文件, 不是单帧或 offset 超出范围时返回 nil, 由调用方从头读取
+func (es *EncryptedStorager) seekRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	info, err := es.Storager.Stat(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	hrc, err := es.Storager.ReadRange(ctx, storagePath, 0, min(info.Size, encFrameHeaderSize(255)))
+	if err != nil {
+		return nil, err
+	}
+	header, err := io.ReadAll(hrc)
+	hrc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) < encFixedHeaderSize || string(header[:4]) != encMagic {
+		return nil, nil
+	}
+	segSize := int64(binary.BigEndian.Uint32(header[4:8]))
+	plainSize := binary.BigEndian.Uint64(header[12:20])
+	headerSize := encFrameHeaderSize(int(header[20]))
+	if segSize == 0 || segSize > maxEncSegmentSize || int64(len(header)) < headerSize {
+		return nil, nil
+	}
+	// 长度已知的帧不一定是最后一帧, 长度不符时按多帧处理
+	if plainSize != encUnknownSize && (plainSize > math.MaxInt64 ||
+		encFrameSize(int(header[20]), int(segSize), int64(plainSize)) != info.Size) {
+		return nil, nil
+	}
+	full := segSize + encTagSize
+	lastIndex := (info.Size-headerSize+full-1)/full - 1
+	index := offset / segSize
+	if index > lastIndex || index > math.MaxUint32 {
+		return nil, nil
+	}
+
+	var cipherLen int64
+	if length > 0 {
+		cipherLen = ((offset+length-1)/segSize - index + 1) * full
+	}
+	rc, err := es.Storager.ReadRange(ctx, storagePath, headerSize+index*full, cipherLen)
+	if err != nil {
+		return nil, err
+	}
+	dr := newDecryptReader(io.MultiReader(bytes.NewReader(header[:headerSize]), rc), es.keys)
+	dr.startIndex = uint32(index)
+	dr.lastIndex = lastIndex
+	return LimitRange(&readCloser{Reader: dr, Closer: rc}, offset-index*segSize, length)
+}
+
+// Stat Save 写入的文件根据元数据中的密钥 ID 和分段大小计算明文长度,
+// 分片上传的文件由多个帧组成, 逐帧读取帧头累加明文长度; 没有加密元数据时返回密文长度
+func (es *EncryptedStorager) Stat(ctx context.Context, storagePath string) (*ObjectInfo, error) {
+	info, err := es.Storager.Stat(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Metadata[metaEncMultipart] == "true" {
+		size, err := encFramesPlainSize(ctx, es.Storager, storagePath, info.Size)
+		if err != nil {
+			logs.Errorf("[encrypted_storage] read frame headers of %s failed: %s", storagePath, err)
+			return nil, err
+		}
+		info.Size = size
+		return info, nil
+	}
+	keyID, ok := info.Metadata[metaEncKeyID]
+	segSize, err := strconv.Atoi(info.Metadata[metaEncSegSize])
+	if ok && err == nil && segSize > 0 {
+		if size, ok := encPlainSize(keyID, segSize, info.Size); ok {
+			info.Size = size
+		}
+	}
+	return info, nil
+}
+
+func (es *EncryptedStorager) GetPresignedURL(method, storagePath string) (string, error) {
+	return "", fmt.Errorf("presigned %s URL not supported for encrypted storage", method)
+}
+
+func (es *EncryptedStorager) GeneratePresignedURL(ctx context.Context, in *GeneratePresignedURLInput) (*string, error) {
+	return nil, fmt.Errorf("presigned part URL not supported for encrypted storage")
+}
+
+// CreateMultipartUpload 在用户元数据中写入加密信息和分片标记, 后端在完成上传后写入对象
+func (es *EncryptedStorager) CreateMultipartUpload(ctx context.Context, in *CreateMultipartUploadInput) (*string, error) {
+	if in == nil {
+		return nil, fmt.Errorf("input is nil")
+	}
+	create := *in
+	create.Metadata = make(map[string]string, len(in.Metadata)+4)
+	for k, v := range in.Metadata {
+		create.Metadata[k] = v
+	}
+	create.Metadata[metaEncAlgorithm] = EncryptionAlgorithm
+	create.Metadata[metaEncKeyID] = es.keyID
+	create.Metadata[metaEncSegSize] = strconv.Itoa(es.segSize)
+	create.Metadata[metaEncMultipart] = "true"
+	return es.Storager.CreateMultipartUpload(ctx, &create)
+}
+
+// UploadPart 每个分片加密为一个帧, 以分片序号作为帧序号, 合并后的文件可以整体解密
+func (es *EncryptedStorager) UploadPart(ctx context.Context, in *UploadPartInput) (*string, error) {
+	if in == nil || in.PartNumber == nil || in.Data == nil {
+		return nil, fmt.Errorf("partNumber or data is nil")
+	}
+	if *in.PartNumber < 1 {
+		return nil, fmt.Errorf("invalid part number %d", *in.PartNumber)
+	}
+	masterKey, err := es.keys(es.keyID)
+	if err != nil {
+		logs.Errorf("[encrypted_storage] load master key %s failed: %s", es.keyID, err)
+		return nil, err
+	}
+	part := *in
+	if in.ContentLength != nil {
+		// 长度已知时边读边加密, 帧头记录明文长度, 分片长度改为密文长度
+		er, err := newEncryptReader(in.Data, masterKey, es.keyID, es.segSize, uint32(*in.PartNumber), *in.ContentLength)
+		if err != nil {
+			return nil, err
+		}
+		size := encCipherSize(es.keyID, es.segSize, *in.ContentLength)
+		part.Data = er
+		part.ContentLength = &size
+		return es.Storager.UploadPart(ctx, &part)
+	}
+	// 分片后面还有其他帧, 帧头需要记录明文长度, 长度未知时分片缓存在内存中
+	plain, err := io.ReadAll(in.Data)
+	if err != nil {
+		return nil, err
+	}
+	er, err := newEncryptReader(bytes.NewReader(plain), masterKey, es.keyID, es.segSize, uint32(*in.PartNumber), int64(len(plain)))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(er)
+	if err != nil {
+		return nil, err
+	}
+	part.Data = bytes.NewReader(data)
+	return es.Storager.UploadPart(ctx, &part)
+}
+
+// UploadDirectory 逐个文件加密上传
+func (es *EncryptedStorager) UploadDirectory(localDirPath, destDir string) ([]string, error) {
+	var uploadedPaths []string
+	if localDirPath == "" {
+		return nil, fmt.Errorf("local directory path is empty")
+	}
+	err := filepath.WalkDir(localDirPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing path %s: %w", filePath, err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(localDirPath, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
+		}
+		storagePath := path.Join(destDir, filepath.ToSlash(relPath))
+		file, err := os.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", filePath, err)
+		}
+		defer file.Close()
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fi := &FileInfo{
+			StoragePath: storagePath,
+			FileExt:     path.Ext(filePath),
+			Size:        info.Size(),
+		}
+		if err := es.Save(context.Background(), fi, file); err != nil {
+			return fmt.Errorf("failed to upload file %s to %s: %w", filePath, storagePath, err)
+		}
+		uploadedPaths = append(uploadedPaths, storagePath)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploadedPaths, nil
+}
+
+// setEncryptionExtra 在 Extra 中写入 encryption 字段, 保留其他字段
+func setEncryptionExtra(extra datatypes.JSON, enc EncryptionExtra) (datatypes.JSON, error) {
+	m := map[string]interface{}{}
+	if len(extra) > 0 {
+		if err := json.Unmarshal(extra, &m); err != nil {
+			return nil, err
+		}
+	}
+	m[extraEncryption] = enc
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return datatypes.JSON(data), nil
+}
+
+// GetEncryptionExtra 读取 FileInfo.Extra 中的加密信息, 未加密时返回 nil
+func GetEncryptionExtra(fi *FileInfo) *EncryptionExtra {
+	if len(fi.Extra) == 0 {
+		return nil
+	}
+	var m struct {
+		Encryption *EncryptionExtra `json:"encryption"`
+	}
+	if err := json.Unmarshal(fi.Extra, &m); err != nil {
+		return nil
+	}
+	return m.Encryption
+}
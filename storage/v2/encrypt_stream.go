@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// 加密后的文件由一个或多个帧组成, Save 写入一个帧, 分片上传时每个分片一个帧.
+// 帧格式:
+//
+//	magic(4) | segment_size(4) | frame_index(4) | plain_size(8) | key_id_len(1) | key_id | wrapped_key(60) | nonce_prefix(7) | segments...
+//
+// plain_size 为帧的明文长度, 流式写入时长度未知, 记为 encUnknownSize, 这样的帧只能是最后一帧.
+// wrapped_key 为主密钥对数据密钥的 AES-GCM 加密结果(nonce + 密文 + tag), 以帧头其余字段为附加数据.
+// 每个明文段单独使用数据密钥 AES-GCM 加密, nonce 为 nonce_prefix | 段序号(4) | 是否最后一段(1),
+// 段被截断、重排或跨帧拼接都会导致解密失败.
+const (
+	encMagic           = "YGE1"
+	encFixedHeaderSize = 4 + 4 + 4 + 8 + 1
+	encDataKeySize     = 32
+	encNoncePrefixSize = 7
+	encWrappedKeySize  = 12 + encDataKeySize + 16
+	encTagSize         = 16
+
+	defaultEncSegmentSize = 64 << 10
+	maxEncSegmentSize     = 16 << 20
+
+	encUnknownSize = ^uint64(0)
+)
+
+var errEncInvalidFrame = errors.New("invalid encrypted frame")
+
+// MasterKeyFunc 根据主密钥 ID 返回主密钥
+type MasterKeyFunc func(keyID string) ([]byte, error)
+
+// encFrameHeaderSize 帧头长度
+func encFrameHeaderSize(keyLen int) int64 {
+	return int64(encFixedHeaderSize + keyLen + encWrappedKeySize + encNoncePrefixSize)
+}
+
+// encCipherSize 明文长度为 plainSize 时单帧密文的长度
+func encCipherSize(keyID string, segSize int, plainSize int64) int64 {
+	return encFrameSize(len(keyID), segSize, plainSize)
+}
+
+// encFrameSize 主密钥 ID 长度为 keyLen 时单帧密文的长度
+func encFrameSize(keyLen int, segSize int, plainSize int64) int64 {
+	segments := (plainSize + int64(segSize) - 1) / int64(segSize)
+	if segments == 0 {
+		segments = 1
+	}
+	return encFrameHeaderSize(keyLen) + plainSize + segments*encTagSize
+}
+
+// encPlainSize 根据单帧密文的长度计算明文长度
+func encPlainSize(keyID string, segSize int, cipherSize int64) (int64, bool) {
+	body := cipherSize - encFrameHeaderSize(len(keyID))
+	if body < encTagSize {
+		return 0, false
+	}
+	full := int64(segSize + encTagSize)
+	segments, rest := body/full, body%full
+	if rest == 0 {
+		return segments * int64(segSize), true
+	}
+	if rest < encTagSize {
+		return 0, false
+	}
+	return segments*int64(segSize) + rest - encTagSize, true
+}
+
+// encFramesPlainSize 依次读取对象中各帧的帧头, 累加明文长度.
+// 分片上传的对象每个分片一个帧, 各帧长度不同, 只能逐帧计算
+func encFramesPlainSize(ctx context.Context, s Storager, storagePath string, cipherSize int64) (int64, error) {
+	var plain, offset int64
+	fixed := make([]byte, encFixedHeaderSize)
+	for offset < cipherSize {
+		rc, err := s.ReadRange(ctx, storagePath, offset, encFixedHeaderSize)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.ReadFull(rc, fixed)
+		rc.Close()
+		if err != nil {
+			return 0, fmt.Errorf("%w: read header: %v", errEncInvalidFrame, err)
+		}
+		if string(fixed[:4]) != encMagic {
+			return 0, fmt.Errorf("%w: bad magic", errEncInvalidFrame)
+		}
+		segSize := binary.BigEndian.Uint32(fixed[4:8])
+		size := binary.BigEndian.Uint64(fixed[12:20])
+		if segSize == 0 || segSize > maxEncSegmentSize || size > math.MaxInt64 {
+			return 0, fmt.Errorf("%w: bad segment size %d or plain size %d", errEncInvalidFrame, segSize, size)
+		}
+		plain += int64(size)
+		offset += encFrameSize(int(fixed[20]), int(segSize), int64(size))
+	}
+	if offset != cipherSize {
+		return 0, fmt.Errorf("%w: frames end at %d, object size %d", errEncInvalidFrame, offset, cipherSize)
+	}
+	return plain, nil
+}
+
+// encryptReader 从 src 读取明文, 输出一个加密帧, plainSize 小于 0 表示长度未知
+type encryptReader struct {
+	src    *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	plain  []byte
+	out    []byte
+	buf    []byte
+	index  uint32
+	done   bool
+	// size 帧头记录的明文长度, 小于 0 表示长度未知
+	size int64
+	// n 已读取的明文长度
+	n int64
+}
+
+func newEncryptReader(src io.Reader, masterKey []byte, keyID string, segSize int, frameIndex uint32, plainSize int64) (*encryptReader, error) {
+	if len(keyID) > 255 {
+		return nil, fmt.Errorf("key id %q too long", keyID)
+	}
+	dataKey := make([]byte, encDataKeySize)
+	prefix := make([]byte, encNoncePrefixSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, encFrameHeaderSize(len(keyID)))
+	header = append(header, encMagic...)
+	header = binary.BigEndian.AppendUint32(header, uint32(segSize))
+	header = binary.BigEndian.AppendUint32(header, frameIndex)
+	if plainSize < 0 {
+		header = binary.BigEndian.AppendUint64(header, encUnknownSize)
+	} else {
+		header = binary.BigEndian.AppendUint64(header, uint64(plainSize))
+	}
+	header = append(header, byte(len(keyID)))
+	header = append(header, keyID...)
+	wrapped, err := wrapDataKey(masterKey, dataKey, encKeyAAD(header, prefix))
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, wrapped...)
+	header = append(header, prefix...)
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if plainSize >= 0 {
+		// 多读一个字节用于发现超出 plainSize 的数据
+		src = io.LimitReader(src, plainSize+1)
+	}
+	return &encryptReader{
+		src:    bufio.NewReaderSize(src, segSize+1),
+		aead:   aead,
+		prefix: prefix,
+		plain:  make([]byte, segSize),
+		out:    make([]byte, 0, segSize+encTagSize),
+		buf:    header,
+		size:   plainSize,
+	}, nil
+}
+
+func (er *encryptReader) Read(p []byte) (int, error) {
+	for len(er.buf) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		if err := er.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, er.buf)
+	er.buf = er.buf[n:]
+	return n, nil
+}
+
+func (er *encryptReader) next() error {
+	n, err := io.ReadFull(er.src, er.plain)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := n < len(er.plain)
+	if !last {
+		if _, err := er.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	er.n += int64(n)
+	if er.size >= 0 && (er.n > er.size || last && er.n != er.size) {
+		return fmt.Errorf("plain size mismatch, declared %d, got %d", er.size, er.n)
+	}
+	er.buf = er.aead.Seal(er.out[:0], segmentNonce(er.prefix, er.index, last), er.plain[:n], nil)
+	er.index++
+	er.done = last
+	return nil
+}
+
+// decryptReader 依次解密 src 中的所有帧
+type decryptReader struct {
+	src  *bufio.Reader
+	keys MasterKeyFunc
+
+	aead      cipher.AEAD
+	prefix    []byte
+	segSize   int
+	index     uint32
+	lastFrame int64
+	inFrame   bool
+	// remaining 当前帧剩余的明文长度, 小于 0 表示读到结尾为止
+	remaining int64
+	// startIndex 第一帧从该段开始解密, src 中帧头之后紧接该段的密文
+	startIndex uint32
+	// lastIndex 不小于 0 时长度未知的帧以该段为最后一段, 用于 src 被截断的情况
+	lastIndex int64
+	cipher    []byte
+	plain     []byte
+	buf       []byte
+	err       error
+}
+
+func newDecryptReader(src io.Reader, keys MasterKeyFunc) *decryptReader {
+	return &decryptReader{src: bufio.NewReader(src), keys: keys, lastFrame: -1, lastIndex: -1}
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		dr.err = dr.next()
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) next() error {
+	if !dr.inFrame {
+		if _, err := dr.src.Peek(1); err == io.EOF && dr.lastFrame >= 0 {
+			return io.EOF
+		}
+		if err := dr.readHeader(); err != nil {
+			return err
+		}
+	}
+	var (
+		n    int
+		last bool
+		err  error
+	)
+	if dr.remaining >= 0 {
+		size := min(int64(dr.segSize), dr.remaining)
+		n, err = io.ReadFull(dr.src, dr.cipher[:size+encTagSize])
+		dr.remaining -= size
+		last = dr.remaining == 0
+	} else {
+		// 长度未知的帧读到结尾为止, 不足一段时一定是最后一段
+		n, err = io.ReadFull(dr.src, dr.cipher)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		last = n < len(dr.cipher)
+		if dr.lastIndex >= 0 {
+			last = int64(dr.index) >= dr.lastIndex
+		} else if !last {
+			if _, err := dr.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: unexpected end of frame", errEncInvalidFrame)
+	}
+	if err != nil {
+		return err
+	}
+	plain, err := dr.aead.Open(dr.plain[:0], segmentNonce(dr.prefix, dr.index, last), dr.cipher[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: segment %d: %v", errEncInvalidFrame, dr.index, err)
+	}
+	dr.buf = plain
+	dr.index++
+	if last {
+		dr.inFrame = false
+	}
+	return nil
+}
+
+func (dr *decryptReader) readHeader() error {
+	fixed := make([]byte, encFixedHeaderSize)
+	if _, err := io.ReadFull(dr.src, fixed); err != nil {
+		return fmt.Errorf("%w: read header: %v", errEncInvalidFrame, err)
+	}
+	if string(fixed[:4]) != encMagic {
+		return fmt.Errorf("%w: bad magic", errEncInvalidFrame)
+	}
+	segSize := binary.BigEndian.Uint32(fixed[4:8])
+	frameIndex := int64(binary.BigEndian.Uint32(fixed[8:12]))
+	plainSize := binary.BigEndian.Uint64(fixed[12:20])
+	if plainSize != encUnknownSize && plainSize > math.MaxInt64 {
+		return fmt.Errorf("%w: bad plain size %d", errEncInvalidFrame, plainSize)
+	}
+	if segSize == 0 || segSize > maxEncSegmentSize {
+		return fmt.Errorf("%w: bad segment size %d", errEncInvalidFrame, segSize)
+	}
+	// 分片按序号递增合并, 帧序号必须递增
+	if frameIndex <= dr.lastFrame {
+		return fmt.Errorf("%w: frame %d after %d", errEncInvalidFrame, frameIndex, dr.lastFrame)
+	}
+	rest := make([]byte, int(fixed[20])+encWrappedKeySize+encNoncePrefixSize)
+	if _, err := io.ReadFull(dr.src, rest); err != nil {
+		return fmt.Errorf("%w: read header: %v", errEncInvalidFrame, err)
+	}
+	keyID := string(rest[:fixed[20]])
+	wrapped := rest[fixed[20] : int(fixed[20])+encWrappedKeySize]
+	prefix := rest[int(fixed[20])+encWrappedKeySize:]
+
+	masterKey, err := dr.keys(keyID)
+	if err != nil {
+		return fmt.Errorf("load master key %q: %w", keyID, err)
+	}
+	header := append(fixed, keyID...)
+	dataKey, err := unwrapDataKey(masterKey, wrapped, encKeyAAD(header, prefix))
+	if err != nil {
+		return fmt.Errorf("%w: unwrap data key with %q: %v", errEncInvalidFrame, keyID, err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	dr.aead = aead
+	dr.prefix = prefix
+	dr.segSize = int(segSize)
+	dr.index = 0
+	dr.lastFrame = frameIndex
+	dr.inFrame = true
+	dr.remaining = -1
+	if plainSize != encUnknownSize {
+		dr.remaining = int64(plainSize)
+	}
+	if dr.startIndex > 0 {
+		dr.index = dr.startIndex
+		if dr.remaining >= 0 {
+			dr.remaining -= int64(dr.startIndex) * int64(segSize)
+		}
+		dr.startIndex = 0
+	}
+	if cap(dr.cipher) < dr.segSize+encTagSize {
+		dr.cipher = make([]byte, dr.segSize+encTagSize)
+		dr.plain = make([]byte, 0, dr.segSize)
+	}
+	dr.cipher = dr.cipher[:dr.segSize+encTagSize]
+	return nil
+}
+
+func segmentNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// encKeyAAD 数据密钥的附加数据, 绑定帧头中除 wrapped_key 外的所有字段
+func encKeyAAD(header, prefix []byte) []byte {
+	aad := make([]byte, 0, len(header)+len(prefix))
+	aad = append(aad, header...)
+	return append(aad, prefix...)
+}
+
+func wrapDataKey(masterKey, dataKey, aad []byte) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, aad), nil
+}
+
+func unwrapDataKey(masterKey, wrapped, aad []byte) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, ct := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, ct, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,203 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory/memorytest"
+)
+
+func testMasterKeys(ids ...string) storage.MasterKeyFunc {
+	keys := map[string][]byte{}
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[:1]), 32)
+	}
+	return func(keyID string) ([]byte, error) {
+		if k, ok := keys[keyID]; ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("master key %s not found", keyID)
+	}
+}
+
+// readAll 读取 ReadFile/ReadRange 的全部结果
+func readAll(rc io.ReadCloser, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	return string(data), err
+}
+
+func TestEncryptedStorager(t *testing.T) {
+	ms := memorytest.NewStorage(t)
+	ctx := context.Background()
+	keys := testMasterKeys("k1", "k2")
+
+	_, err := storage.NewEncryptedStorager(ms, config.StorageEncryptionConfig{KeyID: "none"}, keys)
+	assert.Error(t, err)
+
+	es, err := storage.NewEncryptedStorager(ms, config.StorageEncryptionConfig{KeyID: "k1", SegmentSize: 16}, keys)
+	assert.NoError(t, err)
+
+	// 覆盖空文件、不足一段、正好整段和多段
+	for _, size := range []int{0, 5, 16, 32, 100} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		p := fmt.Sprintf("enc/%d.bin", size)
+		fi := &storage.FileInfo{StoragePath: p, Size: int64(size), Metadata: map[string]string{"owner": "42"}}
+		assert.NoError(t, es.Save(ctx, fi, bytes.NewReader(plain)))
+		assert.Equal(t, int64(size), fi.Size)
+		assert.Equal(t, map[string]string{"owner": "42"}, fi.Metadata)
+		assert.Equal(t, &storage.EncryptionExtra{Algorithm: storage.EncryptionAlgorithm, KeyID: "k1"}, storage.GetEncryptionExtra(fi))
+
+		raw, _ := ms.Bytes(p)
+		if size > 0 {
+			assert.False(t, bytes.Contains(raw, plain))
+		}
+		data, err := readAll(es.ReadFile(p))
+		assert.NoError(t, err)
+		assert.Equal(t, string(plain), data)
+
+		info, err := es.Stat(ctx, p)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), info.Size)
+		assert.Equal(t, "k1", info.Metadata["encryption-key-id"])
+		assert.Equal(t, "42", info.Metadata["owner"])
+
+		// 从 offset 所在的段开始读取, 覆盖段内、跨段和最后一段
+		for offset := 1; offset < size; offset += 7 {
+			for _, length := range []int{0, 1, 15, 16, 40} {
+				data, err := readAll(es.ReadRange(ctx, p, int64(offset), int64(length)))
+				assert.NoError(t, err)
+				end := size
+				if length > 0 {
+					end = min(size, offset+length)
+				}
+				assert.Equal(t, string(plain[offset:end]), data, "offset %d length %d", offset, length)
+			}
+		}
+	}
+
+	fi := &storage.FileInfo{StoragePath: "enc/a.txt", Extra: []byte(`{"x":1}`)}
+	assert.NoError(t, es.Save(ctx, fi, strings.NewReader("hello encrypted world")))
+	assert.Equal(t, int64(21), fi.Size)
+	assert.Equal(t, "md5:ab037d036616162de302257f296c5110", fi.Hash)
+	assert.JSONEq(t, `{"x":1,"encryption":{"alg":"AES-256-GCM-STREAM","key_id":"k1"}}`, string(fi.Extra))
+	data, err := readAll(es.ReadRange(ctx, "enc/a.txt", 6, 9))
+	assert.NoError(t, err)
+	assert.Equal(t, "encrypted", data)
+	data, err = readAll(es.ReadRange(ctx, "enc/a.txt", 16, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", data)
+
+	// 轮换主密钥后旧文件仍可读取
+	es2, err := storage.NewEncryptedStorager(ms, config.StorageEncryptionConfig{KeyID: "k2"}, keys)
+	assert.NoError(t, err)
+	data, err = readAll(es2.ReadFile("enc/a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello encrypted world", data)
+
+	// 缺少主密钥或密文被篡改时读取失败
+	es3, err := storage.NewEncryptedStorager(ms, config.StorageEncryptionConfig{KeyID: "k2"}, testMasterKeys("k2"))
+	assert.NoError(t, err)
+	_, err = readAll(es3.ReadFile("enc/a.txt"))
+	assert.Error(t, err)
+	raw, _ := ms.Bytes("enc/a.txt")
+	raw = bytes.Clone(raw)
+	raw[len(raw)-1] ^= 1
+	assert.NoError(t, ms.Save(ctx, &storage.FileInfo{StoragePath: "enc/bad.txt"}, bytes.NewReader(raw)))
+	_, err = readAll(es.ReadFile("enc/bad.txt"))
+	assert.Error(t, err)
+	// 截断最后一段
+	assert.NoError(t, ms.Save(ctx, &storage.FileInfo{StoragePath: "enc/cut.txt"}, bytes.NewReader(raw[:len(raw)-17])))
+	_, err = readAll(es.ReadFile("enc/cut.txt"))
+	assert.Error(t, err)
+	_, err = readAll(es.ReadRange(ctx, "enc/cut.txt", 16, 0))
+	assert.Error(t, err)
+	// 只解密需要的段, 前面的段被篡改不影响读取后面的段
+	raw = bytes.Clone(raw)
+	raw[len(raw)-1] ^= 1
+	raw[len(raw)-22] ^= 1
+	assert.NoError(t, ms.Save(ctx, &storage.FileInfo{StoragePath: "enc/head.txt"}, bytes.NewReader(raw)))
+	data, err = readAll(es.ReadRange(ctx, "enc/head.txt", 16, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", data)
+
+	_, err = es.GetPresignedURL("PUT", "enc/a.txt")
+	assert.Error(t, err)
+}
+
+func TestEncryptedStoragerMultipart(t *testing.T) {
+	ms := memorytest.NewStorage(t)
+	ctx := context.Background()
+	es, err := storage.NewEncryptedStorager(ms, config.StorageEncryptionConfig{KeyID: "k1", SegmentSize: 8}, testMasterKeys("k1"))
+	assert.NoError(t, err)
+
+	uploadID, err := es.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: aws.String("enc/big.bin")})
+	assert.NoError(t, err)
+	_, err = es.GeneratePresignedURL(ctx, &storage.GeneratePresignedURLInput{UploadID: uploadID})
+	assert.Error(t, err)
+
+	parts := []string{"part one is sixteen", "part two", "3"}
+	completed := []types.CompletedPart{}
+	for i, p := range parts {
+		in := &storage.UploadPartInput{
+			StoragePath: aws.String("enc/big.bin"),
+			UploadID:    uploadID,
+			PartNumber:  aws.Int(i + 1),
+			Data:        strings.NewReader(p),
+		}
+		if i > 0 {
+			// 长度已知的分片边读边加密
+			in.ContentLength = aws.Int64(int64(len(p)))
+		}
+		etag, err := es.UploadPart(ctx, in)
+		assert.NoError(t, err)
+		completed = append(completed, types.CompletedPart{PartNumber: aws.Int32(int32(i + 1)), ETag: etag})
+	}
+	assert.NoError(t, es.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+		StoragePath: aws.String("enc/big.bin"),
+		UploadID:    uploadID,
+		Parts:       &types.CompletedMultipartUpload{Parts: completed},
+	}))
+	data, err := readAll(es.ReadFile("enc/big.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(parts, ""), data)
+	info, err := es.Stat(ctx, "enc/big.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	// 多帧的文件从头解密后截取
+	data, err = readAll(es.ReadRange(ctx, "enc/big.bin", 17, 6))
+	assert.NoError(t, err)
+	assert.Equal(t, "enpart", data)
+
+	// 声明的长度与实际不符时上传失败
+	_, err = es.UploadPart(ctx, &storage.UploadPartInput{
+		StoragePath:   aws.String("enc/short.bin"),
+		UploadID:      uploadID,
+		PartNumber:    aws.Int(1),
+		Data:          strings.NewReader("abc"),
+		ContentLength: aws.Int64(4),
+	})
+	assert.Error(t, err)
+
+	// 分片顺序被调换时读取失败
+	raw, _ := ms.Bytes("enc/big.bin")
+	swapped := append(bytes.Clone(raw[len(raw)/2:]), raw[:len(raw)/2]...)
+	assert.NoError(t, ms.Save(ctx, &storage.FileInfo{StoragePath: "enc/swapped.bin"}, bytes.NewReader(swapped)))
+	_, err = readAll(es.ReadFile("enc/swapped.bin"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,519 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/mutex"
+	"github.com/ygpkg/yg-go/storage/imaging"
+	"github.com/ygpkg/yg-go/storage/quota"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultGCAbandonedUploadAge = 24 * time.Hour
+	defaultGCDeletedGracePeriod = 7 * 24 * time.Hour
+	defaultGCOrphanMinAge       = 24 * time.Hour
+	defaultGCBatchSize          = 100
+
+	// GCMutexKey 定时垃圾回收使用的集群锁
+	GCMutexKey = "storage_gc_mutex"
+
+	// 不支持分片上传的存储由 storage 包把分片保存为独立对象, 上传 ID 以 chunked- 开头,
+	// 分片对象位于目标文件所在目录的 .parts/{上传ID}/ 下, 分片记录保存在 core_upload_files_tmp_parts
+	chunkedUploadIDPrefix = "chunked-"
+	tableNameTempFilePart = "core_upload_files_tmp_parts"
+)
+
+var (
+	gcUploadingStatus = []FileStatus{FileStatusInit, FileStatusUploading, FileStatusUploadWaitComp, FileStatusFailed}
+	gcDeletedStatus   = []FileStatus{FileStatusDeleted, FileStatusAborted}
+)
+
+// GCOptions 垃圾回收参数
+type GCOptions struct {
+	// DryRun 只生成报告, 不修改数据库和存储
+	DryRun bool
+	// AbandonedUploadAge 分片上传超过该时间没有更新视为废弃, 默认 24h
+	AbandonedUploadAge time.Duration
+	// DeletedGracePeriod 文件删除或取消上传后保留对象的时间, 默认 7 天
+	DeletedGracePeriod time.Duration
+	// OrphanPrefixes 需要扫描孤儿对象的用途及路径前缀, 前缀为空时扫描整个存储; 为空时不扫描
+	OrphanPrefixes map[config.FilePurpose]string
+	// OrphanMinAge 修改时间在该时长内的对象不视为孤儿, 避免误判刚上传还未入库的对象, 默认 24h
+	OrphanMinAge time.Duration
+	// DeleteOrphans 是否删除孤儿对象, 默认只报告
+	DeleteOrphans bool
+	// ResizeCachePrefix ImageHandler 的缩放缓存目录, 原图仍有记录的缓存不视为孤儿, 默认 .cache/resize
+	ResizeCachePrefix string
+	// BatchSize 每批处理的记录数, 默认 100
+	BatchSize int
+}
+
+// GCReport 垃圾回收报告, 路径格式为 purpose:storage_path
+type GCReport struct {
+	DryRun bool `json:"dry_run"`
+	// AbortedUploads 取消的废弃分片上传
+	AbortedUploads []string `json:"aborted_uploads"`
+	// ExpiredTempFiles 清理的过期临时文件记录数
+	ExpiredTempFiles int `json:"expired_temp_files"`
+	// DeletedObjects 已删除文件对应的被删除对象
+	DeletedObjects []string `json:"deleted_objects"`
+	// PurgedFiles 彻底删除的文件记录数
+	PurgedFiles int `json:"purged_files"`
+	// Orphans 没有对应文件记录的对象
+	Orphans []string `json:"orphans"`
+	// Errors 处理失败的对象及原因
+	Errors []string `json:"errors"`
+}
+
+func (r *GCReport) addError(purpose, storagePath string, err error) {
+	r.Errors = append(r.Errors, fmt.Sprintf("%s:%s: %s", purpose, storagePath, err))
+}
+
+// GarbageCollector 清理废弃的分片上传、过期的临时文件、已删除文件的对象和孤儿对象
+type GarbageCollector struct {
+	db   *gorm.DB
+	opts GCOptions
+}
+
+// NewGarbageCollector 创建垃圾回收器, 存储实例通过 LoadStorager 按文件用途获取
+func NewGarbageCollector(db *gorm.DB, opts GCOptions) *GarbageCollector {
+	if opts.AbandonedUploadAge <= 0 {
+		opts.AbandonedUploadAge = defaultGCAbandonedUploadAge
+	}
+	if opts.DeletedGracePeriod <= 0 {
+		opts.DeletedGracePeriod = defaultGCDeletedGracePeriod
+	}
+	if opts.OrphanMinAge <= 0 {
+		opts.OrphanMinAge = defaultGCOrphanMinAge
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultGCBatchSize
+	}
+	if opts.ResizeCachePrefix == "" {
+		opts.ResizeCachePrefix = defaultResizeCachePrefix
+	}
+	return &GarbageCollector{db: db, opts: opts}
+}
+
+// Run 执行一次垃圾回收, 单个对象处理失败时记录到报告中并继续
+func (gc *GarbageCollector) Run(ctx context.Context) (*GCReport, error) {
+	now := time.Now()
+	report := &GCReport{DryRun: gc.opts.DryRun}
+	steps := []struct {
+		name string
+		fn   func(context.Context, time.Time, *GCReport) error
+	}{
+		{"abort abandoned uploads", gc.abortAbandonedUploads},
+		{"clean expired temp files", gc.cleanExpiredTempFiles},
+		{"purge deleted files", gc.purgeDeletedFiles},
+		{"find orphans", gc.findOrphans},
+	}
+	for _, step := range steps {
+		if err := step.fn(ctx, now, report); err != nil {
+			logs.Errorf("[storage_gc] %s failed: %s", step.name, err)
+			return report, fmt.Errorf("%s: %w", step.name, err)
+		}
+	}
+	logs.Infof("[storage_gc] done, dry_run: %v, aborted uploads: %d, expired temp files: %d, deleted objects: %d, orphans: %d, errors: %d",
+		report.DryRun, len(report.AbortedUploads), report.ExpiredTempFiles, len(report.DeletedObjects), len(report.Orphans), len(report.Errors))
+	return report, nil
+}
+
+// abortAbandonedUploads 取消长时间没有更新的分片上传, 并将文件标记为已取消
+func (gc *GarbageCollector) abortAbandonedUploads(ctx context.Context, now time.Time, report *GCReport) error {
+	before := now.Add(-gc.opts.AbandonedUploadAge)
+	return gc.eachFile(ctx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("status IN ? AND upload_s3_id <> '' AND updated_at < ?", gcUploadingStatus, before)
+	}, func(fi *FileInfo) error {
+		report.AbortedUploads = append(report.AbortedUploads, fi.Purpose+":"+fi.StoragePath)
+		if gc.opts.DryRun {
+			return nil
+		}
+		if err := gc.abortUpload(ctx, fi.Purpose, fi.StoragePath, fi.UploadS3ID); err != nil {
+			// 后端的分片上传可能已经过期, 仍然标记为已取消, 对象由后续步骤清理
+			report.addError(fi.Purpose, fi.StoragePath, err)
+		}
+		return gc.db.WithContext(ctx).Model(fi).Updates(map[string]interface{}{
+			"status":   FileStatusAborted,
+			"abort_at": now,
+		}).Error
+	})
+}
+
+// cleanExpiredTempFiles 取消过期临时文件的分片上传并删除记录
+func (gc *GarbageCollector) cleanExpiredTempFiles(ctx context.Context, now time.Time, report *GCReport) error {
+	var lastID uint
+	for {
+		var files []*TempFile
+		err := gc.db.WithContext(ctx).Unscoped().
+			Where("expired_at > ? AND expired_at < ? AND id > ?", time.Time{}, now, lastID).
+			Order("id").Limit(gc.opts.BatchSize).Find(&files).Error
+		if err != nil {
+			return err
+		}
+		for _, tf := range files {
+			lastID = tf.ID
+			report.ExpiredTempFiles++
+			if gc.opts.DryRun {
+				continue
+			}
+			if strings.HasPrefix(tf.ThirdUploadID, chunkedUploadIDPrefix) {
+				if err := gc.abortChunkedUpload(ctx, tf); err != nil {
+					report.addError(tf.Purpose, tf.StoragePath, err)
+				}
+			} else if tf.ThirdUploadID != "" {
+				if err := gc.abortUpload(ctx, tf.Purpose, tf.StoragePath, tf.ThirdUploadID); err != nil {
+					report.addError(tf.Purpose, tf.StoragePath, err)
+				}
+			}
+			if err := gc.db.WithContext(ctx).Unscoped().Delete(tf).Error; err != nil {
+				return err
+			}
+		}
+		if len(files) < gc.opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+// purgeDeletedFiles 删除已删除或已取消超过保留期的文件对象及图片变体, 再彻底删除记录.
+// 秒传的文件可能共用同一个对象, 仍有其他有效记录引用时只删除记录
+func (gc *GarbageCollector) purgeDeletedFiles(ctx context.Context, now time.Time, report *GCReport) error {
+	before := now.Add(-gc.opts.DeletedGracePeriod)
+	return gc.eachFile(ctx, func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Where("(status IN ? AND updated_at < ?) OR (deleted_at IS NOT NULL AND deleted_at < ?)",
+			gcDeletedStatus, before, before)
+	}, func(fi *FileInfo) error {
+		if fi.StoragePath != "" {
+			var refs int64
+			err := gc.db.WithContext(ctx).Model(&FileInfo{}).
+				Where("purpose = ? AND path = ? AND id <> ? AND status NOT IN ?", fi.Purpose, fi.StoragePath, fi.ID, gcDeletedStatus).
+				Count(&refs).Error
+			if err != nil {
+				return err
+			}
+			if refs == 0 {
+				for _, p := range objectPaths(fi) {
+					report.DeletedObjects = append(report.DeletedObjects, fi.Purpose+":"+p)
+					if gc.opts.DryRun {
+						continue
+					}
+					if err := gc.deleteObject(fi.Purpose, p); err != nil {
+						report.addError(fi.Purpose, p, err)
+						return nil
+					}
+				}
+			}
+		}
+		report.PurgedFiles++
+		if gc.opts.DryRun {
+			return nil
+		}
+		return gc.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Delete(fi).Error; err != nil {
+				return err
+			}
+			// deleted/aborted 状态的文件在变更状态时已经释放, 只软删除的文件仍计入用量, 在这里释放
+			if !quota.IsCounted(string(fi.Status)) {
+				return nil
+			}
+			return quota.Release(tx, quota.Key{CompanyID: fi.CompanyID, Uin: fi.Uin, Purpose: fi.Purpose}, fi.Size)
+		})
+	})
+}
+
+// findOrphans 列举存储中的对象, 找出没有文件记录和临时文件记录的对象
+func (gc *GarbageCollector) findOrphans(ctx context.Context, now time.Time, report *GCReport) error {
+	before := now.Add(-gc.opts.OrphanMinAge)
+	for purpose, prefix := range gc.opts.OrphanPrefixes {
+		s, err := LoadStorager(purpose)
+		if err != nil {
+			return fmt.Errorf("load storager %s: %w", purpose, err)
+		}
+		batch := make([]string, 0, gc.opts.BatchSize)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			orphans, err := gc.unknownPaths(ctx, purpose, batch)
+			batch = batch[:0]
+			if err != nil {
+				return err
+			}
+			for _, p := range orphans {
+				report.Orphans = append(report.Orphans, purpose+":"+p)
+				if gc.opts.DryRun || !gc.opts.DeleteOrphans {
+					continue
+				}
+				if err := gc.deleteObject(purpose, p); err != nil {
+					report.addError(purpose, p, err)
+				}
+			}
+			return nil
+		}
+		for obj, err := range ListAll(ctx, s, prefix, nil) {
+			if err != nil {
+				return fmt.Errorf("list %s: %w", purpose, err)
+			}
+			if !obj.LastModified.IsZero() && obj.LastModified.After(before) {
+				continue
+			}
+			batch = append(batch, obj.StoragePath)
+			if len(batch) >= gc.opts.BatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unknownPaths 返回 paths 中既没有文件记录也没有临时文件记录, 也不是现有文件派生对象的路径,
+// 已软删除的记录也算作有记录
+func (gc *GarbageCollector) unknownPaths(ctx context.Context, purpose string, paths []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, model := range []interface{}{&FileInfo{}, &TempFile{}} {
+		var found []string
+		err := gc.db.WithContext(ctx).Unscoped().Model(model).
+			Where("purpose = ? AND path IN ?", purpose, paths).
+			Pluck("path", &found).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range found {
+			known[p] = true
+		}
+	}
+	var rest []string
+	for _, p := range paths {
+		if !known[p] {
+			rest = append(rest, p)
+		}
+	}
+	derived, err := gc.derivedPaths(ctx, purpose, rest)
+	if err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for _, p := range rest {
+		if !derived[p] {
+			unknown = append(unknown, p)
+		}
+	}
+	return unknown, nil
+}
+
+// derivedPath 可能由原图派生的对象
+type derivedPath struct {
+	path  string
+	cache bool
+}
+
+// derivedPaths 返回 paths 中属于现有文件的派生对象: 记录在 Extra 中的图片变体 {原图}_{name}.{ext},
+// 以及原图仍有记录的缩放缓存 {ResizeCachePrefix}/{w}x{h}_{fit}/{原图}.{ext}
+func (gc *GarbageCollector) derivedPaths(ctx context.Context, purpose string, paths []string) (map[string]bool, error) {
+	// 按原图路径(去掉扩展名)分组
+	bases := map[string][]derivedPath{}
+	for _, p := range paths {
+		if base, ok := gc.cacheOrigin(p); ok {
+			bases[base] = append(bases[base], derivedPath{path: p, cache: true})
+		} else if base, ok := variantOrigin(p); ok {
+			bases[base] = append(bases[base], derivedPath{path: p})
+		}
+	}
+	if len(bases) == 0 {
+		return nil, nil
+	}
+
+	conds := make([]string, 0, len(bases))
+	args := make([]interface{}, 0, len(bases)*2)
+	for base := range bases {
+		conds = append(conds, "path = ? OR path LIKE ? ESCAPE '!'")
+		args = append(args, base, escapeLike(base)+".%")
+	}
+	var files []*FileInfo
+	err := gc.db.WithContext(ctx).Unscoped().Select("id", "path", "extra").
+		Where("purpose = ?", purpose).Where("("+strings.Join(conds, " OR ")+")", args...).
+		Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+
+	derived := map[string]bool{}
+	for _, fi := range files {
+		variants := map[string]bool{}
+		if img := imaging.GetImageExtra(fi.Extra); img != nil {
+			for _, v := range img.Variants {
+				variants[v.Path] = true
+			}
+		}
+		for _, d := range bases[strings.TrimSuffix(fi.StoragePath, path.Ext(fi.StoragePath))] {
+			if d.cache || variants[d.path] {
+				derived[d.path] = true
+			}
+		}
+	}
+	return derived, nil
+}
+
+// cacheOrigin 缩放缓存对应的原图路径(去掉扩展名)
+func (gc *GarbageCollector) cacheOrigin(p string) (string, bool) {
+	rest, ok := strings.CutPrefix(p, strings.Trim(gc.opts.ResizeCachePrefix, "/")+"/")
+	if !ok {
+		return "", false
+	}
+	_, name, ok := strings.Cut(rest, "/")
+	if !ok || name == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(name, path.Ext(name)), true
+}
+
+// variantOrigin 图片变体对应的原图路径(去掉扩展名), 见 imaging.VariantPath
+func variantOrigin(p string) (string, bool) {
+	stem := strings.TrimSuffix(p, path.Ext(p))
+	i := strings.LastIndex(stem, "_")
+	if i <= strings.LastIndex(stem, "/")+1 {
+		return "", false
+	}
+	return stem[:i], true
+}
+
+// escapeLike 转义 LIKE 通配符, 配合 ESCAPE '!' 使用
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// objectPaths 文件对应的所有对象, 包括图片变体
+func objectPaths(fi *FileInfo) []string {
+	paths := []string{fi.StoragePath}
+	if img := imaging.GetImageExtra(fi.Extra); img != nil {
+		for _, v := range img.Variants {
+			if v.Path != "" {
+				paths = append(paths, v.Path)
+			}
+		}
+	}
+	return paths
+}
+
+// eachFile 按 ID 顺序分批遍历符合条件的文件记录, 处理过程中修改记录不影响遍历
+func (gc *GarbageCollector) eachFile(ctx context.Context, scope func(*gorm.DB) *gorm.DB, fn func(*FileInfo) error) error {
+	var lastID uint
+	for {
+		var files []*FileInfo
+		err := scope(gc.db.WithContext(ctx)).Where("id > ?", lastID).
+			Order("id").Limit(gc.opts.BatchSize).Find(&files).Error
+		if err != nil {
+			return err
+		}
+		for _, fi := range files {
+			lastID = fi.ID
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(fi); err != nil {
+				return err
+			}
+		}
+		if len(files) < gc.opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (gc *GarbageCollector) abortUpload(ctx context.Context, purpose, storagePath, uploadID string) error {
+	s, err := LoadStorager(purpose)
+	if err != nil {
+		return err
+	}
+	err = s.AbortMultipartUpload(ctx, &AbortMultipartUploadInput{
+		StoragePath: &storagePath,
+		UploadID:    &uploadID,
+	})
+	if err != nil {
+		logs.Warnf("[storage_gc] abort upload %s of %s:%s failed: %s", uploadID, purpose, storagePath, err)
+	}
+	return err
+}
+
+// abortChunkedUpload 删除分片对象上传已保存的分片对象和分片记录
+func (gc *GarbageCollector) abortChunkedUpload(ctx context.Context, tf *TempFile) error {
+	s, err := LoadStorager(tf.Purpose)
+	if err != nil {
+		return err
+	}
+	prefix := path.Join(strings.TrimPrefix(path.Dir(tf.StoragePath), "/"), ".parts", tf.ThirdUploadID) + "/"
+	for obj, err := range ListAll(ctx, s, prefix, nil) {
+		if err != nil {
+			logs.Warnf("[storage_gc] list chunks of %s:%s failed: %s", tf.Purpose, tf.StoragePath, err)
+			return err
+		}
+		if err := gc.deleteObject(tf.Purpose, obj.StoragePath); err != nil {
+			return err
+		}
+	}
+	err = gc.db.WithContext(ctx).Exec("DELETE FROM "+tableNameTempFilePart+" WHERE upload_id = ?", tf.ThirdUploadID).Error
+	if err != nil {
+		logs.Warnf("[storage_gc] delete chunk records of %s:%s failed: %s", tf.Purpose, tf.StoragePath, err)
+	}
+	return err
+}
+
+func (gc *GarbageCollector) deleteObject(purpose, storagePath string) error {
+	s, err := LoadStorager(purpose)
+	if err != nil {
+		return err
+	}
+	err = s.DeleteFile(storagePath)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		logs.Warnf("[storage_gc] delete %s:%s failed: %s", purpose, storagePath, err)
+		return err
+	}
+	return nil
+}
+
+// JobFunc 返回可注册为定时任务的函数, 输出报告的 JSON.
+// job.RegistryCronFunc 已经通过集群锁保证只在一个节点执行, 例:
+// job.RegistryCronFunc(db, "0 30 3 * * *", "storage-gc", gc.JobFunc())
+func (gc *GarbageCollector) JobFunc() func() (string, error) {
+	return func() (string, error) {
+		report, err := gc.Run(context.Background())
+		data, _ := json.Marshal(report)
+		return string(data), err
+	}
+}
+
+// Start 每隔 interval 在主节点执行一次垃圾回收, ctx 结束时退出
+func (gc *GarbageCollector) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !mutex.IsMaster(mutex.WithMutexKey(GCMutexKey)) {
+					continue
+				}
+				if _, err := gc.Run(ctx); err != nil {
+					logs.Errorf("[storage_gc] run failed: %s", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,144 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/ygpkg/yg-go/storage/imaging"
+	"github.com/ygpkg/yg-go/storage/quota"
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory/memorytest"
+)
+
+func TestGarbageCollector(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gc.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&storage.FileInfo{}, &storage.TempFile{}))
+	assert.NoError(t, quota.InitDB(db))
+
+	ms := memorytest.NewStorage(t)
+	storage.SetStorager("gc", ms)
+	t.Cleanup(func() { storage.RemoveStorager("gc") })
+
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	save := func(p string) {
+		saveString(t, ms, p, "data")
+	}
+	create := func(fi *storage.FileInfo, updatedAt time.Time) *storage.FileInfo {
+		fi.Purpose = "gc"
+		assert.NoError(t, db.Create(fi).Error)
+		assert.NoError(t, db.Model(fi).UpdateColumn("updated_at", updatedAt).Error)
+		return fi
+	}
+
+	// 废弃的分片上传
+	uploadID, err := ms.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: aws.String("up/a.bin")})
+	assert.NoError(t, err)
+	abandoned := create(&storage.FileInfo{StoragePath: "up/a.bin", Status: storage.FileStatusUploading, UploadS3ID: *uploadID}, old)
+	create(&storage.FileInfo{StoragePath: "up/b.bin", Status: storage.FileStatusUploading, UploadS3ID: "recent"}, time.Now())
+
+	// 已删除的文件, 其中 shared 仍被另一条记录引用
+	save("f/deleted.txt")
+	save("f/shared.txt")
+	save("f/recent.txt")
+	create(&storage.FileInfo{StoragePath: "f/deleted.txt", Status: storage.FileStatusDeleted}, old)
+	create(&storage.FileInfo{StoragePath: "f/shared.txt", Status: storage.FileStatusDeleted}, old)
+	create(&storage.FileInfo{StoragePath: "f/shared.txt", Status: storage.FileStatusNormal}, old)
+	create(&storage.FileInfo{StoragePath: "f/recent.txt", Status: storage.FileStatusDeleted}, time.Now())
+
+	// 只软删除的文件仍计入用量, 彻底删除时释放
+	save("f/soft.txt")
+	soft := create(&storage.FileInfo{CompanyID: 1, StoragePath: "f/soft.txt", Status: storage.FileStatusNormal, Size: 4}, old)
+	assert.NoError(t, quota.Add(db, quota.Key{CompanyID: 1, Purpose: "gc"}, soft.Size))
+	assert.NoError(t, db.Delete(soft).Error)
+	assert.NoError(t, db.Unscoped().Model(soft).UpdateColumn("deleted_at", old).Error)
+
+	// 过期的临时文件
+	tmpUploadID, err := ms.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: aws.String("tmp/a.bin")})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Create(&storage.TempFile{Purpose: "gc", StoragePath: "tmp/a.bin", ThirdUploadID: *tmpUploadID, ExpiredAt: old}).Error)
+	assert.NoError(t, db.Create(&storage.TempFile{Purpose: "gc", StoragePath: "tmp/b.bin", ExpiredAt: time.Now().Add(time.Hour)}).Error)
+	// 不支持分片上传的存储把分片保存为独立对象, 过期时删除分片对象和分片记录
+	assert.NoError(t, db.Exec("CREATE TABLE core_upload_files_tmp_parts (id integer PRIMARY KEY, upload_id text, part_number integer)").Error)
+	assert.NoError(t, db.Exec("INSERT INTO core_upload_files_tmp_parts (upload_id, part_number) VALUES ('chunked-1', 0), ('chunked-1', 1)").Error)
+	save("tmp/.parts/chunked-1/00000")
+	save("tmp/.parts/chunked-1/00001")
+	assert.NoError(t, db.Create(&storage.TempFile{Purpose: "gc", StoragePath: "/tmp/c.bin", ThirdUploadID: "chunked-1", ExpiredAt: old}).Error)
+
+	// 图片变体和缩放缓存属于原图, 原图不存在的缓存和没有记录的变体视为孤儿
+	imageExtra := func(p string, variants ...string) []byte {
+		img := &imaging.ImageExtra{Variants: map[string]*imaging.VariantExtra{}}
+		for _, v := range variants {
+			img.Variants[v] = &imaging.VariantExtra{Path: imaging.VariantPath(p, v, "jpeg")}
+		}
+		extra, err := imaging.SetImageExtra(nil, img)
+		assert.NoError(t, err)
+		return extra
+	}
+	save("f/img.png")
+	save("f/img_thumb.jpg")
+	save("f/img_large.jpg")
+	save(".cache/resize/100x0_contain/f/img.png")
+	save(".cache/resize/100x0_contain/f/gone.png")
+	create(&storage.FileInfo{StoragePath: "f/img.png", Status: storage.FileStatusNormal, Extra: imageExtra("f/img.png", "thumb")}, old)
+	save("f/del.png")
+	save("f/del_thumb.jpg")
+	create(&storage.FileInfo{StoragePath: "f/del.png", Status: storage.FileStatusDeleted, Extra: imageExtra("f/del.png", "thumb")}, old)
+
+	// 孤儿对象
+	save("f/orphan.txt")
+	assert.Equal(t, 2, ms.PendingUploads())
+
+	opts := storage.GCOptions{
+		DryRun:         true,
+		OrphanPrefixes: map[string]string{"gc": ""},
+		OrphanMinAge:   time.Nanosecond,
+		DeleteOrphans:  true,
+		BatchSize:      2,
+	}
+	report, err := storage.NewGarbageCollector(db, opts).Run(ctx)
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"gc:up/a.bin"}, report.AbortedUploads)
+	assert.Equal(t, 2, report.ExpiredTempFiles)
+	assert.Equal(t, []string{"gc:f/deleted.txt", "gc:f/soft.txt", "gc:f/del.png", "gc:f/del_thumb.jpg"}, report.DeletedObjects)
+	assert.Equal(t, 4, report.PurgedFiles)
+	assert.ElementsMatch(t, []string{"gc:.cache/resize/100x0_contain/f/gone.png", "gc:f/img_large.jpg", "gc:f/orphan.txt",
+		"gc:tmp/.parts/chunked-1/00000", "gc:tmp/.parts/chunked-1/00001"}, report.Orphans)
+	assert.Empty(t, report.Errors)
+	// 试运行不修改任何数据
+	assert.Equal(t, 2, ms.PendingUploads())
+	assert.Len(t, ms.Paths(), 14)
+
+	opts.DryRun = false
+	out, err := storage.NewGarbageCollector(db, opts).JobFunc()()
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(out, `"gc:f/orphan.txt"`))
+	assert.Equal(t, 0, ms.PendingUploads())
+	assert.ElementsMatch(t, []string{"f/shared.txt", "f/recent.txt", "f/img.png", "f/img_thumb.jpg", ".cache/resize/100x0_contain/f/img.png"}, ms.Paths())
+
+	var fi storage.FileInfo
+	assert.NoError(t, db.First(&fi, abandoned.ID).Error)
+	assert.Equal(t, storage.FileStatusAborted, fi.Status)
+	assert.NotNil(t, fi.AbortAt)
+	var count int64
+	db.Unscoped().Model(&storage.FileInfo{}).Count(&count)
+	assert.EqualValues(t, 5, count)
+	db.Unscoped().Model(&storage.TempFile{}).Count(&count)
+	assert.EqualValues(t, 1, count)
+	db.Table("core_upload_files_tmp_parts").Count(&count)
+	assert.Zero(t, count)
+	var usage quota.Usage
+	assert.NoError(t, db.Where("company_id = ? AND purpose = ?", 1, "gc").First(&usage).Error)
+	assert.Zero(t, usage.Size)
+	assert.Zero(t, usage.FileCount)
+}
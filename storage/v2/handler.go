@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ygpkg/yg-go/logs"
+)
+
+// FileHandler 通过 Storager 提供文件下载, 支持 Range/If-Range/If-None-Match/If-Modified-Since,
+// 用于后端没有公开访问地址或需要经过鉴权中间件的场景.
+// 例: router.GRequireLogin("file.src", storage.NewFileHandler(s))
+type FileHandler struct {
+	s Storager
+	// PathFunc 从请求中取出存储路径, 默认取 query 参数 p
+	PathFunc func(r *http.Request) string
+	// CacheControl 非空时设置 Cache-Control 头
+	CacheControl string
+}
+
+var _ http.Handler = (*FileHandler)(nil)
+
+// NewFileHandler 创建 FileHandler
+func NewFileHandler(s Storager) *FileHandler {
+	return &FileHandler{
+		s: s,
+		PathFunc: func(r *http.Request) string {
+			return r.URL.Query().Get("p")
+		},
+	}
+}
+
+func (h *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	storagePath := h.PathFunc(r)
+	if storagePath == "" {
+		http.Error(w, "storage path is empty", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	info, err := h.s.Stat(ctx, storagePath)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		logs.Errorf("[file_handler] stat %s failed: %s", storagePath, err)
+		http.Error(w, "stat file failed", http.StatusInternalServerError)
+		return
+	}
+
+	etag := ""
+	if info.ETag != "" {
+		etag = strconv.Quote(strings.Trim(info.ETag, `"`))
+	}
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if !info.LastModified.IsZero() {
+		header.Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if h.CacheControl != "" {
+		header.Set("Cache-Control", h.CacheControl)
+	}
+	if notModified(r, etag, info.LastModified) {
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	ct := info.ContentType
+	if ct == "" {
+		ct = mime.TypeByExtension(path.Ext(storagePath))
+	}
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	header.Set("Content-Type", ct)
+
+	offset, length, status := int64(0), info.Size, http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" && ifRangeMatch(r, etag, info.LastModified) {
+		start, n, ok := parseRange(rng, info.Size)
+		if !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			http.Error(w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if n >= 0 {
+			offset, length, status = start, n, http.StatusPartialContent
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+		}
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead || length == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	rc, err := h.s.ReadRange(ctx, storagePath, offset, length)
+	if err != nil {
+		header.Del("Content-Length")
+		header.Del("Content-Range")
+		if errors.Is(err, ErrObjectNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		logs.Errorf("[file_handler] read %s failed: %s", storagePath, err)
+		http.Error(w, "read file failed", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	w.WriteHeader(status)
+	if _, err := io.CopyN(w, rc, length); err != nil {
+		logs.Warnf("[file_handler] write %s failed: %s", storagePath, err)
+	}
+}
+
+// notModified 处理 If-None-Match, 没有该头时处理 If-Modified-Since
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagMatch(inm, etag, true)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	return err == nil && !modTime.Truncate(time.Second).After(t)
+}
+
+// ifRangeMatch If-Range 与当前版本一致时 Range 才生效, If-Range 为 ETag 时使用强比较
+func ifRangeMatch(r *http.Request, etag string, modTime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etag != "" && etagMatch(ir, etag, false)
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !modTime.IsZero() && modTime.Truncate(time.Second).Equal(t)
+}
+
+// etagMatch 判断 header 中的 ETag 列表是否包含 etag, weak 为 true 时忽略 W/ 前缀
+func etagMatch(header, etag string, weak bool) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" && weak {
+			return true
+		}
+		if strings.HasPrefix(v, "W/") {
+			if !weak {
+				continue
+			}
+			v = v[2:]
+		}
+		if v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange 解析单个区间的 Range 头, 返回起始位置和长度.
+// 多区间或格式不支持时返回长度 -1, 按完整内容响应; 区间无法满足时 ok 为 false
+func parseRange(s string, size int64) (start, length int64, ok bool) {
+	spec, found := strings.CutPrefix(s, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, -1, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, -1, true
+	}
+	if first == "" {
+		// bytes=-n 读取最后 n 字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, -1, true
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, -1, true
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, -1, true
+		}
+		if e < end {
+			end = e
+		}
+	}
+	return start, end - start + 1, true
+}
@@ -0,0 +1,103 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory/memorytest"
+)
+
+func TestFileHandler(t *testing.T) {
+	ms := memorytest.NewStorage(t)
+	fi := &storage.FileInfo{StoragePath: "v/a.txt", FileExt: ".txt"}
+	assert.NoError(t, ms.Save(context.Background(), fi, strings.NewReader("0123456789")))
+	srv := httptest.NewServer(storage.NewFileHandler(ms))
+	t.Cleanup(srv.Close)
+
+	do := func(method, p string, header map[string]string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, srv.URL+"?p="+p, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, body := do(http.MethodGet, "v/a.txt", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+	etag := resp.Header.Get("ETag")
+	assert.Equal(t, `"781e5e245d69b566979b86e28d23f2c7"`, etag)
+	lastModified := resp.Header.Get("Last-Modified")
+
+	cases := []struct {
+		header map[string]string
+		status int
+		body   string
+		cr     string
+	}{
+		{map[string]string{"Range": "bytes=2-4"}, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{map[string]string{"Range": "bytes=7-"}, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{map[string]string{"Range": "bytes=8-100"}, http.StatusPartialContent, "89", "bytes 8-9/10"},
+		// 多区间按完整内容响应
+		{map[string]string{"Range": "bytes=0-1,3-4"}, http.StatusOK, "0123456789", ""},
+		{map[string]string{"Range": "bytes=10-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		// If-Range 匹配时 Range 生效, 不匹配时返回完整内容
+		{map[string]string{"Range": "bytes=0-0", "If-Range": etag}, http.StatusPartialContent, "0", "bytes 0-0/10"},
+		{map[string]string{"Range": "bytes=0-0", "If-Range": `"other"`}, http.StatusOK, "0123456789", ""},
+		{map[string]string{"Range": "bytes=0-0", "If-Range": lastModified}, http.StatusPartialContent, "0", "bytes 0-0/10"},
+		{map[string]string{"If-None-Match": etag}, http.StatusNotModified, "", ""},
+		{map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified, "", ""},
+		{map[string]string{"If-None-Match": `"other"`}, http.StatusOK, "0123456789", ""},
+		{map[string]string{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}, http.StatusNotModified, "", ""},
+	}
+	for _, c := range cases {
+		resp, body := do(http.MethodGet, "v/a.txt", c.header)
+		assert.Equal(t, c.status, resp.StatusCode, c.header)
+		if c.status != http.StatusRequestedRangeNotSatisfiable {
+			assert.Equal(t, c.body, body, c.header)
+		}
+		assert.Equal(t, c.cr, resp.Header.Get("Content-Range"), c.header)
+	}
+
+	resp, body = do(http.MethodHead, "v/a.txt", map[string]string{"Range": "bytes=1-2"})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Content-Length"))
+	assert.Empty(t, body)
+
+	resp, _ = do(http.MethodGet, "v/none.txt", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = do(http.MethodGet, "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = do(http.MethodPost, "v/a.txt", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestLimitRange(t *testing.T) {
+	assert.Equal(t, "", storage.RangeHeader(0, 0))
+	assert.Equal(t, "bytes=5-", storage.RangeHeader(5, -1))
+	assert.Equal(t, "bytes=5-9", storage.RangeHeader(5, 5))
+
+	rc, err := storage.LimitRange(io.NopCloser(strings.NewReader("0123456789")), 3, 4)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	assert.Equal(t, "3456", string(data))
+	assert.NoError(t, rc.Close())
+
+	_, err = storage.LimitRange(io.NopCloser(strings.NewReader("01")), 3, 1)
+	assert.Error(t, err)
+}
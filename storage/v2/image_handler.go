@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/storage/imaging"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultResizeMaxSize     = 4096
+	defaultResizeStep        = 128
+	defaultResizeCachePrefix = ".cache/resize"
+
+	metaResizeSource = "resize-source"
+)
+
+// ImageHandler 按需缩放图片, 请求参数 w/h 为目标宽高, fit 为 contain(默认) 或 cover.
+// 缩放结果缓存在存储的 CachePrefix 目录下并记录原图版本, 原图更新后重新生成;
+// 缓存命中后通过 FileHandler 响应, 支持 ETag/Range 等条件请求.
+// 例: router.G("image.src", storage.NewImageHandler(s))
+type ImageHandler struct {
+	s Storager
+	// PathFunc 从请求中取出原图的存储路径, 默认取 query 参数 p
+	PathFunc func(r *http.Request) string
+	// MaxSize 允许的最大宽高, 默认 4096
+	MaxSize int
+	// Step 宽高向上取整为 Step 的倍数, 限制每张原图缓存的尺寸数量, 默认 128.
+	// 小于等于 0 时不取整, 任意宽高都会生成一个永久缓存, 只应在设置了 Sizes 时使用
+	Step int
+	// Sizes 非空时只允许这些宽高, 请求的宽高向上取整为不小于它的最小尺寸, 超出最大尺寸时返回错误, 忽略 Step
+	Sizes []int
+	// CachePrefix 缓存目录, 默认 .cache/resize
+	CachePrefix string
+	// Quality JPEG 编码质量, 默认 85
+	Quality int
+	// CacheControl 非空时设置 Cache-Control 头
+	CacheControl string
+
+	group singleflight.Group
+}
+
+var _ http.Handler = (*ImageHandler)(nil)
+
+// NewImageHandler 创建 ImageHandler
+func NewImageHandler(s Storager) *ImageHandler {
+	return &ImageHandler{
+		s: s,
+		PathFunc: func(r *http.Request) string {
+			return r.URL.Query().Get("p")
+		},
+		MaxSize:     defaultResizeMaxSize,
+		Step:        defaultResizeStep,
+		CachePrefix: defaultResizeCachePrefix,
+	}
+}
+
+func (h *ImageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	storagePath := h.PathFunc(r)
+	if storagePath == "" {
+		http.Error(w, "storage path is empty", http.StatusBadRequest)
+		return
+	}
+	opts, err := h.parseOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cachePath := h.cachePath(storagePath, opts)
+
+	ctx := r.Context()
+	src, err := h.s.Stat(ctx, storagePath)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		logs.Errorf("[image_handler] stat %s failed: %s", storagePath, err)
+		http.Error(w, "stat file failed", http.StatusInternalServerError)
+		return
+	}
+	version := sourceVersion(src)
+	cached, err := h.s.Stat(ctx, cachePath)
+	if err != nil || cached.Metadata[metaResizeSource] != version {
+		_, err, _ = h.group.Do(cachePath, func() (interface{}, error) {
+			return nil, h.generate(context.WithoutCancel(ctx), storagePath, cachePath, version, opts)
+		})
+		if errors.Is(err, imaging.ErrInvalidImage) {
+			http.Error(w, "unsupported image", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			logs.Errorf("[image_handler] resize %s failed: %s", storagePath, err)
+			http.Error(w, "resize image failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	fh := &FileHandler{
+		s:            h.s,
+		PathFunc:     func(*http.Request) string { return cachePath },
+		CacheControl: h.CacheControl,
+	}
+	fh.ServeHTTP(w, r)
+}
+
+func (h *ImageHandler) parseOptions(r *http.Request) (imaging.ResizeOptions, error) {
+	q := r.URL.Query()
+	opts := imaging.ResizeOptions{Fit: q.Get("fit"), Quality: h.Quality}
+	for _, v := range []struct {
+		name string
+		dst  *int
+	}{{"w", &opts.Width}, {"h", &opts.Height}} {
+		s := q.Get(v.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > h.MaxSize {
+			return opts, fmt.Errorf("%s must be in [0, %d]", v.name, h.MaxSize)
+		}
+		if len(h.Sizes) > 0 {
+			if n, err = h.roundSize(n); err != nil {
+				return opts, fmt.Errorf("%s %w", v.name, err)
+			}
+		} else if h.Step > 0 && n%h.Step != 0 {
+			n = min((n/h.Step+1)*h.Step, h.MaxSize)
+		}
+		*v.dst = n
+	}
+	if opts.Width == 0 && opts.Height == 0 {
+		return opts, fmt.Errorf("w or h is required")
+	}
+	switch opts.Fit {
+	case "":
+		opts.Fit = imaging.FitContain
+	case imaging.FitContain:
+	case imaging.FitCover:
+		if opts.Width == 0 || opts.Height == 0 {
+			return opts, fmt.Errorf("fit cover requires both w and h")
+		}
+	default:
+		return opts, fmt.Errorf("unknown fit %q", opts.Fit)
+	}
+	return opts, nil
+}
+
+// cachePath 缓存路径 {CachePrefix}/{w}x{h}_{fit}/{原图路径去掉扩展名}.{jpg|png},
+// 输出格式由原图扩展名决定, 以便 FileHandler 根据扩展名返回 Content-Type
+// roundSize 把宽高取整为 Sizes 中不小于 n 的最小尺寸, 0 表示按比例计算
+func (h *ImageHandler) roundSize(n int) (int, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	ret := 0
+	for _, size := range h.Sizes {
+		if size >= n && size <= h.MaxSize && (ret == 0 || size < ret) {
+			ret = size
+		}
+	}
+	if ret == 0 {
+		return 0, fmt.Errorf("must not exceed the largest allowed size")
+	}
+	return ret, nil
+}
+
+func (h *ImageHandler) cachePath(storagePath string, opts imaging.ResizeOptions) string {
+	// 先按绝对路径清理, 防止 .. 跳出缓存目录
+	p := path.Clean("/" + storagePath)
+	ext := path.Ext(p)
+	format := imaging.OutputFormat(imaging.FormatByExt(ext))
+	dir := fmt.Sprintf("%dx%d_%s", opts.Width, opts.Height, opts.Fit)
+	name := strings.TrimSuffix(p, ext) + imaging.FormatExt(format)
+	return path.Join(h.CachePrefix, dir, name)
+}
+
+func (h *ImageHandler) generate(ctx context.Context, storagePath, cachePath, version string, opts imaging.ResizeOptions) error {
+	rc, err := h.s.ReadRange(ctx, storagePath, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	opts.Format = imaging.OutputFormat(imaging.FormatByExt(path.Ext(storagePath)))
+	img, err := imaging.Resize(rc, opts)
+	if err != nil {
+		return err
+	}
+	fi := &FileInfo{
+		StoragePath: cachePath,
+		FileExt:     img.Ext(),
+		MIMEType:    img.ContentType(),
+		Size:        int64(len(img.Data)),
+		Metadata:    map[string]string{metaResizeSource: version},
+	}
+	return h.s.Save(ctx, fi, bytes.NewReader(img.Data))
+}
+
+// sourceVersion 原图的版本标识, 没有 ETag 时使用大小和修改时间
+func sourceVersion(info *ObjectInfo) string {
+	if etag := strings.Trim(info.ETag, `"`); etag != "" {
+		return etag
+	}
+	return fmt.Sprintf("%d-%d", info.Size, info.LastModified.Unix())
+}
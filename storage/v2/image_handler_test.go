@@ -0,0 +1,106 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory/memorytest"
+)
+
+func savePNG(t *testing.T, s storage.Storager, p string, w, h int) {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	assert.NoError(t, s.Save(context.Background(), &storage.FileInfo{StoragePath: p}, &buf))
+}
+
+func TestImageHandler(t *testing.T) {
+	ms := memorytest.NewStorage(t)
+	savePNG(t, ms, "img/a.png", 100, 50)
+	h := storage.NewImageHandler(ms)
+	h.Step = 10
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	get := func(query string) (*http.Response, []byte) {
+		resp, err := http.Get(srv.URL + "?" + query)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("p=img/a.png&w=18")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	cfg, err := png.DecodeConfig(bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, 20, cfg.Width)
+	assert.Equal(t, 10, cfg.Height)
+	_, ok := ms.Bytes(".cache/resize/20x0_contain/img/a.png")
+	assert.True(t, ok)
+
+	// 缓存命中时支持条件请求
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?p=img/a.png&w=20", nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	cached, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	cached.Body.Close()
+	assert.Equal(t, http.StatusNotModified, cached.StatusCode)
+
+	// 原图更新后重新生成
+	savePNG(t, ms, "img/a.png", 40, 40)
+	_, body = get("p=img/a.png&w=20")
+	cfg, err = png.DecodeConfig(bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, 20, cfg.Height)
+
+	resp, _ = get("p=img/a.png&w=10&h=10&fit=cover")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, ok = ms.Bytes(".cache/resize/10x10_cover/img/a.png")
+	assert.True(t, ok)
+
+	resp, _ = get("p=../../img/a.png&w=10")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = get("p=img/a.png")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = get("p=img/a.png&w=5000")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = get("p=img/a.png&w=10&fit=cover")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = get("p=img/missing.png&w=10")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	saveString(t, ms, "img/b.png", "not an image")
+	resp, _ = get("p=img/b.png&w=10")
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	// 默认宽高取整为 128 的倍数
+	assert.Equal(t, 128, storage.NewImageHandler(ms).Step)
+
+	// 只允许配置的尺寸
+	h.Sizes = []int{32, 16}
+	_, body = get("p=img/a.png&w=17")
+	cfg, err = png.DecodeConfig(bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, 32, cfg.Width)
+	_, ok = ms.Bytes(".cache/resize/32x0_contain/img/a.png")
+	assert.True(t, ok)
+	resp, _ = get("p=img/a.png&w=33")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,144 @@
+package local
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ygpkg/yg-go/httptools"
+	"github.com/ygpkg/yg-go/logs"
+)
+
+const (
+	queryPath      = "p"
+	queryExpires   = "e"
+	querySignature = "s"
+
+	defaultPresignedTimeout = 15 * time.Minute
+)
+
+var _ http.Handler = (*LocalStorage)(nil)
+
+// ServeHTTP 提供本地存储文件的访问, 挂载到 GetPublicURL 返回的 public.src 路由上
+// 例: router.Any("public.src", ls)
+// GET/HEAD 支持 Range 请求, 配置 Private 时需要有效签名;
+// PUT 必须携带 GetPresignedURL 生成的签名.
+func (ls *LocalStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	storagePath := q.Get(queryPath)
+	fpath, err := ls.fullPath(storagePath)
+	if err != nil {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if ls.cfg.Private || q.Get(querySignature) != "" {
+			if err := ls.verify(http.MethodGet, storagePath, q.Get(queryExpires), q.Get(querySignature)); err != nil {
+				logs.Warnf("[local_storage] verify %s failed: %s", storagePath, err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		ls.serveFile(w, r, fpath)
+	case http.MethodPut:
+		if err := ls.verify(http.MethodPut, storagePath, q.Get(queryExpires), q.Get(querySignature)); err != nil {
+			logs.Warnf("[local_storage] verify %s failed: %s", storagePath, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		defer r.Body.Close()
+		if _, err := ls.writeFile(fpath, r.Body); err != nil {
+			http.Error(w, "write file failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (ls *LocalStorage) serveFile(w http.ResponseWriter, r *http.Request, fpath string) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		logs.Errorf("[local_storage] open file %s failed: %s", fpath, err)
+		http.Error(w, "open file failed", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		logs.Errorf("[local_storage] stat file %s failed: %s", fpath, err)
+		http.Error(w, "stat file failed", http.StatusInternalServerError)
+		return
+	}
+	if st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if ct := httptools.TransformExt2ContentType(strings.ToLower(filepath.Ext(fpath))); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	http.ServeContent(w, r, st.Name(), st.ModTime(), f)
+}
+
+// fullPath 将存储路径转换为本地文件路径, 保证结果不会越出存储目录
+func (ls *LocalStorage) fullPath(storagePath string) (string, error) {
+	if strings.ContainsRune(storagePath, 0) {
+		return "", fmt.Errorf("invalid storage path %q", storagePath)
+	}
+	cleaned := path.Clean("/" + filepath.ToSlash(storagePath))
+	if cleaned == "/" {
+		return "", fmt.Errorf("storage path is empty")
+	}
+	if cleaned == "/"+metaDirName || strings.HasPrefix(cleaned, "/"+metaDirName+"/") {
+		return "", fmt.Errorf("invalid storage path %q", storagePath)
+	}
+	return filepath.Join(ls.Dir, filepath.FromSlash(cleaned)), nil
+}
+
+func (ls *LocalStorage) presignedTimeout() time.Duration {
+	if ls.opt.PresignedTimeout > 0 {
+		return ls.opt.PresignedTimeout
+	}
+	return defaultPresignedTimeout
+}
+
+func (ls *LocalStorage) sign(method, storagePath string, expires int64) string {
+	mac := hmac.New(sha256.New, ls.signKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d", method, storagePath, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (ls *LocalStorage) verify(method, storagePath, expiresStr, signature string) error {
+	if expiresStr == "" || signature == "" {
+		return fmt.Errorf("signature required")
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expires")
+	}
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("signature expired")
+	}
+	expected := ls.sign(method, storagePath, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
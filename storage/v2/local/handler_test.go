@@ -0,0 +1,144 @@
+package local
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+func newTestStorage(t *testing.T, private bool) (*LocalStorage, *httptest.Server) {
+	mux := http.NewServeMux()
+	svr := httptest.NewServer(mux)
+	t.Cleanup(svr.Close)
+
+	ls, err := NewLocalStorageWithOption(config.LocalStorageConfig{
+		Dir:          t.TempDir(),
+		PublicPrefix: svr.URL,
+		SignKey:      "test-key",
+		Private:      private,
+	}, config.StorageOption{PresignedTimeout: time.Minute})
+	assert.NoError(t, err)
+	mux.Handle("/public.src", ls)
+	return ls, svr
+}
+
+func TestServePublicFile(t *testing.T) {
+	ls, _ := newTestStorage(t, false)
+	fi := &storage.FileInfo{StoragePath: "a/b/hello.txt"}
+	assert.NoError(t, ls.Save(context.Background(), fi, strings.NewReader("hello world")))
+
+	resp, err := http.Get(ls.GetPublicURL(fi.StoragePath, false))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "hello world", string(body))
+
+	req, _ := http.NewRequest(http.MethodGet, ls.GetPublicURL(fi.StoragePath, false), nil)
+	req.Header.Set("Range", "bytes=6-")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "world", string(body))
+
+	// 路径中的特殊字符需要转义
+	fi = &storage.FileInfo{StoragePath: "a/b c&d+e#f.txt"}
+	assert.NoError(t, ls.Save(context.Background(), fi, strings.NewReader("special")))
+	resp, err = http.Get(ls.GetPublicURL(fi.StoragePath, false))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "special", string(body))
+}
+
+func TestServePrivateFile(t *testing.T) {
+	ls, _ := newTestStorage(t, true)
+	fi := &storage.FileInfo{StoragePath: "secret.txt"}
+	assert.NoError(t, ls.Save(context.Background(), fi, strings.NewReader("top secret")))
+
+	resp, err := http.Get(ls.GetPublicURL(fi.StoragePath, false))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = http.Get(ls.GetPublicURL(fi.StoragePath, true))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "top secret", string(body))
+
+	// 篡改路径后签名失效
+	u, _ := url.Parse(ls.GetPublicURL(fi.StoragePath, true))
+	q := u.Query()
+	q.Set(queryPath, "other.txt")
+	u.RawQuery = q.Encode()
+	resp, err = http.Get(u.String())
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestPresignedPut(t *testing.T) {
+	ls, _ := newTestStorage(t, false)
+
+	putURL, err := ls.GetPresignedURL(http.MethodPut, "upload/data.json")
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPut, putURL, strings.NewReader(`{"a":1}`))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	rc, err := ls.ReadFile("upload/data.json")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, `{"a":1}`, string(data))
+
+	// GET 签名不能用于 PUT
+	getURL, _ := ls.GetPresignedURL(http.MethodGet, "upload/data.json")
+	req, _ = http.NewRequest(http.MethodPut, getURL, strings.NewReader("x"))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// 过期签名
+	expires := time.Now().Add(-time.Second).Unix()
+	q := url.Values{}
+	q.Set(queryPath, "upload/data.json")
+	q.Set(queryExpires, strconv.FormatInt(expires, 10))
+	q.Set(querySignature, ls.sign(http.MethodPut, "upload/data.json", expires))
+	req, _ = http.NewRequest(http.MethodPut, ls.cfg.PublicPrefix+"/public.src?"+q.Encode(), strings.NewReader("x"))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestFullPathTraversal(t *testing.T) {
+	ls, _ := newTestStorage(t, false)
+	for _, p := range []string{"../../etc/passwd", "/../etc/passwd", "a/../../../etc/passwd"} {
+		fpath, err := ls.fullPath(p)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(fpath, ls.Dir), fpath)
+	}
+	_, err := ls.fullPath("..")
+	assert.Error(t, err)
+}
@@ -0,0 +1,152 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ygpkg/yg-go/httptools"
+	"github.com/ygpkg/yg-go/logs"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+// metaDirName 用户元数据目录, 位于存储目录下, 每个文件对应一个 json
+const metaDirName = ".meta"
+
+func (ls *LocalStorage) Stat(ctx context.Context, storagePath string) (*storage.ObjectInfo, error) {
+	fpath, err := ls.fullPath(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		return nil, &fs.PathError{Op: "stat", Path: fpath, Err: storage.ErrObjectNotFound}
+	}
+	meta, err := ls.readMetadata(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.ObjectInfo{
+		StoragePath:  strings.TrimPrefix(path.Clean("/"+storagePath), "/"),
+		Size:         st.Size(),
+		ETag:         fileETag(st),
+		ContentType:  contentType(fpath),
+		LastModified: st.ModTime(),
+		Metadata:     meta,
+	}, nil
+}
+
+func (ls *LocalStorage) Exists(ctx context.Context, storagePath string) (bool, error) {
+	fpath, err := ls.fullPath(storagePath)
+	if err != nil {
+		return false, err
+	}
+	st, err := os.Stat(fpath)
+	if err != nil {
+		return storage.ExistsByStat(nil, err)
+	}
+	return !st.IsDir(), nil
+}
+
+// List 按 key 的顺序遍历存储目录, NextMarker 为本页最后一个文件的路径, 列表中不计算 ETag
+func (ls *LocalStorage) List(ctx context.Context, prefix string, opts *storage.ListOptions) (*storage.ListResult, error) {
+	res, err := storage.ListDirs(ctx, prefix, opts, ls.readDir)
+	if err != nil {
+		logs.Errorf("[local_storage] list %s failed: %s", prefix, err)
+		return nil, err
+	}
+	return res, nil
+}
+
+// readDir 读取 dir 下的条目, 根目录下跳过元数据目录
+func (ls *LocalStorage) readDir(_ context.Context, dir string) ([]storage.DirEntry, error) {
+	fdir := filepath.Join(ls.Dir, filepath.FromSlash(dir))
+	des, err := os.ReadDir(fdir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := make([]storage.DirEntry, 0, len(des))
+	for _, d := range des {
+		if d.IsDir() {
+			if dir == "" && d.Name() == metaDirName {
+				continue
+			}
+			entries = append(entries, storage.DirEntry{Name: d.Name(), IsDir: true})
+			continue
+		}
+		st, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, storage.DirEntry{Name: d.Name(), Object: &storage.ObjectInfo{
+			Size:         st.Size(),
+			ContentType:  contentType(filepath.Join(fdir, d.Name())),
+			LastModified: st.ModTime(),
+		}})
+	}
+	return entries, nil
+}
+
+func (ls *LocalStorage) metaPath(fpath string) string {
+	rel, _ := filepath.Rel(ls.Dir, fpath)
+	return filepath.Join(ls.Dir, metaDirName, rel+".json")
+}
+
+// writeMetadata 保存用户元数据, 为空时删除已有的元数据
+func (ls *LocalStorage) writeMetadata(fpath string, meta map[string]string) error {
+	mpath := ls.metaPath(fpath)
+	if len(meta) == 0 {
+		if err := os.Remove(mpath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(mpath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(mpath, data, 0644)
+}
+
+func (ls *LocalStorage) readMetadata(fpath string) (map[string]string, error) {
+	data, err := os.ReadFile(ls.metaPath(fpath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var meta map[string]string
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// fileETag 由修改时间和大小生成 ETag, 与 nginx 相同, 避免每次 Stat 读取整个文件计算 md5
+func fileETag(st fs.FileInfo) string {
+	return fmt.Sprintf("%x-%x", st.ModTime().UnixNano(), st.Size())
+}
+
+func contentType(fpath string) string {
+	return httptools.TransformExt2ContentType(strings.ToLower(filepath.Ext(fpath)))
+}
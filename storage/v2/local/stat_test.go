@@ -0,0 +1,92 @@
+package local
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+func TestStatAndList(t *testing.T) {
+	ls, _ := newTestStorage(t, false)
+	ctx := context.Background()
+
+	fi := &storage.FileInfo{StoragePath: "l/a.txt", Metadata: map[string]string{"owner": "42"}}
+	assert.NoError(t, ls.Save(ctx, fi, strings.NewReader("hello")))
+	for _, p := range []string{"l/b/c.txt", "l/bb.txt", "m/d.txt"} {
+		assert.NoError(t, ls.Save(ctx, &storage.FileInfo{StoragePath: p}, strings.NewReader(p)))
+	}
+
+	info, err := ls.Stat(ctx, "/l/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "l/a.txt", info.StoragePath)
+	assert.Equal(t, int64(5), info.Size)
+	assert.NotEmpty(t, info.ETag)
+	assert.Equal(t, "text/plain", info.ContentType)
+	// 内容变化后 ETag 随大小和修改时间变化
+	assert.NoError(t, ls.Save(ctx, &storage.FileInfo{StoragePath: "l/a.txt"}, strings.NewReader("hello!")))
+	info2, err := ls.Stat(ctx, "l/a.txt")
+	assert.NoError(t, err)
+	assert.NotEqual(t, info.ETag, info2.ETag)
+	// 重新保存时写入元数据
+	assert.NoError(t, ls.Save(ctx, fi, strings.NewReader("hello")))
+	info, err = ls.Stat(ctx, fi.StoragePath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "42"}, info.Metadata)
+
+	// 读取部分内容
+	rc, err := ls.ReadRange(ctx, "l/a.txt", 1, 3)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "ell", string(data))
+	rc, err = ls.ReadRange(ctx, "l/a.txt", 2, 0)
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "llo", string(data))
+	_, err = ls.ReadRange(ctx, "l/none.txt", 0, 1)
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+
+	// 目录和不存在的文件
+	_, err = ls.Stat(ctx, "l/b")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+	ok, err := ls.Exists(ctx, "l/b")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = ls.Exists(ctx, "l/bb.txt")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	res, err := ls.List(ctx, "l/b", nil)
+	assert.NoError(t, err)
+	assert.Len(t, res.Objects, 2)
+	assert.Equal(t, "l/b/c.txt", res.Objects[0].StoragePath)
+	assert.Equal(t, "l/bb.txt", res.Objects[1].StoragePath)
+
+	// 元数据目录不出现在列表中
+	res, err = ls.List(ctx, "", &storage.ListOptions{MaxKeys: 3})
+	assert.NoError(t, err)
+	assert.True(t, res.IsTruncated)
+	assert.Equal(t, "l/bb.txt", res.NextMarker)
+	res, err = ls.List(ctx, "", &storage.ListOptions{Marker: res.NextMarker})
+	assert.NoError(t, err)
+	assert.False(t, res.IsTruncated)
+	assert.Len(t, res.Objects, 1)
+	assert.Equal(t, "m/d.txt", res.Objects[0].StoragePath)
+
+	res, err = ls.List(ctx, "none/", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, res.Objects)
+
+	// 删除文件同时删除元数据
+	assert.NoError(t, ls.DeleteFile("l/a.txt"))
+	assert.NoError(t, ls.Save(ctx, &storage.FileInfo{StoragePath: "l/a.txt"}, strings.NewReader("x")))
+	info, err = ls.Stat(ctx, "l/a.txt")
+	assert.NoError(t, err)
+	assert.Nil(t, info.Metadata)
+}
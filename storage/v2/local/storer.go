@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/ygpkg/yg-go/config"
 	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/random"
 
 	storage "github.com/ygpkg/yg-go/storage/v2"
 )
@@ -18,21 +23,34 @@ func init() {
 		if cfg.Local == nil {
 			return nil, fmt.Errorf("local config is nil")
 		}
-		return NewLocalStorage(*cfg.Local)
+		return NewLocalStorageWithOption(*cfg.Local, cfg.StorageOption)
 	})
 }
 
 var _ storage.Storager = (*LocalStorage)(nil)
 
 type LocalStorage struct {
-	cfg config.LocalStorageConfig
-	Dir string
+	cfg     config.LocalStorageConfig
+	opt     config.StorageOption
+	signKey []byte
+	Dir     string
 }
 
 func NewLocalStorage(cfg config.LocalStorageConfig) (*LocalStorage, error) {
+	return NewLocalStorageWithOption(cfg, config.StorageOption{})
+}
+
+// NewLocalStorageWithOption 创建本地存储, opt 中的 PresignedTimeout 用于预签名链接的有效期
+func NewLocalStorageWithOption(cfg config.LocalStorageConfig, opt config.StorageOption) (*LocalStorage, error) {
 	ls := &LocalStorage{
-		cfg: cfg,
-		Dir: cfg.Dir,
+		cfg:     cfg,
+		opt:     opt,
+		signKey: []byte(cfg.SignKey),
+		Dir:     cfg.Dir,
+	}
+	if len(ls.signKey) == 0 {
+		ls.signKey = []byte(random.String(32))
+		logs.Warnf("[local_storage] sign_key is empty, presigned urls will be invalid after restart")
 	}
 	if err := os.MkdirAll(ls.cfg.Dir, 0755); err != nil {
 		return nil, err
@@ -41,41 +59,80 @@ func NewLocalStorage(cfg config.LocalStorageConfig) (*LocalStorage, error) {
 }
 
 func (ls *LocalStorage) Save(ctx context.Context, fi *storage.FileInfo, r io.Reader) error {
+	fpath, err := ls.fullPath(fi.StoragePath)
+	if err != nil {
+		return err
+	}
 	fi.StoragePath = filepath.Clean(fi.StoragePath)
-	fpath := filepath.Join(ls.Dir, fi.StoragePath)
+	fi.Size, err = ls.writeFile(fpath, r)
+	if err != nil {
+		return err
+	}
+	if err := ls.writeMetadata(fpath, fi.Metadata); err != nil {
+		logs.Errorf("[local_storage] write metadata of %s failed: %s", fpath, err)
+		return err
+	}
+	return nil
+}
+
+func (ls *LocalStorage) writeFile(fpath string, r io.Reader) (int64, error) {
 	dir := filepath.Dir(fpath)
 
 	if _, err := os.Stat(dir); err != nil {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			logs.Errorf("[local_storage] mkdir %s failed: %s", dir, err)
-			return err
+			return 0, err
 		}
 	}
 
 	f, err := os.Create(fpath)
 	if err != nil {
 		logs.Errorf("[local_storage] create file %s failed: %s", fpath, err)
-		return err
+		return 0, err
 	}
-	fi.Size, err = io.Copy(f, r)
+	defer f.Close()
+	n, err := io.Copy(f, r)
 	if err != nil {
 		logs.Errorf("[local_storage] write file %s failed: %s", fpath, err)
-		return err
+		return n, err
 	}
-	return nil
+	return n, nil
 }
 
-func (ls *LocalStorage) GetPublicURL(storagePath string, _ bool) string {
-	return fmt.Sprintf("%s/public.src?p=%s", ls.cfg.PublicPrefix, storagePath)
+func (ls *LocalStorage) GetPublicURL(storagePath string, temp bool) string {
+	if temp {
+		u, err := ls.GetPresignedURL(http.MethodGet, storagePath)
+		if err != nil {
+			logs.Errorf("[local_storage] get presigned url failed: %s", err)
+			return ""
+		}
+		return u
+	}
+	return fmt.Sprintf("%s/public.src?p=%s", ls.cfg.PublicPrefix, url.QueryEscape(storagePath))
 }
 
 func (ls *LocalStorage) GetPresignedURL(method, storagePath string) (string, error) {
-	return "", nil
+	switch method {
+	case http.MethodGet, http.MethodPut:
+	default:
+		return "", fmt.Errorf("only GET and PUT are allowed, now: %s", method)
+	}
+	if _, err := ls.fullPath(storagePath); err != nil {
+		return "", err
+	}
+	expires := time.Now().Add(ls.presignedTimeout()).Unix()
+	q := url.Values{}
+	q.Set(queryPath, storagePath)
+	q.Set(queryExpires, strconv.FormatInt(expires, 10))
+	q.Set(querySignature, ls.sign(method, storagePath, expires))
+	return fmt.Sprintf("%s/public.src?%s", ls.cfg.PublicPrefix, q.Encode()), nil
 }
 
 func (ls *LocalStorage) ReadFile(storagePath string) (io.ReadCloser, error) {
-	storagePath = filepath.Clean(storagePath)
-	fpath := filepath.Join(ls.Dir, storagePath)
+	fpath, err := ls.fullPath(storagePath)
+	if err != nil {
+		return nil, err
+	}
 	if _, err := os.Stat(fpath); err != nil {
 		logs.Errorf("[local_storage] file %s does not exist", fpath)
 		return nil, err
@@ -88,9 +145,31 @@ func (ls *LocalStorage) ReadFile(storagePath string) (io.ReadCloser, error) {
 	return file, nil
 }
 
+func (ls *LocalStorage) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	fpath, err := ls.fullPath(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fpath)
+	if err != nil {
+		logs.Errorf("[local_storage] open file %s failed: %s", fpath, err)
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			logs.Errorf("[local_storage] seek file %s failed: %s", fpath, err)
+			return nil, err
+		}
+	}
+	return storage.LimitRange(file, 0, length)
+}
+
 func (ls *LocalStorage) DeleteFile(storagePath string) error {
-	storagePath = filepath.Clean(storagePath)
-	fpath := filepath.Join(ls.Dir, storagePath)
+	fpath, err := ls.fullPath(storagePath)
+	if err != nil {
+		return err
+	}
 	if _, err := os.Stat(fpath); err != nil {
 		logs.Errorf("[local_storage] file %s does not exist", fpath)
 		return err
@@ -99,19 +178,26 @@ func (ls *LocalStorage) DeleteFile(storagePath string) error {
 		logs.Errorf("[local_storage] delete file %s failed: %s", fpath, err)
 		return err
 	}
+	if err := ls.writeMetadata(fpath, nil); err != nil {
+		logs.Warnf("[local_storage] delete metadata of %s failed: %s", fpath, err)
+	}
 	return nil
 }
 
 func (ls *LocalStorage) CopyDir(storagePath, dest string) error {
-	storagePath = filepath.Clean(storagePath)
-	dest = filepath.Clean(dest)
-	srcPath := filepath.Join(ls.Dir, storagePath)
+	srcPath, err := ls.fullPath(storagePath)
+	if err != nil {
+		return err
+	}
+	destPath, err := ls.fullPath(dest)
+	if err != nil {
+		return err
+	}
 	srcInfo, err := os.Stat(srcPath)
 	if err != nil {
 		logs.Errorf("[local_storage] source path %s does not exist", srcPath)
 		return err
 	}
-	destPath := filepath.Join(ls.Dir, dest)
 	if srcInfo.IsDir() {
 		return ls.copyDirectory(srcPath, destPath)
 	}
@@ -178,7 +264,17 @@ func (l *LocalStorage) CreateMultipartUpload(ctx context.Context, in *storage.Cr
 	return nil, fmt.Errorf("multipart upload not supported for LocalStorage")
 }
 func (l *LocalStorage) GeneratePresignedURL(ctx context.Context, in *storage.GeneratePresignedURLInput) (*string, error) {
-	return nil, fmt.Errorf("presigned part URL not supported for LocalStorage")
+	if in == nil || in.StoragePath == nil || in.Method == nil {
+		return nil, fmt.Errorf("storagePath or method is nil")
+	}
+	if in.UploadID != nil && *in.UploadID != "" {
+		return nil, fmt.Errorf("presigned part URL not supported for LocalStorage")
+	}
+	u, err := l.GetPresignedURL(*in.Method, *in.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
 func (l *LocalStorage) UploadPart(ctx context.Context, in *storage.UploadPartInput) (*string, error) {
 	return nil, fmt.Errorf("multipart upload not supported for LocalStorage")
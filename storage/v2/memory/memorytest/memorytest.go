@@ -0,0 +1,43 @@
+// Package memorytest 提供基于内存存储的测试工具
+package memorytest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ygpkg/yg-go/config"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory"
+)
+
+// NewStorage 创建内存存储并启动 httptest 服务处理预签名链接, 测试结束时自动清理.
+// 指定 purposes 时只注册这些用途, 否则作为所有用途的默认实例,
+// 之后 storage.LoadStorager 不再读取配置, 也不会访问磁盘或网络.
+func NewStorage(tb testing.TB, purposes ...string) *memory.MemoryStorage {
+	tb.Helper()
+	// 服务地址确定后才能创建存储, 先以闭包挂载
+	var ms *memory.MemoryStorage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms.ServeHTTP(w, r)
+	}))
+	ms, _ = memory.NewMemoryStorage(config.MemoryStorageConfig{PublicPrefix: srv.URL}, config.StorageOption{})
+
+	if len(purposes) == 0 {
+		storage.SetFallbackStorager(ms)
+	}
+	for _, purpose := range purposes {
+		storage.SetStorager(purpose, ms)
+	}
+	tb.Cleanup(func() {
+		if len(purposes) == 0 {
+			storage.SetFallbackStorager(nil)
+		}
+		for _, purpose := range purposes {
+			storage.RemoveStorager(purpose)
+		}
+		srv.Close()
+	})
+	return ms
+}
@@ -0,0 +1,539 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/random"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+func init() {
+	storage.Register("memory", func(cfg config.StorageConfig) (storage.Storager, error) {
+		if cfg.Memory == nil {
+			return nil, fmt.Errorf("memory config is nil")
+		}
+		return NewMemoryStorage(*cfg.Memory, cfg.StorageOption)
+	})
+}
+
+var _ storage.Storager = (*MemoryStorage)(nil)
+
+const defaultPresignedTimeout = 15 * time.Minute
+
+type object struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+	metadata    map[string]string
+}
+
+type multipartUpload struct {
+	storagePath string
+	contentType string
+	metadata    map[string]string
+	parts       map[int][]byte
+}
+
+// MemoryStorage 内存存储, 数据只保存在进程内, 用于单元测试.
+// 预签名链接由 ServeHTTP 处理, 需要挂载到 PublicPrefix 对应的服务上.
+type MemoryStorage struct {
+	cfg     config.MemoryStorageConfig
+	opt     config.StorageOption
+	signKey []byte
+
+	mu      sync.RWMutex
+	objects map[string]*object
+	uploads map[string]*multipartUpload
+}
+
+func NewMemoryStorage(cfg config.MemoryStorageConfig, opt config.StorageOption) (*MemoryStorage, error) {
+	cfg.PublicPrefix = strings.TrimSuffix(cfg.PublicPrefix, "/")
+	return &MemoryStorage{
+		cfg:     cfg,
+		opt:     opt,
+		signKey: []byte(random.String(32)),
+		objects: map[string]*object{},
+		uploads: map[string]*multipartUpload{},
+	}, nil
+}
+
+func (ms *MemoryStorage) Save(ctx context.Context, fi *storage.FileInfo, r io.Reader) error {
+	p, err := cleanPath(fi.StoragePath)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return fmt.Errorf("reader is empty")
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	ms.put(p, data, mime.TypeByExtension(fi.FileExt), maps.Clone(fi.Metadata))
+
+	sum := md5.Sum(data)
+	fi.StoragePath = p
+	fi.Size = int64(len(data))
+	fi.Hash = "md5:" + hex.EncodeToString(sum[:])
+	fi.PublicURL = ms.GetPublicURL(p, false)
+	return nil
+}
+
+func (ms *MemoryStorage) GetPublicURL(storagePath string, temp bool) string {
+	if temp {
+		u, err := ms.GetPresignedURL(http.MethodGet, storagePath)
+		if err != nil {
+			return ""
+		}
+		return u
+	}
+	q := url.Values{}
+	q.Set(queryPath, storagePath)
+	return ms.cfg.PublicPrefix + "/?" + q.Encode()
+}
+
+func (ms *MemoryStorage) GetPresignedURL(method, storagePath string) (string, error) {
+	switch method {
+	case http.MethodGet, http.MethodPut:
+	default:
+		return "", fmt.Errorf("only GET and PUT are allowed, now: %s", method)
+	}
+	p, err := cleanPath(storagePath)
+	if err != nil {
+		return "", err
+	}
+	return ms.presign(method, p, "", 0), nil
+}
+
+func (ms *MemoryStorage) ReadFile(storagePath string) (io.ReadCloser, error) {
+	p, err := cleanPath(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := ms.get(p)
+	if !ok {
+		return nil, notExist(p)
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (ms *MemoryStorage) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	p, err := cleanPath(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := ms.get(p)
+	if !ok {
+		return nil, notExist(p)
+	}
+	size := int64(len(obj.data))
+	if offset < 0 || offset > size {
+		return nil, fmt.Errorf("memory object %s: offset %d out of range", p, offset)
+	}
+	end := size
+	if length > 0 && offset+length < size {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(obj.data[offset:end])), nil
+}
+
+func (ms *MemoryStorage) Stat(ctx context.Context, storagePath string) (*storage.ObjectInfo, error) {
+	p, err := cleanPath(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := ms.get(p)
+	if !ok {
+		return nil, notExist(p)
+	}
+	return obj.info(p), nil
+}
+
+func (ms *MemoryStorage) Exists(ctx context.Context, storagePath string) (bool, error) {
+	return storage.ExistsByStat(ms.Stat(ctx, storagePath))
+}
+
+// List NextMarker 为本页最后一个文件的路径
+func (ms *MemoryStorage) List(ctx context.Context, prefix string, opts *storage.ListOptions) (*storage.ListResult, error) {
+	marker, maxKeys := opts.GetMarker(), opts.GetMaxKeys()
+	res := &storage.ListResult{}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	keys := make([]string, 0, len(ms.objects))
+	for k := range ms.objects {
+		if strings.HasPrefix(k, prefix) && k > marker {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		res.IsTruncated = true
+		res.NextMarker = keys[maxKeys-1]
+	}
+	for _, k := range keys {
+		res.Objects = append(res.Objects, ms.objects[k].info(k))
+	}
+	return res, nil
+}
+
+// DeleteFile 删除不存在的文件不报错, 与对象存储一致
+func (ms *MemoryStorage) DeleteFile(storagePath string) error {
+	p, err := cleanPath(storagePath)
+	if err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.objects, p)
+	return nil
+}
+
+func (ms *MemoryStorage) CopyDir(storagePath, dest string) error {
+	src, err := cleanPath(storagePath)
+	if err != nil {
+		return err
+	}
+	dst, err := cleanPath(dest)
+	if err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if obj, ok := ms.objects[src]; ok {
+		ms.objects[dst] = obj
+		return nil
+	}
+	copied := map[string]*object{}
+	for k, obj := range ms.objects {
+		if strings.HasPrefix(k, src+"/") {
+			copied[dst+strings.TrimPrefix(k, src)] = obj
+		}
+	}
+	if len(copied) == 0 {
+		return notExist(src)
+	}
+	for k, obj := range copied {
+		ms.objects[k] = obj
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) UploadDirectory(localDirPath, destDir string) ([]string, error) {
+	var uploadedPaths []string
+	if localDirPath == "" {
+		return nil, fmt.Errorf("local directory path is empty")
+	}
+	err := filepath.WalkDir(localDirPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing path %s: %w", filePath, err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(localDirPath, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", filePath, err)
+		}
+		fi := &storage.FileInfo{
+			StoragePath: path.Join(destDir, filepath.ToSlash(relPath)),
+			FileExt:     path.Ext(filePath),
+		}
+		if err := ms.Save(context.Background(), fi, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		uploadedPaths = append(uploadedPaths, fi.StoragePath)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploadedPaths, nil
+}
+
+func (ms *MemoryStorage) CreateMultipartUpload(ctx context.Context, in *storage.CreateMultipartUploadInput) (*string, error) {
+	if in == nil || in.StoragePath == nil {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	p, err := cleanPath(*in.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	up := &multipartUpload{storagePath: p, metadata: maps.Clone(in.Metadata), parts: map[int][]byte{}}
+	if in.ContentType != nil {
+		up.contentType = *in.ContentType
+	}
+	uploadID := uuid.NewString()
+	ms.mu.Lock()
+	ms.uploads[uploadID] = up
+	ms.mu.Unlock()
+	return &uploadID, nil
+}
+
+// GeneratePresignedURL 生成上传分片的预签名链接, 只支持 PUT
+func (ms *MemoryStorage) GeneratePresignedURL(ctx context.Context, in *storage.GeneratePresignedURLInput) (*string, error) {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil || in.PartNumber == nil {
+		return nil, fmt.Errorf("storagePath, uploadID or partNumber is nil")
+	}
+	if in.Method != nil && *in.Method != http.MethodPut {
+		return nil, fmt.Errorf("only PUT is allowed, now: %s", *in.Method)
+	}
+	p, err := cleanPath(*in.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ms.upload(*in.UploadID, p); err != nil {
+		return nil, err
+	}
+	u := ms.presign(http.MethodPut, p, *in.UploadID, *in.PartNumber)
+	return &u, nil
+}
+
+func (ms *MemoryStorage) UploadPart(ctx context.Context, in *storage.UploadPartInput) (*string, error) {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil || in.PartNumber == nil {
+		return nil, fmt.Errorf("storagePath, uploadID or partNumber is nil")
+	}
+	if in.Data == nil {
+		return nil, fmt.Errorf("reader is empty")
+	}
+	data, err := io.ReadAll(in.Data)
+	if err != nil {
+		return nil, err
+	}
+	p, err := cleanPath(*in.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	etag, err := ms.putPart(*in.UploadID, p, *in.PartNumber, data)
+	if err != nil {
+		return nil, err
+	}
+	return &etag, nil
+}
+
+// CompleteMultipartUpload 按分片序号合并, in.Parts 为空时合并全部已上传分片, 指定 ETag 时校验
+func (ms *MemoryStorage) CompleteMultipartUpload(ctx context.Context, in *storage.CompleteMultipartUploadInput) error {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil {
+		return fmt.Errorf("storagePath or uploadID is nil")
+	}
+	p, err := cleanPath(*in.StoragePath)
+	if err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	up, err := ms.uploadLocked(*in.UploadID, p)
+	if err != nil {
+		return err
+	}
+
+	etags := map[int]string{}
+	var nums []int
+	if in.Parts != nil && len(in.Parts.Parts) > 0 {
+		for _, part := range in.Parts.Parts {
+			if part.PartNumber == nil {
+				return fmt.Errorf("part number is nil")
+			}
+			n := int(*part.PartNumber)
+			if _, ok := up.parts[n]; !ok {
+				return fmt.Errorf("part %d not uploaded", n)
+			}
+			if part.ETag != nil {
+				etags[n] = strings.Trim(*part.ETag, `"`)
+			}
+			nums = append(nums, n)
+		}
+	} else {
+		for n := range up.parts {
+			nums = append(nums, n)
+		}
+	}
+	if len(nums) == 0 {
+		return fmt.Errorf("no parts uploaded for %s", *in.UploadID)
+	}
+	sort.Ints(nums)
+
+	var buf bytes.Buffer
+	for _, n := range nums {
+		data := up.parts[n]
+		if etag, ok := etags[n]; ok && etag != md5Hex(data) {
+			return fmt.Errorf("part %d etag mismatch", n)
+		}
+		buf.Write(data)
+	}
+	ms.objects[p] = &object{data: buf.Bytes(), contentType: up.contentType, modTime: time.Now(), metadata: up.metadata}
+	delete(ms.uploads, *in.UploadID)
+	return nil
+}
+
+func (ms *MemoryStorage) AbortMultipartUpload(ctx context.Context, in *storage.AbortMultipartUploadInput) error {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil {
+		return fmt.Errorf("storagePath or uploadID is nil")
+	}
+	p, err := cleanPath(*in.StoragePath)
+	if err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, err := ms.uploadLocked(*in.UploadID, p); err != nil {
+		return err
+	}
+	delete(ms.uploads, *in.UploadID)
+	return nil
+}
+
+// Bytes 返回文件内容, 便于测试断言
+func (ms *MemoryStorage) Bytes(storagePath string) ([]byte, bool) {
+	p, err := cleanPath(storagePath)
+	if err != nil {
+		return nil, false
+	}
+	obj, ok := ms.get(p)
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(obj.data), true
+}
+
+// Paths 返回全部文件路径, 按字典序排列
+func (ms *MemoryStorage) Paths() []string {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	paths := make([]string, 0, len(ms.objects))
+	for k := range ms.objects {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// PendingUploads 返回未完成的分片上传数量
+func (ms *MemoryStorage) PendingUploads() int {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return len(ms.uploads)
+}
+
+// Reset 清空全部文件和分片上传
+func (ms *MemoryStorage) Reset() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.objects = map[string]*object{}
+	ms.uploads = map[string]*multipartUpload{}
+}
+
+func (obj *object) info(p string) *storage.ObjectInfo {
+	return &storage.ObjectInfo{
+		StoragePath:  p,
+		Size:         int64(len(obj.data)),
+		ETag:         md5Hex(obj.data),
+		ContentType:  obj.contentType,
+		LastModified: obj.modTime,
+		Metadata:     maps.Clone(obj.metadata),
+	}
+}
+
+func (ms *MemoryStorage) get(p string) (*object, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	obj, ok := ms.objects[p]
+	return obj, ok
+}
+
+func (ms *MemoryStorage) put(p string, data []byte, contentType string, metadata map[string]string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.objects[p] = &object{data: data, contentType: contentType, modTime: time.Now(), metadata: metadata}
+}
+
+func (ms *MemoryStorage) putPart(uploadID, p string, partNumber int, data []byte) (string, error) {
+	if partNumber < 1 || partNumber > 10000 {
+		return "", fmt.Errorf("part number %d out of range", partNumber)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	up, err := ms.uploadLocked(uploadID, p)
+	if err != nil {
+		return "", err
+	}
+	up.parts[partNumber] = data
+	return md5Hex(data), nil
+}
+
+func (ms *MemoryStorage) upload(uploadID, p string) (*multipartUpload, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.uploadLocked(uploadID, p)
+}
+
+func (ms *MemoryStorage) uploadLocked(uploadID, p string) (*multipartUpload, error) {
+	up, ok := ms.uploads[uploadID]
+	if !ok {
+		return nil, fmt.Errorf("multipart upload %s not found", uploadID)
+	}
+	if up.storagePath != p {
+		return nil, fmt.Errorf("multipart upload %s does not belong to %s", uploadID, p)
+	}
+	return up, nil
+}
+
+func (ms *MemoryStorage) presign(method, p, uploadID string, partNumber int) string {
+	timeout := ms.opt.PresignedTimeout
+	if timeout <= 0 {
+		timeout = defaultPresignedTimeout
+	}
+	expires := time.Now().Add(timeout).Unix()
+	q := url.Values{}
+	q.Set(queryPath, p)
+	if uploadID != "" {
+		q.Set(queryUploadID, uploadID)
+		q.Set(queryPartNumber, strconv.Itoa(partNumber))
+	}
+	q.Set(queryExpires, strconv.FormatInt(expires, 10))
+	q.Set(querySignature, ms.sign(method, p, uploadID, partNumber, expires))
+	return ms.cfg.PublicPrefix + "/?" + q.Encode()
+}
+
+// cleanPath 统一存储路径格式, 去掉开头的 /
+func cleanPath(storagePath string) (string, error) {
+	p := strings.TrimPrefix(path.Clean("/"+storagePath), "/")
+	if p == "" {
+		return "", fmt.Errorf("storage path is empty")
+	}
+	return p, nil
+}
+
+func notExist(p string) error {
+	return fmt.Errorf("memory storage %s: %w", p, fs.ErrNotExist)
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
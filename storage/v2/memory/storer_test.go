@@ -0,0 +1,185 @@
+package memory_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory/memorytest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	ms := memorytest.NewStorage(t, "avatar")
+	s, err := storage.LoadStorager("avatar")
+	assert.NoError(t, err)
+	assert.Same(t, ms, s)
+	ctx := context.Background()
+
+	fi := &storage.FileInfo{StoragePath: "/a/b.txt", FileExt: ".txt"}
+	assert.NoError(t, s.Save(ctx, fi, strings.NewReader("hello")))
+	assert.Equal(t, "a/b.txt", fi.StoragePath)
+	assert.Equal(t, int64(5), fi.Size)
+	assert.Equal(t, "md5:5d41402abc4b2a76b9719d911017c592", fi.Hash)
+
+	// 公开链接由 httptest 服务提供, 支持 Range
+	req, _ := http.NewRequest(http.MethodGet, fi.PublicURL, nil)
+	req.Header.Set("Range", "bytes=1-3")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "ell", string(body))
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+
+	// 预签名上传
+	u, err := s.GetPresignedURL(http.MethodPut, "c/d.bin")
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPut, u, strings.NewReader("put"))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data, ok := ms.Bytes("c/d.bin")
+	assert.True(t, ok)
+	assert.Equal(t, "put", string(data))
+
+	// 签名被篡改
+	req, _ = http.NewRequest(http.MethodPut, strings.Replace(u, "d.bin", "e.bin", 1), strings.NewReader("x"))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	assert.NoError(t, s.CopyDir("a", "backup"))
+	assert.NoError(t, s.CopyDir("c/d.bin", "backup/d.bin"))
+	assert.Equal(t, []string{"a/b.txt", "backup/b.txt", "backup/d.bin", "c/d.bin"}, ms.Paths())
+	assert.True(t, errors.Is(s.CopyDir("missing", "x"), fs.ErrNotExist))
+
+	assert.NoError(t, s.DeleteFile("a/b.txt"))
+	_, err = s.ReadFile("a/b.txt")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	rc, err := s.ReadFile("backup/b.txt")
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(rc)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestMemoryStorageStatAndList(t *testing.T) {
+	ms := memorytest.NewStorage(t)
+	ctx := context.Background()
+
+	fi := &storage.FileInfo{StoragePath: "l/a.txt", FileExt: ".txt", Metadata: map[string]string{"owner": "42"}}
+	assert.NoError(t, ms.Save(ctx, fi, strings.NewReader("hello")))
+	for _, p := range []string{"l/b/c.txt", "l/bb.txt", "m/d.txt"} {
+		assert.NoError(t, ms.Save(ctx, &storage.FileInfo{StoragePath: p}, strings.NewReader(p)))
+	}
+
+	info, err := ms.Stat(ctx, "/l/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "l/a.txt", info.StoragePath)
+	assert.Equal(t, int64(5), info.Size)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", info.ETag)
+	assert.Equal(t, map[string]string{"owner": "42"}, info.Metadata)
+
+	_, err = ms.Stat(ctx, "l/none.txt")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+	ok, err := ms.Exists(ctx, "l/none.txt")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	res, err := ms.List(ctx, "l/b", &storage.ListOptions{MaxKeys: 1})
+	assert.NoError(t, err)
+	assert.True(t, res.IsTruncated)
+	assert.Equal(t, "l/b/c.txt", res.NextMarker)
+
+	keys := []string{}
+	for obj, err := range storage.ListAll(ctx, ms, "", &storage.ListOptions{MaxKeys: 3}) {
+		assert.NoError(t, err)
+		keys = append(keys, obj.StoragePath)
+	}
+	assert.Equal(t, []string{"l/a.txt", "l/b/c.txt", "l/bb.txt", "m/d.txt"}, keys)
+}
+
+func TestMemoryStorageMultipart(t *testing.T) {
+	ms := memorytest.NewStorage(t)
+	s, err := storage.LoadStorager("any-purpose")
+	assert.NoError(t, err)
+	assert.Same(t, ms, s)
+	ctx := context.Background()
+
+	uploadID, err := s.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		ContentType: aws.String("application/octet-stream"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ms.PendingUploads())
+
+	// 分片 2 通过预签名链接上传, 分片 1 直接上传
+	u, err := s.GeneratePresignedURL(ctx, &storage.GeneratePresignedURLInput{
+		Method:      aws.String(http.MethodPut),
+		StoragePath: aws.String("big.bin"),
+		UploadID:    uploadID,
+		PartNumber:  aws.Int(2),
+	})
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPut, *u, strings.NewReader("world"))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	etag2, _ := strconv.Unquote(resp.Header.Get("ETag"))
+
+	etag1, err := s.UploadPart(ctx, &storage.UploadPartInput{
+		StoragePath: aws.String("big.bin"),
+		UploadID:    uploadID,
+		PartNumber:  aws.Int(1),
+		Data:        bytes.NewReader([]byte("hello ")),
+	})
+	assert.NoError(t, err)
+
+	// ETag 不匹配
+	err = s.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		UploadID:    uploadID,
+		Parts: &types.CompletedMultipartUpload{Parts: []types.CompletedPart{
+			{PartNumber: aws.Int32(1), ETag: aws.String(etag2)},
+		}},
+	})
+	assert.Error(t, err)
+
+	assert.NoError(t, s.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		UploadID:    uploadID,
+		Parts: &types.CompletedMultipartUpload{Parts: []types.CompletedPart{
+			{PartNumber: aws.Int32(2), ETag: aws.String(`"` + etag2 + `"`)},
+			{PartNumber: aws.Int32(1), ETag: etag1},
+		}},
+	}))
+	data, _ := ms.Bytes("big.bin")
+	assert.Equal(t, "hello world", string(data))
+	assert.Equal(t, 0, ms.PendingUploads())
+
+	uploadID, err = s.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: aws.String("x.bin")})
+	assert.NoError(t, err)
+	assert.Error(t, s.AbortMultipartUpload(ctx, &storage.AbortMultipartUploadInput{
+		StoragePath: aws.String("y.bin"),
+		UploadID:    uploadID,
+	}))
+	assert.NoError(t, s.AbortMultipartUpload(ctx, &storage.AbortMultipartUploadInput{
+		StoragePath: aws.String("x.bin"),
+		UploadID:    uploadID,
+	}))
+	assert.Equal(t, 0, ms.PendingUploads())
+}
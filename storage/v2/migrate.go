@@ -0,0 +1,510 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/settings"
+	"github.com/ygpkg/yg-go/storage/imaging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TableNameMigration        = "core_storage_migrations"
+	TableNameMigrationFailure = "core_storage_migration_failures"
+
+	defaultMigrateConcurrency = 8
+	defaultMigrateBatchSize   = 100
+	// maxReportFailures 报告中最多列出的失败路径数, 完整列表见 MigrationFailure
+	maxReportFailures = 100
+)
+
+// MigrateSource 迁移时遍历对象的来源
+type MigrateSource string
+
+const (
+	// MigrateFromFiles 遍历 core_upload_files 中该用途的有效文件记录
+	MigrateFromFiles MigrateSource = "files"
+	// MigrateFromListing 列举源存储中的对象, 包括图片变体等没有文件记录的对象
+	MigrateFromListing MigrateSource = "listing"
+)
+
+// MigrationCheckpoint 迁移进度, 按 Name 保存, 中断后再次运行时从 Cursor 之后继续, 并重试 MigrationFailure 中的路径
+type MigrationCheckpoint struct {
+	gorm.Model
+
+	Name    string        `gorm:"column:name;type:varchar(128);uniqueIndex" json:"name"`
+	Purpose string        `gorm:"column:purpose;type:varchar(32)" json:"purpose"`
+	Source  MigrateSource `gorm:"column:source;type:varchar(16)" json:"source"`
+	// Cursor 已完成的位置, 遍历文件记录时为 ID, 列举对象时为路径
+	Cursor  string `gorm:"column:cursor;type:varchar(256)" json:"cursor"`
+	Copied  int64  `gorm:"column:copied" json:"copied"`
+	Skipped int64  `gorm:"column:skipped" json:"skipped"`
+	// FinishedAt 遍历完成的时间, 仍有失败路径时迁移没有完成
+	FinishedAt *time.Time `gorm:"column:finished_at" json:"finished_at"`
+}
+
+func (*MigrationCheckpoint) TableName() string { return TableNameMigration }
+
+// MigrationFailure 复制失败的路径, 下次运行时重试, 成功后删除; 存在失败记录时不会切换配置
+type MigrationFailure struct {
+	ID          uint      `gorm:"primarykey" json:"-"`
+	Name        string    `gorm:"column:name;type:varchar(128);uniqueIndex:idx_migration_failure" json:"name"`
+	StoragePath string    `gorm:"column:path;type:varchar(256);uniqueIndex:idx_migration_failure" json:"path"`
+	ErrMsg      string    `gorm:"column:err_msg;type:varchar(256)" json:"err_msg"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (*MigrationFailure) TableName() string { return TableNameMigrationFailure }
+
+// MigrateOptions 迁移参数
+type MigrateOptions struct {
+	// Name 检查点名称, 默认 migrate-{purpose}, 重新开始一次迁移时需要换一个名称
+	Name string
+	// Purpose 迁移的文件用途
+	Purpose string
+	// Source 遍历对象的来源, 默认 MigrateFromFiles
+	Source MigrateSource
+	// Prefix 列举对象时的路径前缀
+	Prefix string
+	// Concurrency 同时复制的对象数, 默认 8
+	Concurrency int
+	// BatchSize 每批处理的对象数, 每批完成后保存一次检查点, 默认 100
+	BatchSize int
+	// SwitchSetting 全部复制成功后把 cos-{purpose} 配置改为目标存储, 只有通过 NewMigrator 创建时有效
+	SwitchSetting bool
+}
+
+// MigrateReport 迁移结果, 累计包含之前中断的运行
+type MigrateReport struct {
+	Copied  int64 `json:"copied"`
+	Skipped int64 `json:"skipped"`
+	// FailedCount 仍未复制成功的路径数
+	FailedCount int64 `json:"failed_count"`
+	// Failed 部分失败路径, 最多 100 条
+	Failed []string `json:"failed"`
+	// URLsUpdated 本次运行改写 public_url 或变体地址的文件记录数
+	URLsUpdated int64 `json:"urls_updated"`
+	Switched    bool  `json:"switched"`
+}
+
+// Migrator 把一个用途的对象及其图片变体从源存储复制到目标存储, 校验内容的 SHA-256,
+// 并把文件记录的 public_url 和变体地址改写为目标存储的地址
+type Migrator struct {
+	db     *gorm.DB
+	src    Storager
+	dst    Storager
+	dstCfg *config.StorageConfig
+	opts   MigrateOptions
+}
+
+// NewMigrator 从 purpose 当前配置的存储迁移到 target
+func NewMigrator(db *gorm.DB, target config.StorageConfig, opts MigrateOptions) (*Migrator, error) {
+	src, err := LoadStorager(opts.Purpose)
+	if err != nil {
+		return nil, fmt.Errorf("load source storager: %w", err)
+	}
+	if target.Purpose == "" {
+		target.Purpose = opts.Purpose
+	}
+	dst, err := NewStorageWithCfg(target)
+	if err != nil {
+		return nil, fmt.Errorf("create target storager: %w", err)
+	}
+	m := NewMigratorWithStorager(db, src, dst, opts)
+	m.dstCfg = &target
+	return m, nil
+}
+
+// NewMigratorWithStorager 在两个已创建的存储之间迁移, 不支持 SwitchSetting
+func NewMigratorWithStorager(db *gorm.DB, src, dst Storager, opts MigrateOptions) *Migrator {
+	if opts.Name == "" {
+		opts.Name = "migrate-" + opts.Purpose
+	}
+	if opts.Source == "" {
+		opts.Source = MigrateFromFiles
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultMigrateConcurrency
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultMigrateBatchSize
+	}
+	return &Migrator{db: db, src: src, dst: dst, opts: opts}
+}
+
+// InitMigrationDB 创建迁移检查点表和失败记录表
+func InitMigrationDB(db *gorm.DB) error {
+	return db.AutoMigrate(&MigrationCheckpoint{}, &MigrationFailure{})
+}
+
+// Run 执行迁移, 可重复调用, 已完成的部分不会重复复制
+func (m *Migrator) Run(ctx context.Context) (*MigrateReport, error) {
+	cp, err := m.loadCheckpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &MigrateReport{}
+
+	// 先重试上次失败的路径, 成功的记录在 migrateBatch 中删除
+	var lastID uint
+	for {
+		var failures []*MigrationFailure
+		err := m.db.WithContext(ctx).Where("name = ? AND id > ?", m.opts.Name, lastID).
+			Order("id").Limit(m.opts.BatchSize).Find(&failures).Error
+		if err != nil {
+			return m.finish(ctx, cp, report, err)
+		}
+		if len(failures) == 0 {
+			break
+		}
+		paths := make([]string, len(failures))
+		for i, f := range failures {
+			paths[i] = f.StoragePath
+		}
+		lastID = failures[len(failures)-1].ID
+		logs.Infof("[storage_migrate] %s retry %d failed paths", m.opts.Name, len(paths))
+		if err := m.migrateBatch(ctx, cp, report, paths); err != nil {
+			return m.finish(ctx, cp, report, err)
+		}
+		if err := m.saveCheckpoint(cp); err != nil {
+			return m.finish(ctx, cp, report, err)
+		}
+	}
+
+	next := m.nextFileBatch
+	if m.opts.Source == MigrateFromListing {
+		next = m.nextListingBatch
+	}
+	for cp.FinishedAt == nil {
+		paths, cursor, done, err := next(ctx, cp.Cursor)
+		if err != nil {
+			return m.finish(ctx, cp, report, err)
+		}
+		if err := m.migrateBatch(ctx, cp, report, paths); err != nil {
+			return m.finish(ctx, cp, report, err)
+		}
+		if done {
+			now := time.Now()
+			cp.FinishedAt = &now
+		}
+		if cursor != "" {
+			cp.Cursor = cursor
+		}
+		if err := m.saveCheckpoint(cp); err != nil {
+			return m.finish(ctx, cp, report, err)
+		}
+		logs.Infof("[storage_migrate] %s cursor: %s, copied: %d, skipped: %d",
+			m.opts.Name, cp.Cursor, cp.Copied, cp.Skipped)
+	}
+
+	if m.opts.SwitchSetting {
+		failed, err := m.failedCount(ctx)
+		if err != nil {
+			return m.finish(ctx, cp, report, err)
+		}
+		if failed == 0 {
+			if err := m.switchSetting(); err != nil {
+				return m.finish(ctx, cp, report, err)
+			}
+			report.Switched = true
+		}
+	}
+	return m.finish(ctx, cp, report, nil)
+}
+
+func (m *Migrator) finish(ctx context.Context, cp *MigrationCheckpoint, report *MigrateReport, err error) (*MigrateReport, error) {
+	report.Copied, report.Skipped = cp.Copied, cp.Skipped
+	ctx = context.WithoutCancel(ctx)
+	if n, ferr := m.failedCount(ctx); ferr == nil {
+		report.FailedCount = n
+	}
+	m.db.WithContext(ctx).Model(&MigrationFailure{}).Where("name = ?", m.opts.Name).
+		Order("id").Limit(maxReportFailures).Pluck("path", &report.Failed)
+	if err != nil {
+		logs.Errorf("[storage_migrate] %s stopped: %s", m.opts.Name, err)
+		if serr := m.saveCheckpoint(cp); serr != nil {
+			logs.Errorf("[storage_migrate] %s save checkpoint failed: %s", m.opts.Name, serr)
+		}
+		return report, err
+	}
+	logs.Infof("[storage_migrate] %s done, copied: %d, skipped: %d, failed: %d, urls updated: %d, switched: %v",
+		m.opts.Name, report.Copied, report.Skipped, report.FailedCount, report.URLsUpdated, report.Switched)
+	return report, nil
+}
+
+func (m *Migrator) failedCount(ctx context.Context) (int64, error) {
+	var n int64
+	err := m.db.WithContext(ctx).Model(&MigrationFailure{}).Where("name = ?", m.opts.Name).Count(&n).Error
+	return n, err
+}
+
+// nextFileBatch 按 ID 顺序读取下一批有效文件记录的路径, 上传中和已删除的文件不迁移
+func (m *Migrator) nextFileBatch(ctx context.Context, cursor string) ([]string, string, bool, error) {
+	lastID, _ := strconv.ParseUint(cursor, 10, 64)
+	var files []*FileInfo
+	err := m.db.WithContext(ctx).Select("id", "path").
+		Where("purpose = ? AND id > ? AND path <> '' AND status NOT IN ?", m.opts.Purpose, lastID,
+			append(append([]FileStatus{}, gcUploadingStatus...), gcDeletedStatus...)).
+		Order("id").Limit(m.opts.BatchSize).Find(&files).Error
+	if err != nil {
+		return nil, "", false, err
+	}
+	if len(files) == 0 {
+		return nil, "", true, nil
+	}
+	paths := make([]string, len(files))
+	for i, fi := range files {
+		paths[i] = fi.StoragePath
+	}
+	return paths, strconv.FormatUint(uint64(files[len(files)-1].ID), 10), len(files) < m.opts.BatchSize, nil
+}
+
+// nextListingBatch 列举源存储中 cursor 之后的一页对象
+func (m *Migrator) nextListingBatch(ctx context.Context, cursor string) ([]string, string, bool, error) {
+	res, err := m.src.List(ctx, m.opts.Prefix, &ListOptions{Marker: cursor, MaxKeys: m.opts.BatchSize})
+	if err != nil {
+		return nil, "", false, err
+	}
+	if len(res.Objects) == 0 {
+		return nil, "", true, nil
+	}
+	paths := make([]string, len(res.Objects))
+	for i, obj := range res.Objects {
+		paths[i] = obj.StoragePath
+	}
+	return paths, paths[len(paths)-1], !res.IsTruncated || res.NextMarker == "", nil
+}
+
+// migrateBatch 并发复制一批对象, 单个对象失败写入 MigrationFailure, 成功时删除之前的失败记录,
+// 只有 ctx 取消或数据库错误时返回错误
+func (m *Migrator) migrateBatch(ctx context.Context, cp *MigrationCheckpoint, report *MigrateReport, paths []string) error {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, m.opts.Concurrency)
+		dbErr error
+	)
+	for _, p := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(p string) {
+			defer func() { <-sem; wg.Done() }()
+			var copied, skipped, updated int64
+			files, err := m.loadFiles(ctx, p)
+			if err == nil {
+				copied, skipped, err = m.migrateFile(ctx, p, files)
+			}
+			if err == nil {
+				updated, err = m.rewriteURL(ctx, files)
+			}
+			var recErr error
+			if err != nil {
+				logs.Warnf("[storage_migrate] %s migrate %s failed: %s", m.opts.Name, p, err)
+				recErr = m.recordFailure(ctx, p, err)
+			} else {
+				recErr = m.db.WithContext(ctx).Where("name = ? AND path = ?", m.opts.Name, p).
+					Delete(&MigrationFailure{}).Error
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if recErr != nil {
+				dbErr = recErr
+			}
+			cp.Copied += copied
+			cp.Skipped += skipped
+			report.URLsUpdated += updated
+		}(p)
+	}
+	wg.Wait()
+	if dbErr != nil {
+		return dbErr
+	}
+	return ctx.Err()
+}
+
+// recordFailure 记录失败路径, 同一路径重复失败时只更新原因
+func (m *Migrator) recordFailure(ctx context.Context, p string, cause error) error {
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "path"}},
+		DoUpdates: clause.AssignmentColumns([]string{"err_msg", "updated_at"}),
+	}).Create(&MigrationFailure{Name: m.opts.Name, StoragePath: p, ErrMsg: truncate(cause.Error(), 256)}).Error
+}
+
+// loadFiles 读取引用该路径的文件记录, 用于迁移图片变体和改写地址
+func (m *Migrator) loadFiles(ctx context.Context, p string) ([]*FileInfo, error) {
+	var files []*FileInfo
+	err := m.db.WithContext(ctx).Unscoped().Select("id", "path", "public_url", "extra").
+		Where("purpose = ? AND path = ?", m.opts.Purpose, p).Find(&files).Error
+	return files, err
+}
+
+// migrateFile 复制路径本身和文件记录中的图片变体, 任一对象失败时整体记为失败, 下次重试全部对象
+func (m *Migrator) migrateFile(ctx context.Context, p string, files []*FileInfo) (copied, skipped int64, err error) {
+	paths := []string{p}
+	seen := map[string]bool{p: true}
+	for _, fi := range files {
+		for _, op := range objectPaths(fi) {
+			if !seen[op] {
+				seen[op] = true
+				paths = append(paths, op)
+			}
+		}
+	}
+	for _, op := range paths {
+		skip, err := m.migrateObject(ctx, op)
+		if err != nil {
+			if op != p {
+				err = fmt.Errorf("%s: %w", op, err)
+			}
+			return copied, skipped, err
+		}
+		if skip {
+			skipped++
+		} else {
+			copied++
+		}
+	}
+	return copied, skipped, nil
+}
+
+// migrateObject 复制单个对象并校验, 目标中已有内容相同的对象时跳过
+func (m *Migrator) migrateObject(ctx context.Context, p string) (bool, error) {
+	info, err := m.src.Stat(ctx, p)
+	if err != nil {
+		return false, fmt.Errorf("stat source: %w", err)
+	}
+	if dstInfo, err := m.dst.Stat(ctx, p); err == nil && dstInfo.Size == info.Size {
+		srcSum, err := checksum(ctx, m.src, p)
+		if err != nil {
+			return false, fmt.Errorf("checksum source: %w", err)
+		}
+		if dstSum, err := checksum(ctx, m.dst, p); err == nil && bytes.Equal(srcSum, dstSum) {
+			return true, nil
+		}
+	} else if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return false, fmt.Errorf("stat target: %w", err)
+	}
+
+	rc, err := m.src.ReadRange(ctx, p, 0, 0)
+	if err != nil {
+		return false, fmt.Errorf("read source: %w", err)
+	}
+	defer rc.Close()
+	h := sha256.New()
+	fi := &FileInfo{
+		StoragePath: p,
+		FileExt:     path.Ext(p),
+		MIMEType:    info.ContentType,
+		Size:        info.Size,
+		Metadata:    info.Metadata,
+	}
+	if err := m.dst.Save(ctx, fi, io.TeeReader(rc, h)); err != nil {
+		return false, fmt.Errorf("save target: %w", err)
+	}
+	dstSum, err := checksum(ctx, m.dst, p)
+	if err != nil {
+		return false, fmt.Errorf("checksum target: %w", err)
+	}
+	if !bytes.Equal(h.Sum(nil), dstSum) {
+		return false, errors.New("checksum mismatch")
+	}
+	return false, nil
+}
+
+// rewriteURL 把文件记录的 public_url 和图片变体的地址改为目标存储的地址,
+// 没有公开地址的记录和变体(如等待扫描)不修改
+func (m *Migrator) rewriteURL(ctx context.Context, files []*FileInfo) (int64, error) {
+	var n int64
+	for _, fi := range files {
+		updates := map[string]interface{}{}
+		if fi.PublicURL != "" {
+			updates["public_url"] = m.dst.GetPublicURL(fi.StoragePath, false)
+		}
+		if img := imaging.GetImageExtra(fi.Extra); img != nil && len(img.Variants) > 0 {
+			changed := false
+			for _, v := range img.Variants {
+				if v.URL != "" && v.Path != "" {
+					v.URL = m.dst.GetPublicURL(v.Path, false)
+					changed = true
+				}
+			}
+			if changed {
+				extra, err := imaging.SetImageExtra(fi.Extra, img)
+				if err != nil {
+					return n, err
+				}
+				updates["extra"] = extra
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		res := m.db.WithContext(ctx).Unscoped().Model(&FileInfo{}).Where("id = ?", fi.ID).Updates(updates)
+		if res.Error != nil {
+			return n, res.Error
+		}
+		n += res.RowsAffected
+	}
+	return n, nil
+}
+
+// switchSetting 把 cos-{purpose} 配置改为目标存储, 并清除已加载的实例
+func (m *Migrator) switchSetting() error {
+	if m.dstCfg == nil {
+		return errors.New("switch setting requires a migrator created by NewMigrator")
+	}
+	if err := settings.SetYaml(settings.SettingGroupCore, SettingPrefix+m.opts.Purpose, m.dstCfg); err != nil {
+		return fmt.Errorf("switch setting: %w", err)
+	}
+	RemoveStorager(m.opts.Purpose)
+	logs.Infof("[storage_migrate] %s switched %s%s to target storage", m.opts.Name, SettingPrefix, m.opts.Purpose)
+	return nil
+}
+
+func (m *Migrator) loadCheckpoint(ctx context.Context) (*MigrationCheckpoint, error) {
+	cp := &MigrationCheckpoint{}
+	err := m.db.WithContext(ctx).Where("name = ?", m.opts.Name).First(cp).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		cp = &MigrationCheckpoint{Name: m.opts.Name, Purpose: m.opts.Purpose, Source: m.opts.Source}
+		return cp, m.db.WithContext(ctx).Create(cp).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cp.Purpose != m.opts.Purpose || cp.Source != m.opts.Source {
+		return nil, fmt.Errorf("checkpoint %s belongs to %s/%s", cp.Name, cp.Purpose, cp.Source)
+	}
+	return cp, nil
+}
+
+func (m *Migrator) saveCheckpoint(cp *MigrationCheckpoint) error {
+	return m.db.Save(cp).Error
+}
+
+func checksum(ctx context.Context, s Storager, p string) ([]byte, error) {
+	rc, err := s.ReadRange(ctx, p, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
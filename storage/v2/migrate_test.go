@@ -0,0 +1,155 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/storage/imaging"
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "migrate.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&storage.FileInfo{}))
+	assert.NoError(t, storage.InitMigrationDB(db))
+
+	src, _ := memory.NewMemoryStorage(config.MemoryStorageConfig{PublicPrefix: "http://old"}, config.StorageOption{})
+	dst, _ := memory.NewMemoryStorage(config.MemoryStorageConfig{PublicPrefix: "http://new"}, config.StorageOption{})
+	create := func(p string, status storage.FileStatus) *storage.FileInfo {
+		fi := &storage.FileInfo{Purpose: "mig", StoragePath: p, PublicURL: src.GetPublicURL(p, false), Status: status}
+		assert.NoError(t, db.Create(fi).Error)
+		return fi
+	}
+	for _, p := range []string{"a/1.txt", "a/2.txt", "a/3.txt", "a/4.txt"} {
+		saveString(t, src, p, "content of "+p)
+		create(p, storage.FileStatusNormal)
+	}
+	create("a/uploading.txt", storage.FileStatusUploading)
+	missing := create("a/missing.txt", storage.FileStatusNormal)
+	saveString(t, src, "a/1_thumb.jpg", "thumb")
+	// 目标中已有相同内容的对象跳过, 内容不同的重新复制
+	saveString(t, dst, "a/2.txt", "content of a/2.txt")
+	saveString(t, dst, "a/3.txt", "content of a/x.txt")
+
+	m := storage.NewMigratorWithStorager(db, src, dst, storage.MigrateOptions{Purpose: "mig", BatchSize: 2, Concurrency: 2})
+	report, err := m.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), report.Copied)
+	assert.Equal(t, int64(1), report.Skipped)
+	assert.Equal(t, int64(1), report.FailedCount)
+	assert.Equal(t, []string{"a/missing.txt"}, report.Failed)
+	assert.False(t, report.Switched)
+	assert.Equal(t, int64(4), report.URLsUpdated)
+
+	data, ok := dst.Bytes("a/3.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "content of a/3.txt", string(data))
+	_, ok = dst.Bytes("a/uploading.txt")
+	assert.False(t, ok)
+	_, ok = dst.Bytes("a/1_thumb.jpg")
+	assert.False(t, ok)
+
+	var fi storage.FileInfo
+	assert.NoError(t, db.Where("path = ?", "a/1.txt").First(&fi).Error)
+	assert.Equal(t, dst.GetPublicURL("a/1.txt", false), fi.PublicURL)
+	fi = storage.FileInfo{}
+	assert.NoError(t, db.First(&fi, missing.ID).Error)
+	assert.Equal(t, src.GetPublicURL("a/missing.txt", false), fi.PublicURL)
+
+	var cp storage.MigrationCheckpoint
+	assert.NoError(t, db.Where("name = ?", "migrate-mig").First(&cp).Error)
+	assert.NotNil(t, cp.FinishedAt)
+	var failures []storage.MigrationFailure
+	assert.NoError(t, db.Where("name = ?", "migrate-mig").Find(&failures).Error)
+	assert.Len(t, failures, 1)
+	assert.Equal(t, "a/missing.txt", failures[0].StoragePath)
+	assert.NotEmpty(t, failures[0].ErrMsg)
+
+	// 仍然失败时保留记录, 不会重复插入
+	report, err = m.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), report.FailedCount)
+
+	// 再次运行只重试失败的路径
+	saveString(t, src, "a/missing.txt", "found")
+	report, err = m.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), report.Copied)
+	assert.Zero(t, report.FailedCount)
+	assert.Empty(t, report.Failed)
+	assert.Equal(t, int64(1), report.URLsUpdated)
+	_, ok = dst.Bytes("a/missing.txt")
+	assert.True(t, ok)
+
+	// 列举模式包括没有文件记录的对象
+	m = storage.NewMigratorWithStorager(db, src, dst, storage.MigrateOptions{
+		Name: "listing", Purpose: "mig", Source: storage.MigrateFromListing, Prefix: "a/", BatchSize: 2,
+	})
+	report, err = m.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), report.Copied)
+	assert.Equal(t, int64(5), report.Skipped)
+	_, ok = dst.Bytes("a/1_thumb.jpg")
+	assert.True(t, ok)
+
+	// 检查点属于其他用途时拒绝运行
+	_, err = storage.NewMigratorWithStorager(db, src, dst, storage.MigrateOptions{Name: "listing", Purpose: "other"}).Run(ctx)
+	assert.Error(t, err)
+}
+
+func TestMigratorImageVariants(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "migrate.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&storage.FileInfo{}))
+	assert.NoError(t, storage.InitMigrationDB(db))
+
+	src, _ := memory.NewMemoryStorage(config.MemoryStorageConfig{PublicPrefix: "http://old"}, config.StorageOption{})
+	dst, _ := memory.NewMemoryStorage(config.MemoryStorageConfig{PublicPrefix: "http://new"}, config.StorageOption{})
+	saveString(t, src, "img/p.jpg", "original")
+	saveString(t, src, "img/p_thumb.jpg", "thumb")
+	extra, err := imaging.SetImageExtra(nil, &imaging.ImageExtra{Width: 100, Height: 100, Format: "jpeg",
+		Variants: map[string]*imaging.VariantExtra{
+			"thumb": {URL: src.GetPublicURL("img/p_thumb.jpg", false), Path: "img/p_thumb.jpg", Width: 10, Height: 10},
+			"small": {URL: src.GetPublicURL("img/p_small.jpg", false), Path: "img/p_small.jpg", Width: 50, Height: 50},
+		}})
+	assert.NoError(t, err)
+	fi := &storage.FileInfo{Purpose: "img", StoragePath: "img/p.jpg", PublicURL: src.GetPublicURL("img/p.jpg", false),
+		Status: storage.FileStatusNormal, Extra: extra}
+	assert.NoError(t, db.Create(fi).Error)
+
+	// 变体缺失时整体失败, 不改写地址
+	m := storage.NewMigratorWithStorager(db, src, dst, storage.MigrateOptions{Purpose: "img"})
+	report, err := m.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"img/p.jpg"}, report.Failed)
+	assert.Zero(t, report.URLsUpdated)
+
+	saveString(t, src, "img/p_small.jpg", "small")
+	report, err = m.Run(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, report.FailedCount)
+	assert.Equal(t, int64(1), report.URLsUpdated)
+	for _, p := range []string{"img/p.jpg", "img/p_thumb.jpg", "img/p_small.jpg"} {
+		_, ok := dst.Bytes(p)
+		assert.True(t, ok, p)
+	}
+
+	var got storage.FileInfo
+	assert.NoError(t, db.First(&got, fi.ID).Error)
+	assert.Equal(t, dst.GetPublicURL("img/p.jpg", false), got.PublicURL)
+	img := imaging.GetImageExtra(got.Extra)
+	assert.NotNil(t, img)
+	assert.Equal(t, 100, img.Width)
+	assert.Equal(t, dst.GetPublicURL("img/p_thumb.jpg", false), img.Variants["thumb"].URL)
+	assert.Equal(t, dst.GetPublicURL("img/p_small.jpg", false), img.Variants["small"].URL)
+}
@@ -72,7 +72,8 @@ func (mfs *MinFs) Save(ctx context.Context, fi *storage.FileInfo, r io.Reader) e
 		return fmt.Errorf("reader is empty")
 	}
 	_, err := mfs.client.PutObject(mfs.ctx, mfs.mfsCfg.Bucket, fi.StoragePath, r, fi.Size, minioClient.PutObjectOptions{
-		ContentType: mime.TypeByExtension(fi.FileExt),
+		ContentType:  mime.TypeByExtension(fi.FileExt),
+		UserMetadata: fi.Metadata,
 	})
 	return err
 }
@@ -102,6 +103,84 @@ func (mfs *MinFs) ReadFile(storagePath string) (io.ReadCloser, error) {
 	return obj, nil
 }
 
+// ReadRange minio 的 GetObject 延迟发起请求, 对象不存在的错误在首次读取时返回
+func (mfs *MinFs) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	opts := minioClient.GetObjectOptions{}
+	if rng := storage.RangeHeader(offset, length); rng != "" {
+		opts.Set("Range", rng)
+	}
+	obj, err := mfs.client.GetObject(ctx, mfs.mfsCfg.Bucket, storagePath, opts)
+	if err != nil {
+		logs.Errorf("minoss get object range error: %v", err)
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (mfs *MinFs) Stat(ctx context.Context, storagePath string) (*storage.ObjectInfo, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	info, err := mfs.client.StatObject(ctx, mfs.mfsCfg.Bucket, storagePath, minioClient.StatObjectOptions{})
+	if err != nil {
+		if minioClient.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("minio object %s: %w", storagePath, storage.ErrObjectNotFound)
+		}
+		logs.Errorf("minoss stat object error: %v", err)
+		return nil, err
+	}
+	return &storage.ObjectInfo{
+		StoragePath:  storagePath,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+		Metadata:     info.UserMetadata,
+	}, nil
+}
+
+func (mfs *MinFs) Exists(ctx context.Context, storagePath string) (bool, error) {
+	return storage.ExistsByStat(mfs.Stat(ctx, storagePath))
+}
+
+// List NextMarker 为本页最后一个对象的路径
+func (mfs *MinFs) List(ctx context.Context, prefix string, opts *storage.ListOptions) (*storage.ListResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	maxKeys := opts.GetMaxKeys()
+	objectsCh := mfs.client.ListObjects(ctx, mfs.mfsCfg.Bucket, minioClient.ListObjectsOptions{
+		Prefix:     prefix,
+		Recursive:  true,
+		StartAfter: opts.GetMarker(),
+		MaxKeys:    maxKeys,
+	})
+	res := &storage.ListResult{}
+	for obj := range objectsCh {
+		if obj.Err != nil {
+			logs.Errorf("minio list objects error: %v", obj.Err)
+			return nil, obj.Err
+		}
+		if len(res.Objects) == maxKeys {
+			res.IsTruncated = true
+			break
+		}
+		res.Objects = append(res.Objects, &storage.ObjectInfo{
+			StoragePath:  obj.Key,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			ContentType:  obj.ContentType,
+			LastModified: obj.LastModified,
+		})
+	}
+	if res.IsTruncated {
+		res.NextMarker = res.Objects[len(res.Objects)-1].StoragePath
+	}
+	return res, nil
+}
+
 func (mfs *MinFs) DeleteFile(storagePath string) error {
 	if storagePath == "" {
 		return fmt.Errorf("storage path is empty")
@@ -190,7 +269,11 @@ func (mfs *MinFs) CreateMultipartUpload(ctx context.Context, in *storage.CreateM
 		return nil, fmt.Errorf("storage path is empty")
 	}
 	core := minioClient.Core{Client: mfs.client}
-	uploadID, err := core.NewMultipartUpload(ctx, mfs.mfsCfg.Bucket, *in.StoragePath, minioClient.PutObjectOptions{})
+	opts := minioClient.PutObjectOptions{UserMetadata: in.Metadata}
+	if in.ContentType != nil {
+		opts.ContentType = *in.ContentType
+	}
+	uploadID, err := core.NewMultipartUpload(ctx, mfs.mfsCfg.Bucket, *in.StoragePath, opts)
 	if err != nil {
 		logs.Errorf("minoss initiate multipart upload error: %v", err)
 		return nil, err
@@ -212,8 +295,12 @@ func (mfs *MinFs) UploadPart(ctx context.Context, in *storage.UploadPartInput) (
 	if in.Data == nil {
 		return nil, fmt.Errorf("reader is empty")
 	}
+	size := int64(-1)
+	if in.ContentLength != nil {
+		size = *in.ContentLength
+	}
 	core := minioClient.Core{Client: mfs.client}
-	objPart, err := core.PutObjectPart(ctx, mfs.mfsCfg.Bucket, *in.StoragePath, *in.UploadID, *in.PartNumber, in.Data, -1, minioClient.PutObjectPartOptions{})
+	objPart, err := core.PutObjectPart(ctx, mfs.mfsCfg.Bucket, *in.StoragePath, *in.UploadID, *in.PartNumber, in.Data, size, minioClient.PutObjectPartOptions{})
 	if err != nil {
 		logs.Errorf("minoss upload part error: %v", err)
 		return nil, err
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"iter"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrObjectNotFound 对象不存在, 各驱动的 Stat/ReadFile 返回的错误可用 errors.Is 判断
+var ErrObjectNotFound = fs.ErrNotExist
+
+// DefaultListMaxKeys List 每页默认数量
+const DefaultListMaxKeys = 1000
+
+// ObjectInfo 存储对象的元信息
+type ObjectInfo struct {
+	StoragePath  string            `json:"storage_path"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag"`
+	ContentType  string            `json:"content_type"`
+	LastModified time.Time         `json:"last_modified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// ListOptions List 的分页参数
+type ListOptions struct {
+	// Marker 上一页返回的 NextMarker, 为空时从头开始
+	Marker string
+	// MaxKeys 每页最多返回的数量, <=0 时为 DefaultListMaxKeys
+	MaxKeys int
+}
+
+// GetMaxKeys 返回有效的每页数量
+func (o *ListOptions) GetMaxKeys() int {
+	if o == nil || o.MaxKeys <= 0 {
+		return DefaultListMaxKeys
+	}
+	return o.MaxKeys
+}
+
+// GetMarker 返回分页标记
+func (o *ListOptions) GetMarker() string {
+	if o == nil {
+		return ""
+	}
+	return o.Marker
+}
+
+// ListResult List 的一页结果, List 只返回对象不返回目录, Metadata 不一定填充
+type ListResult struct {
+	Objects     []*ObjectInfo
+	NextMarker  string
+	IsTruncated bool
+}
+
+// ExistsByStat 根据 Stat 的结果判断对象是否存在, 供驱动实现 Exists
+func ExistsByStat(_ *ObjectInfo, err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// ListAll 遍历 prefix 下的全部对象, 自动翻页, 出错时产出错误后结束
+func ListAll(ctx context.Context, s Storager, prefix string, opts *ListOptions) iter.Seq2[*ObjectInfo, error] {
+	return func(yield func(*ObjectInfo, error) bool) {
+		pageOpts := ListOptions{Marker: opts.GetMarker(), MaxKeys: opts.GetMaxKeys()}
+		for {
+			res, err := s.List(ctx, prefix, &pageOpts)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, obj := range res.Objects {
+				if !yield(obj, nil) {
+					return
+				}
+			}
+			if !res.IsTruncated || res.NextMarker == "" {
+				return
+			}
+			pageOpts.Marker = res.NextMarker
+		}
+	}
+}
+
+// DirEntry 目录型存储中的一个条目, 文件条目的 Object 由驱动填充, StoragePath 由 ListDirs 填写
+type DirEntry struct {
+	Name   string
+	IsDir  bool
+	Object *ObjectInfo
+}
+
+// errListPageFull 本页已取满, 用于提前结束遍历
+var errListPageFull = errors.New("list page full")
+
+// ListDirs 为目录型存储实现 List, 按 key 的字典序深度遍历 prefix 所在的目录,
+// 跳过 marker 之前的子目录, 取满 MaxKeys+1 个对象后即停止.
+// readDir 返回 dir (不带首尾的 "/", 根目录为 "") 下的条目, 目录不存在时返回空列表
+func ListDirs(ctx context.Context, prefix string, opts *ListOptions,
+	readDir func(ctx context.Context, dir string) ([]DirEntry, error)) (*ListResult, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	marker := opts.GetMarker()
+	maxKeys := opts.GetMaxKeys()
+	root := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = strings.Trim(path.Clean("/"+prefix[:i]), "/")
+	}
+
+	var objects []*ObjectInfo
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := readDir(ctx, dir)
+		if err != nil {
+			return err
+		}
+		// 目录按 name+"/" 排序, 保证遍历顺序与 key 的字典序一致
+		sortName := func(e DirEntry) string {
+			if e.IsDir {
+				return e.Name + "/"
+			}
+			return e.Name
+		}
+		sort.Slice(entries, func(i, j int) bool { return sortName(entries[i]) < sortName(entries[j]) })
+		for _, e := range entries {
+			key := e.Name
+			if dir != "" {
+				key = dir + "/" + e.Name
+			}
+			if e.IsDir {
+				sub := key + "/"
+				if !strings.HasPrefix(sub, prefix) && !strings.HasPrefix(prefix, sub) {
+					continue
+				}
+				if sub <= marker && !strings.HasPrefix(marker, sub) {
+					continue
+				}
+				if err := walk(key); err != nil {
+					return err
+				}
+				continue
+			}
+			if !strings.HasPrefix(key, prefix) || key <= marker {
+				continue
+			}
+			obj := e.Object
+			if obj == nil {
+				obj = &ObjectInfo{}
+			}
+			obj.StoragePath = key
+			objects = append(objects, obj)
+			if len(objects) > maxKeys {
+				return errListPageFull
+			}
+		}
+		return nil
+	}
+	if err := walk(root); err != nil && !errors.Is(err, errListPageFull) {
+		return nil, err
+	}
+
+	res := &ListResult{Objects: objects}
+	if len(objects) > maxKeys {
+		res.Objects = objects[:maxKeys]
+		res.IsTruncated = true
+		res.NextMarker = res.Objects[maxKeys-1].StoragePath
+	}
+	return res, nil
+}
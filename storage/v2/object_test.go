@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+func TestListDirs(t *testing.T) {
+	ctx := context.Background()
+	// 目录树, 以 "/" 结尾的为目录
+	tree := map[string][]string{
+		"":    {"b/", "a.txt", "a/", "c.txt"},
+		"a":   {"2.txt", "1.txt"},
+		"b":   {"x/", "1.txt"},
+		"b/x": {"1.txt"},
+	}
+	var visited []string
+	readDir := func(_ context.Context, dir string) ([]storage.DirEntry, error) {
+		visited = append(visited, dir)
+		var entries []storage.DirEntry
+		for _, name := range tree[dir] {
+			if strings.HasSuffix(name, "/") {
+				entries = append(entries, storage.DirEntry{Name: strings.TrimSuffix(name, "/"), IsDir: true})
+				continue
+			}
+			entries = append(entries, storage.DirEntry{Name: name, Object: &storage.ObjectInfo{Size: 1}})
+		}
+		return entries, nil
+	}
+	keys := func(res *storage.ListResult) []string {
+		var ret []string
+		for _, obj := range res.Objects {
+			ret = append(ret, obj.StoragePath)
+		}
+		return ret
+	}
+
+	res, err := storage.ListDirs(ctx, "", nil, readDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "a/1.txt", "a/2.txt", "b/1.txt", "b/x/1.txt", "c.txt"}, keys(res))
+	assert.False(t, res.IsTruncated)
+
+	// 取满一页即停止, 不再读取后面的目录
+	visited = nil
+	res, err = storage.ListDirs(ctx, "", &storage.ListOptions{MaxKeys: 2}, readDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "a/1.txt"}, keys(res))
+	assert.True(t, res.IsTruncated)
+	assert.Equal(t, "a/1.txt", res.NextMarker)
+	assert.Equal(t, []string{"", "a"}, visited)
+
+	// marker 之前的目录不再读取
+	visited = nil
+	res, err = storage.ListDirs(ctx, "", &storage.ListOptions{Marker: "b/1.txt"}, readDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b/x/1.txt", "c.txt"}, keys(res))
+	assert.False(t, res.IsTruncated)
+	assert.Equal(t, []string{"", "b", "b/x"}, visited)
+
+	// 只进入与 prefix 相关的目录
+	visited = nil
+	res, err = storage.ListDirs(ctx, "b/", nil, readDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b/1.txt", "b/x/1.txt"}, keys(res))
+	assert.Equal(t, []string{"b", "b/x"}, visited)
+}
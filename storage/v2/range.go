@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+)
+
+// RangeHeader 生成 ReadRange 对应的 HTTP Range 头, 读取整个文件时返回空
+func RangeHeader(offset, length int64) string {
+	if length <= 0 {
+		if offset <= 0 {
+			return ""
+		}
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// LimitRange 跳过 rc 的前 offset 字节并限制读取 length 字节,
+// 用于不支持 Range 的后端, 或服务端忽略 Range 头返回了完整内容的情况
+func LimitRange(rc io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+			rc.Close()
+			if err == io.EOF {
+				return nil, fmt.Errorf("offset %d out of range", offset)
+			}
+			return nil, err
+		}
+	}
+	if length <= 0 {
+		return rc, nil
+	}
+	return &readCloser{Reader: io.LimitReader(rc, length), Closer: rc}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,492 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/logs"
+)
+
+const (
+	defaultReplicaMaxRetries    = 5
+	defaultReplicaRetryInterval = 10 * time.Second
+	defaultReplicaQueueSize     = 1024
+)
+
+type replicaOp int
+
+const (
+	replicaPut replicaOp = iota
+	replicaDelete
+	replicaCopyDir
+)
+
+func (op replicaOp) String() string {
+	switch op {
+	case replicaPut:
+		return "put"
+	case replicaDelete:
+		return "delete"
+	case replicaCopyDir:
+		return "copy"
+	}
+	return "unknown"
+}
+
+// replicaTask 待复制到副本的操作
+type replicaTask struct {
+	op       replicaOp
+	path     string
+	dest     string
+	attempts int
+}
+
+// ReplicatedStorager 主备复制存储, 写入主存储后复制到副本, 读取主存储失败时读取副本.
+// 复制时从主存储读回对象再写入副本, 分片上传在 CompleteMultipartUpload 后整体复制;
+// 客户端通过预签名链接直接上传的单个文件不会触发复制, 需要由对账任务补齐.
+// 异步复制的重试队列只保存在内存中, 进程退出或重试耗尽时丢失的操作同样由对账任务修复.
+type ReplicatedStorager struct {
+	primary   Storager
+	secondary Storager
+	cfg       config.StorageReplicationConfig
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	tasks   chan *replicaTask
+	pending sync.WaitGroup
+	// mu 保证 Close 之后不再有任务进入队列
+	mu     sync.Mutex
+	closed bool
+}
+
+var _ Storager = (*ReplicatedStorager)(nil)
+
+// NewReplicatedStorager 创建主备复制存储, 异步模式下启动后台复制协程, 使用完毕后调用 Close
+func NewReplicatedStorager(primary, secondary Storager, cfg config.StorageReplicationConfig) *ReplicatedStorager {
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultReplicaMaxRetries
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaultReplicaRetryInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultReplicaQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rs := &ReplicatedStorager{
+		primary:   primary,
+		secondary: secondary,
+		cfg:       cfg,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if cfg.Async {
+		rs.tasks = make(chan *replicaTask, cfg.QueueSize)
+		go rs.worker()
+	}
+	return rs
+}
+
+func newReplicatedStoragerWithCfg(cfg config.StorageReplicationConfig) (*ReplicatedStorager, error) {
+	if cfg.Primary == nil || cfg.Secondary == nil {
+		return nil, fmt.Errorf("replication primary and secondary must be configured")
+	}
+	primary, err := NewStorageWithCfg(*cfg.Primary)
+	if err != nil {
+		return nil, fmt.Errorf("new primary storage: %w", err)
+	}
+	secondary, err := NewStorageWithCfg(*cfg.Secondary)
+	if err != nil {
+		return nil, fmt.Errorf("new secondary storage: %w", err)
+	}
+	return NewReplicatedStorager(primary, secondary, cfg), nil
+}
+
+// Primary 主存储
+func (rs *ReplicatedStorager) Primary() Storager { return rs.primary }
+
+// Secondary 副本存储
+func (rs *ReplicatedStorager) Secondary() Storager { return rs.secondary }
+
+// Wait 等待异步复制队列中的操作(包括等待重试的)全部结束
+func (rs *ReplicatedStorager) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		rs.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close 停止后台复制, 未完成的操作被丢弃, 之后 Wait 不再阻塞
+func (rs *ReplicatedStorager) Close() error {
+	rs.mu.Lock()
+	rs.closed = true
+	rs.cancel()
+	rs.mu.Unlock()
+	return nil
+}
+
+func (rs *ReplicatedStorager) Save(ctx context.Context, fi *FileInfo, r io.Reader) error {
+	if err := rs.primary.Save(ctx, fi, r); err != nil {
+		return err
+	}
+	return rs.replicate(ctx, &replicaTask{op: replicaPut, path: fi.StoragePath})
+}
+
+func (rs *ReplicatedStorager) GetPublicURL(storagePath string, temp bool) string {
+	return rs.primary.GetPublicURL(storagePath, temp)
+}
+
+func (rs *ReplicatedStorager) GetPresignedURL(method, storagePath string) (string, error) {
+	return rs.primary.GetPresignedURL(method, storagePath)
+}
+
+func (rs *ReplicatedStorager) ReadFile(storagePath string) (io.ReadCloser, error) {
+	rc, err := rs.primary.ReadFile(storagePath)
+	if err == nil {
+		return rc, nil
+	}
+	rc, serr := rs.secondary.ReadFile(storagePath)
+	if serr != nil {
+		return nil, err
+	}
+	logs.Warnf("[replicated_storage] read %s from primary failed, fallback to secondary: %s", storagePath, err)
+	return rc, nil
+}
+
+func (rs *ReplicatedStorager) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := rs.primary.ReadRange(ctx, storagePath, offset, length)
+	if err == nil {
+		return rc, nil
+	}
+	rc, serr := rs.secondary.ReadRange(ctx, storagePath, offset, length)
+	if serr != nil {
+		return nil, err
+	}
+	logs.Warnf("[replicated_storage] read %s from primary failed, fallback to secondary: %s", storagePath, err)
+	return rc, nil
+}
+
+func (rs *ReplicatedStorager) Stat(ctx context.Context, storagePath string) (*ObjectInfo, error) {
+	info, err := rs.primary.Stat(ctx, storagePath)
+	if err == nil {
+		return info, nil
+	}
+	info, serr := rs.secondary.Stat(ctx, storagePath)
+	if serr != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (rs *ReplicatedStorager) Exists(ctx context.Context, storagePath string) (bool, error) {
+	return ExistsByStat(rs.Stat(ctx, storagePath))
+}
+
+// List 只在主存储出错时列举副本, 不合并两边的结果
+func (rs *ReplicatedStorager) List(ctx context.Context, prefix string, opts *ListOptions) (*ListResult, error) {
+	res, err := rs.primary.List(ctx, prefix, opts)
+	if err == nil {
+		return res, nil
+	}
+	res, serr := rs.secondary.List(ctx, prefix, opts)
+	if serr != nil {
+		return nil, err
+	}
+	logs.Warnf("[replicated_storage] list %s from primary failed, fallback to secondary: %s", prefix, err)
+	return res, nil
+}
+
+func (rs *ReplicatedStorager) DeleteFile(storagePath string) error {
+	if err := rs.primary.DeleteFile(storagePath); err != nil {
+		return err
+	}
+	return rs.replicate(context.Background(), &replicaTask{op: replicaDelete, path: storagePath})
+}
+
+func (rs *ReplicatedStorager) CopyDir(storagePath, dest string) error {
+	if err := rs.primary.CopyDir(storagePath, dest); err != nil {
+		return err
+	}
+	return rs.replicate(context.Background(), &replicaTask{op: replicaCopyDir, path: storagePath, dest: dest})
+}
+
+func (rs *ReplicatedStorager) UploadDirectory(localDirPath, destDir string) ([]string, error) {
+	paths, err := rs.primary.UploadDirectory(localDirPath, destDir)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, p := range paths {
+		if err := rs.replicate(context.Background(), &replicaTask{op: replicaPut, path: p}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return paths, errors.Join(errs...)
+}
+
+func (rs *ReplicatedStorager) CreateMultipartUpload(ctx context.Context, in *CreateMultipartUploadInput) (*string, error) {
+	return rs.primary.CreateMultipartUpload(ctx, in)
+}
+
+func (rs *ReplicatedStorager) GeneratePresignedURL(ctx context.Context, in *GeneratePresignedURLInput) (*string, error) {
+	return rs.primary.GeneratePresignedURL(ctx, in)
+}
+
+func (rs *ReplicatedStorager) UploadPart(ctx context.Context, in *UploadPartInput) (*string, error) {
+	return rs.primary.UploadPart(ctx, in)
+}
+
+func (rs *ReplicatedStorager) CompleteMultipartUpload(ctx context.Context, in *CompleteMultipartUploadInput) error {
+	if err := rs.primary.CompleteMultipartUpload(ctx, in); err != nil {
+		return err
+	}
+	if in == nil || in.StoragePath == nil {
+		return nil
+	}
+	return rs.replicate(ctx, &replicaTask{op: replicaPut, path: *in.StoragePath})
+}
+
+func (rs *ReplicatedStorager) AbortMultipartUpload(ctx context.Context, in *AbortMultipartUploadInput) error {
+	return rs.primary.AbortMultipartUpload(ctx, in)
+}
+
+// replicate 同步模式下立即复制, 异步模式下放入队列
+func (rs *ReplicatedStorager) replicate(ctx context.Context, t *replicaTask) error {
+	if !rs.cfg.Async {
+		if err := rs.apply(ctx, t); err != nil {
+			logs.Errorf("[replicated_storage] %s %s to secondary failed: %s", t.op, t.path, err)
+			return fmt.Errorf("replicate %s %s to secondary: %w", t.op, t.path, err)
+		}
+		return nil
+	}
+	rs.pending.Add(1)
+	rs.enqueue(t)
+	return nil
+}
+
+func (rs *ReplicatedStorager) enqueue(t *replicaTask) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.closed {
+		rs.pending.Done()
+		logs.Warnf("[replicated_storage] closed, drop %s %s, wait for reconciliation", t.op, t.path)
+		return
+	}
+	select {
+	case rs.tasks <- t:
+	default:
+		rs.pending.Done()
+		logs.Errorf("[replicated_storage] queue full, drop %s %s, wait for reconciliation", t.op, t.path)
+	}
+}
+
+func (rs *ReplicatedStorager) worker() {
+	for {
+		select {
+		case <-rs.ctx.Done():
+			rs.drop()
+			return
+		case t := <-rs.tasks:
+			rs.run(t)
+		}
+	}
+}
+
+// drop Close 后丢弃队列中剩余的操作
+func (rs *ReplicatedStorager) drop() {
+	for {
+		select {
+		case t := <-rs.tasks:
+			rs.pending.Done()
+			logs.Warnf("[replicated_storage] closed, drop %s %s, wait for reconciliation", t.op, t.path)
+		default:
+			return
+		}
+	}
+}
+
+func (rs *ReplicatedStorager) run(t *replicaTask) {
+	err := rs.apply(rs.ctx, t)
+	if err == nil {
+		rs.pending.Done()
+		return
+	}
+	t.attempts++
+	if t.attempts > rs.cfg.MaxRetries || rs.ctx.Err() != nil {
+		rs.pending.Done()
+		logs.Errorf("[replicated_storage] %s %s to secondary failed after %d attempts, wait for reconciliation: %s",
+			t.op, t.path, t.attempts, err)
+		return
+	}
+	delay := rs.cfg.RetryInterval << (t.attempts - 1)
+	logs.Warnf("[replicated_storage] %s %s to secondary failed, retry in %s: %s", t.op, t.path, delay, err)
+	time.AfterFunc(delay, func() { rs.enqueue(t) })
+}
+
+func (rs *ReplicatedStorager) apply(ctx context.Context, t *replicaTask) error {
+	switch t.op {
+	case replicaPut:
+		return copyObject(ctx, rs.primary, rs.secondary, t.path)
+	case replicaDelete:
+		err := rs.secondary.DeleteFile(t.path)
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+		return nil
+	case replicaCopyDir:
+		return rs.secondary.CopyDir(t.path, t.dest)
+	}
+	return fmt.Errorf("unknown replica op %d", t.op)
+}
+
+// copyObject 把 src 中的对象复制到 dst, 保留元数据
+func copyObject(ctx context.Context, src, dst Storager, storagePath string) error {
+	return copyObjectTo(ctx, src, dst, storagePath, storagePath)
+}
+
+// copyObjectTo 把 src 中的 srcPath 复制到 dst 的 dstPath, 保留元数据
+func copyObjectTo(ctx context.Context, src, dst Storager, srcPath, dstPath string) error {
+	info, err := src.Stat(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	rc, err := src.ReadRange(ctx, srcPath, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	fi := &FileInfo{
+		StoragePath: dstPath,
+		FileExt:     path.Ext(dstPath),
+		Size:        info.Size,
+		Metadata:    info.Metadata,
+	}
+	return dst.Save(ctx, fi, rc)
+}
+
+// ReconcileOptions 对账参数
+type ReconcileOptions struct {
+	// Prefix 只对账该前缀下的对象
+	Prefix string
+	// DryRun 只比较不修复
+	DryRun bool
+	// Bidirectional 同时把只存在于副本的对象复制回主存储, 否则只报告
+	Bidirectional bool
+}
+
+// ReconcileReport 对账结果
+type ReconcileReport struct {
+	// Checked 主存储中检查的对象数
+	Checked int `json:"checked"`
+	// MissingInSecondary 副本中缺失的对象
+	MissingInSecondary []string `json:"missing_in_secondary"`
+	// SizeMismatch 两边大小不一致的对象, 以主存储为准修复
+	SizeMismatch []string `json:"size_mismatch"`
+	// MissingInPrimary 只存在于副本的对象
+	MissingInPrimary []string `json:"missing_in_primary"`
+	// Repaired 修复成功的对象数
+	Repaired int `json:"repaired"`
+	// Failed 修复失败的对象
+	Failed []string `json:"failed"`
+}
+
+// Reconcile 按路径顺序同时遍历主存储和副本, 找出缺失或大小不一致的对象并修复.
+// 不同后端的 ETag 算法不同, 只比较大小
+func (rs *ReplicatedStorager) Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	nextPrimary, stopPrimary := iter.Pull2(ListAll(ctx, rs.primary, opts.Prefix, nil))
+	defer stopPrimary()
+	nextSecondary, stopSecondary := iter.Pull2(ListAll(ctx, rs.secondary, opts.Prefix, nil))
+	defer stopSecondary()
+
+	pull := func(next func() (*ObjectInfo, error, bool)) (*ObjectInfo, error) {
+		obj, err, ok := next()
+		if !ok {
+			return nil, nil
+		}
+		return obj, err
+	}
+	report := &ReconcileReport{}
+	repair := func(src, dst Storager, p string) {
+		if opts.DryRun {
+			return
+		}
+		if err := copyObject(ctx, src, dst, p); err != nil {
+			logs.Errorf("[replicated_storage] reconcile %s failed: %s", p, err)
+			report.Failed = append(report.Failed, p)
+			return
+		}
+		report.Repaired++
+	}
+
+	p, err := pull(nextPrimary)
+	if err != nil {
+		return nil, fmt.Errorf("list primary: %w", err)
+	}
+	s, err := pull(nextSecondary)
+	if err != nil {
+		return nil, fmt.Errorf("list secondary: %w", err)
+	}
+	for p != nil || s != nil {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		switch {
+		case s == nil || (p != nil && p.StoragePath < s.StoragePath):
+			report.Checked++
+			report.MissingInSecondary = append(report.MissingInSecondary, p.StoragePath)
+			repair(rs.primary, rs.secondary, p.StoragePath)
+			p, err = pull(nextPrimary)
+		case p == nil || s.StoragePath < p.StoragePath:
+			report.MissingInPrimary = append(report.MissingInPrimary, s.StoragePath)
+			if opts.Bidirectional {
+				repair(rs.secondary, rs.primary, s.StoragePath)
+			}
+			s, err = pull(nextSecondary)
+		default:
+			report.Checked++
+			if p.Size != s.Size {
+				report.SizeMismatch = append(report.SizeMismatch, p.StoragePath)
+				repair(rs.primary, rs.secondary, p.StoragePath)
+			}
+			if p, err = pull(nextPrimary); err == nil {
+				s, err = pull(nextSecondary)
+			}
+		}
+		if err != nil {
+			return report, fmt.Errorf("list objects: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// ReconcileFunc 返回可注册为定时任务的对账函数, 输出对账结果的 JSON
+// 例: job.RegistryCronFunc(db, "0 0 3 * * *", "storage-reconcile", rs.ReconcileFunc(storage.ReconcileOptions{}))
+func (rs *ReplicatedStorager) ReconcileFunc(opts ReconcileOptions) func() (string, error) {
+	return func() (string, error) {
+		report, err := rs.Reconcile(context.Background(), opts)
+		if err != nil {
+			logs.Errorf("[replicated_storage] reconcile failed: %s", err)
+			return "", err
+		}
+		data, _ := json.Marshal(report)
+		if len(report.Failed) > 0 {
+			return string(data), fmt.Errorf("reconcile %d objects failed", len(report.Failed))
+		}
+		return string(data), nil
+	}
+}
@@ -0,0 +1,190 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory/memorytest"
+)
+
+var errUnavailable = errors.New("storage unavailable")
+
+// flakyStorage down 为 true 时写入和读取都返回错误
+type flakyStorage struct {
+	storage.Storager
+	down atomic.Bool
+}
+
+func (fs *flakyStorage) Save(ctx context.Context, fi *storage.FileInfo, r io.Reader) error {
+	if fs.down.Load() {
+		return errUnavailable
+	}
+	return fs.Storager.Save(ctx, fi, r)
+}
+
+func (fs *flakyStorage) ReadRange(ctx context.Context, p string, offset, length int64) (io.ReadCloser, error) {
+	if fs.down.Load() {
+		return nil, errUnavailable
+	}
+	return fs.Storager.ReadRange(ctx, p, offset, length)
+}
+
+func (fs *flakyStorage) Stat(ctx context.Context, p string) (*storage.ObjectInfo, error) {
+	if fs.down.Load() {
+		return nil, errUnavailable
+	}
+	return fs.Storager.Stat(ctx, p)
+}
+
+func saveString(t *testing.T, s storage.Storager, p, content string) {
+	fi := &storage.FileInfo{StoragePath: p, Metadata: map[string]string{"k": "v"}}
+	assert.NoError(t, s.Save(context.Background(), fi, strings.NewReader(content)))
+}
+
+func TestReplicatedStoragerSync(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := memorytest.NewStorage(t), memorytest.NewStorage(t)
+	flaky := &flakyStorage{Storager: secondary}
+	rs := storage.NewReplicatedStorager(primary, flaky, config.StorageReplicationConfig{})
+	t.Cleanup(func() { rs.Close() })
+
+	saveString(t, rs, "a.txt", "hello")
+	data, ok := secondary.Bytes("a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(data))
+	info, err := secondary.Stat(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", info.Metadata["k"])
+
+	// 同步模式副本写入失败时返回错误, 主存储已写入
+	flaky.down.Store(true)
+	fi := &storage.FileInfo{StoragePath: "b.txt"}
+	assert.ErrorIs(t, rs.Save(ctx, fi, strings.NewReader("b")), errUnavailable)
+	_, ok = primary.Bytes("b.txt")
+	assert.True(t, ok)
+	flaky.down.Store(false)
+
+	assert.NoError(t, rs.CopyDir("a.txt", "c.txt"))
+	_, ok = secondary.Bytes("c.txt")
+	assert.True(t, ok)
+	assert.NoError(t, rs.DeleteFile("a.txt"))
+	_, ok = secondary.Bytes("a.txt")
+	assert.False(t, ok)
+}
+
+func TestReplicatedStoragerAsyncRetry(t *testing.T) {
+	primary, secondary := memorytest.NewStorage(t), memorytest.NewStorage(t)
+	flaky := &flakyStorage{Storager: secondary}
+	flaky.down.Store(true)
+	rs := storage.NewReplicatedStorager(primary, flaky, config.StorageReplicationConfig{
+		Async:         true,
+		MaxRetries:    10,
+		RetryInterval: 10 * time.Millisecond,
+	})
+	t.Cleanup(func() { rs.Close() })
+
+	saveString(t, rs, "a.txt", "hello")
+	time.Sleep(30 * time.Millisecond)
+	_, ok := secondary.Bytes("a.txt")
+	assert.False(t, ok)
+
+	flaky.down.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, rs.Wait(ctx))
+	data, ok := secondary.Bytes("a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestReplicatedStoragerClose(t *testing.T) {
+	primary, secondary := memorytest.NewStorage(t), memorytest.NewStorage(t)
+	flaky := &flakyStorage{Storager: secondary}
+	flaky.down.Store(true)
+	rs := storage.NewReplicatedStorager(primary, flaky, config.StorageReplicationConfig{
+		Async:         true,
+		MaxRetries:    10,
+		RetryInterval: 10 * time.Millisecond,
+	})
+
+	// 关闭后丢弃等待重试和新加入的操作, Wait 不再阻塞
+	saveString(t, rs, "a.txt", "hello")
+	assert.NoError(t, rs.Close())
+	saveString(t, rs, "b.txt", "hello")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, rs.Wait(ctx))
+	_, ok := secondary.Bytes("b.txt")
+	assert.False(t, ok)
+}
+
+func TestReplicatedStoragerFallback(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := memorytest.NewStorage(t), memorytest.NewStorage(t)
+	flaky := &flakyStorage{Storager: primary}
+	rs := storage.NewReplicatedStorager(flaky, secondary, config.StorageReplicationConfig{})
+	t.Cleanup(func() { rs.Close() })
+
+	saveString(t, rs, "a.txt", "hello")
+	flaky.down.Store(true)
+	content, err := readAll(rs.ReadRange(ctx, "a.txt", 1, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, "ell", content)
+	info, err := rs.Stat(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, info.Size)
+
+	_, err = rs.ReadRange(ctx, "missing.txt", 0, 0)
+	assert.ErrorIs(t, err, errUnavailable)
+}
+
+func TestReplicatedStoragerReconcile(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := memorytest.NewStorage(t), memorytest.NewStorage(t)
+	rs := storage.NewReplicatedStorager(primary, secondary, config.StorageReplicationConfig{})
+	t.Cleanup(func() { rs.Close() })
+
+	saveString(t, primary, "d/a.txt", "a")
+	saveString(t, primary, "d/b.txt", "bb")
+	saveString(t, secondary, "d/b.txt", "b")
+	saveString(t, primary, "d/c.txt", "c")
+	saveString(t, secondary, "d/c.txt", "c")
+	saveString(t, secondary, "d/d.txt", "d")
+	saveString(t, primary, "e/x.txt", "x")
+
+	report, err := rs.Reconcile(ctx, storage.ReconcileOptions{Prefix: "d/", DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Checked)
+	assert.Equal(t, []string{"d/a.txt"}, report.MissingInSecondary)
+	assert.Equal(t, []string{"d/b.txt"}, report.SizeMismatch)
+	assert.Equal(t, []string{"d/d.txt"}, report.MissingInPrimary)
+	assert.Equal(t, 0, report.Repaired)
+	_, ok := secondary.Bytes("d/a.txt")
+	assert.False(t, ok)
+
+	report, err = rs.Reconcile(ctx, storage.ReconcileOptions{Prefix: "d/", Bidirectional: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Repaired)
+	assert.Empty(t, report.Failed)
+	data, _ := secondary.Bytes("d/b.txt")
+	assert.Equal(t, "bb", string(data))
+	_, ok = primary.Bytes("d/d.txt")
+	assert.True(t, ok)
+	_, ok = secondary.Bytes("e/x.txt")
+	assert.False(t, ok)
+
+	out, err := rs.ReconcileFunc(storage.ReconcileOptions{})()
+	assert.NoError(t, err)
+	assert.Contains(t, out, `"missing_in_secondary":["e/x.txt"]`)
+	_, ok = secondary.Bytes("e/x.txt")
+	assert.True(t, ok)
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	s3config "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -90,6 +91,7 @@ func (s3fs *S3Fs) Save(ctx context.Context, fi *storage.FileInfo, r io.Reader) e
 		Key:         aws.String(fi.StoragePath),
 		Body:        r,
 		ContentType: aws.String(mime.TypeByExtension(fi.FileExt)),
+		Metadata:    fi.Metadata,
 	})
 	if err != nil {
 		return err
@@ -166,6 +168,98 @@ func (s3fs *S3Fs) ReadFile(storagePath string) (io.ReadCloser, error) {
 	return obj.Body, nil
 }
 
+func (s3fs *S3Fs) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(s3fs.s3fsCfg.Bucket),
+		Key:    aws.String(storagePath),
+	}
+	if rng := storage.RangeHeader(offset, length); rng != "" {
+		in.Range = aws.String(rng)
+	}
+	obj, err := s3fs.client.GetObject(ctx, in)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("s3 object %s: %w", storagePath, storage.ErrObjectNotFound)
+		}
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+func (s3fs *S3Fs) Stat(ctx context.Context, storagePath string) (*storage.ObjectInfo, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	out, err := s3fs.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s3fs.s3fsCfg.Bucket),
+		Key:    aws.String(storagePath),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("s3 object %s: %w", storagePath, storage.ErrObjectNotFound)
+		}
+		return nil, err
+	}
+	return &storage.ObjectInfo{
+		StoragePath:  storagePath,
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+		Metadata:     out.Metadata,
+	}, nil
+}
+
+func (s3fs *S3Fs) Exists(ctx context.Context, storagePath string) (bool, error) {
+	return storage.ExistsByStat(s3fs.Stat(ctx, storagePath))
+}
+
+// List NextMarker 为 ListObjectsV2 的 ContinuationToken
+func (s3fs *S3Fs) List(ctx context.Context, prefix string, opts *storage.ListOptions) (*storage.ListResult, error) {
+	in := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s3fs.s3fsCfg.Bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(opts.GetMaxKeys())),
+	}
+	if marker := opts.GetMarker(); marker != "" {
+		in.ContinuationToken = aws.String(marker)
+	}
+	out, err := s3fs.client.ListObjectsV2(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	res := &storage.ListResult{
+		Objects:     make([]*storage.ObjectInfo, 0, len(out.Contents)),
+		NextMarker:  aws.ToString(out.NextContinuationToken),
+		IsTruncated: aws.ToBool(out.IsTruncated),
+	}
+	for _, obj := range out.Contents {
+		res.Objects = append(res.Objects, &storage.ObjectInfo{
+			StoragePath:  aws.ToString(obj.Key),
+			Size:         aws.ToInt64(obj.Size),
+			ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+			LastModified: aws.ToTime(obj.LastModified),
+		})
+	}
+	return res, nil
+}
+
+func isNotFound(err error) bool {
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
+
 func (s3fs *S3Fs) DeleteFile(storagePath string) error {
 	if storagePath == "" {
 		return fmt.Errorf("storage path is empty")
@@ -296,6 +390,7 @@ func (s3fs *S3Fs) CreateMultipartUpload(ctx context.Context, in *storage.CreateM
 		Bucket:      s3fs.getBucketName(in.Bucket),
 		Key:         in.StoragePath,
 		ContentType: in.ContentType,
+		Metadata:    in.Metadata,
 	})
 	if err != nil {
 		return nil, err
@@ -362,11 +457,12 @@ func (s3fs *S3Fs) UploadPart(ctx context.Context, in *storage.UploadPartInput) (
 		return nil, fmt.Errorf("reader is empty")
 	}
 	out, err := s3fs.client.UploadPart(ctx, &s3.UploadPartInput{
-		Bucket:     s3fs.getBucketName(in.Bucket),
-		Key:        in.StoragePath,
-		UploadId:   in.UploadID,
-		PartNumber: aws.Int32(int32(*in.PartNumber)),
-		Body:       in.Data,
+		Bucket:        s3fs.getBucketName(in.Bucket),
+		Key:           in.StoragePath,
+		UploadId:      in.UploadID,
+		PartNumber:    aws.Int32(int32(*in.PartNumber)),
+		Body:          in.Data,
+		ContentLength: in.ContentLength,
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ygpkg/yg-go/logs"
+	"github.com/ygpkg/yg-go/mutex"
+	"github.com/ygpkg/yg-go/storage/imaging"
+	"github.com/ygpkg/yg-go/storage/quota"
+	"github.com/ygpkg/yg-go/storage/scanner"
+	"gorm.io/gorm"
+)
+
+const (
+	TableNameScanRecord = "core_upload_file_scans"
+
+	defaultScanBatchSize   = 100
+	defaultScanConcurrency = 4
+	defaultScanTimeout     = 5 * time.Minute
+	defaultScanMaxAttempts = 5
+	defaultScanRetryDelay  = time.Minute
+
+	// ScanMutexKey 扫描任务使用的集群锁
+	ScanMutexKey = "storage_scan_mutex"
+)
+
+// ScanVerdict 扫描结论
+type ScanVerdict string
+
+const (
+	ScanVerdictClean    ScanVerdict = "clean"
+	ScanVerdictInfected ScanVerdict = "infected"
+	ScanVerdictError    ScanVerdict = "error"
+)
+
+// ScanRecord 文件的扫描记录, 每次扫描一条, 扫描失败重试时会有多条
+type ScanRecord struct {
+	gorm.Model
+
+	FileID      uint        `gorm:"column:file_id;index" json:"file_id"`
+	Purpose     string      `gorm:"column:purpose;type:varchar(32)" json:"purpose"`
+	StoragePath string      `gorm:"column:path;type:varchar(128)" json:"path"`
+	Scanner     string      `gorm:"column:scanner;type:varchar(32)" json:"scanner"`
+	Verdict     ScanVerdict `gorm:"column:verdict;type:varchar(16)" json:"verdict"`
+	Signature   string      `gorm:"column:signature;type:varchar(128)" json:"signature"`
+	ErrMsg      string      `gorm:"column:err_msg;type:varchar(256)" json:"err_msg"`
+	// QuarantinePath 感染文件隔离后的路径, 为空表示已删除
+	QuarantinePath string        `gorm:"column:quarantine_path;type:varchar(256)" json:"quarantine_path"`
+	Duration       time.Duration `gorm:"column:duration" json:"duration"`
+}
+
+func (*ScanRecord) TableName() string { return TableNameScanRecord }
+
+// maxStoragePathLen core_upload_files.path 字段的长度
+const maxStoragePathLen = 128
+
+// ScanOptions 扫描任务参数
+type ScanOptions struct {
+	// QuarantinePrefix 感染文件移动到该前缀下, 保留原路径; 为空时直接删除
+	QuarantinePrefix string
+	// BatchSize 每批读取的待扫描记录数, 默认 100
+	BatchSize int
+	// Concurrency 同时扫描的文件数, 默认 4
+	Concurrency int
+	// Timeout 单个文件的扫描超时, 默认 5m
+	Timeout time.Duration
+	// MaxAttempts 单个文件最多扫描失败的次数, 达到后标记为 failed 不再重试, 默认 5
+	MaxAttempts int
+	// RetryDelay 扫描失败后到下次重试的间隔, 每失败一次翻倍, 默认 1m
+	RetryDelay time.Duration
+}
+
+// ScanReport 一次扫描的结果, 路径格式为 purpose:storage_path
+type ScanReport struct {
+	Clean    int      `json:"clean"`
+	Infected []string `json:"infected"`
+	// Errors 扫描失败的文件及原因, 文件保持 pending_scan 状态等待下次重试
+	Errors []string `json:"errors"`
+	// Failed 失败次数达到上限后标记为 failed 的文件
+	Failed []string `json:"failed"`
+}
+
+// ScanWorker 扫描 pending_scan 状态的文件, 干净的文件改为 normal,
+// 感染的文件隔离或删除后改为 infected, 每次扫描都写入 ScanRecord, 图片变体与原图一起扫描和隔离
+type ScanWorker struct {
+	db      *gorm.DB
+	scanner scanner.Scanner
+	opts    ScanOptions
+	notify  chan struct{}
+}
+
+// NewScanWorker 创建扫描任务, 存储实例通过 LoadStorager 按文件用途获取
+func NewScanWorker(db *gorm.DB, sc scanner.Scanner, opts ScanOptions) *ScanWorker {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultScanBatchSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultScanConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultScanTimeout
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultScanMaxAttempts
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultScanRetryDelay
+	}
+	return &ScanWorker{
+		db:      db,
+		scanner: sc,
+		opts:    opts,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// InitScanDB 创建扫描记录表
+func InitScanDB(db *gorm.DB) error {
+	return db.AutoMigrate(&ScanRecord{})
+}
+
+// Notify 通知后台任务立即扫描, 上传完成后调用可减少等待时间
+func (w *ScanWorker) Notify() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run 扫描当前所有 pending_scan 状态的文件, 单个文件扫描失败时记录到报告中并继续,
+// 仍在重试间隔内的文件本次跳过
+func (w *ScanWorker) Run(ctx context.Context) (*ScanReport, error) {
+	report := &ScanReport{}
+	var mu sync.Mutex
+	var lastID uint
+	for {
+		var files []*FileInfo
+		err := w.db.WithContext(ctx).Where("status = ? AND id > ?", FileStatusPendingScan, lastID).
+			Order("id").Limit(w.opts.BatchSize).Find(&files).Error
+		if err != nil {
+			return report, err
+		}
+		failures, err := w.failures(ctx, files)
+		if err != nil {
+			return report, err
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, w.opts.Concurrency)
+		for _, fi := range files {
+			lastID = fi.ID
+			if ctx.Err() != nil {
+				break
+			}
+			attempts := failures[fi.ID]
+			if attempts != nil && time.Now().Before(attempts.last.Add(w.retryDelay(attempts.count))) {
+				continue
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(fi *FileInfo) {
+				defer func() { <-sem; wg.Done() }()
+				verdict, err := w.scanFile(ctx, fi, attempts.failed()+1)
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case verdict == ScanVerdictError && err == nil:
+					report.Failed = append(report.Failed, fi.Purpose+":"+fi.StoragePath)
+				case err != nil:
+					report.Errors = append(report.Errors, fmt.Sprintf("%s:%s: %s", fi.Purpose, fi.StoragePath, err))
+				case verdict == ScanVerdictInfected:
+					report.Infected = append(report.Infected, fi.Purpose+":"+fi.StoragePath)
+				default:
+					report.Clean++
+				}
+			}(fi)
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if len(files) < w.opts.BatchSize {
+			break
+		}
+	}
+	if report.Clean > 0 || len(report.Infected) > 0 || len(report.Errors) > 0 || len(report.Failed) > 0 {
+		logs.Infof("[storage_scan] done, clean: %d, infected: %d, errors: %d, failed: %d",
+			report.Clean, len(report.Infected), len(report.Errors), len(report.Failed))
+	}
+	return report, nil
+}
+
+// scanFailures 文件之前扫描失败的次数和最后一次失败的时间
+type scanFailures struct {
+	count int
+	last  time.Time
+}
+
+func (f *scanFailures) failed() int {
+	if f == nil {
+		return 0
+	}
+	return f.count
+}
+
+// failures 从扫描记录中统计这批文件之前的失败情况
+func (w *ScanWorker) failures(ctx context.Context, files []*FileInfo) (map[uint]*scanFailures, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, 0, len(files))
+	for _, fi := range files {
+		ids = append(ids, fi.ID)
+	}
+	var recs []*ScanRecord
+	err := w.db.WithContext(ctx).Select("file_id", "created_at").
+		Where("file_id IN ? AND verdict = ?", ids, ScanVerdictError).Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[uint]*scanFailures)
+	for _, rec := range recs {
+		f := ret[rec.FileID]
+		if f == nil {
+			f = &scanFailures{}
+			ret[rec.FileID] = f
+		}
+		f.count++
+		if rec.CreatedAt.After(f.last) {
+			f.last = rec.CreatedAt
+		}
+	}
+	return ret, nil
+}
+
+// retryDelay 失败 n 次后的重试间隔, 最多翻倍到 2^10 倍
+func (w *ScanWorker) retryDelay(n int) time.Duration {
+	return w.opts.RetryDelay << min(max(n-1, 0), 10)
+}
+
+// scanFile 扫描单个文件并更新状态, attempt 为本次是第几次扫描.
+// 返回的错误表示扫描没有完成; 失败次数达到上限时文件标记为 failed, 返回 ScanVerdictError 和 nil
+func (w *ScanWorker) scanFile(ctx context.Context, fi *FileInfo, attempt int) (ScanVerdict, error) {
+	// 扫描超时后仍需要更新状态
+	dbCtx := context.WithoutCancel(ctx)
+	ctx, cancel := context.WithTimeout(ctx, w.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	rec := &ScanRecord{
+		FileID:      fi.ID,
+		Purpose:     fi.Purpose,
+		StoragePath: fi.StoragePath,
+		Scanner:     w.scanner.Name(),
+	}
+	res, err := w.scan(ctx, fi)
+	rec.Duration = time.Since(start)
+	if err != nil {
+		logs.Warnf("[storage_scan] scan %s:%s failed: %s", fi.Purpose, fi.StoragePath, err)
+		rec.Verdict = ScanVerdictError
+		rec.ErrMsg = truncate(err.Error(), 256)
+		w.saveRecord(rec)
+		if attempt < w.opts.MaxAttempts {
+			return ScanVerdictError, err
+		}
+		// 失败次数达到上限, 标记为 failed 并释放用量, 不再重试
+		logs.Errorf("[storage_scan] give up %s:%s after %d attempts", fi.Purpose, fi.StoragePath, attempt)
+		updates := map[string]interface{}{"status": FileStatusFailed, "public_url": ""}
+		return ScanVerdictError, w.setStatus(dbCtx, fi, updates, true)
+	}
+
+	if !res.Infected {
+		rec.Verdict = ScanVerdictClean
+		w.saveRecord(rec)
+		updates, err := w.publish(fi)
+		if err != nil {
+			return ScanVerdictError, err
+		}
+		updates["status"] = FileStatusNormal
+		return ScanVerdictClean, w.setStatus(dbCtx, fi, updates, false)
+	}
+
+	logs.Warnf("[storage_scan] %s:%s infected: %s", fi.Purpose, fi.StoragePath, res.Signature)
+	rec.Verdict = ScanVerdictInfected
+	rec.Signature = res.Signature
+	updates := map[string]interface{}{"status": FileStatusInfected, "public_url": ""}
+	qpath, err := w.quarantine(ctx, fi)
+	if err != nil {
+		// 隔离失败时仍标记为 infected, 避免文件被使用, 对象由人工处理
+		logs.Errorf("[storage_scan] quarantine %s:%s failed: %s", fi.Purpose, fi.StoragePath, err)
+		rec.ErrMsg = truncate(err.Error(), 256)
+	} else if qpath != "" {
+		rec.QuarantinePath = qpath
+		updates["path"] = qpath
+	}
+	if len(fi.Extra) > 0 {
+		updates["extra"] = fi.Extra
+	}
+	w.saveRecord(rec)
+	return ScanVerdictInfected, w.setStatus(dbCtx, fi, updates, true)
+}
+
+// scan 扫描原图及其变体, 任一对象感染即视为感染, 已不存在的变体跳过
+func (w *ScanWorker) scan(ctx context.Context, fi *FileInfo) (*scanner.Result, error) {
+	s, err := LoadStorager(fi.Purpose)
+	if err != nil {
+		return nil, err
+	}
+	res := &scanner.Result{}
+	for i, p := range objectPaths(fi) {
+		rc, err := s.ReadRange(ctx, p, 0, 0)
+		if err != nil {
+			if i > 0 && errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		res, err = w.scanner.Scan(ctx, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if res.Infected {
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+// publish 补上扫描前未保存的访问地址, 包括 Extra 中图片变体的地址
+func (w *ScanWorker) publish(fi *FileInfo) (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	s, err := LoadStorager(fi.Purpose)
+	if err != nil {
+		return nil, err
+	}
+	if fi.PublicURL == "" {
+		updates["public_url"] = s.GetPublicURL(fi.StoragePath, false)
+	}
+	img := imaging.GetImageExtra(fi.Extra)
+	if img == nil {
+		return updates, nil
+	}
+	changed := false
+	for _, v := range img.Variants {
+		if v.URL == "" && v.Path != "" {
+			v.URL = s.GetPublicURL(v.Path, false)
+			changed = true
+		}
+	}
+	if changed {
+		extra, err := imaging.SetImageExtra(fi.Extra, img)
+		if err != nil {
+			return nil, err
+		}
+		updates["extra"] = extra
+	}
+	return updates, nil
+}
+
+// quarantine 把感染文件及其变体移动到隔离前缀下, 没有配置隔离前缀时直接删除,
+// 返回原文件隔离后的路径, Extra 中变体的地址同时清空.
+// 隔离后的路径超出 path 字段长度时不移动任何对象, 避免移动后无法更新记录
+func (w *ScanWorker) quarantine(ctx context.Context, fi *FileInfo) (string, error) {
+	if w.opts.QuarantinePrefix != "" {
+		if qpath := path.Join(w.opts.QuarantinePrefix, fi.StoragePath); len(qpath) > maxStoragePathLen {
+			return "", fmt.Errorf("quarantine path %s exceeds %d bytes", qpath, maxStoragePathLen)
+		}
+	}
+	s, err := LoadStorager(fi.Purpose)
+	if err != nil {
+		return "", err
+	}
+	var qpath string
+	for i, p := range objectPaths(fi) {
+		var dst string
+		if w.opts.QuarantinePrefix != "" {
+			dst = path.Join(w.opts.QuarantinePrefix, p)
+			if err := copyObjectTo(ctx, s, s, p, dst); err != nil {
+				if i > 0 && errors.Is(err, ErrObjectNotFound) {
+					continue
+				}
+				return qpath, err
+			}
+		}
+		if i == 0 {
+			qpath = dst
+		}
+		if err := s.DeleteFile(p); err != nil {
+			return qpath, err
+		}
+	}
+	if img := imaging.GetImageExtra(fi.Extra); img != nil && len(img.Variants) > 0 {
+		for _, v := range img.Variants {
+			v.URL = ""
+			if v.Path != "" && w.opts.QuarantinePrefix != "" {
+				v.Path = path.Join(w.opts.QuarantinePrefix, v.Path)
+			}
+		}
+		if fi.Extra, err = imaging.SetImageExtra(fi.Extra, img); err != nil {
+			return qpath, err
+		}
+	}
+	return qpath, nil
+}
+
+// setStatus 只更新仍处于 pending_scan 状态的记录, 避免覆盖扫描期间被删除的文件;
+// release 时在同一事务中释放文件占用的用量, infected 和 failed 不计入用量
+func (w *ScanWorker) setStatus(ctx context.Context, fi *FileInfo, updates map[string]interface{}, release bool) error {
+	err := w.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		ret := tx.Model(&FileInfo{}).
+			Where("id = ? AND status = ?", fi.ID, FileStatusPendingScan).
+			Updates(updates)
+		if ret.Error != nil || ret.RowsAffected == 0 || !release {
+			return ret.Error
+		}
+		return quota.Release(tx, quota.Key{CompanyID: fi.CompanyID, Uin: fi.Uin, Purpose: fi.Purpose}, fi.Size)
+	})
+	if err != nil {
+		logs.Errorf("[storage_scan] update %s:%s status failed: %s", fi.Purpose, fi.StoragePath, err)
+	}
+	return err
+}
+
+func (w *ScanWorker) saveRecord(rec *ScanRecord) {
+	if err := w.db.Create(rec).Error; err != nil {
+		logs.Errorf("[storage_scan] save scan record of %s:%s failed: %s", rec.Purpose, rec.StoragePath, err)
+	}
+}
+
+// JobFunc 返回可注册为定时任务的函数, 输出报告的 JSON
+func (w *ScanWorker) JobFunc() func() (string, error) {
+	return func() (string, error) {
+		report, err := w.Run(context.Background())
+		data, _ := json.Marshal(report)
+		return string(data), err
+	}
+}
+
+// Start 每隔 interval 或收到 Notify 时在主节点扫描一次, ctx 结束时退出
+func (w *ScanWorker) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-w.notify:
+			}
+			if !mutex.IsMaster(mutex.WithMutexKey(ScanMutexKey)) {
+				continue
+			}
+			if _, err := w.Run(ctx); err != nil && ctx.Err() == nil {
+				logs.Errorf("[storage_scan] run failed: %s", err)
+			}
+		}
+	}()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,190 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/ygpkg/yg-go/storage/imaging"
+	"github.com/ygpkg/yg-go/storage/quota"
+	"github.com/ygpkg/yg-go/storage/scanner"
+	storage "github.com/ygpkg/yg-go/storage/v2"
+	"github.com/ygpkg/yg-go/storage/v2/memory/memorytest"
+)
+
+func TestScanWorker(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "scan.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&storage.FileInfo{}))
+	assert.NoError(t, storage.InitScanDB(db))
+	assert.NoError(t, quota.InitDB(db))
+
+	ms := memorytest.NewStorage(t, "scan")
+	create := func(p, content string, status storage.FileStatus) *storage.FileInfo {
+		saveString(t, ms, p, content)
+		fi := &storage.FileInfo{CompanyID: 1, Purpose: "scan", StoragePath: p, PublicURL: "http://x/" + p, Status: status, Size: int64(len(content))}
+		assert.NoError(t, db.Create(fi).Error)
+		assert.NoError(t, quota.Add(db, quota.Key{CompanyID: 1, Purpose: "scan"}, fi.Size))
+		return fi
+	}
+	clean := create("u/clean.txt", "hello", storage.FileStatusPendingScan)
+	infected := create("u/eicar.txt", "x"+scanner.EICAR, storage.FileStatusPendingScan)
+	normal := create("u/normal.txt", scanner.EICAR, storage.FileStatusNormal)
+
+	sc := scanner.NewFake()
+	sc.SetError(errors.New("clamd unavailable"))
+	w := storage.NewScanWorker(db, sc, storage.ScanOptions{QuarantinePrefix: ".quarantine", BatchSize: 1, RetryDelay: time.Nanosecond})
+
+	// 扫描失败时保持 pending_scan 并记录
+	report, err := w.Run(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, report.Errors, 2)
+	var fi storage.FileInfo
+	assert.NoError(t, db.First(&fi, clean.ID).Error)
+	assert.Equal(t, storage.FileStatusPendingScan, fi.Status)
+
+	sc.SetError(nil)
+	report, err = w.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Clean)
+	assert.Equal(t, []string{"scan:u/eicar.txt"}, report.Infected)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, 2, sc.Scanned())
+
+	assert.NoError(t, db.First(&fi, clean.ID).Error)
+	assert.Equal(t, storage.FileStatusNormal, fi.Status)
+
+	fi = storage.FileInfo{}
+	assert.NoError(t, db.First(&fi, infected.ID).Error)
+	assert.Equal(t, storage.FileStatusInfected, fi.Status)
+	assert.Equal(t, ".quarantine/u/eicar.txt", fi.StoragePath)
+	assert.Empty(t, fi.PublicURL)
+	_, ok := ms.Bytes("u/eicar.txt")
+	assert.False(t, ok)
+	_, ok = ms.Bytes(".quarantine/u/eicar.txt")
+	assert.True(t, ok)
+	// 感染的文件不再计入用量
+	var usage quota.Usage
+	assert.NoError(t, db.Where("company_id = ? AND purpose = ?", 1, "scan").First(&usage).Error)
+	assert.Equal(t, clean.Size+normal.Size, usage.Size)
+	assert.Equal(t, int64(2), usage.FileCount)
+
+	fi = storage.FileInfo{}
+	assert.NoError(t, db.First(&fi, normal.ID).Error)
+	assert.Equal(t, storage.FileStatusNormal, fi.Status)
+
+	var records []storage.ScanRecord
+	assert.NoError(t, db.Where("file_id = ?", infected.ID).Order("id").Find(&records).Error)
+	assert.Len(t, records, 2)
+	assert.Equal(t, storage.ScanVerdictError, records[0].Verdict)
+	assert.Equal(t, "clamd unavailable", records[0].ErrMsg)
+	assert.Equal(t, storage.ScanVerdictInfected, records[1].Verdict)
+	assert.Equal(t, "Eicar-Signature", records[1].Signature)
+	assert.Equal(t, ".quarantine/u/eicar.txt", records[1].QuarantinePath)
+
+	// 隔离后的路径超出字段长度时不移动对象, 仍标记为 infected
+	long := create("u/"+strings.Repeat("x", 120)+".txt", scanner.EICAR, storage.FileStatusPendingScan)
+	_, err = storage.NewScanWorker(db, sc, storage.ScanOptions{QuarantinePrefix: ".quarantine"}).Run(ctx)
+	assert.NoError(t, err)
+	fi = storage.FileInfo{}
+	assert.NoError(t, db.First(&fi, long.ID).Error)
+	assert.Equal(t, storage.FileStatusInfected, fi.Status)
+	assert.Equal(t, long.StoragePath, fi.StoragePath)
+	_, ok = ms.Bytes(long.StoragePath)
+	assert.True(t, ok)
+	records = nil
+	assert.NoError(t, db.Where("file_id = ?", long.ID).Find(&records).Error)
+	assert.Len(t, records, 1)
+	assert.Empty(t, records[0].QuarantinePath)
+	assert.Contains(t, records[0].ErrMsg, "exceeds 128 bytes")
+
+	// 没有隔离前缀时直接删除
+	deleted := create("u/eicar2.txt", scanner.EICAR, storage.FileStatusPendingScan)
+	_, err = storage.NewScanWorker(db, sc, storage.ScanOptions{}).Run(ctx)
+	assert.NoError(t, err)
+	fi = storage.FileInfo{}
+	assert.NoError(t, db.First(&fi, deleted.ID).Error)
+	assert.Equal(t, storage.FileStatusInfected, fi.Status)
+	assert.Equal(t, "u/eicar2.txt", fi.StoragePath)
+	_, ok = ms.Bytes("u/eicar2.txt")
+	assert.False(t, ok)
+
+	// 重试间隔内跳过, 失败次数达到上限后标记为 failed 并释放用量
+	broken := create("u/broken.txt", "broken", storage.FileStatusPendingScan)
+	sc.SetError(errors.New("clamd unavailable"))
+	w = storage.NewScanWorker(db, sc, storage.ScanOptions{MaxAttempts: 2, RetryDelay: time.Hour})
+	report, err = w.Run(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, report.Errors, 1)
+	report, err = w.Run(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Errors)
+
+	w = storage.NewScanWorker(db, sc, storage.ScanOptions{MaxAttempts: 2, RetryDelay: time.Nanosecond})
+	report, err = w.Run(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, []string{"scan:u/broken.txt"}, report.Failed)
+	fi = storage.FileInfo{}
+	assert.NoError(t, db.First(&fi, broken.ID).Error)
+	assert.Equal(t, storage.FileStatusFailed, fi.Status)
+	assert.Empty(t, fi.PublicURL)
+	usage = quota.Usage{}
+	assert.NoError(t, db.Where("company_id = ? AND purpose = ?", 1, "scan").First(&usage).Error)
+	assert.Equal(t, clean.Size+normal.Size, usage.Size)
+
+	report, err = w.Run(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.Empty(t, report.Failed)
+
+	// 图片变体与原图一起扫描和隔离, 扫描通过后补上访问地址
+	createImage := func(p, variant string) *storage.FileInfo {
+		vpath := imaging.VariantPath(p, "thumb", "jpeg")
+		saveString(t, ms, p, "image")
+		saveString(t, ms, vpath, variant)
+		extra, err := imaging.SetImageExtra(nil, &imaging.ImageExtra{
+			Variants: map[string]*imaging.VariantExtra{"thumb": {Path: vpath}},
+		})
+		assert.NoError(t, err)
+		fi := &storage.FileInfo{CompanyID: 1, Purpose: "scan", StoragePath: p, Status: storage.FileStatusPendingScan, Extra: extra}
+		assert.NoError(t, db.Create(fi).Error)
+		return fi
+	}
+	cleanImage := createImage("u/clean.jpg", "thumb")
+	infectedImage := createImage("u/infected.jpg", scanner.EICAR)
+	sc.SetError(nil)
+	report, err = storage.NewScanWorker(db, sc, storage.ScanOptions{QuarantinePrefix: ".quarantine"}).Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Clean)
+	assert.Equal(t, []string{"scan:u/infected.jpg"}, report.Infected)
+
+	fi = storage.FileInfo{}
+	assert.NoError(t, db.First(&fi, cleanImage.ID).Error)
+	assert.Equal(t, storage.FileStatusNormal, fi.Status)
+	assert.Equal(t, ms.GetPublicURL("u/clean.jpg", false), fi.PublicURL)
+	img := imaging.GetImageExtra(fi.Extra)
+	assert.Equal(t, ms.GetPublicURL(img.Variants["thumb"].Path, false), img.Variants["thumb"].URL)
+
+	fi = storage.FileInfo{}
+	assert.NoError(t, db.First(&fi, infectedImage.ID).Error)
+	assert.Equal(t, storage.FileStatusInfected, fi.Status)
+	assert.Equal(t, ".quarantine/u/infected.jpg", fi.StoragePath)
+	img = imaging.GetImageExtra(fi.Extra)
+	vpath := imaging.VariantPath("u/infected.jpg", "thumb", "jpeg")
+	assert.Equal(t, path.Join(".quarantine", vpath), img.Variants["thumb"].Path)
+	assert.Empty(t, img.Variants["thumb"].URL)
+	_, ok = ms.Bytes(vpath)
+	assert.False(t, ok)
+	_, ok = ms.Bytes(path.Join(".quarantine", vpath))
+	assert.True(t, ok)
+}
@@ -16,6 +16,13 @@ type Storager interface {
 	GetPublicURL(storagePath string, temp bool) string
 	GetPresignedURL(method, storagePath string) (string, error)
 	ReadFile(storagePath string) (io.ReadCloser, error)
+	// ReadRange 从 offset 开始读取 length 字节, length <= 0 时读取到文件末尾
+	ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error)
+	// Stat 获取对象元信息, 不存在时返回的错误包装 ErrObjectNotFound
+	Stat(ctx context.Context, storagePath string) (*ObjectInfo, error)
+	Exists(ctx context.Context, storagePath string) (bool, error)
+	// List 分页列出 prefix 下的对象, 按路径字典序排列
+	List(ctx context.Context, prefix string, opts *ListOptions) (*ListResult, error)
 	DeleteFile(storagePath string) error
 	CopyDir(storagePath, dest string) error
 	UploadDirectory(localDirPath, destDir string) ([]string, error)
@@ -39,7 +46,12 @@ func Register(name string, fn FactoryFunc) {
 	registry[name] = fn
 }
 
-var storagerMap = new(sync.Map)
+var (
+	storagerMap = new(sync.Map)
+
+	fallbackMu       sync.RWMutex
+	fallbackStorager Storager
+)
 
 const SettingPrefix = "cos-"
 
@@ -47,14 +59,43 @@ func LoadStorager(purpose string) (Storager, error) {
 	if s, ok := storagerMap.Load(purpose); ok {
 		return s.(Storager), nil
 	}
+	fallbackMu.RLock()
+	fallback := fallbackStorager
+	fallbackMu.RUnlock()
+	if fallback != nil {
+		return fallback, nil
+	}
 	s, err := NewStorage(purpose)
 	if err != nil {
 		return nil, err
 	}
-	storagerMap.Store(purpose, s)
+	// 并发创建时只保留先存入的实例, 关闭多创建的实例(如复制存储的后台协程)
+	if actual, loaded := storagerMap.LoadOrStore(purpose, s); loaded {
+		if c, ok := s.(io.Closer); ok {
+			c.Close()
+		}
+		return actual.(Storager), nil
+	}
 	return s, nil
 }
 
+// SetStorager 直接指定 purpose 使用的实例, 不再读取配置, 主要用于测试
+func SetStorager(purpose string, s Storager) {
+	storagerMap.Store(purpose, s)
+}
+
+// RemoveStorager 移除 purpose 已加载的实例, 下次 LoadStorager 时重新创建
+func RemoveStorager(purpose string) {
+	storagerMap.Delete(purpose)
+}
+
+// SetFallbackStorager 设置没有单独加载过的 purpose 使用的实例, 传 nil 取消
+func SetFallbackStorager(s Storager) {
+	fallbackMu.Lock()
+	defer fallbackMu.Unlock()
+	fallbackStorager = s
+}
+
 func NewStorage(purpose string) (Storager, error) {
 	var cfg config.StorageConfig
 	key := SettingPrefix + purpose
@@ -67,6 +108,31 @@ func NewStorage(purpose string) (Storager, error) {
 }
 
 func NewStorageWithCfg(cfg config.StorageConfig) (Storager, error) {
+	s, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Encryption != nil {
+		return NewEncryptedStorager(s, *cfg.Encryption, nil)
+	}
+	return s, nil
+}
+
+func newBackend(cfg config.StorageConfig) (Storager, error) {
+	if cfg.Replication != nil {
+		repl := *cfg.Replication
+		// 主备未单独设置通用选项时继承外层配置
+		for _, sub := range []**config.StorageConfig{&repl.Primary, &repl.Secondary} {
+			if *sub == nil || (*sub).StorageOption != (config.StorageOption{}) {
+				continue
+			}
+			c := **sub
+			c.StorageOption = cfg.StorageOption
+			*sub = &c
+		}
+		return newReplicatedStoragerWithCfg(repl)
+	}
+
 	var (
 		kind string
 	)
@@ -78,6 +144,14 @@ func NewStorageWithCfg(cfg config.StorageConfig) (Storager, error) {
 		kind = "minio"
 	} else if cfg.S3 != nil {
 		kind = "s3"
+	} else if cfg.AliOSS != nil {
+		kind = "alioss"
+	} else if cfg.UpYun != nil {
+		kind = "upyun"
+	} else if cfg.WeedFS != nil {
+		kind = "weedfs"
+	} else if cfg.Memory != nil {
+		kind = "memory"
 	} else {
 		return nil, fmt.Errorf("no storage config matched, registered drivers: %v", registeredNames())
 	}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/tencentyun/cos-go-sdk-v5"
@@ -30,6 +31,9 @@ func init() {
 
 var _ storage.Storager = (*TencentCos)(nil)
 
+// metaPrefix 用户自定义元数据的请求头前缀
+const metaPrefix = "x-cos-meta-"
+
 type TencentCos struct {
 	opt    config.StorageOption
 	cosCfg config.TencentCOSConfig
@@ -65,10 +69,18 @@ func (tc *TencentCos) Save(ctx context.Context, fi *storage.FileInfo, r io.Reade
 	if r == nil {
 		return fmt.Errorf("reader is empty")
 	}
+	headerOpt := &cos.ObjectPutHeaderOptions{
+		ContentType: mime.TypeByExtension(fi.FileExt),
+	}
+	if len(fi.Metadata) > 0 {
+		meta := http.Header{}
+		for k, v := range fi.Metadata {
+			meta.Set(metaPrefix+k, v)
+		}
+		headerOpt.XCosMetaXXX = &meta
+	}
 	resp, err := tc.client.Object.Put(ctx, fi.StoragePath, r, &cos.ObjectPutOptions{
-		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
-			ContentType: mime.TypeByExtension(fi.FileExt),
-		},
+		ObjectPutHeaderOptions: headerOpt,
 	})
 	if err != nil {
 		logs.Errorf("tencent cos put object error: %v", err)
@@ -141,6 +153,91 @@ func (tc *TencentCos) ReadFile(storagePath string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+func (tc *TencentCos) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	var opt *cos.ObjectGetOptions
+	if rng := storage.RangeHeader(offset, length); rng != "" {
+		opt = &cos.ObjectGetOptions{Range: rng}
+	}
+	resp, err := tc.client.Object.Get(ctx, storagePath, opt)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil, fmt.Errorf("tencent cos object %s: %w", storagePath, storage.ErrObjectNotFound)
+		}
+		logs.Errorf("tencent cos get object range error: %v", err)
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (tc *TencentCos) Stat(ctx context.Context, storagePath string) (*storage.ObjectInfo, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	resp, err := tc.client.Object.Head(ctx, storagePath, nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil, fmt.Errorf("tencent cos object %s: %w", storagePath, storage.ErrObjectNotFound)
+		}
+		logs.Errorf("tencent cos head object error: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	info := &storage.ObjectInfo{
+		StoragePath: storagePath,
+		Size:        resp.ContentLength,
+		ETag:        strings.Trim(resp.Header.Get("ETag"), "\""),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	info.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	for k := range resp.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, metaPrefix) {
+			if info.Metadata == nil {
+				info.Metadata = map[string]string{}
+			}
+			info.Metadata[strings.TrimPrefix(lk, metaPrefix)] = resp.Header.Get(k)
+		}
+	}
+	return info, nil
+}
+
+func (tc *TencentCos) Exists(ctx context.Context, storagePath string) (bool, error) {
+	return storage.ExistsByStat(tc.Stat(ctx, storagePath))
+}
+
+// List NextMarker 为 COS 返回的 NextMarker
+func (tc *TencentCos) List(ctx context.Context, prefix string, opts *storage.ListOptions) (*storage.ListResult, error) {
+	res, _, err := tc.client.Bucket.Get(ctx, &cos.BucketGetOptions{
+		Prefix:  prefix,
+		Marker:  opts.GetMarker(),
+		MaxKeys: opts.GetMaxKeys(),
+	})
+	if err != nil {
+		logs.Errorf("tencent cos list objects error: %v", err)
+		return nil, err
+	}
+	ret := &storage.ListResult{
+		Objects:     make([]*storage.ObjectInfo, 0, len(res.Contents)),
+		NextMarker:  res.NextMarker,
+		IsTruncated: res.IsTruncated,
+	}
+	for _, obj := range res.Contents {
+		info := &storage.ObjectInfo{
+			StoragePath: obj.Key,
+			Size:        obj.Size,
+			ETag:        strings.Trim(obj.ETag, "\""),
+		}
+		info.LastModified, _ = time.Parse(time.RFC3339, obj.LastModified)
+		ret.Objects = append(ret.Objects, info)
+	}
+	if ret.IsTruncated && ret.NextMarker == "" && len(ret.Objects) > 0 {
+		ret.NextMarker = ret.Objects[len(ret.Objects)-1].StoragePath
+	}
+	return ret, nil
+}
+
 func (tc *TencentCos) DeleteFile(storagePath string) error {
 	if storagePath == "" {
 		return fmt.Errorf("storage path is empty")
@@ -228,7 +325,22 @@ func (tc *TencentCos) CreateMultipartUpload(ctx context.Context, in *storage.Cre
 	if in == nil || in.StoragePath == nil || *in.StoragePath == "" {
 		return nil, fmt.Errorf("storage path is empty")
 	}
-	initRst, _, err := tc.client.Object.InitiateMultipartUpload(ctx, *in.StoragePath, nil)
+	var opt *cos.InitiateMultipartUploadOptions
+	if len(in.Metadata) > 0 || in.ContentType != nil {
+		headerOpt := &cos.ObjectPutHeaderOptions{}
+		if in.ContentType != nil {
+			headerOpt.ContentType = *in.ContentType
+		}
+		if len(in.Metadata) > 0 {
+			meta := http.Header{}
+			for k, v := range in.Metadata {
+				meta.Set(metaPrefix+k, v)
+			}
+			headerOpt.XCosMetaXXX = &meta
+		}
+		opt = &cos.InitiateMultipartUploadOptions{ObjectPutHeaderOptions: headerOpt}
+	}
+	initRst, _, err := tc.client.Object.InitiateMultipartUpload(ctx, *in.StoragePath, opt)
 	if err != nil {
 		logs.Errorf("tencent cos initiate multipart upload error: %v", err)
 		return nil, err
@@ -274,6 +386,9 @@ func (tc *TencentCos) UploadPart(ctx context.Context, in *storage.UploadPartInpu
 		return nil, fmt.Errorf("reader is empty")
 	}
 	upOpt := &cos.ObjectUploadPartOptions{}
+	if in.ContentLength != nil {
+		upOpt.ContentLength = *in.ContentLength
+	}
 	resp, err := tc.client.Object.UploadPart(ctx, *in.StoragePath, *in.UploadID, *in.PartNumber, in.Data, upOpt)
 	if err != nil {
 		logs.Errorf("tencent cos upload part error: %v", err)
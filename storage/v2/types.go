@@ -30,6 +30,8 @@ const (
 	FileStatusAborted        FileStatus = "aborted"
 	FileStatusUploadWaitComp FileStatus = "upload_wait_comp"
 	FileStatusUploadSuccess  FileStatus = "upload_success"
+	FileStatusPendingScan    FileStatus = "pending_scan"
+	FileStatusInfected       FileStatus = "infected"
 )
 
 type FileInfo struct {
@@ -58,6 +60,8 @@ type FileInfo struct {
 	AbortAt          *time.Time       `gorm:"column:abort_at;type:datetime;comment:用户取消上传时间"`
 	CompletedAt      *time.Time       `gorm:"column:completed_at;type:datetime;comment:文件上传完成时间"`
 	Extra            datatypes.JSON   `gorm:"column:extra;type:json;comment:通用扩展属性"`
+	// Metadata 保存时写入存储后端的用户自定义元数据, 不入库
+	Metadata map[string]string `gorm:"-" json:"-"`
 }
 
 func (*FileInfo) TableName() string { return TableNameFileInfo }
@@ -94,6 +98,8 @@ type CreateMultipartUploadInput struct {
 	Bucket      *string
 	StoragePath *string
 	ContentType *string
+	// Metadata 完成上传后写入对象的用户自定义元数据
+	Metadata map[string]string
 }
 
 type GeneratePresignedURLInput struct {
@@ -113,6 +119,8 @@ type UploadPartInput struct {
 	UploadID    *string
 	PartNumber  *int
 	Data        io.Reader
+	// ContentLength 分片长度, 为空时部分实现需要把分片读入内存
+	ContentLength *int64
 }
 
 type CompleteMultipartUploadInput struct {
@@ -0,0 +1,392 @@
+package upyun
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+const (
+	testBucket   = "yg-test"
+	testOperator = "operator"
+	testPassword = "password"
+)
+
+// fakeUpYun 本地模拟的又拍云服务, 校验签名并在内存中保存对象
+type fakeUpYun struct {
+	mu      sync.Mutex
+	signer  *UpYun
+	objects map[string][]byte
+	headers map[string]http.Header
+	uploads map[string]map[int][]byte
+	seq     int
+}
+
+func (f *fakeUpYun) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.EscapedPath()
+	want := f.signer.authorization(r.Method, uri, r.Header.Get("Date"), r.Header.Get("Content-MD5"))
+	if r.Header.Get("Authorization") != want {
+		f.fail(w, http.StatusUnauthorized, 40100005, "signature error")
+		return
+	}
+	key, _ := url.PathUnescape(strings.TrimPrefix(uri, "/"+testBucket+"/"))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		switch r.Header.Get("X-Upyun-Multi-Stage") {
+		case "initiate":
+			f.seq++
+			id := fmt.Sprintf("uuid-%d", f.seq)
+			f.uploads[id] = map[int][]byte{}
+			w.Header().Set("X-Upyun-Multi-Uuid", id)
+		case "upload":
+			parts, ok := f.uploads[r.Header.Get("X-Upyun-Multi-Uuid")]
+			if !ok {
+				f.fail(w, http.StatusNotFound, 40011059, "file not exist")
+				return
+			}
+			n, _ := strconv.Atoi(r.Header.Get("X-Upyun-Part-Id"))
+			data, _ := io.ReadAll(r.Body)
+			sum := md5.Sum(data)
+			if hex.EncodeToString(sum[:]) != r.Header.Get("Content-MD5") {
+				f.fail(w, http.StatusBadRequest, 40000007, "content md5 not match")
+				return
+			}
+			parts[n] = data
+		case "complete":
+			id := r.Header.Get("X-Upyun-Multi-Uuid")
+			parts := f.uploads[id]
+			ids := make([]int, 0, len(parts))
+			for n := range parts {
+				ids = append(ids, n)
+			}
+			sort.Ints(ids)
+			var buf bytes.Buffer
+			for _, n := range ids {
+				buf.Write(parts[n])
+			}
+			f.objects[key] = buf.Bytes()
+			delete(f.uploads, id)
+		default:
+			if src := r.Header.Get("X-Upyun-Copy-Source"); src != "" {
+				src, _ = url.PathUnescape(strings.TrimPrefix(src, "/"+testBucket+"/"))
+				data, ok := f.objects[src]
+				if !ok {
+					f.fail(w, http.StatusNotFound, 40400001, "file or directory not found")
+					return
+				}
+				f.objects[key] = data
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			f.objects[key] = data
+			f.headers[key] = http.Header{"Content-Type": r.Header["Content-Type"]}
+			for k, vs := range r.Header {
+				if strings.HasPrefix(strings.ToLower(k), metaPrefix) {
+					f.headers[key][k] = vs
+				}
+			}
+		}
+	case http.MethodGet:
+		if r.Header.Get("Accept") == "application/json" && f.isDir(key) {
+			f.list(w, r, key)
+			return
+		}
+		data, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, 40400001, "file or directory not found")
+			return
+		}
+		w.Write(data)
+	case http.MethodHead:
+		if f.isDir(key) {
+			w.Header().Set("x-upyun-file-type", "folder")
+			return
+		}
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, vs := range f.headers[key] {
+			w.Header()[k] = vs
+		}
+		sum := md5.Sum(data)
+		w.Header().Set("Content-Md5", hex.EncodeToString(sum[:]))
+		w.Header().Set("x-upyun-file-type", "file")
+		w.Header().Set("x-upyun-file-size", strconv.Itoa(len(data)))
+		w.Header().Set("x-upyun-file-date", "1700000000")
+	case http.MethodDelete:
+		if _, ok := f.objects[key]; !ok {
+			f.fail(w, http.StatusNotFound, 40400001, "file or directory not found")
+			return
+		}
+		delete(f.objects, key)
+	}
+}
+
+func (f *fakeUpYun) isDir(key string) bool {
+	prefix := dirPrefix(key)
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// list 每页只返回一项, 以覆盖翻页逻辑
+func (f *fakeUpYun) list(w http.ResponseWriter, r *http.Request, key string) {
+	prefix := dirPrefix(key)
+	kinds := map[string]string{}
+	for k := range f.objects {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		name, _, isDir := strings.Cut(strings.TrimPrefix(k, prefix), "/")
+		if isDir {
+			kinds[name] = "folder"
+		} else {
+			kinds[name] = "file"
+		}
+	}
+	names := make([]string, 0, len(kinds))
+	for n := range kinds {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	idx, _ := strconv.Atoi(r.Header.Get("x-list-iter"))
+	res := map[string]interface{}{"iter": listIterEOF, "files": []interface{}{}}
+	if idx < len(names) {
+		name := names[idx]
+		res["files"] = []map[string]interface{}{{
+			"name": name, "type": kinds[name], "length": len(f.objects[prefix+name]), "last_modified": 1700000000,
+		}}
+		if idx+1 < len(names) {
+			res["iter"] = strconv.Itoa(idx + 1)
+		}
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func dirPrefix(key string) string {
+	if key = strings.TrimSuffix(key, "/"); key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func (f *fakeUpYun) fail(w http.ResponseWriter, status, code int, msg string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "msg": msg, "id": "req-id"})
+}
+
+func newTestUpYun(t *testing.T) (*UpYun, *fakeUpYun) {
+	up, err := NewUpYun(config.UpYunConfig{
+		Bucket:      testBucket,
+		Operator:    testOperator,
+		Password:    testPassword,
+		Domain:      "https://cdn.example.com",
+		TokenSecret: "secret",
+	}, config.StorageOption{})
+	assert.NoError(t, err)
+
+	// 服务端使用独立的签名器, 客户端凭证被修改后签名校验失败
+	signer := *up
+	fake := &fakeUpYun{
+		signer:  &signer,
+		objects: map[string][]byte{},
+		headers: map[string]http.Header{},
+		uploads: map[string]map[int][]byte{},
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	up.endpoint = srv.URL
+	return up, fake
+}
+
+func TestUpYunObject(t *testing.T) {
+	up, fake := newTestUpYun(t)
+	ctx := context.Background()
+
+	content := []byte("hello upyun")
+	fi := &storage.FileInfo{StoragePath: "dir/a b.txt", FileExt: ".txt", Size: int64(len(content))}
+	assert.NoError(t, up.Save(ctx, fi, bytes.NewReader(content)))
+	sum := md5.Sum(content)
+	assert.Equal(t, "md5:"+hex.EncodeToString(sum[:]), fi.Hash)
+	assert.Equal(t, "https://cdn.example.com/dir/a%20b.txt", fi.PublicURL)
+	assert.Equal(t, content, fake.objects["dir/a b.txt"])
+
+	rc, err := up.ReadFile("dir/a b.txt")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, content, data)
+
+	// 单文件复制和目录复制
+	assert.NoError(t, up.Save(ctx, &storage.FileInfo{StoragePath: "dir/sub/c.txt", Size: 1}, strings.NewReader("c")))
+	assert.NoError(t, up.CopyDir("dir/a b.txt", "copy/a.txt"))
+	assert.Equal(t, content, fake.objects["copy/a.txt"])
+	assert.NoError(t, up.CopyDir("dir", "backup"))
+	assert.Equal(t, content, fake.objects["backup/a b.txt"])
+	assert.Equal(t, []byte("c"), fake.objects["backup/sub/c.txt"])
+
+	assert.NoError(t, up.DeleteFile("dir/a b.txt"))
+	_, err = up.ReadFile("dir/a b.txt")
+	ue, ok := err.(*upyunError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, ue.StatusCode)
+	assert.Equal(t, 40400001, ue.Code)
+
+	// 签名错误
+	up.passwordMD5 = "wrong"
+	err = up.DeleteFile("backup/sub/c.txt")
+	ue, ok = err.(*upyunError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusUnauthorized, ue.StatusCode)
+}
+
+func TestUpYunStatAndList(t *testing.T) {
+	up, _ := newTestUpYun(t)
+	ctx := context.Background()
+
+	fi := &storage.FileInfo{StoragePath: "l/a.txt", FileExt: ".txt", Metadata: map[string]string{"owner": "42"}}
+	assert.NoError(t, up.Save(ctx, fi, strings.NewReader("hello")))
+	for _, p := range []string{"l/b/c.txt", "l/bb.txt", "m/d.txt"} {
+		assert.NoError(t, up.Save(ctx, &storage.FileInfo{StoragePath: p}, strings.NewReader(p)))
+	}
+
+	info, err := up.Stat(ctx, "l/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", info.ETag)
+	assert.Contains(t, info.ContentType, "text/plain")
+	assert.Equal(t, int64(1700000000), info.LastModified.Unix())
+	assert.Equal(t, map[string]string{"owner": "42"}, info.Metadata)
+
+	// 模拟服务忽略 Range, 由客户端截取
+	rc, err := up.ReadRange(ctx, "l/a.txt", 1, 3)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "ell", string(data))
+	rc, err = up.ReadRange(ctx, "l/a.txt", 2, 0)
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "llo", string(data))
+	_, err = up.ReadRange(ctx, "l/none.txt", 0, 1)
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+
+	// 目录和不存在的文件
+	_, err = up.Stat(ctx, "l/b")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+	ok, err := up.Exists(ctx, "l/none.txt")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	res, err := up.List(ctx, "l/b", nil)
+	assert.NoError(t, err)
+	assert.Len(t, res.Objects, 2)
+	assert.Equal(t, "l/b/c.txt", res.Objects[0].StoragePath)
+	assert.Equal(t, int64(len("l/b/c.txt")), res.Objects[0].Size)
+	assert.Equal(t, "l/bb.txt", res.Objects[1].StoragePath)
+
+	res, err = up.List(ctx, "", &storage.ListOptions{MaxKeys: 2})
+	assert.NoError(t, err)
+	assert.True(t, res.IsTruncated)
+	assert.Equal(t, "l/b/c.txt", res.NextMarker)
+	res, err = up.List(ctx, "", &storage.ListOptions{Marker: res.NextMarker, MaxKeys: 2})
+	assert.NoError(t, err)
+	assert.False(t, res.IsTruncated)
+	assert.Len(t, res.Objects, 2)
+	assert.Equal(t, "m/d.txt", res.Objects[1].StoragePath)
+
+	res, err = up.List(ctx, "none/", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, res.Objects)
+}
+
+func TestUpYunURL(t *testing.T) {
+	up, _ := newTestUpYun(t)
+
+	assert.Equal(t, "https://cdn.example.com/a/b.png", up.GetPublicURL("a/b.png", false))
+	u, err := up.GetPresignedURL(http.MethodGet, "a/b.png")
+	assert.NoError(t, err)
+	parsed, err := url.Parse(u)
+	assert.NoError(t, err)
+	upt := parsed.Query().Get("_upt")
+	assert.Len(t, upt, 18)
+	etime := upt[8:]
+	sum := md5.Sum([]byte("secret&" + etime + "&/a/b.png"))
+	assert.Equal(t, hex.EncodeToString(sum[:])[12:20], upt[:8])
+
+	_, err = up.GetPresignedURL(http.MethodPut, "a/b.png")
+	assert.Error(t, err)
+
+	up.upCfg.TokenSecret = ""
+	assert.Equal(t, "https://cdn.example.com/a/b.png", up.GetPublicURL("a/b.png", true))
+}
+
+func TestUpYunMultipart(t *testing.T) {
+	up, fake := newTestUpYun(t)
+	ctx := context.Background()
+
+	uploadID, err := up.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		ContentType: aws.String("application/octet-stream"),
+	})
+	assert.NoError(t, err)
+
+	// 乱序上传
+	for _, n := range []int{2, 1} {
+		etag, err := up.UploadPart(ctx, &storage.UploadPartInput{
+			StoragePath: aws.String("big.bin"),
+			UploadID:    uploadID,
+			PartNumber:  aws.Int(n),
+			Data:        strings.NewReader(fmt.Sprintf("part%d", n)),
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, *etag)
+	}
+	assert.NoError(t, up.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		UploadID:    uploadID,
+	}))
+	assert.Equal(t, []byte("part1part2"), fake.objects["big.bin"])
+
+	assert.NoError(t, up.AbortMultipartUpload(ctx, &storage.AbortMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		UploadID:    uploadID,
+	}))
+}
+
+func TestNewStorageWithCfg(t *testing.T) {
+	s, err := storage.NewStorageWithCfg(config.StorageConfig{UpYun: &config.UpYunConfig{
+		Bucket: testBucket, Operator: testOperator, Password: testPassword,
+	}})
+	assert.NoError(t, err)
+	assert.IsType(t, &UpYun{}, s)
+	assert.Equal(t, "http://yg-test.test.upcdn.net/x", s.GetPublicURL("x", false))
+}
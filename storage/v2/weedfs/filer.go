@@ -3,10 +3,12 @@ package weedfs
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/fs"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/linxGnu/goseaweedfs"
 	"github.com/ygpkg/yg-go/logs"
@@ -23,7 +25,10 @@ type WeedFileEntry struct {
 	Mime     string          `json:"Mime"`
 	Md5      string          `json:"Md5"`
 	FileSize int64           `json:"FileSize"`
+	Mtime    time.Time       `json:"Mtime"`
 	Chunks   []WeedFileChunk `json:"chunks"`
+	// Extended 上传时 Seaweed- 前缀请求头保存的扩展属性
+	Extended map[string][]byte `json:"Extended"`
 }
 
 type WeedFileListResponse struct {
@@ -80,7 +85,7 @@ func ListWeedDirFiles(filer *goseaweedfs.Filer, rootDir string, lastFilename str
 	}
 	if code != 200 {
 		logs.Errorf("weed filer get file error status code: %v", code)
-		return nil, err
+		return nil, fmt.Errorf("weed filer list %s failed, status: %d", rootDir, code)
 	}
 	var resp WeedFileListResponse
 	err = json.Unmarshal(data, &resp)
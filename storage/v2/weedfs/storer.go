@@ -0,0 +1,696 @@
+package weedfs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	"github.com/linxGnu/goseaweedfs"
+	"github.com/ygpkg/yg-go/config"
+	"github.com/ygpkg/yg-go/logs"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+func init() {
+	storage.Register("weedfs", func(cfg config.StorageConfig) (storage.Storager, error) {
+		if cfg.WeedFS == nil {
+			return nil, fmt.Errorf("weedfs config is nil")
+		}
+		return NewWeedFS(*cfg.WeedFS, cfg.StorageOption)
+	})
+}
+
+var _ storage.Storager = (*WeedFS)(nil)
+
+const (
+	// multipartDir 分块上传的临时目录, 位于 RootDir 下
+	multipartDir = ".multipart"
+	manifestName = "manifest.json"
+	partPrefix   = "part-"
+	maxPartNum   = 10000
+	// metaPrefix filer 把该前缀的请求头保存为扩展属性
+	metaPrefix = "Seaweed-"
+)
+
+// multipartManifest 分块上传清单, 与分块文件一起保存在 {RootDir}/.multipart/{uploadID}/ 下
+type multipartManifest struct {
+	StoragePath string            `json:"storage_path"`
+	ContentType string            `json:"content_type"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// WeedFS SeaweedFS 存储, 通过 filer 的 HTTP 接口读写.
+// filer 本身没有分块上传接口, 分块先作为独立文件保存, 完成时按顺序合并为目标文件.
+type WeedFS struct {
+	opt      config.StorageOption
+	cfg      config.WeedFSConfig
+	filerURL string
+	filer    *goseaweedfs.Filer
+	client   *http.Client
+}
+
+func NewWeedFS(cfg config.WeedFSConfig, opt config.StorageOption) (*WeedFS, error) {
+	if cfg.FilerURL == "" {
+		return nil, errors.New("configuration filer_url error")
+	}
+	filerURL := strings.TrimSuffix(cfg.FilerURL, "/")
+	if cfg.PublicPrefix == "" {
+		cfg.PublicPrefix = filerURL
+	}
+	client := &http.Client{}
+	filer, err := goseaweedfs.NewFiler(filerURL, client)
+	if err != nil {
+		return nil, err
+	}
+	return &WeedFS{
+		opt:      opt,
+		cfg:      cfg,
+		filerURL: filerURL,
+		filer:    filer,
+		client:   client,
+	}, nil
+}
+
+func (w *WeedFS) Save(ctx context.Context, fi *storage.FileInfo, r io.Reader) error {
+	if err := checkStoragePath(fi.StoragePath); err != nil {
+		return err
+	}
+	if r == nil {
+		return fmt.Errorf("reader is empty")
+	}
+	h := md5.New()
+	err := w.upload(ctx, w.fullPath(fi.StoragePath), mime.TypeByExtension(fi.FileExt), fi.Metadata, io.TeeReader(r, h))
+	if err != nil {
+		logs.Errorf("weedfs upload file error: %v", err)
+		return err
+	}
+	fi.Hash = "md5:" + hex.EncodeToString(h.Sum(nil))
+	fi.PublicURL = w.GetPublicURL(fi.StoragePath, false)
+	return nil
+}
+
+// GetPublicURL filer 没有签名机制, temp 参数被忽略, 访问控制交给前置网关
+func (w *WeedFS) GetPublicURL(storagePath string, temp bool) string {
+	return strings.TrimSuffix(w.cfg.PublicPrefix, "/") + escapeKey(w.fullPath(storagePath))
+}
+
+// GetPresignedURL 只支持 GET, 返回公开地址
+func (w *WeedFS) GetPresignedURL(method, storagePath string) (string, error) {
+	if method != http.MethodGet {
+		return "", fmt.Errorf("presigned %s URL not supported for WeedFS", method)
+	}
+	return w.GetPublicURL(storagePath, true), nil
+}
+
+func (w *WeedFS) ReadFile(storagePath string) (io.ReadCloser, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	rc, err := w.get(context.Background(), w.fullPath(storagePath))
+	if err != nil {
+		logs.Errorf("weedfs get file error: %v", err)
+		return nil, err
+	}
+	return rc, nil
+}
+
+func (w *WeedFS) ReadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	rc, err := w.getRange(ctx, w.fullPath(storagePath), offset, length)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logs.Errorf("weedfs get file range error: %v", err)
+		}
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Stat 目录视为不存在, ETag 为 filer 记录的 md5, 大文件可能为空
+func (w *WeedFS) Stat(ctx context.Context, storagePath string) (*storage.ObjectInfo, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	ent, err := w.metadata(ctx, w.fullPath(storagePath))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logs.Errorf("weedfs get metadata error: %v", err)
+		}
+		return nil, err
+	}
+	if ent.Mode.IsDir() {
+		return nil, fmt.Errorf("weedfs file %s is a directory: %w", storagePath, storage.ErrObjectNotFound)
+	}
+	info := w.objectInfo(storagePath, ent)
+	for k, v := range ent.Extended {
+		if strings.HasPrefix(k, metaPrefix) {
+			if info.Metadata == nil {
+				info.Metadata = map[string]string{}
+			}
+			info.Metadata[strings.ToLower(strings.TrimPrefix(k, metaPrefix))] = string(v)
+		}
+	}
+	return info, nil
+}
+
+func (w *WeedFS) Exists(ctx context.Context, storagePath string) (bool, error) {
+	return storage.ExistsByStat(w.Stat(ctx, storagePath))
+}
+
+// List filer 只能按目录列举, 按 key 的顺序逐层遍历 prefix 所在目录, 跳过分块上传临时目录
+func (w *WeedFS) List(ctx context.Context, prefix string, opts *storage.ListOptions) (*storage.ListResult, error) {
+	res, err := storage.ListDirs(ctx, prefix, opts, w.readDir)
+	if err != nil {
+		logs.Errorf("weedfs list dir error: %v", err)
+		return nil, err
+	}
+	return res, nil
+}
+
+// readDir 分页读取 dir 下的全部条目, 目录不存在时返回空列表
+func (w *WeedFS) readDir(ctx context.Context, dir string) ([]storage.DirEntry, error) {
+	fullDir := w.fullPath(dir)
+	var entries []storage.DirEntry
+	lastFilename := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ents, err := ListWeedDirFiles(w.filer, fullDir, lastFilename)
+		if err != nil {
+			if _, merr := w.metadata(ctx, fullDir); errors.Is(merr, fs.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if len(ents) == 0 {
+			return entries, nil
+		}
+		for _, ent := range ents {
+			name := path.Base(ent.FullPath)
+			if ent.Mode.IsDir() {
+				if dir == "" && name == multipartDir {
+					continue
+				}
+				entries = append(entries, storage.DirEntry{Name: name, IsDir: true})
+				continue
+			}
+			entries = append(entries, storage.DirEntry{Name: name, Object: w.objectInfo("", ent)})
+		}
+		lastFilename = path.Base(ents[len(ents)-1].FullPath)
+	}
+}
+
+func (w *WeedFS) objectInfo(storagePath string, ent *WeedFileEntry) *storage.ObjectInfo {
+	info := &storage.ObjectInfo{
+		StoragePath:  storagePath,
+		Size:         ent.FileSize,
+		ContentType:  ent.Mime,
+		LastModified: ent.Mtime,
+		ETag:         ent.Md5,
+	}
+	// filer 返回的 Md5 为 base64 编码
+	if sum, err := base64.StdEncoding.DecodeString(ent.Md5); err == nil && len(sum) == md5.Size {
+		info.ETag = hex.EncodeToString(sum)
+	}
+	return info
+}
+
+func (w *WeedFS) DeleteFile(storagePath string) error {
+	if err := checkStoragePath(storagePath); err != nil {
+		return err
+	}
+	if err := w.delete(context.Background(), w.fullPath(storagePath), false); err != nil {
+		logs.Errorf("weedfs delete file error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (w *WeedFS) CopyDir(storagePath, dest string) error {
+	if storagePath == "" {
+		return fmt.Errorf("source storage path is empty")
+	}
+	if err := checkStoragePath(dest); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	src, dst := w.fullPath(storagePath), w.fullPath(dest)
+	ent, err := w.metadata(ctx, src)
+	if err != nil {
+		logs.Errorf("weedfs check source path error: %v", err)
+		return err
+	}
+	if !ent.Mode.IsDir() {
+		return w.copyFile(ctx, src, dst)
+	}
+	return WalkWeedDir(ctx, w.filer, src, func(ctx context.Context, _ *goseaweedfs.Filer, _ string, ent *WeedFileEntry, err error) error {
+		if err != nil || ent.Mode.IsDir() {
+			return err
+		}
+		return w.copyFile(ctx, ent.FullPath, dst+strings.TrimPrefix(ent.FullPath, src))
+	})
+}
+
+func (w *WeedFS) UploadDirectory(localDirPath, destDir string) ([]string, error) {
+	var uploadedPaths []string
+	if localDirPath == "" {
+		return nil, fmt.Errorf("local directory path is empty")
+	}
+	err := filepath.WalkDir(localDirPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing path %s: %w", filePath, err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(localDirPath, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
+		}
+		storagePath := path.Join(destDir, filepath.ToSlash(relPath))
+		file, err := os.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", filePath, err)
+		}
+		defer file.Close()
+		fi := &storage.FileInfo{StoragePath: storagePath, FileExt: path.Ext(filePath)}
+		if err := w.Save(context.Background(), fi, file); err != nil {
+			return fmt.Errorf("failed to upload file %s to %s: %w", filePath, storagePath, err)
+		}
+		uploadedPaths = append(uploadedPaths, storagePath)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploadedPaths, nil
+}
+
+func (w *WeedFS) CreateMultipartUpload(ctx context.Context, in *storage.CreateMultipartUploadInput) (*string, error) {
+	if in == nil || in.StoragePath == nil {
+		return nil, fmt.Errorf("storage path is empty")
+	}
+	if err := checkStoragePath(*in.StoragePath); err != nil {
+		return nil, err
+	}
+	m := multipartManifest{StoragePath: *in.StoragePath, Metadata: in.Metadata, CreatedAt: time.Now()}
+	if in.ContentType != nil {
+		m.ContentType = *in.ContentType
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	uploadID := uuid.NewString()
+	err = w.upload(ctx, path.Join(w.uploadDir(uploadID), manifestName), "application/json", nil, strings.NewReader(string(data)))
+	if err != nil {
+		logs.Errorf("weedfs create multipart upload error: %v", err)
+		return nil, err
+	}
+	return &uploadID, nil
+}
+
+func (w *WeedFS) GeneratePresignedURL(ctx context.Context, in *storage.GeneratePresignedURLInput) (*string, error) {
+	return nil, fmt.Errorf("presigned part URL not supported for WeedFS")
+}
+
+func (w *WeedFS) UploadPart(ctx context.Context, in *storage.UploadPartInput) (*string, error) {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil || in.PartNumber == nil {
+		return nil, fmt.Errorf("storagePath, uploadID or partNumber is nil")
+	}
+	if *in.PartNumber < 1 || *in.PartNumber > maxPartNum {
+		return nil, fmt.Errorf("part number %d out of range", *in.PartNumber)
+	}
+	if in.Data == nil {
+		return nil, fmt.Errorf("reader is empty")
+	}
+	if err := checkUploadID(*in.UploadID); err != nil {
+		return nil, err
+	}
+	if _, err := w.loadManifest(ctx, *in.UploadID, *in.StoragePath); err != nil {
+		return nil, err
+	}
+	h := md5.New()
+	err := w.upload(ctx, w.partPath(*in.UploadID, *in.PartNumber), "application/octet-stream", nil, io.TeeReader(in.Data, h))
+	if err != nil {
+		logs.Errorf("weedfs upload part error: %v", err)
+		return nil, err
+	}
+	etag := hex.EncodeToString(h.Sum(nil))
+	return &etag, nil
+}
+
+// CompleteMultipartUpload 按分块序号合并为目标文件, in.Parts 为空时使用已上传的全部分块.
+// 指定了 ETag 的分块在合并时校验 md5, 不一致则放弃合并.
+func (w *WeedFS) CompleteMultipartUpload(ctx context.Context, in *storage.CompleteMultipartUploadInput) error {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil {
+		return fmt.Errorf("storagePath or uploadID is nil")
+	}
+	if err := checkUploadID(*in.UploadID); err != nil {
+		return err
+	}
+	m, err := w.loadManifest(ctx, *in.UploadID, *in.StoragePath)
+	if err != nil {
+		return err
+	}
+	parts, err := w.completedParts(*in.UploadID, in.Parts)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("no parts uploaded for %s", *in.UploadID)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(w.concatParts(ctx, *in.UploadID, parts, pw))
+	}()
+	err = w.upload(ctx, w.fullPath(m.StoragePath), m.ContentType, m.Metadata, pr)
+	pr.Close()
+	if err != nil {
+		logs.Errorf("weedfs complete multipart upload error: %v", err)
+		return err
+	}
+	if err := w.delete(ctx, w.uploadDir(*in.UploadID), true); err != nil {
+		logs.Warnf("weedfs clean multipart upload %s error: %v", *in.UploadID, err)
+	}
+	return nil
+}
+
+func (w *WeedFS) AbortMultipartUpload(ctx context.Context, in *storage.AbortMultipartUploadInput) error {
+	if in == nil || in.StoragePath == nil || in.UploadID == nil {
+		return fmt.Errorf("storagePath or uploadID is nil")
+	}
+	if err := checkUploadID(*in.UploadID); err != nil {
+		return err
+	}
+	if err := w.delete(ctx, w.uploadDir(*in.UploadID), true); err != nil {
+		logs.Errorf("weedfs abort multipart upload error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// completedPart 待合并的分块, etag 为空时不校验
+type completedPart struct {
+	num  int
+	etag string
+}
+
+func (w *WeedFS) completedParts(uploadID string, in *types.CompletedMultipartUpload) ([]completedPart, error) {
+	var parts []completedPart
+	if in != nil && len(in.Parts) > 0 {
+		for _, p := range in.Parts {
+			if p.PartNumber == nil {
+				return nil, fmt.Errorf("part number is nil")
+			}
+			cp := completedPart{num: int(*p.PartNumber)}
+			if p.ETag != nil {
+				cp.etag = strings.Trim(*p.ETag, `"`)
+			}
+			parts = append(parts, cp)
+		}
+	} else {
+		dir := w.uploadDir(uploadID)
+		lastFilename := ""
+		for {
+			entries, err := ListWeedDirFiles(w.filer, dir, lastFilename)
+			if err != nil {
+				return nil, err
+			}
+			if len(entries) == 0 {
+				break
+			}
+			for _, ent := range entries {
+				name := path.Base(ent.FullPath)
+				if !strings.HasPrefix(name, partPrefix) {
+					continue
+				}
+				num, err := strconv.Atoi(strings.TrimPrefix(name, partPrefix))
+				if err != nil {
+					continue
+				}
+				parts = append(parts, completedPart{num: num})
+			}
+			lastFilename = path.Base(entries[len(entries)-1].FullPath)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].num < parts[j].num })
+	for i := 1; i < len(parts); i++ {
+		if parts[i].num == parts[i-1].num {
+			return nil, fmt.Errorf("duplicate part number %d", parts[i].num)
+		}
+	}
+	return parts, nil
+}
+
+func (w *WeedFS) concatParts(ctx context.Context, uploadID string, parts []completedPart, dst io.Writer) error {
+	for _, p := range parts {
+		rc, err := w.get(ctx, w.partPath(uploadID, p.num))
+		if err != nil {
+			return fmt.Errorf("read part %d: %w", p.num, err)
+		}
+		h := md5.New()
+		_, err = io.Copy(io.MultiWriter(dst, h), rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("copy part %d: %w", p.num, err)
+		}
+		if p.etag != "" && p.etag != hex.EncodeToString(h.Sum(nil)) {
+			return fmt.Errorf("part %d etag mismatch", p.num)
+		}
+	}
+	return nil
+}
+
+func (w *WeedFS) loadManifest(ctx context.Context, uploadID, storagePath string) (*multipartManifest, error) {
+	rc, err := w.get(ctx, path.Join(w.uploadDir(uploadID), manifestName))
+	if err != nil {
+		return nil, fmt.Errorf("multipart upload %s: %w", uploadID, err)
+	}
+	defer rc.Close()
+	m := &multipartManifest{}
+	if err := json.NewDecoder(rc).Decode(m); err != nil {
+		return nil, err
+	}
+	if m.StoragePath != storagePath {
+		return nil, fmt.Errorf("multipart upload %s does not belong to %s", uploadID, storagePath)
+	}
+	return m, nil
+}
+
+func (w *WeedFS) copyFile(ctx context.Context, src, dst string) error {
+	if src == dst {
+		return nil
+	}
+	rc, err := w.get(ctx, src)
+	if err != nil {
+		logs.Errorf("weedfs copy file %s error: %v", src, err)
+		return err
+	}
+	defer rc.Close()
+	if err := w.upload(ctx, dst, mime.TypeByExtension(path.Ext(dst)), nil, rc); err != nil {
+		logs.Errorf("weedfs copy file %s to %s error: %v", src, dst, err)
+		return err
+	}
+	return nil
+}
+
+// upload 以 multipart/form-data 流式写入 filer, 同名文件会被覆盖
+func (w *WeedFS) upload(ctx context.Context, fullPath, contentType string, metadata map[string]string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, path.Base(fullPath)))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(h)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	u := w.filerURL + escapeKey(fullPath)
+	if w.cfg.Collection != "" {
+		u += "?collection=" + url.QueryEscape(w.cfg.Collection)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	for k, v := range metadata {
+		req.Header.Set(metaPrefix+k, v)
+	}
+	resp, err := w.client.Do(req)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var ret goseaweedfs.FilerUploadResult
+	json.Unmarshal(data, &ret)
+	if resp.StatusCode/100 != 2 || ret.Error != "" {
+		return fmt.Errorf("weedfs upload %s failed, status: %d, error: %s", fullPath, resp.StatusCode, ret.Error)
+	}
+	return nil
+}
+
+// get 读取文件, 不存在时返回的错误包装 fs.ErrNotExist
+func (w *WeedFS) get(ctx context.Context, fullPath string) (io.ReadCloser, error) {
+	return w.getRange(ctx, fullPath, 0, 0)
+}
+
+// getRange 读取文件的一部分, filer 忽略 Range 头时在本地截取
+func (w *WeedFS) getRange(ctx context.Context, fullPath string, offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.filerURL+escapeKey(fullPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	rng := storage.RangeHeader(offset, length)
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		return resp.Body, nil
+	}
+	if resp.StatusCode == http.StatusOK && rng != "" {
+		return storage.LimitRange(resp.Body, offset, length)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("weedfs file %s: %w", fullPath, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("weedfs get %s failed, status: %d", fullPath, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// metadata 读取文件或目录的元数据
+func (w *WeedFS) metadata(ctx context.Context, fullPath string) (*WeedFileEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.filerURL+escapeKey(fullPath)+"?metadata=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("weedfs file %s: %w", fullPath, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("weedfs get metadata %s failed, status: %d", fullPath, resp.StatusCode)
+	}
+	ent := &WeedFileEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(ent); err != nil {
+		return nil, err
+	}
+	return ent, nil
+}
+
+// delete 删除文件或目录, 不存在时不报错
+func (w *WeedFS) delete(ctx context.Context, fullPath string, recursive bool) error {
+	u := w.filerURL + escapeKey(fullPath)
+	if recursive {
+		u += "?recursive=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("weedfs delete %s failed, status: %d", fullPath, resp.StatusCode)
+	}
+	return nil
+}
+
+// fullPath 将存储路径转换为 filer 路径, 存储路径先相对 / 清理, 保证结果不会越出 RootDir
+func (w *WeedFS) fullPath(storagePath string) string {
+	return path.Join("/", w.cfg.RootDir, path.Clean("/"+storagePath))
+}
+
+// uploadDir 分片上传的临时目录, uploadID 来自请求, 调用前需要通过 checkUploadID 校验
+func (w *WeedFS) uploadDir(uploadID string) string {
+	return path.Join("/", w.cfg.RootDir, multipartDir, uploadID)
+}
+
+// checkUploadID 只接受 CreateMultipartUpload 生成的 uuid, 避免拼出的目录指向 multipartDir 本身或其他位置
+func checkUploadID(uploadID string) error {
+	if _, err := uuid.Parse(uploadID); err != nil || len(uploadID) != 36 {
+		return fmt.Errorf("invalid upload id %q", uploadID)
+	}
+	return nil
+}
+
+// checkStoragePath 检查写入和删除的存储路径, multipartDir 下保存分片上传的清单和分块, 不允许直接修改
+func checkStoragePath(storagePath string) error {
+	cleaned := path.Clean("/" + storagePath)
+	if cleaned == "/" {
+		return fmt.Errorf("storage path is empty")
+	}
+	if cleaned == "/"+multipartDir || strings.HasPrefix(cleaned, "/"+multipartDir+"/") {
+		return fmt.Errorf("invalid storage path %q", storagePath)
+	}
+	return nil
+}
+
+func (w *WeedFS) partPath(uploadID string, partNumber int) string {
+	return path.Join(w.uploadDir(uploadID), fmt.Sprintf("%s%05d", partPrefix, partNumber))
+}
+
+// escapeKey 逐段转义路径, 保留 /
+func escapeKey(key string) string {
+	segs := strings.Split(key, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
@@ -0,0 +1,393 @@
+package weedfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/ygpkg/yg-go/config"
+
+	storage "github.com/ygpkg/yg-go/storage/v2"
+)
+
+// fakeFiler 本地模拟的 filer, 只实现驱动用到的接口
+type fakeFiler struct {
+	mu       sync.Mutex
+	files    map[string][]byte
+	extended map[string]map[string][]byte
+}
+
+func (f *fakeFiler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean(r.URL.Path)
+	// 合并分块时请求体依赖对分块的读取, 先读完请求体再加锁
+	var data []byte
+	var ext map[string][]byte
+	if r.Method == http.MethodPost {
+		for k := range r.Header {
+			if strings.HasPrefix(k, metaPrefix) {
+				if ext == nil {
+					ext = map[string][]byte{}
+				}
+				ext[k] = []byte(r.Header.Get(k))
+			}
+		}
+		file, _, err := r.FormFile("file")
+		if err == nil {
+			data, err = io.ReadAll(file)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPost:
+		f.files[p] = data
+		f.extended[p] = ext
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": path.Base(p), "size": len(data)})
+	case http.MethodGet:
+		isDir := f.isDir(p)
+		if r.URL.Query().Get("metadata") == "true" {
+			ent := WeedFileEntry{FullPath: p}
+			if isDir {
+				ent.Mode = fs.ModeDir | 0o770
+			} else if _, ok := f.files[p]; ok {
+				ent = *f.entry(p)
+				ent.Extended = f.extended[p]
+			} else {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(ent)
+			return
+		}
+		if isDir {
+			f.list(w, r, p)
+			return
+		}
+		data, ok := f.files[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		if r.URL.Query().Get("recursive") == "true" {
+			for k := range f.files {
+				if strings.HasPrefix(k, p+"/") {
+					delete(f.files, k)
+				}
+			}
+		}
+		if _, ok := f.files[p]; !ok && !f.isDir(p) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.files, p)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeFiler) entry(p string) *WeedFileEntry {
+	data := f.files[p]
+	sum := md5.Sum(data)
+	return &WeedFileEntry{
+		FullPath: p,
+		Mode:     0o660,
+		Mime:     mime.TypeByExtension(path.Ext(p)),
+		Md5:      base64.StdEncoding.EncodeToString(sum[:]),
+		FileSize: int64(len(data)),
+		Mtime:    time.Unix(1700000000, 0),
+	}
+}
+
+func (f *fakeFiler) isDir(p string) bool {
+	for k := range f.files {
+		if strings.HasPrefix(k, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// list 按名称排序, 每页两项, 以覆盖 lastFileName 翻页
+func (f *fakeFiler) list(w http.ResponseWriter, r *http.Request, dir string) {
+	children := map[string]bool{}
+	for k := range f.files {
+		if !strings.HasPrefix(k, dir+"/") {
+			continue
+		}
+		name, _, sub := strings.Cut(strings.TrimPrefix(k, dir+"/"), "/")
+		children[name] = children[name] || sub
+	}
+	names := make([]string, 0, len(children))
+	for n := range children {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	last := r.URL.Query().Get("lastFileName")
+	resp := WeedFileListResponse{Path: dir}
+	for _, n := range names {
+		if n <= last || len(resp.Entries) == 2 {
+			continue
+		}
+		ent := &WeedFileEntry{FullPath: dir + "/" + n, Mode: fs.ModeDir | 0o770}
+		if !children[n] {
+			ent = f.entry(dir + "/" + n)
+		}
+		resp.Entries = append(resp.Entries, ent)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestWeedFS(t *testing.T) (*WeedFS, *fakeFiler) {
+	fake := &fakeFiler{files: map[string][]byte{}, extended: map[string]map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	s, err := storage.NewStorageWithCfg(config.StorageConfig{WeedFS: &config.WeedFSConfig{
+		FilerURL:     srv.URL,
+		RootDir:      "/yg",
+		PublicPrefix: "https://cdn.example.com/",
+	}})
+	assert.NoError(t, err)
+	return s.(*WeedFS), fake
+}
+
+func TestWeedFSObject(t *testing.T) {
+	wfs, fake := newTestWeedFS(t)
+	ctx := context.Background()
+
+	content := []byte("hello weedfs")
+	fi := &storage.FileInfo{StoragePath: "dir/a b.txt", FileExt: ".txt"}
+	assert.NoError(t, wfs.Save(ctx, fi, bytes.NewReader(content)))
+	sum := md5.Sum(content)
+	assert.Equal(t, "md5:"+hex.EncodeToString(sum[:]), fi.Hash)
+	assert.Equal(t, "https://cdn.example.com/yg/dir/a%20b.txt", fi.PublicURL)
+	assert.Equal(t, content, fake.files["/yg/dir/a b.txt"])
+
+	// 路径中的 .. 不能越出 RootDir
+	assert.NoError(t, wfs.Save(ctx, &storage.FileInfo{StoragePath: "../../etc/x"}, strings.NewReader("x")))
+	assert.Equal(t, []byte("x"), fake.files["/yg/etc/x"])
+
+	rc, err := wfs.ReadFile("dir/a b.txt")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, content, data)
+
+	for _, name := range []string{"b", "c", "sub/d"} {
+		assert.NoError(t, wfs.Save(ctx, &storage.FileInfo{StoragePath: "dir/" + name}, strings.NewReader(name)))
+	}
+	assert.NoError(t, wfs.CopyDir("dir/a b.txt", "single.txt"))
+	assert.Equal(t, content, fake.files["/yg/single.txt"])
+	assert.NoError(t, wfs.CopyDir("dir", "backup"))
+	assert.Equal(t, content, fake.files["/yg/backup/a b.txt"])
+	assert.Equal(t, []byte("c"), fake.files["/yg/backup/c"])
+	assert.Equal(t, []byte("sub/d"), fake.files["/yg/backup/sub/d"])
+
+	assert.NoError(t, wfs.DeleteFile("dir/a b.txt"))
+	_, err = wfs.ReadFile("dir/a b.txt")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	assert.True(t, errors.Is(wfs.CopyDir("missing", "x"), os.ErrNotExist))
+
+	u, err := wfs.GetPresignedURL(http.MethodGet, "dir/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/yg/dir/b", u)
+	_, err = wfs.GetPresignedURL(http.MethodPut, "dir/b")
+	assert.Error(t, err)
+}
+
+func TestWeedFSStatAndList(t *testing.T) {
+	wfs, _ := newTestWeedFS(t)
+	ctx := context.Background()
+
+	fi := &storage.FileInfo{StoragePath: "l/a.txt", FileExt: ".txt", Metadata: map[string]string{"owner": "42"}}
+	assert.NoError(t, wfs.Save(ctx, fi, strings.NewReader("hello")))
+	for _, p := range []string{"l/b/c.txt", "l/bb.txt", "m/d.txt"} {
+		assert.NoError(t, wfs.Save(ctx, &storage.FileInfo{StoragePath: p}, strings.NewReader(p)))
+	}
+	// 未完成的分块上传不出现在列表中
+	_, err := wfs.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: aws.String("x.bin")})
+	assert.NoError(t, err)
+
+	info, err := wfs.Stat(ctx, "l/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", info.ETag)
+	assert.Contains(t, info.ContentType, "text/plain")
+	assert.Equal(t, int64(1700000000), info.LastModified.Unix())
+	assert.Equal(t, map[string]string{"owner": "42"}, info.Metadata)
+
+	// 模拟 filer 忽略 Range, 由客户端截取
+	rc, err := wfs.ReadRange(ctx, "l/a.txt", 1, 3)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "ell", string(data))
+	rc, err = wfs.ReadRange(ctx, "l/a.txt", 2, 0)
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "llo", string(data))
+	_, err = wfs.ReadRange(ctx, "l/none.txt", 0, 1)
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+
+	_, err = wfs.Stat(ctx, "l/b")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+	ok, err := wfs.Exists(ctx, "l/none.txt")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	res, err := wfs.List(ctx, "l/b", nil)
+	assert.NoError(t, err)
+	assert.Len(t, res.Objects, 2)
+	assert.Equal(t, "l/b/c.txt", res.Objects[0].StoragePath)
+	assert.Equal(t, "l/bb.txt", res.Objects[1].StoragePath)
+
+	keys := []string{}
+	for obj, err := range storage.ListAll(ctx, wfs, "", &storage.ListOptions{MaxKeys: 3}) {
+		assert.NoError(t, err)
+		keys = append(keys, obj.StoragePath)
+	}
+	assert.Equal(t, []string{"l/a.txt", "l/b/c.txt", "l/bb.txt", "m/d.txt"}, keys)
+
+	res, err = wfs.List(ctx, "none/", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, res.Objects)
+}
+
+func TestWeedFSMultipart(t *testing.T) {
+	wfs, fake := newTestWeedFS(t)
+	ctx := context.Background()
+
+	uploadID, err := wfs.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		ContentType: aws.String("application/octet-stream"),
+	})
+	assert.NoError(t, err)
+
+	etags := map[int]string{}
+	for _, n := range []int{3, 1, 2} {
+		etag, err := wfs.UploadPart(ctx, &storage.UploadPartInput{
+			StoragePath: aws.String("big.bin"),
+			UploadID:    uploadID,
+			PartNumber:  aws.Int(n),
+			Data:        strings.NewReader("part" + strconv.Itoa(n)),
+		})
+		assert.NoError(t, err)
+		etags[n] = *etag
+	}
+
+	// 分块不属于该路径
+	_, err = wfs.UploadPart(ctx, &storage.UploadPartInput{
+		StoragePath: aws.String("other.bin"),
+		UploadID:    uploadID,
+		PartNumber:  aws.Int(4),
+		Data:        strings.NewReader("x"),
+	})
+	assert.Error(t, err)
+
+	// ETag 不一致时放弃合并, 分块保留
+	err = wfs.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		UploadID:    uploadID,
+		Parts: &types.CompletedMultipartUpload{Parts: []types.CompletedPart{
+			{PartNumber: aws.Int32(1), ETag: aws.String(etags[2])},
+		}},
+	})
+	assert.Error(t, err)
+	_, ok := fake.files["/yg/big.bin"]
+	assert.False(t, ok)
+
+	// 不指定 Parts 时合并全部分块
+	assert.NoError(t, wfs.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+		StoragePath: aws.String("big.bin"),
+		UploadID:    uploadID,
+	}))
+	assert.Equal(t, []byte("part1part2part3"), fake.files["/yg/big.bin"])
+	assert.False(t, fake.isDir("/yg/.multipart/"+*uploadID))
+
+	// 指定 Parts 时只合并列出的分块
+	uploadID, err = wfs.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: aws.String("small.bin")})
+	assert.NoError(t, err)
+	for _, n := range []int{1, 2} {
+		_, err := wfs.UploadPart(ctx, &storage.UploadPartInput{
+			StoragePath: aws.String("small.bin"),
+			UploadID:    uploadID,
+			PartNumber:  aws.Int(n),
+			Data:        strings.NewReader("p" + strconv.Itoa(n)),
+		})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, wfs.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+		StoragePath: aws.String("small.bin"),
+		UploadID:    uploadID,
+		Parts: &types.CompletedMultipartUpload{Parts: []types.CompletedPart{
+			{PartNumber: aws.Int32(2)},
+		}},
+	}))
+	assert.Equal(t, []byte("p2"), fake.files["/yg/small.bin"])
+
+	// 取消上传清理临时目录
+	uploadID, err = wfs.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: aws.String("aborted.bin")})
+	assert.NoError(t, err)
+	assert.True(t, fake.isDir("/yg/.multipart/"+*uploadID))
+	assert.NoError(t, wfs.AbortMultipartUpload(ctx, &storage.AbortMultipartUploadInput{
+		StoragePath: aws.String("aborted.bin"),
+		UploadID:    uploadID,
+	}))
+	assert.False(t, fake.isDir("/yg/.multipart/"+*uploadID))
+
+	// 非法的 uploadID 不能指向 .multipart 目录本身, 其他上传不受影响
+	uploadID, err = wfs.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{StoragePath: aws.String("kept.bin")})
+	assert.NoError(t, err)
+	for _, id := range []string{"", "/", ".", "..", "../x", *uploadID + "/.."} {
+		assert.Error(t, wfs.AbortMultipartUpload(ctx, &storage.AbortMultipartUploadInput{
+			StoragePath: aws.String("kept.bin"),
+			UploadID:    aws.String(id),
+		}))
+		_, err = wfs.UploadPart(ctx, &storage.UploadPartInput{
+			StoragePath: aws.String("kept.bin"),
+			UploadID:    aws.String(id),
+			PartNumber:  aws.Int(1),
+			Data:        strings.NewReader("x"),
+		})
+		assert.Error(t, err)
+		assert.Error(t, wfs.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+			StoragePath: aws.String("kept.bin"),
+			UploadID:    aws.String(id),
+		}))
+	}
+	assert.True(t, fake.isDir("/yg/.multipart/"+*uploadID))
+
+	// 不能直接写入 .multipart 下的清单
+	err = wfs.Save(ctx, &storage.FileInfo{StoragePath: ".multipart/" + *uploadID + "/manifest.json"}, strings.NewReader("{}"))
+	assert.Error(t, err)
+	err = wfs.Save(ctx, &storage.FileInfo{StoragePath: "a/../.multipart/x"}, strings.NewReader("{}"))
+	assert.Error(t, err)
+}